@@ -16,27 +16,130 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"log"
+	"os"
 	"strings"
 
 	"github.com/redborian/go-types-to-jsonschema/pkg/crd"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		validate(os.Args[2:])
+		return
+	}
+
 	op := &crd.SingleVersionGenerator{
 		SingleVersionOptions: crd.SingleVersionOptions{},
 		WriterOptions:        crd.WriterOptions{},
 	}
 
-	flag.StringVar(&op.InputPackage, "package-name", "", "Go package name")
-	flag.StringVar(&op.OutputPath, "output-file", "", "Output schema json path")
+	packageNames := flag.String("package-name", "", "Go package name or directory, or comma separated names of several packages combined in one schema")
+	flag.StringVar(&op.OutputPath, "output-file", "", "Output schema json path, - for the standard output")
 	// TODO: use cobra StringSlice https://godoc.org/github.com/spf13/pflag#StringSlice
 	typeList := flag.String("types", "", "List of types")
-	flag.BoolVar(&op.Flatten, "flatten schema", false, "If flatten the schema using ref tag")
-	flag.StringVar(&op.OutputFormat, "output-format", "json", "Output format of the schema")
+	excludeTypes := flag.String("exclude-types", "", "Comma separated definitions left out of the schema, with the properties of their type")
+	flag.BoolVar(&op.PruneUnreachable, "prune-unreachable", false, "If remove the definitions the schema doesn't refer to once transformed and inlined")
+	flag.BoolVar(&op.Flatten, "flatten", false, "If flatten the schema using ref tag")
+	flag.BoolVar(&op.DeduplicateDefinitions, "deduplicate-definitions", false, "If keep a single definition of the types having the same schema in a flattened schema")
+	flag.BoolVar(&op.Inline, "inline", false, "If write the types in the root schema without refs, instead of in the definitions")
+	check := flag.Bool("check", false, "If compare the schema with the output-file, and the CRDs with the crd-output-file, instead of writing them, exiting with status 1 when they differ")
+	flag.StringVar(&op.CRDOutputPath, "crd-output-file", "", "Path the CRDs of the types are written to as well, in json if it ends with .json and in yaml otherwise")
+	flag.BoolVar(&op.SplitOutput, "split-output", false, "If write one file per type in the output-file directory, instead of a single schema")
+	flag.StringVar(&op.OutputFormat, "output-format", "json", "Output format of the schema, either json, yaml, openapi3 or flat")
+	flag.StringVar(&op.Indent, "indent", crd.DefaultIndent, "Indentation of the json output")
+	flag.BoolVar(&op.Compact, "compact", false, "If write the json output on a single line")
+	flag.StringVar(&op.GOOS, "goos", "", "Operating system the files of the packages are selected for, defaults to the one of the Go environment")
+	flag.StringVar(&op.GOARCH, "goarch", "", "Architecture the files of the packages are selected for, defaults to the one of the Go environment")
+	buildTagSets := flag.String("build-tag-sets", "", "Semicolon separated sets of comma separated build tags, one schema is generated per set")
+	flag.StringVar(&op.MetaSchemaPath, "meta-schema", "", "Path of a JSON schema the output must conform to")
+	flag.BoolVar(&op.NamespaceDefinitions, "namespace-definitions", false, "If group the definitions by package")
+	flag.StringVar(&op.NamingStrategy, "naming-strategy", "", "How the definitions of the types of the other packages are named, either full, package or short. Defaults to full")
+	flag.StringVar(&op.EmptySchemaStyle, "empty-schema-style", "", "How a schema accepting any value is written, either empty, true or preserve-unknown-fields. Defaults to empty")
+	flag.StringVar(&op.AnonymousInterfacePolicy, "anonymous-interface-policy", "", "What to do with fields typed by an anonymous interface with methods, either permissive or error. Defaults to permissive")
+	flag.StringVar(&op.ExamplesDir, "examples-dir", "", "Directory of the examples of the definitions, one JSON file named after each definition")
+	flag.BoolVar(&op.PropagateDeprecation, "propagate-deprecation", false, "If note in the description of a property that its type is deprecated")
+	flag.IntVar(&op.Concurrency, "concurrency", 0, "How many input packages are parsed at once, defaults to the number of CPUs")
+	flag.IntVar(&op.MaxDepth, "max-depth", crd.DefaultMaxDepth, "How deep the types can refer to each other when embedded or flattened")
+	flag.IntVar(&op.InlineThreshold, "inline-threshold", 0, "Inline the definitions having less properties than this in a flattened schema")
+	flag.BoolVar(&op.Strict, "strict", false, "If fail on likely mistakes in the input, like refs to unknown types")
+	flag.BoolVar(&op.Lint, "lint", false, "If log the likely mistakes found in the generated schema")
+	flag.BoolVar(&op.WarningsAsErrors, "werror", false, "If fail when any warning is logged, e.g. in CI")
+	flag.BoolVar(&op.AutoDiscoverImplementations, "auto-discover-implementations", false, "If write the named interfaces as the oneOf of the types of their package implementing them")
+	flag.BoolVar(&op.OmitNumberFormats, "omit-number-formats", false, "If leave out the int32, int64, float and double formats of the integers and numbers")
+	flag.BoolVar(&op.IotaEnums, "iota-enums", false, "If set the enum of the integer types to the values of their constants declared with iota")
+	flag.BoolVar(&op.OptionalByDefault, "optional-by-default", false, "If only the fields with a +required marker are required, instead of the ones without omitempty")
+	flag.BoolVar(&op.AllFieldsRequired, "all-fields-required", false, "If every field that isn't a pointer is required, even if it is tagged omitempty")
+	flag.BoolVar(&op.DisallowUnknownFields, "disallow-unknown-fields", false, "If reject the properties the Go types don't have")
+	flag.StringVar(&op.SchemaVersion, "schema-version", "", "JSON schema version of the output, either draft-04, draft-06, draft-07, 2019-09 or 2020-12. Defaults to draft-04")
+	flag.BoolVar(&op.CanonicalKeyOrder, "canonical-key-order", false, "If write $schema, $ref, type and description first in every schema object, and the other keywords alphabetically")
+	flag.BoolVar(&op.EmitTitles, "emit-titles", false, "If set the title of the definitions and properties to the name of their Go type and field")
+	flag.StringVar(&op.SchemaID, "schema-id", "", "Id of the schema, e.g. the URL it is published at")
+	flag.StringVar(&op.Title, "schema-title", "", "Title of the root of the schema")
+	flag.StringVar(&op.Description, "schema-description", "", "Description of the root of the schema")
+	flag.IntVar(&op.MaxDescriptionLength, "max-description-length", 0, "Truncate the descriptions longer than this many characters at a word boundary, zero keeps them whole")
+	flag.BoolVar(&op.OmitPackageDescription, "omit-package-description", false, "If leave the root of the schema without a description instead of the first sentence of the package doc")
+	flag.StringVar(&op.RefBaseURI, "ref-base-uri", "", "Base URI of the hosted schemas of the types, one per type, the refs point at instead of the definitions")
+	flag.StringVar(&op.DefinitionRefPrefix, "definition-ref-prefix", "", "Prefix of the refs to the definitions, replacing #/definitions/")
+	flag.BoolVar(&op.Report, "report", false, "If log how many types and fields the generation saw, parsed, pruned and skipped")
+	flag.BoolVar(&op.Verbose, "v", false, "If log the informational messages, like the types found again in another package")
+	flag.BoolVar(&op.EmitSourceInfo, "emit-source-info", false, "If add x-source with the Go file and line of their type to the definitions")
 
 	flag.Parse()
 
+	if len(*packageNames) > 0 {
+		packages := strings.Split(*packageNames, ",")
+		op.InputPackage, op.InputPackages = packages[0], packages[1:]
+	}
 	op.Types = strings.Split(*typeList, ",")
+	if len(*excludeTypes) > 0 {
+		op.ExcludeTypes = strings.Split(*excludeTypes, ",")
+	}
+	if len(*buildTagSets) > 0 {
+		for _, tags := range strings.Split(*buildTagSets, ";") {
+			op.BuildTagSets = append(op.BuildTagSets, strings.Split(tags, ","))
+		}
+	}
 
+	if *check {
+		same, diff, err := op.Diff(op.OutputPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !same {
+			fmt.Print(diff)
+			os.Exit(1)
+		}
+		return
+	}
 	op.Generate()
 }
+
+// validate runs the validate subcommand, checking an instance against a
+// generated schema:
+//
+//	go-types-to-json validate -schema schema.json -instance sample.yaml
+//
+// The violations are printed and the exit status is 1 if there are any.
+func validate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	schemaPath := fs.String("schema", "", "Path of the generated schema, or CRD, in JSON or YAML")
+	instancePath := fs.String("instance", "", "Path of the JSON or YAML instance to validate")
+	fs.Parse(args)
+
+	if len(*schemaPath) == 0 || len(*instancePath) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	violations, err := crd.Validate(*schemaPath, *instancePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, v := range violations {
+		fmt.Println(v)
+	}
+	if len(violations) > 0 {
+		os.Exit(1)
+	}
+}
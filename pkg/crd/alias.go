@@ -0,0 +1,42 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+
+// resolveAliases points the refs to a type alias of a named type, e.g.
+// type X = Y, at the aliased type, so the alias is transparent. The
+// definitions of the aliases are kept for the requested types, and are pruned
+// otherwise once nothing refers to them.
+func resolveAliases(defs v1beta1.JSONSchemaDefinitions, aliases map[string]bool) {
+	// resolve follows the refs to aliases, a cycle of aliases doesn't
+	// compile.
+	resolve := func(ref string) string {
+		for name := getNameFromURL(ref); aliases[name]; name = getNameFromURL(ref) {
+			alias, ok := defs[name]
+			if !ok || alias.Ref == nil {
+				break
+			}
+			ref = *alias.Ref
+		}
+		return ref
+	}
+	walkDefinitionMap(defs, "#/definitions", func(_ string, def *v1beta1.JSONSchemaProps) {
+		if def.Ref != nil {
+			ref := resolve(*def.Ref)
+			def.Ref = &ref
+		}
+	})
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// canonicalKeys are the keywords written first in a schema object, in this
+// order, when CanonicalKeyOrder is set. The other ones follow alphabetically.
+var canonicalKeys = []string{"$schema", "$ref", "type", "description"}
+
+// orderedObject is a JSON object whose keys are written in the given order.
+type orderedObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// canonicalSchema returns the generic JSON form of a schema with the keys of
+// every schema object in it in the canonical order, see canonicalKeys. The
+// keys of the other objects, e.g. properties, stay alphabetical. groups are
+// the entries of the definitions holding the definitions of a package, see
+// NamespaceDefinitions.
+func canonicalSchema(schema map[string]interface{}, groups map[string]bool) orderedObject {
+	values := map[string]interface{}{}
+	for key, value := range schema {
+		switch key {
+		case "definitions", "$defs":
+			defs, _ := value.(map[string]interface{})
+			ordered := map[string]interface{}{}
+			for name, def := range defs {
+				if !groups[name] {
+					ordered[name] = canonicalValue(def)
+					continue
+				}
+				pkgDefs, _ := def.(map[string]interface{})
+				orderedPkgDefs := map[string]interface{}{}
+				for typeName, pkgDef := range pkgDefs {
+					orderedPkgDefs[typeName] = canonicalValue(pkgDef)
+				}
+				ordered[name] = orderedPkgDefs
+			}
+			value = ordered
+		case "properties", "patternProperties", "dependencies":
+			if m, ok := value.(map[string]interface{}); ok {
+				ordered := map[string]interface{}{}
+				for name, sub := range m {
+					ordered[name] = canonicalValue(sub)
+				}
+				value = ordered
+			}
+		case "allOf", "anyOf", "oneOf", "items":
+			if a, ok := value.([]interface{}); ok {
+				ordered := make([]interface{}, len(a))
+				for i, sub := range a {
+					ordered[i] = canonicalValue(sub)
+				}
+				value = ordered
+				break
+			}
+			value = canonicalValue(value)
+		case "not", "additionalProperties", "additionalItems":
+			value = canonicalValue(value)
+		}
+		values[key] = value
+	}
+	return orderedObject{keys: canonicalOrder(values), values: values}
+}
+
+// canonicalValue orders value if it is a schema object. Boolean schemas and
+// the string arrays of dependencies are returned as is.
+func canonicalValue(value interface{}) interface{} {
+	if m, ok := value.(map[string]interface{}); ok {
+		return canonicalSchema(m, nil)
+	}
+	return value
+}
+
+// canonicalOrder returns the keys of a schema object in the canonical order.
+func canonicalOrder(values map[string]interface{}) []string {
+	var keys, rest []string
+	first := map[string]bool{}
+	for _, key := range canonicalKeys {
+		first[key] = true
+		if _, ok := values[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	for key := range values {
+		if !first[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// objectKeys returns the keys of the JSON object raw, in order.
+func objectKeys(t *testing.T, raw json.RawMessage) []string {
+	t.Helper()
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	if _, err := dec.Token(); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key.(string))
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return keys
+}
+
+func TestCanonicalKeyOrder(t *testing.T) {
+	src := `package api
+
+// T is the root.
+type T struct {
+	// +kubebuilder:validation:Minimum=1
+	// A is a count.
+	A int ` + "`json:\"a\"`" + `
+	// B refers to U.
+	B U ` + "`json:\"b\"`" + `
+}
+
+// U is a leaf.
+type U struct {
+	Name string ` + "`json:\"name,omitempty\"`" + `
+}
+`
+	op := testGenerator(t, map[string]string{"types.go": src}, "T")
+	op.Flatten = true
+	op.CanonicalKeyOrder = true
+	var schema struct {
+		Definitions map[string]json.RawMessage `json:"definitions"`
+	}
+	out := json.RawMessage(generateOutput(t, op))
+	if err := json.Unmarshal(out, &schema); err != nil {
+		t.Fatal(err)
+	}
+	var def struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(schema.Definitions["T"], &def); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		raw  json.RawMessage
+		want []string
+	}{
+		{name: "root", raw: out, want: []string{"$schema", "type", "anyOf", "definitions"}},
+		{name: "T", raw: schema.Definitions["T"], want: []string{"type", "description", "properties", "required"}},
+		{name: "T.a", raw: def.Properties["a"], want: []string{"type", "description", "format", "minimum"}},
+		{name: "T.b", raw: def.Properties["b"], want: []string{"$ref", "description"}},
+	}
+	for _, tt := range tests {
+		if got := objectKeys(t, tt.raw); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("the keys of %s are %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"log"
+	"strings"
+)
+
+// commentMarker sets the $comment of the definition of a type, e.g.
+// +schemagen:comment=Generated from the v2 API. Unlike the doc comment it is
+// meant for the tools and maintainers reading the schema, not for its users.
+const commentMarker = "schemagen:comment"
+
+// commentKeyword is the keyword the comments are written with, from draft-07.
+const commentKeyword = "$comment"
+
+// collectComment adds the comment set by the marker in comments to the
+// definition named defName. It is kept with the extensions, which are written
+// the same way.
+func collectComment(comments []string, defName string, extensions definitionKeywords) {
+	if comment := strings.TrimSpace(Comments(comments).getTag(commentMarker, "=")); comment != "" {
+		extensions.set(defName, commentKeyword, comment)
+	}
+}
+
+// dropComments removes the comments from extensions when the output doesn't
+// know the $comment keyword, i.e. before draft-07 and in an OpenAPI 3
+// document.
+func dropComments(extensions definitionKeywords, version, format string) {
+	if schemaVersionAtLeast(version, SchemaVersionDraft07) && format != openAPI3Format {
+		return
+	}
+	for name, keywords := range extensions {
+		if _, ok := keywords[commentKeyword]; ok {
+			log.Printf("Ignoring the comment of %s, $comment needs schema version %s or later", name, SchemaVersionDraft07)
+			delete(keywords, commentKeyword)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import "testing"
+
+func TestCommentMarker(t *testing.T) {
+	src := `package api
+
+// T is a thing.
+// +schemagen:comment=Generated from the v2 API.
+type T struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+`
+	tests := []struct {
+		version string
+		format  string
+		ref     string
+		want    bool
+	}{
+		{version: SchemaVersionDraft04, ref: "#/definitions/T"},
+		{version: SchemaVersionDraft06, ref: "#/definitions/T"},
+		{version: SchemaVersionDraft07, ref: "#/definitions/T", want: true},
+		{version: SchemaVersion202012, ref: "#/$defs/T", want: true},
+		{format: openAPI3Format, ref: "#/components/schemas/T"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version+tt.format, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.Flatten = true
+			op.SchemaVersion = tt.version
+			op.OutputFormat = tt.format
+			def, ok := resolveRef(generateGeneric(t, op), tt.ref).(map[string]interface{})
+			if !ok {
+				t.Fatalf("no definition at %s", tt.ref)
+			}
+			comment, ok := def["$comment"]
+			if ok != tt.want || ok && comment != "Generated from the v2 API." {
+				t.Errorf("$comment is %v, want it %v", comment, tt.want)
+			}
+			// The comment isn't part of the description.
+			if description := def["description"]; description != "T is a thing." {
+				t.Errorf("the description is %q", description)
+			}
+		})
+	}
+}
@@ -37,7 +37,7 @@ func parseCRDs(comments []string) *v1beta1.CustomResourceDefinitionSpec {
 
 	crdVersion := v1beta1.CustomResourceDefinitionVersion{
 		Name:    parseVersion(comments),
-		Served:  true,
+		Served:  isServedVersion(comments),
 		Storage: isStorageVersion(comments),
 	}
 
@@ -104,6 +104,9 @@ func parseCRDs(comments []string) *v1beta1.CustomResourceDefinitionSpec {
 	if len(rt.ShortName) > 0 {
 		crdSpec.Names.ShortNames = strings.Split(rt.ShortName, ";")
 	}
+	if len(rt.Scope) > 0 {
+		crdSpec.Scope = v1beta1.ResourceScope(rt.Scope)
+	}
 
 	return crdSpec
 }
@@ -270,6 +273,7 @@ type resourceTags struct {
 	REST      string
 	Strategy  string
 	ShortName string
+	Scope     string
 }
 
 // ParseKV parses key-value string formatted as "foo=bar" and returns key and value.
@@ -301,6 +305,11 @@ func resourceAnnotationValue(tag string) (resourceTags, error) {
 			res.Resource = value
 		case "shortName":
 			res.ShortName = value
+		case "scope":
+			if value != "Namespaced" && value != "Cluster" {
+				return resourceTags{}, fmt.Errorf("the scope of // +kubebuilder:resource must be either Namespaced or Cluster, got %s", value)
+			}
+			res.Scope = value
 		default:
 			return resourceTags{}, fmt.Errorf("The given input %s is invalid", value)
 		}
@@ -551,7 +560,13 @@ func parseVersion(comments []string) string {
 	return Comments(comments).getTag("kubebuilder:crd:version", "=")
 }
 
+// isStorageVersion tells if the version of a CRD is the one it is stored as,
+// with either a +kubebuilder:storageversion or a +kubebuilder:crd:storage=true
+// marker.
 func isStorageVersion(comments []string) bool {
+	if Comments(comments).hasTag("kubebuilder:storageversion") {
+		return true
+	}
 	storage := strings.ToLower(Comments(comments).getTag("kubebuilder:crd:storage", "="))
 	if len(storage) > 0 {
 		switch storage {
@@ -565,3 +580,24 @@ func isStorageVersion(comments []string) bool {
 	}
 	return false
 }
+
+// isServedVersion tells if the version of a CRD is served by the API server,
+// unless it has either a +kubebuilder:unservedversion or a
+// +kubebuilder:crd:served=false marker.
+func isServedVersion(comments []string) bool {
+	if Comments(comments).hasTag("kubebuilder:unservedversion") {
+		return false
+	}
+	served := strings.ToLower(Comments(comments).getTag("kubebuilder:crd:served", "="))
+	if len(served) > 0 {
+		switch served {
+		case "true":
+			return true
+		case "false":
+			return false
+		default:
+			log.Fatalf("the value associated with kubebuilder:crd:served should either true or false")
+		}
+	}
+	return true
+}
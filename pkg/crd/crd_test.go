@@ -0,0 +1,148 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestCRDSpecs(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		kind    schema.GroupKind
+		names   v1beta1.CustomResourceDefinitionNames
+		scope   v1beta1.ResourceScope
+		version string
+		wantErr string
+	}{
+		{
+			name: "every marker",
+			src: `// +groupName=example.com
+package v1
+
+// +kubebuilder:resource:path=widgets,shortName=wd;wdg,scope=Cluster
+// +kubebuilder:singular=widget
+// +kubebuilder:crd:version=v2
+type Widget struct {
+	Size int ` + "`json:\"size\"`" + `
+}
+`,
+			kind:    schema.GroupKind{Group: "example.com", Kind: "Widget"},
+			names:   v1beta1.CustomResourceDefinitionNames{Plural: "widgets", Singular: "widget", ShortNames: []string{"wd", "wdg"}},
+			scope:   "Cluster",
+			version: "v2",
+		},
+		{
+			name: "defaults",
+			src: `// +groupName=example.com
+package v1
+
+// +kubebuilder:resource:path=widgets
+type Widget struct {
+	Size int ` + "`json:\"size\"`" + `
+}
+`,
+			kind:    schema.GroupKind{Group: "example.com", Kind: "Widget"},
+			names:   v1beta1.CustomResourceDefinitionNames{Plural: "widgets"},
+			scope:   "Namespaced",
+			version: "v1",
+		},
+		{
+			name: "no group",
+			src: `package v1
+
+// +kubebuilder:resource:path=widgets
+type Widget struct {
+	Size int ` + "`json:\"size\"`" + `
+}
+`,
+			wantErr: "CRD Widget has no group",
+		},
+		{
+			name: "no plural",
+			src: `// +groupName=example.com
+package v1
+
+// +kubebuilder:resource:shortName=wd
+type Widget struct {
+	Size int ` + "`json:\"size\"`" + `
+}
+`,
+			wantErr: "CRD Widget has no plural name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := &SingleVersionGenerator{}
+			op.InputPackage = "example.com/apis/v1"
+			op.Types = []string{"Widget"}
+			op.fs = testPackages(t, map[string]map[string]string{op.InputPackage: {"types.go": tt.src}})
+			_, err := op.GenerateSchema()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("GenerateSchema() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateSchema() = %v", err)
+			}
+			spec, ok := op.crdSpecs[tt.kind]
+			if !ok || len(op.crdSpecs) != 1 {
+				t.Fatalf("CRD specs %v, want only %v", op.crdSpecs, tt.kind)
+			}
+			if spec.Group != tt.kind.Group {
+				t.Errorf("group %q, want %q", spec.Group, tt.kind.Group)
+			}
+			names := spec.Names
+			names.Kind, names.ListKind = "", ""
+			if !reflect.DeepEqual(names, tt.names) {
+				t.Errorf("names %+v, want %+v", names, tt.names)
+			}
+			if spec.Scope != tt.scope {
+				t.Errorf("scope %q, want %q", spec.Scope, tt.scope)
+			}
+			if len(spec.Versions) != 1 || spec.Versions[0].Name != tt.version {
+				t.Errorf("versions %+v, want %s", spec.Versions, tt.version)
+			}
+		})
+	}
+}
+
+func TestServedVersion(t *testing.T) {
+	tests := []struct {
+		comments []string
+		want     bool
+	}{
+		{comments: nil, want: true},
+		{comments: []string{"+kubebuilder:storageversion"}, want: true},
+		{comments: []string{"+kubebuilder:unservedversion"}, want: false},
+		{comments: []string{"+kubebuilder:crd:served=false"}, want: false},
+		{comments: []string{"+kubebuilder:crd:served=False"}, want: false},
+		{comments: []string{"+kubebuilder:crd:served=true"}, want: true},
+		{comments: []string{"+kubebuilder:crd:served=true", "+kubebuilder:unservedversion"}, want: false},
+	}
+	for _, tt := range tests {
+		if got := isServedVersion(tt.comments); got != tt.want {
+			t.Errorf("isServedVersion(%q) = %v, want %v", tt.comments, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"fmt"
+	"log"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+const (
+	// CRDVersionV1 writes apiextensions.k8s.io/v1 CRDs.
+	CRDVersionV1 = "v1"
+	// CRDVersionV1beta1 writes apiextensions.k8s.io/v1beta1 CRDs, which are
+	// no longer served since Kubernetes 1.22.
+	CRDVersionV1beta1 = "v1beta1"
+)
+
+// checkCRDVersion returns an error for an unknown CRD version.
+func checkCRDVersion(version string) error {
+	switch version {
+	case "", CRDVersionV1, CRDVersionV1beta1:
+		return nil
+	}
+	return fmt.Errorf("unknown CRD version %q, must be either %s or %s", version, CRDVersionV1, CRDVersionV1beta1)
+}
+
+// stripDefaults removes the default values from the schemas of a v1beta1 CRD,
+// which the API server doesn't apply.
+func stripDefaults(crd *v1beta1.CustomResourceDefinition) {
+	var schemas []*v1beta1.JSONSchemaProps
+	if crd.Spec.Validation != nil {
+		schemas = append(schemas, crd.Spec.Validation.OpenAPIV3Schema)
+	}
+	for _, version := range crd.Spec.Versions {
+		if version.Schema != nil {
+			schemas = append(schemas, version.Schema.OpenAPIV3Schema)
+		}
+	}
+	stripped := false
+	for _, schema := range schemas {
+		walkDefinition(schema, func(def *v1beta1.JSONSchemaProps) {
+			if def.Default != nil {
+				def.Default = nil
+				stripped = true
+			}
+		})
+	}
+	if stripped {
+		log.Printf("Ignoring the default values of CRD %s, they aren't applied in %s", crd.Name, CRDVersionV1beta1)
+	}
+}
+
+// toV1CRD converts a v1beta1 CRD to v1 through the internal version, like the
+// API server does. The schema and subresources end up in every version, and
+// as spec.preserveUnknownFields is false in v1, unknown fields are pruned
+// unless the schema has x-kubernetes-preserve-unknown-fields.
+func toV1CRD(crd *v1beta1.CustomResourceDefinition) (*apiextensionsv1.CustomResourceDefinition, error) {
+	internal := &apiextensions.CustomResourceDefinition{}
+	if err := v1beta1.Convert_v1beta1_CustomResourceDefinition_To_apiextensions_CustomResourceDefinition(crd, internal, nil); err != nil {
+		return nil, fmt.Errorf("failed to convert CRD %q: %v", crd.Name, err)
+	}
+	out := &apiextensionsv1.CustomResourceDefinition{}
+	if err := apiextensionsv1.Convert_apiextensions_CustomResourceDefinition_To_v1_CustomResourceDefinition(internal, out, nil); err != nil {
+		return nil, fmt.Errorf("failed to convert CRD %q: %v", crd.Name, err)
+	}
+	out.APIVersion = apiextensionsv1.SchemeGroupVersion.String()
+	out.Kind = "CustomResourceDefinition"
+	return out, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCRDVersion(t *testing.T) {
+	src := `// +groupName=example.com
+package api
+
+// +kubebuilder:resource:path=widgets
+type Widget struct {
+	// +kubebuilder:default=3
+	Size int ` + "`json:\"size\"`" + `
+}
+`
+	tests := []struct {
+		version    string
+		apiVersion string
+		hasDefault bool
+	}{
+		{version: "", apiVersion: "apiextensions.k8s.io/v1", hasDefault: true},
+		{version: CRDVersionV1, apiVersion: "apiextensions.k8s.io/v1", hasDefault: true},
+		{version: CRDVersionV1beta1, apiVersion: "apiextensions.k8s.io/v1beta1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, "Widget")
+			op.outputCRD = true
+			op.CRDVersion = tt.version
+			var crd struct {
+				APIVersion string `json:"apiVersion"`
+				Spec       struct {
+					Versions []struct {
+						Name    string `json:"name"`
+						Storage bool   `json:"storage"`
+						Schema  struct {
+							OpenAPIV3Schema struct {
+								Properties map[string]map[string]interface{} `json:"properties"`
+							} `json:"openAPIV3Schema"`
+						} `json:"schema"`
+					} `json:"versions"`
+				} `json:"spec"`
+			}
+			if err := json.Unmarshal([]byte(generateOutput(t, op)), &crd); err != nil {
+				t.Fatal(err)
+			}
+			if crd.APIVersion != tt.apiVersion {
+				t.Errorf("apiVersion %q, want %q", crd.APIVersion, tt.apiVersion)
+			}
+			if len(crd.Spec.Versions) != 1 || !crd.Spec.Versions[0].Storage {
+				t.Fatalf("versions %+v, want a single storage version", crd.Spec.Versions)
+			}
+			size := crd.Spec.Versions[0].Schema.OpenAPIV3Schema.Properties["size"]
+			if _, ok := size["default"]; ok != tt.hasDefault {
+				t.Errorf("size is %v, want a default %v", size, tt.hasDefault)
+			}
+		})
+	}
+}
+
+func TestUnknownCRDVersion(t *testing.T) {
+	op := testGenerator(t, map[string]string{"types.go": twoCRDsSource}, "Widget")
+	op.outputCRD = true
+	op.CRDVersion = "v2"
+	if outputError(t, op) == nil {
+		t.Error("the CRD was written, want an error on the unknown version")
+	}
+}
+
+func TestCRDByteSlices(t *testing.T) {
+	src := `// +groupName=example.com
+package api
+
+// +kubebuilder:resource:path=widgets
+type Widget struct {
+	Data  []byte   ` + "`json:\"data\"`" + `
+	Raw   []uint8  ` + "`json:\"raw\"`" + `
+	Blobs [][]byte ` + "`json:\"blobs\"`" + `
+	Hash  [4]byte  ` + "`json:\"hash\"`" + `
+}
+`
+	op := testGenerator(t, map[string]string{"types.go": src}, "Widget")
+	op.outputCRD = true
+	var crd struct {
+		Spec struct {
+			Versions []struct {
+				Schema struct {
+					OpenAPIV3Schema struct {
+						Properties map[string]interface{} `json:"properties"`
+					} `json:"openAPIV3Schema"`
+				} `json:"schema"`
+			} `json:"versions"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal([]byte(generateOutput(t, op)), &crd); err != nil {
+		t.Fatal(err)
+	}
+	props := crd.Spec.Versions[0].Schema.OpenAPIV3Schema.Properties
+	tests := map[string]string{
+		"data":  `{"format":"byte","type":"string"}`,
+		"raw":   `{"format":"byte","type":"string"}`,
+		"blobs": `{"items":{"format":"byte","type":"string"},"type":"array"}`,
+		"hash":  `{"items":{"format":"int32","minimum":0,"type":"integer"},"maxItems":4,"minItems":4,"type":"array"}`,
+	}
+	for name, want := range tests {
+		if got := compactJSON(t, props[name]); got != want {
+			t.Errorf("%s is %s, want %s", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"sort"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// deduplicateDefinitions collapses the definitions with the same schema,
+// regardless of their description and title, into the first one by name, and
+// points the refs at it, see DeduplicateDefinitions. The definitions of the
+// starting types are kept, and the first of them wins. Collapsing definitions
+// can make others the same, e.g. the ones referring to them, so it goes on
+// until no definitions are the same.
+func deduplicateDefinitions(defs v1beta1.JSONSchemaDefinitions, startingTypes map[string]bool) error {
+	for {
+		names := make([]string, 0, len(defs))
+		for name := range defs {
+			names = append(names, name)
+		}
+		// The starting types come first, so they are the ones kept.
+		sort.Slice(names, func(i, j int) bool {
+			if startingTypes[names[i]] != startingTypes[names[j]] {
+				return startingTypes[names[i]]
+			}
+			return names[i] < names[j]
+		})
+
+		kept := map[string]string{}
+		replaced := map[string]string{}
+		for _, name := range names {
+			def := defs[name]
+			def.Description, def.Title = "", ""
+			b, err := json.Marshal(def)
+			if err != nil {
+				return err
+			}
+			first, ok := kept[string(b)]
+			switch {
+			case !ok:
+				kept[string(b)] = name
+			case !startingTypes[name]:
+				replaced[name] = first
+			}
+		}
+		if len(replaced) == 0 {
+			return nil
+		}
+
+		for name := range replaced {
+			delete(defs, name)
+		}
+		walkDefinitionMap(defs, "#/definitions", func(_ string, def *v1beta1.JSONSchemaProps) {
+			if def.Ref == nil {
+				return
+			}
+			if first, ok := replaced[getNameFromURL(*def.Ref)]; ok {
+				def.Ref = getDefLink(first)
+			}
+		})
+	}
+}
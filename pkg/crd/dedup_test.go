@@ -0,0 +1,108 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+func TestDeduplicateDefinitions(t *testing.T) {
+	str := v1beta1.JSONSchemaProps{Type: "string"}
+	object := func(description string, props map[string]v1beta1.JSONSchemaProps) v1beta1.JSONSchemaProps {
+		return v1beta1.JSONSchemaProps{Type: "object", Description: description, Properties: props}
+	}
+	tests := []struct {
+		name     string
+		defs     v1beta1.JSONSchemaDefinitions
+		starting []string
+		want     []string
+		refs     map[string]string
+	}{
+		{
+			name: "identical",
+			defs: v1beta1.JSONSchemaDefinitions{
+				"T": object("", map[string]v1beta1.JSONSchemaProps{"a": {Ref: ref("A")}, "b": {Ref: ref("B")}}),
+				"A": object("A doc.", map[string]v1beta1.JSONSchemaProps{"name": str}),
+				"B": object("B doc.", map[string]v1beta1.JSONSchemaProps{"name": str}),
+			},
+			starting: []string{"T"},
+			want:     []string{"A", "T"},
+			refs:     map[string]string{"a": "A", "b": "A"},
+		},
+		{
+			name: "different",
+			defs: v1beta1.JSONSchemaDefinitions{
+				"T": object("", map[string]v1beta1.JSONSchemaProps{"a": {Ref: ref("A")}, "b": {Ref: ref("B")}}),
+				"A": object("", map[string]v1beta1.JSONSchemaProps{"name": str}),
+				"B": object("", map[string]v1beta1.JSONSchemaProps{"id": str}),
+			},
+			starting: []string{"T"},
+			want:     []string{"A", "B", "T"},
+			refs:     map[string]string{"a": "A", "b": "B"},
+		},
+		{
+			name: "starting types",
+			defs: v1beta1.JSONSchemaDefinitions{
+				"T": object("", map[string]v1beta1.JSONSchemaProps{"a": {Ref: ref("U")}}),
+				"U": object("", map[string]v1beta1.JSONSchemaProps{"a": {Ref: ref("U")}}),
+				"Z": object("", map[string]v1beta1.JSONSchemaProps{"name": str}),
+			},
+			starting: []string{"U", "Z"},
+			want:     []string{"U", "Z"},
+			refs:     map[string]string{},
+		},
+		{
+			name: "cascading",
+			defs: v1beta1.JSONSchemaDefinitions{
+				"T": object("", map[string]v1beta1.JSONSchemaProps{"x": {Ref: ref("X")}, "y": {Ref: ref("Y")}}),
+				"X": object("", map[string]v1beta1.JSONSchemaProps{"v": {Ref: ref("A")}}),
+				"Y": object("", map[string]v1beta1.JSONSchemaProps{"v": {Ref: ref("B")}}),
+				"A": object("", map[string]v1beta1.JSONSchemaProps{"name": str}),
+				"B": object("", map[string]v1beta1.JSONSchemaProps{"name": str}),
+			},
+			starting: []string{"T"},
+			want:     []string{"A", "T", "X"},
+			refs:     map[string]string{"x": "X", "y": "X"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			starting := map[string]bool{}
+			for _, name := range tt.starting {
+				starting[name] = true
+			}
+			if err := deduplicateDefinitions(tt.defs, starting); err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for name := range tt.defs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("definitions %v, want %v", names, tt.want)
+			}
+			for prop, want := range tt.refs {
+				if got := tt.defs["T"].Properties[prop].Ref; got == nil || *got != *ref(want) {
+					t.Errorf("%s refers to %v, want %s", prop, got, *ref(want))
+				}
+			}
+		})
+	}
+}
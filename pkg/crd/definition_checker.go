@@ -16,17 +16,61 @@ package crd
 
 import (
 	"fmt"
+	"os"
+	"sort"
+	"strings"
 
 	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 )
 
-func checkDefinitions(defs v1beta1.JSONSchemaDefinitions, startingTypes map[string]bool) {
-	fmt.Printf("Type checking Starting expecting %d types\n", len(defs))
+// checkDefinitions checks that all the definitions are reachable from the
+// starting types. Unreachable definitions and refs to unknown types are an
+// error if strict is set.
+func checkDefinitions(defs v1beta1.JSONSchemaDefinitions, startingTypes map[string]bool, strict bool) error {
+	fmt.Fprintf(os.Stderr, "Type checking Starting expecting %d types\n", len(defs))
 	pruner := DefinitionPruner{defs, startingTypes}
-	newDefs := pruner.Prune(false)
+	newDefs, err := pruner.Prune(!strict)
+	if err != nil {
+		return err
+	}
 	if len(defs) != len(newDefs) {
-		fmt.Printf("Type checking failed. Expected %d actual %d\n", len(defs), len(newDefs))
+		unreachable := strings.Join(unreachableTypes(defs, newDefs), ", ")
+		if strict {
+			return fmt.Errorf("type checking failed, expected %d types, %d are reachable, unreachable types: %s", len(defs), len(newDefs), unreachable)
+		}
+		fmt.Fprintf(os.Stderr, "Type checking failed. Expected %d actual %d\n", len(defs), len(newDefs))
+		fmt.Fprintf(os.Stderr, "Unreachable types: %s\n", unreachable)
 	} else {
-		fmt.Println("Type checking PASSED")
+		fmt.Fprintln(os.Stderr, "Type checking PASSED")
+	}
+	return nil
+}
+
+// unreachableTypes returns the sorted names of the definitions in defs that
+// are not in reachable.
+func unreachableTypes(defs v1beta1.JSONSchemaDefinitions, reachable map[string]bool) []string {
+	var names []string
+	for name := range defs {
+		if !reachable[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkRequestedTypes returns an error listing the requested types that
+// aren't declared in the input packages, e.g. misspelled ones.
+func checkRequestedTypes(defs v1beta1.JSONSchemaDefinitions, types []string) error {
+	var missing []string
+	for _, name := range types {
+		if _, ok := defs[name]; !ok {
+			missing = append(missing, fmt.Sprintf("%q", name))
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("requested types not found in the input packages: %s", strings.Join(missing, ", "))
 	}
+	return nil
 }
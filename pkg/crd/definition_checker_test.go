@@ -0,0 +1,96 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+func ref(name string) *string {
+	ref := "#/definitions/" + name
+	return &ref
+}
+
+func TestCheckDefinitions(t *testing.T) {
+	defs := v1beta1.JSONSchemaDefinitions{
+		"Root": {
+			Type: "object",
+			Properties: map[string]v1beta1.JSONSchemaProps{
+				"child": {Ref: ref("Child")},
+			},
+		},
+		"Child":  {Type: "string"},
+		"Orphan": {Type: "string"},
+	}
+	tests := []struct {
+		name     string
+		starting []string
+		strict   bool
+		wantErr  string
+	}{
+		{name: "all reachable", starting: []string{"Root", "Orphan"}, strict: true},
+		{name: "orphan", starting: []string{"Root"}, strict: true, wantErr: "unreachable types: Orphan"},
+		{name: "orphan not strict", starting: []string{"Root"}},
+		{name: "unknown type", starting: []string{"Root", "Orphan", "Missing"}, strict: true, wantErr: "unknown types: Missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			starting := map[string]bool{}
+			for _, name := range tt.starting {
+				starting[name] = true
+			}
+			err := checkDefinitions(defs, starting, tt.strict)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("checkDefinitions() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("checkDefinitions() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequestedTypes(t *testing.T) {
+	src := "package api\n\ntype Foo struct {\n\tName string `json:\"name\"`\n}\n\ntype Bar struct {\n\tSize int `json:\"size\"`\n}\n"
+	tests := []struct {
+		types   []string
+		wantErr string
+	}{
+		{types: []string{"Foo", "Bar"}},
+		{types: []string{"Foo", "Bra"}, wantErr: `requested types not found in the input packages: "Bra"`},
+		{types: []string{"Fo", "Baz", "Bar"}, wantErr: `requested types not found in the input packages: "Baz", "Fo"`},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.types, ","), func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, tt.types...)
+			_, err := op.GenerateSchema()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("GenerateSchema() = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("GenerateSchema() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -16,6 +16,8 @@ package crd
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 )
@@ -26,9 +28,12 @@ type DefinitionPruner struct {
 	startingTypes map[string]bool
 }
 
-// Prune prunes the definitions
-func (pruner *DefinitionPruner) Prune(ignoreUnknownTypes bool) map[string]bool {
+// Prune returns the names of the definitions reachable from the starting
+// types. Refs to unknown types are skipped if ignoreUnknownTypes is set, an
+// error listing all of them is returned otherwise.
+func (pruner *DefinitionPruner) Prune(ignoreUnknownTypes bool) (map[string]bool, error) {
 	visitedDefs := make(map[string]bool)
+	unknownTypes := make(map[string]bool)
 	queue := make([]string, 0)
 	// Push starting types into queue
 	for typeName := range pruner.startingTypes {
@@ -46,19 +51,46 @@ func (pruner *DefinitionPruner) Prune(ignoreUnknownTypes bool) map[string]bool {
 		// If no definitions present, (probably an external reference)
 		// Skip it
 		if _, exists := pruner.definitions[curType]; !exists {
-			if ignoreUnknownTypes {
-				continue
-			} else {
-				fmt.Println("Unknown type ", curType)
-				panic("Unknown type")
-			}
+			unknownTypes[curType] = true
+			continue
 		}
 		visitedDefs[curType] = true
 		curDef := pruner.definitions[curType]
 		queue = append(queue, processDefinition(&curDef)...)
 	}
 
-	return visitedDefs
+	if len(unknownTypes) > 0 && !ignoreUnknownTypes {
+		names := make([]string, 0, len(unknownTypes))
+		for name := range unknownTypes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown types: %s", strings.Join(names, ", "))
+	}
+	return visitedDefs, nil
+}
+
+// pruneUnreachable removes the definitions of schema its root doesn't refer
+// to, directly or through other definitions. It returns how many were
+// removed.
+func pruneUnreachable(schema *v1beta1.JSONSchemaProps) int {
+	defs := schema.Definitions
+	schema.Definitions = nil
+	roots := map[string]bool{}
+	for _, name := range processDefinition(schema) {
+		roots[name] = true
+	}
+	schema.Definitions = defs
+
+	reachable := getReachableTypes(roots, defs)
+	pruned := 0
+	for name := range defs {
+		if !reachable[name] {
+			delete(defs, name)
+			pruned++
+		}
+	}
+	return pruned
 }
 
 func processDefinition(def *v1beta1.JSONSchemaProps) []string {
@@ -66,7 +98,8 @@ func processDefinition(def *v1beta1.JSONSchemaProps) []string {
 	if def == nil {
 		return allTypes
 	}
-	if def.Ref != nil && len(*def.Ref) > 0 {
+	// The refs to another document aren't to a definition.
+	if def.Ref != nil && getNameFromURL(*def.Ref) != "" {
 		allTypes = append(allTypes, getNameFromURL(*def.Ref))
 	}
 	allTypes = append(allTypes, processDefinitionMap(def.Definitions)...)
@@ -79,6 +112,10 @@ func processDefinition(def *v1beta1.JSONSchemaProps) []string {
 	}
 	if def.Items != nil {
 		allTypes = append(allTypes, processDefinition(def.Items.Schema)...)
+		allTypes = append(allTypes, processDefinitionArray(def.Items.JSONSchemas)...)
+	}
+	if def.AdditionalProperties != nil {
+		allTypes = append(allTypes, processDefinition(def.AdditionalProperties.Schema)...)
 	}
 	allTypes = append(allTypes, processDefinition(def.Not)...)
 	return allTypes
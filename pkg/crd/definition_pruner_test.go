@@ -0,0 +1,149 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+func TestPruneUnknownTypes(t *testing.T) {
+	defs := v1beta1.JSONSchemaDefinitions{
+		"Root": {
+			Type: "object",
+			Properties: map[string]v1beta1.JSONSchemaProps{
+				"child":   {Ref: ref("Child")},
+				"missing": {Ref: ref("Missing")},
+				"items":   {Type: "array", Items: &v1beta1.JSONSchemaPropsOrArray{Schema: &v1beta1.JSONSchemaProps{Ref: ref("Absent")}}},
+			},
+		},
+		"Child": {Type: "string"},
+	}
+	tests := []struct {
+		name   string
+		ignore bool
+		want   map[string]bool
+		err    string
+	}{
+		{name: "ignored", ignore: true, want: map[string]bool{"Root": true, "Child": true}},
+		{name: "strict", err: "unknown types: Absent, Missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pruner := DefinitionPruner{defs, map[string]bool{"Root": true}}
+			got, err := pruner.Prune(tt.ignore)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("Prune() = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Prune() = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Prune() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStrictUnknownTypes(t *testing.T) {
+	src := "package api\n\ntype T struct {\n\tM Missing `json:\"m\"`\n}\n"
+	for _, strict := range []bool{false, true} {
+		op := testGenerator(t, map[string]string{"types.go": src}, "T")
+		op.Strict = strict
+		schema, err := op.GenerateSchema()
+		if strict {
+			if err == nil || !strings.Contains(err.Error(), "Missing") {
+				t.Errorf("strict: GenerateSchema() = %v, want an error about Missing", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("GenerateSchema() = %v", err)
+		}
+		if m := definition(t, schema, "T").Properties["m"]; m.Ref == nil || *m.Ref != "#/definitions/Missing" {
+			t.Errorf("m is %+v, want a ref to Missing", m)
+		}
+	}
+}
+
+func TestPruneUnreachable(t *testing.T) {
+	src := `package api
+
+type A struct {
+	B B ` + "`json:\"b\"`" + `
+	C C ` + "`json:\"c\"`" + `
+}
+
+type B struct {
+	D D ` + "`json:\"d\"`" + `
+}
+
+type C struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+
+type D struct {
+	Size int ` + "`json:\"size\"`" + `
+}
+`
+	// dropB removes the property of A referring to B, leaving B and D
+	// unreachable.
+	dropB := func(schema *v1beta1.JSONSchemaProps) error {
+		a := schema.Definitions["A"]
+		delete(a.Properties, "b")
+		schema.Definitions["A"] = a
+		return nil
+	}
+	tests := []struct {
+		name      string
+		prune     bool
+		transform bool
+		want      string
+	}{
+		{name: "kept", want: "A,B,C,D"},
+		{name: "nothing unreachable", prune: true, want: "A,B,C,D"},
+		{name: "transformed", transform: true, want: "A,B,C,D"},
+		{name: "pruned", prune: true, transform: true, want: "A,C"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, "A")
+			op.Flatten = true
+			op.PruneUnreachable = tt.prune
+			if tt.transform {
+				op.Transforms = []func(*v1beta1.JSONSchemaProps) error{dropB}
+			}
+			schema, err := op.GenerateSchema()
+			if err != nil {
+				t.Fatalf("GenerateSchema() = %v", err)
+			}
+			var names []string
+			for name := range schema.Definitions {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if got := strings.Join(names, ","); got != tt.want {
+				t.Errorf("the definitions are %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// deprecatedMarker starts the paragraph of a doc comment telling a type is
+// deprecated, like the Go convention.
+const deprecatedMarker = "Deprecated:"
+
+// propagateDeprecation adds a note to the description of the properties whose
+// type is a deprecated definition, directly or as the items or values of
+// their slices and maps.
+func propagateDeprecation(defs v1beta1.JSONSchemaDefinitions) {
+	deprecated := map[string]bool{}
+	for name, def := range defs {
+		if isDeprecated(def.Description) {
+			deprecated[name] = true
+		}
+	}
+	if len(deprecated) == 0 {
+		return
+	}
+
+	annotate := func(def *v1beta1.JSONSchemaProps) {
+		for key, prop := range def.Properties {
+			name := referencedDefinition(&prop)
+			if !deprecated[name] {
+				continue
+			}
+			_, typeName := splitFullName(name)
+			note := "Uses the deprecated type " + typeName + "."
+			// Maps may be shared between definitions, don't add the note twice.
+			if strings.Contains(prop.Description, note) {
+				continue
+			}
+			prop.Description = strings.TrimSpace(prop.Description + " " + note)
+			def.Properties[key] = prop
+		}
+	}
+	for name := range defs {
+		def := defs[name]
+		walkDefinition(&def, annotate)
+		defs[name] = def
+	}
+}
+
+// referencedDefinition returns the name of the definition def refers to,
+// looking through the items of arrays and the values of maps.
+func referencedDefinition(def *v1beta1.JSONSchemaProps) string {
+	switch {
+	case def == nil:
+		return ""
+	case def.Ref != nil && len(*def.Ref) > 0:
+		return getNameFromURL(*def.Ref)
+	case def.Items != nil:
+		return referencedDefinition(def.Items.Schema)
+	case def.AdditionalProperties != nil:
+		return referencedDefinition(def.AdditionalProperties.Schema)
+	}
+	return ""
+}
+
+// isDeprecated tells if a description has the Deprecated: paragraph of a doc
+// comment.
+func isDeprecated(description string) bool {
+	return strings.Contains(description, deprecatedMarker)
+}
+
+// hasDeprecatedProperties tells if a property of schema, or of a schema nested
+// in it, is a field with a Deprecated: paragraph in its doc.
+func hasDeprecatedProperties(schema *v1beta1.JSONSchemaProps) bool {
+	found := false
+	walkDefinition(schema, func(def *v1beta1.JSONSchemaProps) {
+		for _, prop := range def.Properties {
+			found = found || isDeprecated(prop.Description)
+		}
+	})
+	return found
+}
+
+// deprecatedProperties marks the deprecated properties of the generic JSON
+// form of a schema with keyword, i.e. deprecated from 2019-09 and in an
+// OpenAPI 3 document, and x-deprecated before. Their description already
+// holds the deprecation note of their doc. groups are the entries of the
+// definitions holding the definitions of a package, see
+// NamespaceDefinitions.
+func deprecatedProperties(generic map[string]interface{}, groups map[string]bool, keyword string) {
+	walkGeneric(generic, groups, func(schema map[string]interface{}) {
+		props, _ := schema["properties"].(map[string]interface{})
+		for _, prop := range props {
+			p, ok := prop.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if description, _ := p["description"].(string); isDeprecated(description) {
+				p[keyword] = true
+			}
+		}
+	})
+}
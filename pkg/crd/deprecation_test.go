@@ -0,0 +1,122 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPropagateDeprecation(t *testing.T) {
+	src := `package api
+
+// Old is the former spec.
+//
+// Deprecated: use New.
+type Old struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+
+// New is the spec.
+type New struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+
+type T struct {
+	// A is a spec.
+	A Old ` + "`json:\"a\"`" + `
+	B []Old ` + "`json:\"b\"`" + `
+	C map[string]*Old ` + "`json:\"c\"`" + `
+	D New ` + "`json:\"d\"`" + `
+}
+`
+	const note = "Uses the deprecated type Old."
+	tests := []struct {
+		property string
+		want     bool
+	}{
+		{property: "a", want: true},
+		{property: "b", want: true},
+		{property: "c", want: true},
+		{property: "d", want: false},
+	}
+	for _, propagate := range []bool{false, true} {
+		op := testGenerator(t, map[string]string{"types.go": src}, "T")
+		op.Flatten = true
+		op.PropagateDeprecation = propagate
+		def := generateDefinition(t, op, "T")
+		for _, tt := range tests {
+			desc := def.Properties[tt.property].Description
+			if got := strings.Contains(desc, note); got != (tt.want && propagate) {
+				t.Errorf("propagate %v: %s has the description %q, want the note %v", propagate, tt.property, desc, tt.want && propagate)
+			}
+		}
+		if desc := def.Properties["a"].Description; !strings.HasPrefix(desc, "A is a spec.") || strings.Count(desc, note) > 1 {
+			t.Errorf("propagate %v: a has the description %q, want its doc first and the note at most once", propagate, desc)
+		}
+	}
+}
+
+func TestDeprecatedFields(t *testing.T) {
+	src := `package api
+
+type T struct {
+	// Old is the former name.
+	//
+	// Deprecated: use Name.
+	Old string ` + "`json:\"old\"`" + `
+	// Name is the name.
+	Name string ` + "`json:\"name\"`" + `
+}
+`
+	tests := []struct {
+		version string
+		format  string
+		keyword string
+	}{
+		{version: SchemaVersionDraft04, keyword: "x-deprecated"},
+		{version: SchemaVersionDraft07, keyword: "x-deprecated"},
+		{version: SchemaVersion201909, keyword: "deprecated"},
+		{version: SchemaVersion202012, keyword: "deprecated"},
+		{format: openAPI3Format, keyword: "deprecated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version+tt.format, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.Flatten = true
+			op.SchemaVersion = tt.version
+			op.OutputFormat = tt.format
+			root := "#/definitions/T/properties/"
+			switch {
+			case tt.format == openAPI3Format:
+				root = "#/components/schemas/T/properties/"
+			case schemaVersionAtLeast(tt.version, SchemaVersion201909):
+				root = "#/$defs/T/properties/"
+			}
+			generic := generateGeneric(t, op)
+			old, _ := resolveRef(generic, root+"old").(map[string]interface{})
+			if old[tt.keyword] != true {
+				t.Errorf("old is %v, want %s true", old, tt.keyword)
+			}
+			if desc, _ := old["description"].(string); !strings.Contains(desc, "Deprecated: use Name.") {
+				t.Errorf("old has the description %q, want the deprecation note", desc)
+			}
+			name, _ := resolveRef(generic, root+"name").(map[string]interface{})
+			if _, ok := name[tt.keyword]; ok || name == nil {
+				t.Errorf("name is %v, want it without %s", name, tt.keyword)
+			}
+		})
+	}
+}
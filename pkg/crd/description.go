@@ -0,0 +1,54 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// ellipsis ends the truncated descriptions, see MaxDescriptionLength.
+const ellipsis = "..."
+
+// truncateDescriptions shortens the descriptions of schema, and of the
+// schemas nested in it, longer than max characters, see truncateDescription.
+func truncateDescriptions(schema *v1beta1.JSONSchemaProps, max int) {
+	walkDefinition(schema, func(def *v1beta1.JSONSchemaProps) {
+		def.Description = truncateDescription(def.Description, max)
+	})
+}
+
+// truncateDescription returns description cut at the last word boundary that
+// leaves room for the ellipsis within max characters. A description without
+// any is cut in the middle of its first word.
+func truncateDescription(description string, max int) string {
+	runes := []rune(description)
+	if len(runes) <= max {
+		return description
+	}
+	if max <= len(ellipsis) {
+		return string(runes[:max])
+	}
+	cut := string(runes[:max-len(ellipsis)])
+	// The boundary must be in the cut part, a space right after it is one
+	// too.
+	if runes[max-len(ellipsis)] != ' ' {
+		if i := strings.LastIndexAny(cut, " \t\n"); i > 0 {
+			cut = cut[:i]
+		}
+	}
+	return strings.TrimRight(cut, " \t\n.,;:") + ellipsis
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTruncateDescription(t *testing.T) {
+	tests := []struct {
+		description string
+		max         int
+		want        string
+	}{
+		{"Short enough.", 20, "Short enough."},
+		{"The size of the widget in bytes.", 20, "The size of the..."},
+		{"The size, of the widget.", 12, "The size..."},
+		{"The sizes of it.", 12, "The sizes..."},
+		{"Unbreakable", 8, "Unbre..."},
+		{"Widget", 3, "Wid"},
+		{"Ünïcödé wörds hère.", 16, "Ünïcödé wörds..."},
+		{"Anything", 0, ""},
+	}
+	for _, tt := range tests {
+		got := truncateDescription(tt.description, tt.max)
+		if got != tt.want {
+			t.Errorf("truncateDescription(%q, %d) = %q, want %q", tt.description, tt.max, got, tt.want)
+		}
+		if n := len([]rune(got)); n > tt.max && tt.max > 0 {
+			t.Errorf("truncateDescription(%q, %d) is %d characters long", tt.description, tt.max, n)
+		}
+	}
+}
+
+func TestMaxDescriptionLength(t *testing.T) {
+	src := `// +groupName=example.com
+package api
+
+// Part is a part of a widget, with a rather long description.
+type Part struct {
+	// Size is the size of the part in bytes.
+	Size int ` + "`json:\"size\"`" + `
+}
+
+// Widget is a widget made of many parts.
+// +kubebuilder:resource:path=widgets
+type Widget struct {
+	// Parts are the parts of the widget, in order.
+	Parts []Part ` + "`json:\"parts\"`" + `
+	// Main is the main part of the widget.
+	Main *Part ` + "`json:\"main\"`" + `
+}
+`
+	for _, outputCRD := range []bool{false, true} {
+		op := testGenerator(t, map[string]string{"types.go": src}, "Widget")
+		op.outputCRD = outputCRD
+		op.MaxDescriptionLength = 20
+		out := generateOutput(t, op)
+		for _, want := range []string{"Widget is a...", "Parts are the...", "Main is the main..."} {
+			if !strings.Contains(out, want) {
+				t.Errorf("CRD %v: the output doesn't hold %q:\n%s", outputCRD, want, out)
+			}
+		}
+		if strings.Contains(out, "in bytes") || strings.Contains(out, "in order") {
+			t.Errorf("CRD %v: the output holds a whole long description:\n%s", outputCRD, out)
+		}
+	}
+}
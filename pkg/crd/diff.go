@@ -0,0 +1,191 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// diffContext is how many unchanged lines are shown around the changed ones.
+const diffContext = 2
+
+// Diff generates the output like Generate and compares it with the file at
+// existingPath, e.g. the schema committed along the Go types to catch a
+// forgotten regeneration. It is read from the standard input for StdoutPath.
+// The CRDs written along the schema are compared with the file at
+// CRDOutputPath too. It returns whether they are the same, and their
+// differences line by line otherwise. Nothing is written. The output split
+// in several files can't be compared.
+func (op *SingleVersionGenerator) Diff(existingPath string) (bool, string, error) {
+	if op.SplitOutput || len(op.BuildTagSets) > 0 {
+		return false, "", fmt.Errorf("the output written to several files can't be compared")
+	}
+	withCRDs := len(op.CRDOutputPath) > 0 && !op.outputCRD
+	if withCRDs && existingPath == StdoutPath && op.CRDOutputPath == StdoutPath {
+		return false, "", fmt.Errorf("the schema and the CRDs can't both be read from the standard input")
+	}
+	existing, err := readOutput(existingPath)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to read the existing output: %v", err)
+	}
+	var existingCRDs []byte
+	if withCRDs {
+		if existingCRDs, err = readOutput(op.CRDOutputPath); err != nil {
+			return false, "", fmt.Errorf("failed to read the existing CRDs: %v", err)
+		}
+	}
+	schema, err := op.GenerateSchema()
+	if err != nil {
+		return false, "", err
+	}
+	docs, _, format, err := op.documents(op.outputCRD, schema)
+	if err != nil {
+		return false, "", err
+	}
+	diff := outputDiff(existingPath, existing, op.encodeDocuments(docs, format))
+	if withCRDs {
+		crds, err := op.crdWriter()
+		if err != nil {
+			return false, "", err
+		}
+		docs, _, format, err := crds.documents(true, nil)
+		if err != nil {
+			return false, "", err
+		}
+		diff += outputDiff(op.CRDOutputPath, existingCRDs, crds.encodeDocuments(docs, format))
+	}
+	return diff == "", diff, nil
+}
+
+// readOutput reads the output written to path, or the standard input for
+// StdoutPath.
+func readOutput(path string) ([]byte, error) {
+	if path == StdoutPath {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// outputDiff returns the differences between the existing output at path and
+// the generated one, or "" when they are the same.
+func outputDiff(path string, existing, generated []byte) string {
+	if bytes.Equal(existing, generated) {
+		return ""
+	}
+	return fmt.Sprintf("--- %s\n+++ generated\n%s", path, lineDiff(string(existing), string(generated)))
+}
+
+// diffLine is a line of a diff, kept, removed or added.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// lineDiff returns the lines to remove from a and to add to get b, prefixed
+// with - and +, between the unchanged lines around them. The lines skipped in
+// between are replaced by "...".
+func lineDiff(a, b string) string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	// The common start and end, most of the lines, are left out of the
+	// longest common subsequence, which takes len(x)*len(y) memory.
+	var prefix, suffix int
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	var lines []diffLine
+	for _, text := range x[:prefix] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	lines = append(lines, changedLines(x[prefix:len(x)-suffix], y[prefix:len(y)-suffix])...)
+	for _, text := range x[len(x)-suffix:] {
+		lines = append(lines, diffLine{' ', text})
+	}
+
+	var diff strings.Builder
+	last := -1
+	for i, line := range lines {
+		if line.op == ' ' && !nearChange(lines, i) {
+			continue
+		}
+		if last >= 0 && i > last+1 {
+			diff.WriteString("...\n")
+		}
+		diff.WriteByte(line.op)
+		diff.WriteString(line.text)
+		diff.WriteByte('\n')
+		last = i
+	}
+	return diff.String()
+}
+
+// changedLines returns the lines of x and y in the order of their longest
+// common subsequence, the ones out of it removed from x or added from y.
+func changedLines(x, y []string) []diffLine {
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and
+	// y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			switch {
+			case x[i] == y[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			lines = append(lines, diffLine{' ', x[i]})
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', x[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', y[j]})
+			j++
+		}
+	}
+	return lines
+}
+
+// nearChange tells if a line removed or added is at most diffContext lines
+// away from the line i.
+func nearChange(lines []diffLine, i int) bool {
+	for j := i - diffContext; j <= i+diffContext; j++ {
+		if j >= 0 && j < len(lines) && lines[j].op != ' ' {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const widgetSource = `// +groupName=example.com
+package api
+
+// +kubebuilder:resource:path=widgets
+type Widget struct {
+	Size int ` + "`json:\"size\"`" + `
+}
+`
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  func(generated string) string
+		crds    func(generated string) string
+		want    bool
+		wantErr string
+		diff    []string
+	}{
+		{name: "same", want: true},
+		{
+			name:   "schema changed",
+			schema: func(s string) string { return strings.Replace(s, `"size"`, `"length"`, 1) },
+			diff:   []string{"schema.json", `+        "size"`},
+		},
+		{
+			name: "CRDs changed",
+			crds: func(s string) string { return strings.Replace(s, "plural: widgets", "plural: gadgets", 1) },
+			diff: []string{"crds.yaml", "-    plural: gadgets", "+    plural: widgets"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			op := testGenerator(t, map[string]string{"types.go": widgetSource}, "Widget")
+			op.OutputPath = filepath.Join(dir, "schema.json")
+			op.CRDOutputPath = filepath.Join(dir, "crds.yaml")
+			if err := op.GenerateContext(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			for path, edit := range map[string]func(string) string{op.OutputPath: tt.schema, op.CRDOutputPath: tt.crds} {
+				if edit == nil {
+					continue
+				}
+				b, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(path, []byte(edit(string(b))), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			check := testGenerator(t, map[string]string{"types.go": widgetSource}, "Widget")
+			check.OutputPath, check.CRDOutputPath = op.OutputPath, op.CRDOutputPath
+			same, diff, err := check.Diff(check.OutputPath)
+			if err != nil {
+				t.Fatalf("Diff() = %v", err)
+			}
+			if same != tt.want {
+				t.Errorf("Diff() = %v, want %v, with the diff\n%s", same, tt.want, diff)
+			}
+			for _, line := range tt.diff {
+				if !strings.Contains(diff, line) {
+					t.Errorf("the diff doesn't contain %q:\n%s", line, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffStandardInputs(t *testing.T) {
+	op := testGenerator(t, map[string]string{"types.go": widgetSource}, "Widget")
+	op.OutputPath, op.CRDOutputPath = StdoutPath, StdoutPath
+	if _, _, err := op.Diff(op.OutputPath); err == nil {
+		t.Error("Diff() = nil, want an error reading both outputs from the standard input")
+	}
+}
+
+func TestReadOutput(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+	go func() {
+		w.WriteString(`{"type":"object"}`)
+		w.Close()
+	}()
+	got, err := readOutput(StdoutPath)
+	if err != nil || string(got) != `{"type":"object"}` {
+		t.Errorf("readOutput(%q) = %q, %v, want the standard input", StdoutPath, got, err)
+	}
+}
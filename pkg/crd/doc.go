@@ -0,0 +1,31 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package crd generates the JSON schema of Go types, or the CRDs of
+// Kubernetes API types, from the source of their packages. It holds all the
+// logic of the go-types-to-json command, which only sets the options from its
+// flags.
+//
+// A SingleVersionGenerator writes the schema of the requested types, or
+// returns it with GenerateSchema:
+//
+//	op := &crd.SingleVersionGenerator{}
+//	op.InputPackage = "example.com/apis/v1"
+//	op.Types = []string{"Person"}
+//	schema, err := op.GenerateSchema()
+//
+// A MultiVersionGenerator writes the CRDs of the types of several version
+// packages. Validate checks an instance against a written schema or CRD, and
+// LintSchema finds the likely mistakes of a schema.
+package crd
@@ -0,0 +1,198 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+const (
+	// SchemaVersionDraft04 targets JSON schema draft-04.
+	SchemaVersionDraft04 = "draft-04"
+	// SchemaVersionDraft06 targets JSON schema draft-06.
+	SchemaVersionDraft06 = "draft-06"
+	// SchemaVersionDraft07 targets JSON schema draft-07.
+	SchemaVersionDraft07 = "draft-07"
+	// SchemaVersion201909 targets JSON schema 2019-09.
+	SchemaVersion201909 = "2019-09"
+	// SchemaVersion202012 targets JSON schema 2020-12.
+	SchemaVersion202012 = "2020-12"
+)
+
+// schemaURIs are the $schema of the supported schema versions.
+var schemaURIs = map[string]string{
+	SchemaVersionDraft04: "http://json-schema.org/draft-04/schema#",
+	SchemaVersionDraft06: "http://json-schema.org/draft-06/schema#",
+	SchemaVersionDraft07: "http://json-schema.org/draft-07/schema#",
+	SchemaVersion201909:  "https://json-schema.org/draft/2019-09/schema",
+	SchemaVersion202012:  "https://json-schema.org/draft/2020-12/schema",
+}
+
+// schemaURI returns the $schema of version. The empty version is draft-04.
+func schemaURI(version string) string {
+	if version == "" {
+		version = SchemaVersionDraft04
+	}
+	return schemaURIs[version]
+}
+
+// schemaVersions are the supported schema versions, oldest first.
+var schemaVersions = []string{SchemaVersionDraft04, SchemaVersionDraft06, SchemaVersionDraft07, SchemaVersion201909, SchemaVersion202012}
+
+// checkSchemaVersion returns an error for an unknown schema version.
+func checkSchemaVersion(version string) error {
+	if version == "" {
+		return nil
+	}
+	for _, v := range schemaVersions {
+		if v == version {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown schema version %q, must be one of %s", version, strings.Join(schemaVersions, ", "))
+}
+
+// schemaVersionAtLeast tells if version is min or a later one. The empty
+// version is draft-04.
+func schemaVersionAtLeast(version, min string) bool {
+	if version == "" {
+		version = SchemaVersionDraft04
+	}
+	for _, v := range schemaVersions {
+		switch v {
+		case min:
+			return true
+		case version:
+			return false
+		}
+	}
+	return false
+}
+
+// closedMarker closes a struct type, so the properties its Go type doesn't
+// have are rejected. +schemagen:closed=false keeps it open with
+// DisallowUnknownFields.
+const closedMarker = "schemagen:closed"
+
+// closeStruct sets the additionalProperties of def, the schema of the struct
+// type typeName, from the value of its closed marker. Like in closeObjects,
+// an object composed with allOf can't be closed by additionalProperties, it
+// is only closed once flattened.
+func closeStruct(def *v1beta1.JSONSchemaProps, typeName, value string, flatten bool, report *coverageReport) error {
+	switch value {
+	case "", "true":
+		if len(def.AllOf) > 0 && !flatten {
+			report.warnf("%s is composed with allOf, it can only be closed in a flattened schema", typeName)
+			return nil
+		}
+		def.AdditionalProperties = &v1beta1.JSONSchemaPropsOrBool{Allows: false}
+	case "false":
+		def.AdditionalProperties = &v1beta1.JSONSchemaPropsOrBool{Allows: true}
+	default:
+		return fmt.Errorf("+%s=%s must be either true or false", closedMarker, value)
+	}
+	return nil
+}
+
+// closeObjects sets additionalProperties to false on the objects with
+// properties, see DisallowUnknownFields. additionalProperties doesn't see the
+// properties of the allOf members, so the objects composed with allOf are
+// left open here; they are closed with unevaluatedProperties when the schema
+// is written if unevaluated is set, and a warning is logged otherwise. The
+// allOf members are left open too, as closing them would reject the
+// properties of the objects composed with them.
+func closeObjects(defs v1beta1.JSONSchemaDefinitions, unevaluated bool, report *coverageReport) {
+	members := map[string]bool{}
+	walkDefinitionMap(defs, "#/definitions", func(path string, def *v1beta1.JSONSchemaProps) {
+		if def.Ref != nil && isAllOfMember(path) {
+			members[getNameFromURL(*def.Ref)] = true
+		}
+	})
+
+	walkDefinitionMap(defs, "#/definitions", func(path string, def *v1beta1.JSONSchemaProps) {
+		if def.Type != "object" || def.AdditionalProperties != nil || isAllOfMember(path) {
+			return
+		}
+		if name := strings.TrimPrefix(path, "#/definitions/"); members[name] {
+			return
+		}
+		if len(def.AllOf) > 0 {
+			if !unevaluated {
+				report.warnf("%s is composed with allOf, it can only be closed from schema version %s", path, SchemaVersion201909)
+			}
+			return
+		}
+		if len(def.Properties) > 0 {
+			def.AdditionalProperties = &v1beta1.JSONSchemaPropsOrBool{Allows: false}
+		}
+	})
+}
+
+// isAllOfMember tells if the schema at path is a member of an allOf.
+func isAllOfMember(path string) bool {
+	i := strings.LastIndex(path, "/")
+	return i >= 0 && strings.HasSuffix(path[:i], "/allOf")
+}
+
+// addUnevaluatedProperties closes the objects composed with allOf of the
+// generic JSON form of a schema with unevaluatedProperties, which unlike
+// additionalProperties sees the properties of the allOf members. groups are
+// the entries of the definitions holding the definitions of a package, see
+// NamespaceDefinitions.
+func addUnevaluatedProperties(generic map[string]interface{}, groups map[string]bool) {
+	walkGeneric(generic, groups, func(schema map[string]interface{}) {
+		if _, ok := schema["allOf"]; !ok || schema["type"] != "object" {
+			return
+		}
+		if _, ok := schema["additionalProperties"]; ok {
+			return
+		}
+		schema["unevaluatedProperties"] = false
+	})
+}
+
+// numericExclusiveBounds turns the boolean exclusiveMaximum and
+// exclusiveMinimum of draft-04 into the numeric bounds of draft-06 and later
+// in the generic JSON form of a schema, e.g. maximum 5 with exclusiveMaximum
+// true becomes exclusiveMaximum 5. groups are the entries of the definitions
+// holding the definitions of a package, see NamespaceDefinitions.
+func numericExclusiveBounds(generic map[string]interface{}, groups map[string]bool) {
+	walkGeneric(generic, groups, func(schema map[string]interface{}) {
+		for exclusive, bound := range map[string]string{"exclusiveMaximum": "maximum", "exclusiveMinimum": "minimum"} {
+			if b, ok := schema[exclusive].(bool); ok {
+				delete(schema, exclusive)
+				if value, ok := schema[bound]; ok && b {
+					schema[exclusive] = value
+					delete(schema, bound)
+				}
+			}
+		}
+	})
+}
+
+// useDefs moves the definitions of the generic JSON form of a schema to $defs,
+// their keyword from schema version 2019-09, and points the refs at them.
+// groups are the entries of the definitions holding the definitions of a
+// package, see NamespaceDefinitions.
+func useDefs(generic map[string]interface{}, groups map[string]bool) {
+	rebaseRefs(generic, groups, defsPrefix)
+	if defs, ok := generic["definitions"]; ok {
+		generic["$defs"] = defs
+		delete(generic, "definitions")
+	}
+}
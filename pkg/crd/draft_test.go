@@ -0,0 +1,220 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// generateGeneric returns the output of op in its generic JSON form.
+func generateGeneric(t *testing.T, op *SingleVersionGenerator) map[string]interface{} {
+	t.Helper()
+	var generic map[string]interface{}
+	if err := json.Unmarshal([]byte(generateOutput(t, op)), &generic); err != nil {
+		t.Fatal(err)
+	}
+	return generic
+}
+
+func TestExclusiveBounds(t *testing.T) {
+	src := `package api
+
+type T struct {
+	// +kubebuilder:validation:Maximum=10
+	// +kubebuilder:validation:ExclusiveMaximum=true
+	A float64 ` + "`json:\"a\"`" + `
+	// +kubebuilder:validation:ExclusiveMinimum=0
+	B float64 ` + "`json:\"b\"`" + `
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:ExclusiveMinimum=false
+	C float64 ` + "`json:\"c\"`" + `
+}
+`
+	draft04 := map[string]string{
+		"a": `{"exclusiveMaximum":true,"format":"double","maximum":10,"type":"number"}`,
+		"b": `{"exclusiveMinimum":true,"format":"double","minimum":0,"type":"number"}`,
+		"c": `{"format":"double","minimum":1,"type":"number"}`,
+	}
+	numeric := map[string]string{
+		"a": `{"exclusiveMaximum":10,"format":"double","type":"number"}`,
+		"b": `{"exclusiveMinimum":0,"format":"double","type":"number"}`,
+		"c": `{"format":"double","minimum":1,"type":"number"}`,
+	}
+	tests := []struct {
+		version string
+		defs    string
+		want    map[string]string
+	}{
+		{version: "", defs: "definitions", want: draft04},
+		{version: SchemaVersionDraft04, defs: "definitions", want: draft04},
+		{version: SchemaVersionDraft06, defs: "definitions", want: numeric},
+		{version: SchemaVersion202012, defs: "$defs", want: numeric},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.Flatten = true
+			op.SchemaVersion = tt.version
+			props := resolveRef(generateGeneric(t, op), "#/"+tt.defs+"/T/properties")
+			for name, want := range tt.want {
+				if got := compactJSON(t, resolveRef(props, "#/"+name)); got != want {
+					t.Errorf("%s is %s, want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	src := "package api\n\ntype T struct {\n\tU U `json:\"u\"`\n}\n\ntype U struct {\n\tName string `json:\"name\"`\n}\n"
+	tests := []struct {
+		version string
+		schema  string
+		defs    string
+	}{
+		{version: "", schema: "http://json-schema.org/draft-04/schema#", defs: "definitions"},
+		{version: SchemaVersionDraft04, schema: "http://json-schema.org/draft-04/schema#", defs: "definitions"},
+		{version: SchemaVersionDraft07, schema: "http://json-schema.org/draft-07/schema#", defs: "definitions"},
+		{version: SchemaVersion201909, schema: "https://json-schema.org/draft/2019-09/schema", defs: "$defs"},
+		{version: SchemaVersion202012, schema: "https://json-schema.org/draft/2020-12/schema", defs: "$defs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.Flatten = true
+			op.SchemaVersion = tt.version
+			generic := generateGeneric(t, op)
+			if got := generic["$schema"]; got != tt.schema {
+				t.Errorf("$schema is %v, want %s", got, tt.schema)
+			}
+			if got, want := resolveRef(generic, "#/"+tt.defs+"/T/properties/u/$ref"), "#/"+tt.defs+"/U"; got != want {
+				t.Errorf("u refers to %v, want %s", got, want)
+			}
+		})
+	}
+	op := testGenerator(t, map[string]string{"types.go": src}, "T")
+	op.SchemaVersion = "draft-05"
+	if _, err := op.GenerateSchema(); err == nil {
+		t.Error("GenerateSchema() = nil, want an unknown schema version error")
+	}
+}
+
+func TestClosedStructs(t *testing.T) {
+	tests := []struct {
+		name     string
+		marker   string
+		disallow bool
+		want     string
+		wantErr  bool
+	}{
+		{name: "open", want: `{"type":"object","properties":{"labels":{"type":"object","additionalProperties":{"type":"string"}},"name":{"type":"string"}}}`},
+		{name: "disallow", disallow: true, want: `{"type":"object","properties":{"labels":{"type":"object","additionalProperties":{"type":"string"}},"name":{"type":"string"}},"additionalProperties":false}`},
+		{name: "marker", marker: "// +schemagen:closed\n", want: `{"type":"object","properties":{"labels":{"type":"object","additionalProperties":{"type":"string"}},"name":{"type":"string"}},"additionalProperties":false}`},
+		{name: "marker true", marker: "// +schemagen:closed=true\n", want: `{"type":"object","properties":{"labels":{"type":"object","additionalProperties":{"type":"string"}},"name":{"type":"string"}},"additionalProperties":false}`},
+		{name: "kept open", marker: "// +schemagen:closed=false\n", disallow: true, want: `{"type":"object","properties":{"labels":{"type":"object","additionalProperties":{"type":"string"}},"name":{"type":"string"}},"additionalProperties":true}`},
+		{name: "invalid", marker: "// +schemagen:closed=maybe\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package api\n\n" + tt.marker + "type T struct {\n\tName string `json:\"name,omitempty\"`\n\tLabels map[string]string `json:\"labels,omitempty\"`\n}\n"
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.Flatten = true
+			op.DisallowUnknownFields = tt.disallow
+			schema, err := op.GenerateSchema()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("GenerateSchema() = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateSchema() = %v", err)
+			}
+			b, err := json.Marshal(definition(t, schema, "T"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The map stays open, only the struct is closed.
+			if string(b) != tt.want {
+				t.Errorf("T is %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnevaluatedProperties(t *testing.T) {
+	ref := func(name string) v1beta1.JSONSchemaProps {
+		r := "#/definitions/" + name
+		return v1beta1.JSONSchemaProps{Ref: &r}
+	}
+	object := func() v1beta1.JSONSchemaProps {
+		return v1beta1.JSONSchemaProps{Type: "object", Properties: map[string]v1beta1.JSONSchemaProps{"name": {Type: "string"}}}
+	}
+	for _, unevaluated := range []bool{false, true} {
+		composed := object()
+		composed.AllOf = []v1beta1.JSONSchemaProps{ref("Base")}
+		defs := v1beta1.JSONSchemaDefinitions{"Base": object(), "Composed": composed, "Plain": object()}
+		report := &coverageReport{}
+		closeObjects(defs, unevaluated, report)
+		// Composed is closed when written, Base would reject its properties.
+		for name, closed := range map[string]bool{"Base": false, "Composed": false, "Plain": true} {
+			if got := defs[name].AdditionalProperties != nil && !defs[name].AdditionalProperties.Allows; got != closed {
+				t.Errorf("unevaluated %v: %s closed %v, want %v", unevaluated, name, got, closed)
+			}
+		}
+		warned := len(report.warnings) == 1 && strings.Contains(report.warnings[0], "#/definitions/Composed is composed with allOf")
+		if warned == unevaluated || len(report.warnings) > 1 {
+			t.Errorf("unevaluated %v: warnings %q", unevaluated, report.warnings)
+		}
+	}
+
+	// The allOf compositions of the types are flattened, a transform adds
+	// one.
+	compose := func(schema *v1beta1.JSONSchemaProps) error {
+		schema.Definitions["Composed"] = v1beta1.JSONSchemaProps{Type: "object", AllOf: []v1beta1.JSONSchemaProps{ref("Widget")}}
+		return nil
+	}
+	tests := []struct {
+		version  string
+		disallow bool
+		want     interface{}
+	}{
+		{version: SchemaVersion201909, disallow: true, want: false},
+		{version: SchemaVersion202012, disallow: true, want: false},
+		{version: SchemaVersionDraft07, disallow: true},
+		{version: SchemaVersion201909},
+	}
+	for _, tt := range tests {
+		src := "package api\n\ntype Widget struct {\n\tSize int `json:\"size\"`\n}\n"
+		op := testGenerator(t, map[string]string{"types.go": src}, "Widget")
+		op.Flatten = true
+		op.SchemaVersion = tt.version
+		op.DisallowUnknownFields = tt.disallow
+		op.Transforms = []func(*v1beta1.JSONSchemaProps) error{compose}
+		generic := generateGeneric(t, op)
+		defsKey := "definitions"
+		if schemaVersionAtLeast(tt.version, SchemaVersion201909) {
+			defsKey = "$defs"
+		}
+		composed, _ := resolveRef(generic, "#/"+defsKey+"/Composed").(map[string]interface{})
+		if got := composed["unevaluatedProperties"]; got != tt.want {
+			t.Errorf("version %q, disallow %v: unevaluatedProperties %v, want %v", tt.version, tt.disallow, got, tt.want)
+		}
+	}
+}
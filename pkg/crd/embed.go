@@ -15,70 +15,188 @@
 package crd
 
 import (
-	"log"
+	"fmt"
+	"reflect"
 	"strings"
 
 	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 )
 
-func embedSchema(defs map[string]v1beta1.JSONSchemaProps, startingTypes map[string]bool) map[string]v1beta1.JSONSchemaProps {
+// embedSchema returns the definitions of the starting types with the refs
+// replaced by the definitions they point at. A ref that would be replaced
+// again inside its own definition, in a cycle, is kept, and the definition it
+// points at is returned too, embedded the same way. Refs nested deeper than
+// maxDepth are an error.
+func embedSchema(defs map[string]v1beta1.JSONSchemaProps, startingTypes map[string]bool, maxDepth int) (map[string]v1beta1.JSONSchemaProps, error) {
 	newDefs := map[string]v1beta1.JSONSchemaProps{}
+	var queue []string
 	for name := range startingTypes {
-		def := defs[name]
-		embedDefinition(&def, defs)
+		queue = append(queue, name)
+	}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if _, ok := newDefs[name]; ok {
+			continue
+		}
+		src, ok := defs[name]
+		if !ok {
+			continue
+		}
+		// The nested schemas are embedded in place, they mustn't be shared
+		// with defs, which the other definitions are embedded from.
+		def := *src.DeepCopy()
+		if err := embedDefinition(&def, defs, []string{name}, maxDepth); err != nil {
+			return nil, err
+		}
 		newDefs[name] = def
+		queue = append(queue, processDefinition(&def)...)
+	}
+	return newDefs, nil
+}
+
+// inlineRoot replaces the refs of the root schema to the requested types with
+// their definitions, see Inline. A single type becomes the root schema. Only
+// the definitions still referred to, the ones of a cycle, are kept.
+func inlineRoot(schema *v1beta1.JSONSchemaProps) {
+	defs := schema.Definitions
+	for i, def := range schema.AnyOf {
+		if def.Ref == nil {
+			continue
+		}
+		if inlined, ok := defs[getNameFromURL(*def.Ref)]; ok {
+			schema.AnyOf[i] = inlined
+		}
+	}
+	if len(schema.AnyOf) == 1 {
+		root := schema.AnyOf[0]
+		root.Schema, root.ID = schema.Schema, schema.ID
+		*schema = root
+	}
+
+	schema.Definitions = nil
+	referred := map[string]bool{}
+	for _, name := range processDefinition(schema) {
+		referred[name] = true
+	}
+	for name := range getReachableTypes(referred, defs) {
+		if def, ok := defs[name]; ok {
+			if schema.Definitions == nil {
+				schema.Definitions = v1beta1.JSONSchemaDefinitions{}
+			}
+			schema.Definitions[name] = def
+		}
 	}
-	return newDefs
 }
 
-func embedDefinition(def *v1beta1.JSONSchemaProps, refs map[string]v1beta1.JSONSchemaProps) {
+// embedDefinition replaces the refs of def by the definitions they point at.
+// chain holds the names of the definitions being embedded, the refs to them
+// are kept. It is an error for it to get longer than maxDepth.
+func embedDefinition(def *v1beta1.JSONSchemaProps, refs map[string]v1beta1.JSONSchemaProps, chain []string, maxDepth int) error {
 	if def == nil {
-		return
+		return nil
 	}
 
-	if def.Ref != nil && len(*def.Ref) > 0 {
+	// The definition of a type defined as another one, e.g. type Spec
+	// corev1.PodSpec, is itself a ref, embedded in turn.
+	for def.Ref != nil && len(*def.Ref) > 0 {
 		refName := strings.TrimPrefix(*def.Ref, defPrefix)
 		ref, ok := refs[refName]
 		if !ok {
-			log.Panicf("can't find the definition of %q", refName)
+			// An unknown type is kept as an external ref, strict mode fails
+			// on them before.
+			return nil
+		}
+		for _, name := range chain {
+			if name == refName {
+				// Embedding it again would never end.
+				return nil
+			}
 		}
-		def.Properties = ref.Properties
-		def.Required = ref.Required
-		def.Type = ref.Type
-		def.Ref = nil
+		chain = append(chain, refName)
+		if len(chain) > maxDepth {
+			return fmt.Errorf("types nested deeper than %d: %s", maxDepth, strings.Join(chain, " -> "))
+		}
+		embedRef(def, ref)
 	}
 
-	def.Definitions = embedDefinitionMap(def.Definitions, refs)
-	def.Properties = embedDefinitionMap(def.Properties, refs)
+	var err error
+	if def.Definitions, err = embedDefinitionMap(def.Definitions, refs, chain, maxDepth); err != nil {
+		return err
+	}
+	if def.Properties, err = embedDefinitionMap(def.Properties, refs, chain, maxDepth); err != nil {
+		return err
+	}
 	// TODO: decide if we want to do this.
-	//def.AllOf = embedDefinitionArray(def.AllOf, refs)
-	def.AnyOf = embedDefinitionArray(def.AnyOf, refs)
-	def.OneOf = embedDefinitionArray(def.OneOf, refs)
+	//def.AllOf = embedDefinitionArray(def.AllOf, refs, chain, maxDepth)
+	if def.AnyOf, err = embedDefinitionArray(def.AnyOf, refs, chain, maxDepth); err != nil {
+		return err
+	}
+	if def.OneOf, err = embedDefinitionArray(def.OneOf, refs, chain, maxDepth); err != nil {
+		return err
+	}
 	if def.AdditionalItems != nil {
-		embedDefinition(def.AdditionalItems.Schema, refs)
+		if err := embedDefinition(def.AdditionalItems.Schema, refs, chain, maxDepth); err != nil {
+			return err
+		}
 	}
 	if def.Items != nil {
-		embedDefinition(def.Items.Schema, refs)
+		if err := embedDefinition(def.Items.Schema, refs, chain, maxDepth); err != nil {
+			return err
+		}
+		if len(def.Items.JSONSchemas) > 0 {
+			if def.Items.JSONSchemas, err = embedDefinitionArray(def.Items.JSONSchemas, refs, chain, maxDepth); err != nil {
+				return err
+			}
+		}
 	}
-	embedDefinition(def.Not, refs)
+	if def.AdditionalProperties != nil {
+		if err := embedDefinition(def.AdditionalProperties.Schema, refs, chain, maxDepth); err != nil {
+			return err
+		}
+	}
+	return embedDefinition(def.Not, refs, chain, maxDepth)
+}
+
+// embedRef replaces def, a ref, with the definition ref it points at. What def
+// sets itself, e.g. the validation of the markers of a field, wins over the
+// definition, whose description and title aren't kept. The ref of the
+// definition, if it is one, is kept.
+func embedRef(def *v1beta1.JSONSchemaProps, ref v1beta1.JSONSchemaProps) {
+	embedded := ref.DeepCopy()
+	embedded.Description, embedded.Title = "", ""
+	own := *def
+	own.Ref = nil
+	dst := reflect.ValueOf(embedded).Elem()
+	src := reflect.ValueOf(&own).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if field := src.Field(i); !field.IsZero() {
+			dst.Field(i).Set(field)
+		}
+	}
+	*def = *embedded
 }
 
-func embedDefinitionMap(defs map[string]v1beta1.JSONSchemaProps, refs map[string]v1beta1.JSONSchemaProps) map[string]v1beta1.JSONSchemaProps {
+func embedDefinitionMap(defs map[string]v1beta1.JSONSchemaProps, refs map[string]v1beta1.JSONSchemaProps, chain []string, maxDepth int) (map[string]v1beta1.JSONSchemaProps, error) {
 	newDefs := map[string]v1beta1.JSONSchemaProps{}
 	for i := range defs {
 		def := defs[i]
-		embedDefinition(&def, refs)
+		if err := embedDefinition(&def, refs, chain, maxDepth); err != nil {
+			return nil, err
+		}
 		newDefs[i] = def
 	}
-	return newDefs
+	return newDefs, nil
 }
 
-func embedDefinitionArray(defs []v1beta1.JSONSchemaProps, refs map[string]v1beta1.JSONSchemaProps) []v1beta1.JSONSchemaProps {
+func embedDefinitionArray(defs []v1beta1.JSONSchemaProps, refs map[string]v1beta1.JSONSchemaProps, chain []string, maxDepth int) ([]v1beta1.JSONSchemaProps, error) {
 	newDefs := make([]v1beta1.JSONSchemaProps, len(defs))
 	for i := range defs {
 		def := defs[i]
-		embedDefinition(&def, refs)
+		if err := embedDefinition(&def, refs, chain, maxDepth); err != nil {
+			return nil, err
+		}
 		newDefs[i] = def
 	}
-	return newDefs
+	return newDefs, nil
 }
@@ -0,0 +1,167 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+func TestEmbedSchema(t *testing.T) {
+	refTo := func(name string) v1beta1.JSONSchemaProps {
+		ref := defPrefix + name
+		return v1beta1.JSONSchemaProps{Ref: &ref}
+	}
+	defs := map[string]v1beta1.JSONSchemaProps{
+		"A": {Type: "object", Properties: map[string]v1beta1.JSONSchemaProps{
+			"b":  refTo("B"),
+			"bs": {Type: "array", Items: &v1beta1.JSONSchemaPropsOrArray{Schema: &v1beta1.JSONSchemaProps{Ref: refTo("B").Ref}}},
+		}},
+		"B": {Type: "object", Properties: map[string]v1beta1.JSONSchemaProps{"x": {Type: "string"}}},
+		"C": {Type: "object", Properties: map[string]v1beta1.JSONSchemaProps{"b": refTo("B"), "ext": refTo("Missing")}},
+		"N": {Type: "object", Properties: map[string]v1beta1.JSONSchemaProps{"next": refTo("N")}},
+		"P": {Type: "object", Properties: map[string]v1beta1.JSONSchemaProps{"q": refTo("Q")}},
+		"Q": {Type: "object", Properties: map[string]v1beta1.JSONSchemaProps{"p": refTo("P")}},
+	}
+	tests := []struct {
+		name  string
+		start []string
+		want  map[string]string
+	}{
+		{
+			name:  "refs",
+			start: []string{"A"},
+			want: map[string]string{
+				"A": `{"type":"object","properties":{"b":{"type":"object","properties":{"x":{"type":"string"}}},"bs":{"type":"array","items":{"type":"object","properties":{"x":{"type":"string"}}}}}}`,
+			},
+		},
+		{
+			// An unknown type is kept as an external ref.
+			name:  "missing",
+			start: []string{"C"},
+			want: map[string]string{
+				"C": `{"type":"object","properties":{"b":{"type":"object","properties":{"x":{"type":"string"}}},"ext":{"$ref":"#/definitions/Missing"}}}`,
+			},
+		},
+		{
+			name:  "self",
+			start: []string{"N"},
+			want: map[string]string{
+				"N": `{"type":"object","properties":{"next":{"$ref":"#/definitions/N"}}}`,
+			},
+		},
+		{
+			name:  "cycle",
+			start: []string{"P"},
+			want: map[string]string{
+				"P": `{"type":"object","properties":{"q":{"type":"object","properties":{"p":{"$ref":"#/definitions/P"}}}}}`,
+			},
+		},
+		{
+			name:  "several",
+			start: []string{"A", "C"},
+			want: map[string]string{
+				"A": `{"type":"object","properties":{"b":{"type":"object","properties":{"x":{"type":"string"}}},"bs":{"type":"array","items":{"type":"object","properties":{"x":{"type":"string"}}}}}}`,
+				"C": `{"type":"object","properties":{"b":{"type":"object","properties":{"x":{"type":"string"}}},"ext":{"$ref":"#/definitions/Missing"}}}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := map[string]v1beta1.JSONSchemaProps{}
+			for name, def := range defs {
+				before[name] = *def.DeepCopy()
+			}
+			start := map[string]bool{}
+			for _, name := range tt.start {
+				start[name] = true
+			}
+			got, err := embedSchema(defs, start, DefaultMaxDepth)
+			if err != nil {
+				t.Fatalf("embedSchema() = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("%d definitions, want %d", len(got), len(tt.want))
+			}
+			for name, want := range tt.want {
+				b, err := json.Marshal(got[name])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != want {
+					t.Errorf("%s is %s, want %s", name, b, want)
+				}
+			}
+			// The definitions embedded from are left as they are.
+			if !reflect.DeepEqual(defs, before) {
+				t.Errorf("embedSchema() changed the definitions")
+			}
+		})
+	}
+}
+
+func TestEmbedExternalDefinedTypes(t *testing.T) {
+	packages := map[string]map[string]string{
+		"example.com/api": {"types.go": `package api
+
+import "example.com/core"
+
+// Spec is the spec of a T.
+type Spec core.PodSpec
+
+type T struct {
+	// +kubebuilder:validation:MinProperties=1
+	Spec   Spec         ` + "`json:\"spec\"`" + `
+	Direct core.PodSpec ` + "`json:\"direct\"`" + `
+}
+`},
+		"example.com/core": {"types.go": `package core
+
+type PodSpec struct {
+	Image string ` + "`json:\"image\"`" + `
+	Ports []Port ` + "`json:\"ports\"`" + `
+}
+
+type Port struct {
+	Number int ` + "`json:\"number\"`" + `
+}
+`},
+	}
+	const podSpec = `"properties":{"image":{"type":"string"},"ports":{"type":"array","items":{"type":"object","required":["number"],"properties":{"number":{"type":"integer","format":"int64"}}}}}`
+	tests := []struct {
+		property string
+		want     string
+	}{
+		{property: "spec", want: `{"type":"object","minProperties":1,"required":["image","ports"],` + podSpec + `}`},
+		{property: "direct", want: `{"type":"object","required":["image","ports"],` + podSpec + `}`},
+	}
+	op := &SingleVersionGenerator{}
+	op.InputPackage = "example.com/api"
+	op.Types = []string{"T"}
+	op.fs = testPackages(t, packages)
+	def := generateDefinition(t, op, "T")
+	for _, tt := range tests {
+		b, err := json.Marshal(def.Properties[tt.property])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("%s is %s, want %s", tt.property, b, tt.want)
+		}
+	}
+}
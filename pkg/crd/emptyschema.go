@@ -0,0 +1,156 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// Styles of the schema accepting any value, see EmptySchemaStyle.
+const (
+	// EmptySchemaEmpty is the empty object {}.
+	EmptySchemaEmpty = "empty"
+	// EmptySchemaTrue is the boolean schema true of draft 6 and later.
+	EmptySchemaTrue = "true"
+	// EmptySchemaPreserveUnknownFields is {"x-kubernetes-preserve-unknown-fields": true},
+	// which keeps the value from being pruned in a CRD.
+	EmptySchemaPreserveUnknownFields = "preserve-unknown-fields"
+)
+
+// checkEmptySchemaStyle returns an error for an unknown style.
+func checkEmptySchemaStyle(style string) error {
+	switch style {
+	case "", EmptySchemaEmpty, EmptySchemaTrue, EmptySchemaPreserveUnknownFields:
+		return nil
+	}
+	return fmt.Errorf("unknown empty schema style %q, must be one of %q, %q or %q",
+		style, EmptySchemaEmpty, EmptySchemaTrue, EmptySchemaPreserveUnknownFields)
+}
+
+// emptySchema returns the schema accepting any value, e.g. for interface{}
+// fields. With EmptySchemaTrue it is an empty schema that is written as true.
+func (f *file) emptySchema() *v1beta1.JSONSchemaProps {
+	def := &v1beta1.JSONSchemaProps{}
+	if f.options.EmptySchemaStyle == EmptySchemaPreserveUnknownFields {
+		preserve := true
+		def.XPreserveUnknownFields = &preserve
+	}
+	return def
+}
+
+// Policies for the fields typed by an anonymous interface with methods, e.g.
+// interface{ Foo() }, see AnonymousInterfacePolicy.
+const (
+	// AnonymousInterfacePermissive accepts any value for the field.
+	AnonymousInterfacePermissive = "permissive"
+	// AnonymousInterfaceError fails the generation.
+	AnonymousInterfaceError = "error"
+)
+
+// checkAnonymousInterfacePolicy returns an error for an unknown policy.
+func checkAnonymousInterfacePolicy(policy string) error {
+	switch policy {
+	case "", AnonymousInterfacePermissive, AnonymousInterfaceError:
+		return nil
+	}
+	return fmt.Errorf("unknown anonymous interface policy %q, must be either %q or %q",
+		policy, AnonymousInterfacePermissive, AnonymousInterfaceError)
+}
+
+// interfaceToSchema converts an anonymous ast.InterfaceType to
+// JSONSchemaProps. Nothing is known about the values of an interface with
+// methods, they are accepted unless the policy says otherwise.
+func (f *file) interfaceToSchema(interfaceType *ast.InterfaceType) (*v1beta1.JSONSchemaProps, error) {
+	if len(interfaceType.Methods.List) > 0 && f.options.AnonymousInterfacePolicy == AnonymousInterfaceError {
+		return nil, fmt.Errorf("can't describe the values of %s", types.ExprString(interfaceType))
+	}
+	return f.emptySchema(), nil
+}
+
+// isAnyType returns true for interface{} and the predeclared any, unless the
+// file declares its own any.
+func isAnyType(expr ast.Expr) bool {
+	switch t := expr.(type) {
+	case *ast.InterfaceType:
+		return len(t.Methods.List) == 0
+	case *ast.Ident:
+		return t.Name == "any" && t.Obj == nil
+	}
+	return false
+}
+
+// trueEmptySchemas replaces every empty subschema of the generic JSON form
+// of a schema with the boolean schema true. The root schema is kept as an
+// object. groups are the entries of the definitions holding the definitions
+// of a package, see NamespaceDefinitions.
+func trueEmptySchemas(generic map[string]interface{}, groups map[string]bool) {
+	defs, _ := generic["definitions"].(map[string]interface{})
+	delete(generic, "definitions")
+	replaceEmptySchemas(generic)
+	for name, def := range defs {
+		if !groups[name] {
+			defs[name] = emptySchemaToTrue(def)
+			continue
+		}
+		if pkgDefs, ok := def.(map[string]interface{}); ok {
+			for typeName, pkgDef := range pkgDefs {
+				pkgDefs[typeName] = emptySchemaToTrue(pkgDef)
+			}
+		}
+	}
+	if defs != nil {
+		generic["definitions"] = defs
+	}
+}
+
+// replaceEmptySchemas replaces the empty subschemas of schema with true.
+func replaceEmptySchemas(schema map[string]interface{}) {
+	for key, value := range schema {
+		switch key {
+		case "definitions", "properties", "patternProperties":
+			if m, ok := value.(map[string]interface{}); ok {
+				for name, sub := range m {
+					m[name] = emptySchemaToTrue(sub)
+				}
+			}
+		case "allOf", "anyOf", "oneOf", "items":
+			if a, ok := value.([]interface{}); ok {
+				for i, sub := range a {
+					a[i] = emptySchemaToTrue(sub)
+				}
+				continue
+			}
+			schema[key] = emptySchemaToTrue(value)
+		case "not", "additionalProperties", "additionalItems":
+			schema[key] = emptySchemaToTrue(value)
+		}
+	}
+}
+
+func emptySchemaToTrue(schema interface{}) interface{} {
+	m, ok := schema.(map[string]interface{})
+	if !ok {
+		return schema
+	}
+	if len(m) == 0 {
+		return true
+	}
+	replaceEmptySchemas(m)
+	return m
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEmptySchemaStyle(t *testing.T) {
+	src := `package api
+
+import "encoding/json"
+
+type T struct {
+	A interface{}     ` + "`json:\"a\"`" + `
+	B any             ` + "`json:\"b\"`" + `
+	C json.RawMessage ` + "`json:\"c\"`" + `
+	D []interface{}   ` + "`json:\"d\"`" + `
+}
+`
+	tests := []struct {
+		style string
+		want  string
+	}{
+		{style: "", want: `{}`},
+		{style: EmptySchemaEmpty, want: `{}`},
+		{style: EmptySchemaTrue, want: `true`},
+		{style: EmptySchemaPreserveUnknownFields, want: `{"x-kubernetes-preserve-unknown-fields":true}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.style, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.EmptySchemaStyle = tt.style
+			var schema struct {
+				Definitions map[string]struct {
+					Properties map[string]interface{} `json:"properties"`
+				} `json:"definitions"`
+			}
+			if err := json.Unmarshal([]byte(generateOutput(t, op)), &schema); err != nil {
+				t.Fatal(err)
+			}
+			props := schema.Definitions["T"].Properties
+			for _, name := range []string{"a", "b", "c"} {
+				if got := compactJSON(t, props[name]); got != tt.want {
+					t.Errorf("%s is %s, want %s", name, got, tt.want)
+				}
+			}
+			d, _ := props["d"].(map[string]interface{})
+			if got := compactJSON(t, d["items"]); got != tt.want {
+				t.Errorf("the items of d are %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnknownEmptySchemaStyle(t *testing.T) {
+	op := testGenerator(t, map[string]string{"types.go": "package api\n\ntype T struct{}\n"}, "T")
+	op.EmptySchemaStyle = "false"
+	if _, err := op.GenerateSchema(); err == nil {
+		t.Error("GenerateSchema() = nil, want an unknown style error")
+	}
+}
+
+// compactJSON returns v in JSON.
+func compactJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestFreeFormMaps(t *testing.T) {
+	src := `// +groupName=example.com
+package api
+
+import "encoding/json"
+
+// +kubebuilder:resource:path=widgets
+type Widget struct {
+	A map[string]interface{}   ` + "`json:\"a\"`" + `
+	B map[string]any           ` + "`json:\"b\"`" + `
+	C map[string][]interface{} ` + "`json:\"c\"`" + `
+	D interface{}              ` + "`json:\"d\"`" + `
+	E json.RawMessage          ` + "`json:\"e\"`" + `
+}
+`
+	tests := []struct {
+		name      string
+		outputCRD bool
+		want      map[string]string
+	}{
+		{
+			name: "schema",
+			want: map[string]string{
+				"a": `{"type":"object","additionalProperties":{}}`,
+				"b": `{"type":"object","additionalProperties":{}}`,
+				"c": `{"type":"object","additionalProperties":{"type":"array","items":{}}}`,
+				"d": `{}`,
+				"e": `{}`,
+			},
+		},
+		{
+			name:      "CRD",
+			outputCRD: true,
+			want: map[string]string{
+				"a": `{"type":"object","x-kubernetes-preserve-unknown-fields":true}`,
+				"b": `{"type":"object","x-kubernetes-preserve-unknown-fields":true}`,
+				"c": `{"type":"object","additionalProperties":{"type":"array","items":{"x-kubernetes-preserve-unknown-fields":true}}}`,
+				"d": `{"x-kubernetes-preserve-unknown-fields":true}`,
+				"e": `{"x-kubernetes-preserve-unknown-fields":true}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, "Widget")
+			op.outputCRD = tt.outputCRD
+			def := generateDefinition(t, op, "Widget")
+			for name, want := range tt.want {
+				if got := compactJSON(t, def.Properties[name]); got != want {
+					t.Errorf("%s is %s, want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAnonymousInterfacePolicy(t *testing.T) {
+	src := "package api\n\ntype T struct {\n\tF interface{ Foo() } `json:\"f\"`\n}\n"
+	tests := []struct {
+		policy  string
+		wantErr bool
+	}{
+		{policy: ""},
+		{policy: AnonymousInterfacePermissive},
+		{policy: AnonymousInterfaceError, wantErr: true},
+		{policy: "strict", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.AnonymousInterfacePolicy = tt.policy
+			schema, err := op.GenerateSchema()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("GenerateSchema() = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateSchema() = %v", err)
+			}
+			if got := compactJSON(t, definition(t, schema, "T").Properties["f"]); got != `{}` {
+				t.Errorf("f is %s, want {}", got)
+			}
+		})
+	}
+}
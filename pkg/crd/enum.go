@@ -0,0 +1,359 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"strconv"
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// enumValues maps definition names to the values of the constants declared
+// with that type.
+type enumValues map[string][]enumValue
+
+// enumValue is the value of a constant, with the description of its doc.
+type enumValue struct {
+	value v1beta1.JSON
+	doc   string
+}
+
+// collectEnumValues collects the values of the typed constants declared in the
+// file. For example
+//
+//	const (
+//		PhasePending Phase = "Pending"
+//		PhaseRunning Phase = "Running"
+//	)
+//
+// gives the values "Pending" and "Running" for the type Phase. Only constants
+// with an explicit type of the same package and a literal value are used,
+// unless iota is set. Then the integer constants of a block using iota are
+// used too, e.g. 0 and 1 for
+//
+//	const (
+//		PhasePending Phase = iota
+//		PhaseRunning
+//	)
+//
+// The doc of the constants is kept with their value, see enumDescription.
+func collectEnumValues(node *ast.File, pkgPrefix string, iota bool) enumValues {
+	values := enumValues{}
+	for _, decl := range node.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.CONST {
+			continue
+		}
+		// A constant without a type and a value repeats the ones of the
+		// previous constant of the block, with the next iota.
+		var lastType ast.Expr
+		var lastValues []ast.Expr
+		for i, spec := range genDecl.Specs {
+			valueSpec, ok := spec.(*ast.ValueSpec)
+			if !ok {
+				continue
+			}
+			typ, exprs := valueSpec.Type, valueSpec.Values
+			if iota && typ == nil && len(exprs) == 0 {
+				typ, exprs = lastType, lastValues
+			}
+			lastType, lastValues = typ, exprs
+			typeIdent, ok := typ.(*ast.Ident)
+			if !ok || isSimpleType(typeIdent.Name) {
+				continue
+			}
+			name := getFullName(typeIdent.Name, pkgPrefix)
+			doc := valueSpec.Doc
+			if doc == nil && !genDecl.Lparen.IsValid() {
+				doc = genDecl.Doc
+			}
+			if doc == nil {
+				doc = valueSpec.Comment
+			}
+			for j, value := range exprs {
+				// A blank constant, e.g. one skipping an iota value, isn't
+				// a value of the type.
+				if j < len(valueSpec.Names) && valueSpec.Names[j].Name == "_" {
+					continue
+				}
+				var v v1beta1.JSON
+				if lit, ok := value.(*ast.BasicLit); ok {
+					if v, ok = literalToJSON(lit); !ok {
+						continue
+					}
+				} else if n, ok := constant.Int64Val(iotaValue(value, int64(i))); ok && iota {
+					v = v1beta1.JSON{Raw: []byte(strconv.FormatInt(n, 10))}
+				} else {
+					continue
+				}
+				// Repeated constants, e.g. aliases, give the same value.
+				if !containsEnumValue(values[name], v) {
+					values[name] = append(values[name], enumValue{value: v, doc: filterDescription(doc.Text())})
+				}
+			}
+		}
+	}
+	return values
+}
+
+// iotaValue returns the value of the integer constant expression expr of the
+// constant at index iota of its block, e.g. 1 << iota. It is unknown for the
+// other expressions.
+func iotaValue(expr ast.Expr, iota int64) constant.Value {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if e.Name == "iota" {
+			return constant.MakeInt64(iota)
+		}
+	case *ast.ParenExpr:
+		return iotaValue(e.X, iota)
+	case *ast.UnaryExpr:
+		if x := iotaValue(e.X, iota); x.Kind() == constant.Int && (e.Op == token.SUB || e.Op == token.ADD) {
+			return constant.UnaryOp(e.Op, x, 0)
+		}
+		return constant.MakeUnknown()
+	case *ast.BinaryExpr:
+		return intBinaryOp(iotaValue(e.X, iota), e.Op, iotaValue(e.Y, iota))
+	}
+	return constValue(expr)
+}
+
+// literalToJSON converts a string or number literal to its JSON value.
+func literalToJSON(lit *ast.BasicLit) (v1beta1.JSON, bool) {
+	var value interface{}
+	switch lit.Kind {
+	case token.STRING:
+		s, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			return v1beta1.JSON{}, false
+		}
+		value = s
+	case token.INT:
+		i, err := strconv.ParseInt(lit.Value, 0, 64)
+		if err != nil {
+			return v1beta1.JSON{}, false
+		}
+		value = i
+	case token.FLOAT:
+		f, err := strconv.ParseFloat(lit.Value, 64)
+		if err != nil {
+			return v1beta1.JSON{}, false
+		}
+		value = f
+	default:
+		return v1beta1.JSON{}, false
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return v1beta1.JSON{}, false
+	}
+	return v1beta1.JSON{Raw: raw}, true
+}
+
+func mergeEnumValues(lhs, rhs enumValues) {
+	for name := range rhs {
+		lhs[name] = append(lhs[name], rhs[name]...)
+	}
+}
+
+// addEnumValues sets the enum of the definitions that have discovered values,
+// and adds the doc of the values to their description and to docs, see
+// describeEnumFields. An enum set by a marker on the type is kept.
+func addEnumValues(defs v1beta1.JSONSchemaDefinitions, values enumValues, docs map[string]string) {
+	for name := range values {
+		def, ok := defs[name]
+		if !ok || len(def.Enum) > 0 {
+			continue
+		}
+		for _, v := range values[name] {
+			def.Enum = append(def.Enum, v.value)
+		}
+		if description := enumDescription(values[name]); description != "" {
+			def.Description = strings.TrimSpace(def.Description + " " + description)
+			docs[name] = description
+		}
+		defs[name] = def
+	}
+}
+
+// enumDescription lists the documented values with their doc, e.g.
+// "Pending: The pod waits to be scheduled; Running: The pod runs." for the
+// constants
+//
+//	const (
+//		// The pod waits to be scheduled.
+//		PhasePending Phase = "Pending"
+//		PhaseRunning Phase = "Running" // The pod runs.
+//	)
+func enumDescription(values []enumValue) string {
+	var docs []string
+	for _, v := range values {
+		if v.doc == "" {
+			continue
+		}
+		label := string(v.value.Raw)
+		var s string
+		if err := json.Unmarshal(v.value.Raw, &s); err == nil {
+			label = s
+		}
+		docs = append(docs, label+": "+strings.TrimSuffix(v.doc, "."))
+	}
+	if len(docs) == 0 {
+		return ""
+	}
+	return strings.Join(docs, "; ") + "."
+}
+
+const (
+	// EnumMergeMarkerWins uses the values of the Enum marker of a field over
+	// the values discovered from the constants of its type.
+	EnumMergeMarkerWins = "marker-wins"
+	// EnumMergeDiscoveryWins uses the values discovered from the constants of
+	// the type of a field over the values of its Enum marker.
+	EnumMergeDiscoveryWins = "discovery-wins"
+	// EnumMergeIntersect only allows the values that are both in the Enum
+	// marker of a field and discovered from the constants of its type.
+	EnumMergeIntersect = "intersect"
+	// EnumMergeUnion allows the values that are either in the Enum marker of a
+	// field or discovered from the constants of its type.
+	EnumMergeUnion = "union"
+)
+
+// mergeFieldEnums reconciles the Enum markers of fields whose type is a
+// definition with the enum of that definition, following policy.
+// The marker values of such fields are parsed before the type of the
+// definition is known, so they are converted to it here as well. The
+// validators ignore the keywords next to a ref, so the fields keeping an
+// enum get the definition embedded, see embedRef. An intersection without
+// values is an error.
+func mergeFieldEnums(defs v1beta1.JSONSchemaDefinitions, policy string) error {
+	switch policy {
+	case "", EnumMergeMarkerWins, EnumMergeDiscoveryWins, EnumMergeIntersect, EnumMergeUnion:
+	default:
+		return fmt.Errorf("unknown enum merge policy %q", policy)
+	}
+
+	var err error
+	walkDefinitionMap(defs, "#/definitions", func(path string, def *v1beta1.JSONSchemaProps) {
+		if err != nil || def.Ref == nil || len(def.Enum) == 0 {
+			return
+		}
+		name := getNameFromURL(*def.Ref)
+		refDef, ok := defs[name]
+		if !ok {
+			return
+		}
+		markerValues := convertEnumValues(def.Enum, refDef.Type)
+		switch {
+		case len(refDef.Enum) == 0:
+			def.Enum = markerValues
+		case policy == EnumMergeDiscoveryWins:
+			def.Enum = nil
+			return
+		case policy == EnumMergeIntersect:
+			def.Enum = []v1beta1.JSON{}
+			for _, v := range refDef.Enum {
+				if containsJSON(markerValues, v) {
+					def.Enum = append(def.Enum, v)
+				}
+			}
+			if len(def.Enum) == 0 {
+				err = fmt.Errorf("%s: the values of its Enum marker aren't in the enum of %s", path, name)
+				return
+			}
+		case policy == EnumMergeUnion:
+			def.Enum = append([]v1beta1.JSON{}, refDef.Enum...)
+			for _, v := range markerValues {
+				if !containsJSON(def.Enum, v) {
+					def.Enum = append(def.Enum, v)
+				}
+			}
+		default:
+			def.Enum = markerValues
+		}
+		embedRef(def, refDef)
+	})
+	return err
+}
+
+// convertEnumValues converts enum values parsed as strings to the given type.
+func convertEnumValues(values []v1beta1.JSON, typ string) []v1beta1.JSON {
+	if typ == "" || typ == "string" {
+		return values
+	}
+	props := &v1beta1.JSONSchemaProps{Type: typ}
+	converted := []v1beta1.JSON{}
+	for _, v := range values {
+		var s string
+		if err := json.Unmarshal(v.Raw, &s); err != nil {
+			converted = append(converted, v)
+			continue
+		}
+		checkType(props, s, &converted)
+	}
+	return converted
+}
+
+// describeEnumFields adds the doc of the values of an enum definition, see
+// enumDescription, to the description of the properties of that type,
+// directly or as the items or values of their slices and maps. The
+// description of the definition itself isn't kept when it is embedded. docs
+// are the docs of the values by definition name.
+func describeEnumFields(defs v1beta1.JSONSchemaDefinitions, docs map[string]string) {
+	if len(docs) == 0 {
+		return
+	}
+	annotate := func(def *v1beta1.JSONSchemaProps) {
+		for key, prop := range def.Properties {
+			doc, ok := docs[referencedDefinition(&prop)]
+			// Maps may be shared between definitions, don't add the doc twice.
+			if !ok || strings.Contains(prop.Description, doc) {
+				continue
+			}
+			prop.Description = strings.TrimSpace(prop.Description + " " + doc)
+			def.Properties[key] = prop
+		}
+	}
+	for name := range defs {
+		def := defs[name]
+		walkDefinition(&def, annotate)
+		defs[name] = def
+	}
+}
+
+func containsEnumValue(values []enumValue, value v1beta1.JSON) bool {
+	for _, v := range values {
+		if bytes.Equal(v.value.Raw, value.Raw) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsJSON(values []v1beta1.JSON, value v1beta1.JSON) bool {
+	for _, v := range values {
+		if bytes.Equal(v.Raw, value.Raw) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,236 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// enumStrings returns the enum values of def, in JSON.
+func enumStrings(def v1beta1.JSONSchemaProps) []string {
+	var values []string
+	for _, v := range def.Enum {
+		values = append(values, string(v.Raw))
+	}
+	return values
+}
+
+func TestCrossPackageEnum(t *testing.T) {
+	packages := map[string]map[string]string{
+		"example.com/api": {"types.go": `package api
+
+import "example.com/other"
+
+type T struct {
+	Phase other.Phase ` + "`json:\"phase\"`" + `
+}
+`},
+		"example.com/other": {
+			"types.go": "package other\n\ntype Phase string\n",
+			"consts.go": `package other
+
+const (
+	Pending Phase = "Pending"
+	Running Phase = "Running"
+	unrelated = "x"
+)
+`,
+		},
+	}
+	for _, flatten := range []bool{false, true} {
+		op := &SingleVersionGenerator{}
+		op.InputPackage = "example.com/api"
+		op.Types = []string{"T"}
+		op.Flatten = flatten
+		op.fs = testPackages(t, packages)
+		schema, err := op.GenerateSchema()
+		if err != nil {
+			t.Fatal(err)
+		}
+		phase := definition(t, schema, "T").Properties["phase"]
+		if flatten {
+			phase = definition(t, schema, "example.com.other.Phase")
+		}
+		if got := strings.Join(enumStrings(phase), ","); got != `"Pending","Running"` {
+			t.Errorf("flatten %v: phase has the enum %s", flatten, got)
+		}
+	}
+}
+
+func TestEnumMergePolicy(t *testing.T) {
+	src := `package api
+
+type Level int
+
+const (
+	Low  Level = 1
+	High Level = 2
+)
+
+type T struct {
+	// +kubebuilder:validation:Enum=%s
+	Level Level ` + "`json:\"level\"`" + `
+}
+`
+	tests := []struct {
+		policy  string
+		marker  string
+		want    string
+		wantErr bool
+	}{
+		{policy: "", marker: "2;3", want: "2,3"},
+		{policy: EnumMergeMarkerWins, marker: "2;3", want: "2,3"},
+		{policy: EnumMergeDiscoveryWins, marker: "2;3"},
+		{policy: EnumMergeIntersect, marker: "2;3", want: "2"},
+		{policy: EnumMergeIntersect, marker: "3", wantErr: true},
+		{policy: EnumMergeUnion, marker: "2;3", want: "1,2,3"},
+		{policy: "first-wins", marker: "2;3", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy+" "+tt.marker, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": fmt.Sprintf(src, tt.marker)}, "T")
+			op.Flatten = true
+			op.EnumMergePolicy = tt.policy
+			if tt.wantErr {
+				if _, err := op.GenerateSchema(); err == nil {
+					t.Fatal("GenerateSchema() = nil, want an error")
+				}
+				return
+			}
+			var doc interface{}
+			if err := json.Unmarshal([]byte(generateOutput(t, op)), &doc); err != nil {
+				t.Fatal(err)
+			}
+			level, _ := resolveRef(doc, "#/definitions/T/properties/level").(map[string]interface{})
+			if tt.want == "" {
+				// The field refers to the discovered enum.
+				ref, _ := level["$ref"].(string)
+				values, _ := resolveRef(doc, ref+"/enum").([]interface{})
+				if level["enum"] != nil || fmt.Sprint(values) != "[1 2]" {
+					t.Errorf("level is %v, refers to the enum %v, want the discovered one", level, values)
+				}
+				return
+			}
+			// The validators ignore an enum next to a ref.
+			if level["$ref"] != nil || level["type"] != "integer" {
+				t.Errorf("level is %v, want the integer definition embedded", level)
+			}
+			var values []string
+			enum, _ := level["enum"].([]interface{})
+			for _, v := range enum {
+				values = append(values, fmt.Sprint(v))
+			}
+			if got := strings.Join(values, ","); got != tt.want {
+				t.Errorf("the enum of level is [%s], want [%s]", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIotaEnums(t *testing.T) {
+	tests := []struct {
+		name   string
+		consts string
+		iota   bool
+		want   string
+	}{
+		{name: "off", consts: "PhasePending Phase = iota\n\tPhaseRunning\n\tPhaseDone", want: ""},
+		{name: "iota", consts: "PhasePending Phase = iota\n\tPhaseRunning\n\tPhaseDone", iota: true, want: "0,1,2"},
+		{name: "offset", consts: "PhasePending Phase = iota + 1\n\tPhaseRunning\n\tPhaseDone", iota: true, want: "1,2,3"},
+		{name: "shift", consts: "PhasePending Phase = 1 << iota\n\tPhaseRunning\n\tPhaseDone", iota: true, want: "1,2,4"},
+		{name: "skipped", consts: "PhasePending Phase = iota\n\t_\n\tPhaseDone", iota: true, want: "0,2"},
+		{name: "literals", consts: "PhasePending Phase = 3\n\tPhaseRunning Phase = 5", want: "3,5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package api\n\ntype Phase int32\n\nconst (\n\t" + tt.consts + "\n)\n\ntype T struct {\n\tPhase Phase `json:\"phase\"`\n}\n"
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.Flatten = true
+			op.IotaEnums = tt.iota
+			if got := strings.Join(enumStrings(generateDefinition(t, op, "Phase")), ","); got != tt.want {
+				t.Errorf("the enum of Phase is [%s], want [%s]", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnumValueDocs(t *testing.T) {
+	src := `package api
+
+// Phase is the phase of a pod.
+type Phase string
+
+const (
+	// The pod waits to be scheduled.
+	PhasePending Phase = "Pending"
+	PhaseRunning Phase = "Running" // The pod runs.
+	PhaseUnknown Phase = "Unknown"
+)
+
+// Level is a level.
+type Level int
+
+const (
+	// Nothing is logged.
+	LevelNone Level = 0
+	LevelAll  Level = 1
+)
+
+type T struct {
+	// Phase of the pod.
+	Phase  Phase            ` + "`json:\"phase\"`" + `
+	Phases []Phase          ` + "`json:\"phases\"`" + `
+	ByName map[string]Phase ` + "`json:\"byName\"`" + `
+	Level  Level            ` + "`json:\"level\"`" + `
+}
+`
+	const phases = "Pending: The pod waits to be scheduled; Running: The pod runs."
+	tests := []struct {
+		flatten bool
+		ref     string
+		want    string
+	}{
+		{flatten: true, ref: "Phase", want: "Phase is the phase of a pod. " + phases},
+		{flatten: true, ref: "Level", want: "Level is a level. 0: Nothing is logged."},
+		{flatten: true, ref: "T/phase", want: "Phase of the pod. " + phases},
+		{flatten: true, ref: "T/phases", want: phases},
+		{flatten: true, ref: "T/byName", want: phases},
+		{ref: "T/phase", want: "Phase of the pod. " + phases},
+		{ref: "T/level", want: "0: Nothing is logged."},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/flatten %v", tt.ref, tt.flatten), func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.Flatten = tt.flatten
+			schema, err := op.GenerateSchema()
+			if err != nil {
+				t.Fatalf("GenerateSchema() = %v", err)
+			}
+			parts := strings.SplitN(tt.ref, "/", 2)
+			def := definition(t, schema, parts[0])
+			if len(parts) == 2 {
+				def = def.Properties[parts[1]]
+			}
+			if def.Description != tt.want {
+				t.Errorf("the description of %s is %q, want %q", tt.ref, def.Description, tt.want)
+			}
+		})
+	}
+}
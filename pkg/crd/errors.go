@@ -0,0 +1,87 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// The errors below are returned when the versions of a CRD, parsed from
+// different packages, disagree. Left is the value of the versions merged so
+// far, Right the one of the version being merged.
+
+// GroupMismatchError is returned for versions of different groups.
+type GroupMismatchError struct {
+	GroupKind   schema.GroupKind
+	Left, Right string
+}
+
+func (e *GroupMismatchError) Error() string {
+	return fmt.Sprintf("group names %q and %q from different packages must match", e.Left, e.Right)
+}
+
+// ScopeMismatchError is returned for versions of different scopes.
+type ScopeMismatchError struct {
+	GroupKind   schema.GroupKind
+	Left, Right string
+}
+
+func (e *ScopeMismatchError) Error() string {
+	return fmt.Sprintf("scopes %q and %q from different packages must match", e.Left, e.Right)
+}
+
+// KindMismatchError is returned for versions of different kinds.
+type KindMismatchError struct {
+	GroupKind   schema.GroupKind
+	Left, Right string
+}
+
+func (e *KindMismatchError) Error() string {
+	return fmt.Sprintf("kind names %q and %q from different packages must match", e.Left, e.Right)
+}
+
+// PluralMismatchError is returned for versions of different plural resource
+// names.
+type PluralMismatchError struct {
+	GroupKind   schema.GroupKind
+	Left, Right string
+}
+
+func (e *PluralMismatchError) Error() string {
+	return fmt.Sprintf("plural resource names %q and %q from different packages must match", e.Left, e.Right)
+}
+
+// SingularMismatchError is returned for versions of different singular
+// resource names.
+type SingularMismatchError struct {
+	GroupKind   schema.GroupKind
+	Left, Right string
+}
+
+func (e *SingularMismatchError) Error() string {
+	return fmt.Sprintf("singular resource names %q and %q from different packages must match", e.Left, e.Right)
+}
+
+// ShortNamesMismatchError is returned for versions of different short names.
+type ShortNamesMismatchError struct {
+	GroupKind   schema.GroupKind
+	Left, Right []string
+}
+
+func (e *ShortNamesMismatchError) Error() string {
+	return fmt.Sprintf("short names %s and %s from different packages must match", e.Left, e.Right)
+}
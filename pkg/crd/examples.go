@@ -0,0 +1,110 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// loadExamples reads the examples of the definitions of schema from dir. The
+// example of a definition is in the file named after it, e.g. Foo.json. Every
+// example must conform to the schema of its definition.
+func loadExamples(dir string, schema *v1beta1.JSONSchemaProps) (definitionKeywords, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read examples: %v", err)
+	}
+	keywords := definitionKeywords{}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		name := strings.TrimSuffix(file.Name(), ".json")
+		if _, ok := schema.Definitions[name]; !ok {
+			log.Printf("Ignoring example %s, there is no definition named %q", file.Name(), name)
+			continue
+		}
+
+		path := filepath.Join(dir, file.Name())
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read example %q: %v", path, err)
+		}
+		var example interface{}
+		if err := json.Unmarshal(b, &example); err != nil {
+			return nil, fmt.Errorf("failed to parse example %q: %v", path, err)
+		}
+		if err := validateExample(schema.Definitions, name, example); err != nil {
+			return nil, fmt.Errorf("example %q: %v", path, err)
+		}
+		keywords.set(name, "examples", []interface{}{example})
+	}
+	return keywords, nil
+}
+
+// validateExample validates example against the definition named name.
+func validateExample(defs v1beta1.JSONSchemaDefinitions, name string, example interface{}) error {
+	schema := &v1beta1.JSONSchemaProps{
+		Ref:         getDefLink(name),
+		Definitions: defs,
+	}
+	result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(schema), gojsonschema.NewGoLoader(example))
+	if err != nil {
+		return err
+	}
+	if result.Valid() {
+		return nil
+	}
+	var violations []string
+	for _, desc := range result.Errors() {
+		violations = append(violations, desc.String())
+	}
+	return fmt.Errorf("doesn't conform to the schema of %s:\n%s", name, strings.Join(violations, "\n"))
+}
+
+// hasFieldExamples tells if schema, or a schema nested in it, has an example
+// set by a marker.
+func hasFieldExamples(schema *v1beta1.JSONSchemaProps) bool {
+	found := false
+	walkDefinition(schema, func(def *v1beta1.JSONSchemaProps) {
+		found = found || def.Example != nil
+	})
+	return found
+}
+
+// examplesKeyword turns the example of OpenAPI, set by the example markers,
+// into the examples of JSON schema in the generic JSON form of a schema. The
+// examples of the definitions read from the ExamplesDir come first. groups
+// are the entries of the definitions holding the definitions of a package,
+// see NamespaceDefinitions.
+func examplesKeyword(generic map[string]interface{}, groups map[string]bool) {
+	walkGeneric(generic, groups, func(schema map[string]interface{}) {
+		example, ok := schema["example"]
+		if !ok {
+			return
+		}
+		examples, _ := schema["examples"].([]interface{})
+		schema["examples"] = append(examples, example)
+		delete(schema, "example")
+	})
+}
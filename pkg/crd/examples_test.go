@@ -0,0 +1,72 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExamplesDir(t *testing.T) {
+	src := `package api
+
+type Foo struct {
+	Name string ` + "`json:\"name\"`" + `
+	Size int    ` + "`json:\"size,omitempty\"`" + `
+}
+`
+	tests := []struct {
+		name    string
+		example string
+		wantErr bool
+	}{
+		{name: "conforming", example: `{"name": "foo", "size": 3}`},
+		{name: "missing required", example: `{"size": 3}`, wantErr: true},
+		{name: "wrong type", example: `{"name": "foo", "size": "big"}`, wantErr: true},
+		{name: "not JSON", example: `{"name":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, dir, "Foo.json", tt.example)
+			writeFile(t, dir, "Bar.json", `{}`)
+			writeFile(t, dir, "README.md", "examples")
+			op := testGenerator(t, map[string]string{"types.go": src}, "Foo")
+			op.ExamplesDir = dir
+			if tt.wantErr {
+				if _, err := op.GenerateSchema(); err == nil {
+					t.Fatal("GenerateSchema() = nil, want an error")
+				}
+				return
+			}
+			var schema struct {
+				Definitions map[string]struct {
+					Examples []interface{} `json:"examples"`
+				} `json:"definitions"`
+			}
+			if err := json.Unmarshal([]byte(generateOutput(t, op)), &schema); err != nil {
+				t.Fatal(err)
+			}
+			var example interface{}
+			if err := json.Unmarshal([]byte(tt.example), &example); err != nil {
+				t.Fatal(err)
+			}
+			got, want := compactJSON(t, schema.Definitions["Foo"].Examples), compactJSON(t, []interface{}{example})
+			if got != want {
+				t.Errorf("the examples of Foo are %s, want %s", got, want)
+			}
+		})
+	}
+}
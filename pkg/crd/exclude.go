@@ -0,0 +1,83 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"go/ast"
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// ignoreMarker leaves a struct field out of the schema, like a "-" tag.
+const ignoreMarker = "+schemagen:ignore"
+
+// ignoredField tells if a struct field has the ignore marker.
+func (f *file) ignoredField(field *ast.Field) bool {
+	for _, c := range f.commentMap[field] {
+		for _, line := range strings.Split(c.Text(), "\n") {
+			if strings.TrimSpace(line) == ignoreMarker {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// excludeTypes removes the definitions named by types, see ExcludeTypes.
+// The properties referring to them are removed too, and are no longer
+// required, as are the members of an allOf, anyOf or oneOf referring to them.
+func excludeTypes(defs v1beta1.JSONSchemaDefinitions, types []string) {
+	excluded := map[string]bool{}
+	for _, name := range types {
+		excluded[name] = true
+		delete(defs, name)
+	}
+	refersToExcluded := func(def *v1beta1.JSONSchemaProps) bool {
+		for _, name := range processDefinition(def) {
+			if excluded[name] {
+				return true
+			}
+		}
+		return false
+	}
+	keep := func(members []v1beta1.JSONSchemaProps) []v1beta1.JSONSchemaProps {
+		var kept []v1beta1.JSONSchemaProps
+		for i := range members {
+			if !refersToExcluded(&members[i]) {
+				kept = append(kept, members[i])
+			}
+		}
+		return kept
+	}
+	walkDefinitionMap(defs, "#/definitions", func(_ string, def *v1beta1.JSONSchemaProps) {
+		for key, prop := range def.Properties {
+			if !refersToExcluded(&prop) {
+				continue
+			}
+			delete(def.Properties, key)
+			var required []string
+			for _, name := range def.Required {
+				if name != key {
+					required = append(required, name)
+				}
+			}
+			def.Required = required
+		}
+		def.AllOf = keep(def.AllOf)
+		def.AnyOf = keep(def.AnyOf)
+		def.OneOf = keep(def.OneOf)
+	})
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestExcludeTypes(t *testing.T) {
+	src := `package api
+
+type K struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+
+type I struct {
+	Secret string ` + "`json:\"secret\"`" + `
+}
+
+type T struct {
+	Keep     K            ` + "`json:\"keep\"`" + `
+	Internal I            ` + "`json:\"internal\"`" + `
+	Items    []I          ` + "`json:\"items\"`" + `
+	ByName   map[string]I ` + "`json:\"byName\"`" + `
+	// +schemagen:ignore
+	Ignored string ` + "`json:\"ignored\"`" + `
+	Count   int    ` + "`json:\"count\"`" + `
+}
+`
+	tests := []struct {
+		name       string
+		exclude    []string
+		properties []string
+	}{
+		{name: "none", properties: []string{"byName", "count", "internal", "items", "keep"}},
+		{name: "I", exclude: []string{"I"}, properties: []string{"count", "keep"}},
+		{name: "I and K", exclude: []string{"I", "K"}, properties: []string{"count"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.Flatten = true
+			op.ExcludeTypes = tt.exclude
+			schema, err := op.GenerateSchema()
+			if err != nil {
+				t.Fatalf("GenerateSchema() = %v", err)
+			}
+			def := definition(t, schema, "T")
+			var properties []string
+			for name := range def.Properties {
+				properties = append(properties, name)
+			}
+			sort.Strings(properties)
+			if !reflect.DeepEqual(properties, tt.properties) {
+				t.Errorf("properties %v, want %v", properties, tt.properties)
+			}
+			required := append([]string{}, def.Required...)
+			sort.Strings(required)
+			if !reflect.DeepEqual(required, tt.properties) {
+				t.Errorf("required %v, want %v", required, tt.properties)
+			}
+			out := generateJSON(t, op)
+			for _, name := range tt.exclude {
+				if _, ok := schema.Definitions[name]; ok {
+					t.Errorf("%s is still defined", name)
+				}
+				if strings.Contains(out, `"#/definitions/`+name+`"`) {
+					t.Errorf("%s is still referred to: %s", name, out)
+				}
+			}
+		})
+	}
+}
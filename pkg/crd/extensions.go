@@ -0,0 +1,77 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// extensionMarker adds a vendor extension to the definition of a type, e.g.
+// +schemagen:x-go-package="example.com/foo". The value is parsed as JSON, and
+// taken as a string if it isn't JSON.
+const extensionMarker = "schemagen:x-"
+
+// collectExtensions adds the extensions set by the markers in comments to the
+// definition named defName. A marker without a value is an error.
+func collectExtensions(comments []string, defName string, extensions definitionKeywords) error {
+	for _, c := range comments {
+		if !strings.HasPrefix(c, "+"+extensionMarker) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(c, "+schemagen:"), "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("expected +schemagen:x-<name>=<value>, got %s", c)
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(parts[1]), &value); err != nil {
+			value = parts[1]
+		}
+		extensions.set(defName, parts[0], value)
+	}
+	return nil
+}
+
+// isReservedExtension tells if key is an extension the generator emits
+// itself.
+func isReservedExtension(key string) bool {
+	return strings.HasPrefix(key, "x-kubernetes-") || key == "x-source"
+}
+
+// addExtensions adds the extensions set by markers on the definitions in defs
+// to keywords. An extension colliding with one the generator emits is an
+// error.
+func addExtensions(keywords, extensions definitionKeywords, defs v1beta1.JSONSchemaDefinitions) error {
+	names := make([]string, 0, len(extensions))
+	for name := range extensions {
+		if _, ok := defs[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for key, value := range extensions[name] {
+			if isReservedExtension(key) {
+				return fmt.Errorf("extension %s of %s collides with the one emitted by the generator", key, name)
+			}
+			keywords.set(name, key, value)
+		}
+	}
+	return nil
+}
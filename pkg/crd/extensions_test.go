@@ -0,0 +1,53 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtensionMarkers(t *testing.T) {
+	tests := []struct {
+		marker  string
+		key     string
+		want    string
+		wantErr string
+	}{
+		{marker: `+schemagen:x-go-package="example.com/api"`, key: "x-go-package", want: `"example.com/api"`},
+		{marker: `+schemagen:x-order=3`, key: "x-order", want: `3`},
+		{marker: `+schemagen:x-tags=["a","b"]`, key: "x-tags", want: `["a","b"]`},
+		{marker: `+schemagen:x-note=not json`, key: "x-note", want: `"not json"`},
+		{marker: `+schemagen:x-kubernetes-preserve-unknown-fields=true`, wantErr: "collides"},
+		{marker: `+schemagen:x-source="elsewhere"`, wantErr: "collides"},
+		{marker: `+schemagen:x-order`, wantErr: "type T: expected +schemagen:x-<name>=<value>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.marker, func(t *testing.T) {
+			src := "package api\n\n// " + tt.marker + "\ntype T struct {\n\tName string `json:\"name\"`\n}\n"
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			if tt.wantErr != "" {
+				_, err := op.GenerateSchema()
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("GenerateSchema() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if got := compactJSON(t, resolveRef(generateGeneric(t, op), "#/definitions/T/"+tt.key)); got != tt.want {
+				t.Errorf("%s is %s, want %s", tt.key, got, tt.want)
+			}
+		})
+	}
+}
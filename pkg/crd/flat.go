@@ -0,0 +1,58 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// flatFormat is the output format writing the requested types without any
+// ref or definition, for the consumers that can't resolve them. The types
+// are inlined in the root schema, see Inline.
+const flatFormat = "flat"
+
+// checkFlat returns an error for the options whose output can't be flat.
+func checkFlat(op *SingleVersionOptions) error {
+	if op.Flatten {
+		return fmt.Errorf("a flattened schema can't be written in format %q, its types are refs", flatFormat)
+	}
+	return nil
+}
+
+// flatSchema removes the definitions of schema, once its types are inlined.
+// The refs left, e.g. closing a cycle or to a type that isn't known, can't
+// be resolved without them, they are an error.
+func flatSchema(schema *v1beta1.JSONSchemaProps) error {
+	defs := schema.Definitions
+	schema.Definitions = nil
+	seen := map[string]bool{}
+	var refs []string
+	walkDefinition(schema, func(def *v1beta1.JSONSchemaProps) {
+		if def.Ref != nil && !seen[*def.Ref] {
+			seen[*def.Ref] = true
+			refs = append(refs, *def.Ref)
+		}
+	})
+	if len(refs) > 0 {
+		schema.Definitions = defs
+		sort.Strings(refs)
+		return fmt.Errorf("the schema can't be written in format %q, it still refers to %s, e.g. in a cycle", flatFormat, strings.Join(refs, ", "))
+	}
+	return nil
+}
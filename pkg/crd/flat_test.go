@@ -0,0 +1,81 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFlatFormat(t *testing.T) {
+	const acyclic = `package api
+
+type Part struct {
+	Size int ` + "`json:\"size\"`" + `
+}
+
+type Widget struct {
+	Part  Part   ` + "`json:\"part\"`" + `
+	Parts []Part ` + "`json:\"parts\"`" + `
+}
+
+type Gadget struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+`
+	const cyclic = `package api
+
+type Widget struct {
+	Children []Widget ` + "`json:\"children\"`" + `
+}
+`
+	tests := []struct {
+		name    string
+		src     string
+		types   []string
+		flatten bool
+		want    []string
+		wantErr string
+	}{
+		{name: "single type", src: acyclic, types: []string{"Widget"}, want: []string{`"part"`, `"parts"`, `"size"`}},
+		{name: "several types", src: acyclic, types: []string{"Widget", "Gadget"}, want: []string{`"anyOf"`, `"size"`, `"name"`}},
+		{name: "cycle", src: cyclic, types: []string{"Widget"}, wantErr: "it still refers to #/definitions/Widget"},
+		{name: "flattened", src: acyclic, types: []string{"Widget"}, flatten: true, wantErr: "a flattened schema can't be written"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": tt.src}, tt.types...)
+			op.OutputFormat = flatFormat
+			op.Flatten = tt.flatten
+			if tt.wantErr != "" {
+				if _, err := op.GenerateSchema(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("GenerateSchema() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			out := generateOutput(t, op)
+			for _, unwanted := range []string{`"$ref"`, `"definitions"`} {
+				if strings.Contains(out, unwanted) {
+					t.Errorf("the output holds %s:\n%s", unwanted, out)
+				}
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("the output doesn't hold %s:\n%s", want, out)
+				}
+			}
+		})
+	}
+}
@@ -15,69 +15,197 @@
 package crd
 
 import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
 	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 )
 
-// Recursively flattens "allOf" tags. If there is cyclic
-// dependency, execution is aborted.
-func recursiveFlatten(defs v1beta1.JSONSchemaDefinitions, definition *v1beta1.JSONSchemaProps, defName string, visited *map[string]bool) *v1beta1.JSONSchemaProps {
+// promotedField is where a property of a flattened definition comes from,
+// for the precedence of encoding/json between the fields having its key.
+type promotedField struct {
+	// depth is how many embedded structs the field is nested in.
+	depth int
+	// tagged is set for a field named by its json tag.
+	tagged bool
+}
+
+// Recursively flattens "allOf" tags. path holds the names of the
+// definitions being flattened, if there is cyclic dependency an error with
+// the cycle is returned, as it is when path gets longer than maxDepth.
+// tagged holds the keys of the properties named by a json tag, by
+// definition name. Like encoding/json, the members sharing a key the
+// definition doesn't have are resolved by depth and then by tag, see
+// dominantField, and the key is left out if that is ambiguous, which is an
+// error if strict is set. The fields the properties of the flattened
+// definition come from are returned with it.
+func recursiveFlatten(defs v1beta1.JSONSchemaDefinitions, definition *v1beta1.JSONSchemaProps, defName string, tagged map[string]map[string]bool, path []string, strict bool, maxDepth int) (*v1beta1.JSONSchemaProps, map[string]promotedField, error) {
+	fields := map[string]promotedField{}
+	for propKey := range definition.Properties {
+		fields[propKey] = promotedField{tagged: tagged[defName][propKey]}
+	}
 	if len(definition.AllOf) == 0 {
-		return definition
+		return definition, fields, nil
+	}
+	for i, name := range path {
+		if name == defName {
+			cycle := append(append([]string{}, path[i:]...), defName)
+			return nil, nil, fmt.Errorf("cycle detected: %s", strings.Join(cycle, " -> "))
+		}
 	}
-	isAlreadyVisited := (*visited)[defName]
-	if isAlreadyVisited {
-		panic("Cycle detected in definitions")
+	path = append(path, defName)
+	if len(path) > maxDepth {
+		return nil, nil, fmt.Errorf("allOf nested deeper than %d: %s", maxDepth, strings.Join(path, " -> "))
 	}
-	(*visited)[defName] = true
 
-	aggregatedDef := &v1beta1.JSONSchemaProps{
-		Properties: definition.Properties,
-		Required:   definition.Required,
-		Type:       definition.Type,
+	// The other keywords of the definition, e.g. nullable, are kept.
+	aggregatedDef := definition.DeepCopy()
+	aggregatedDef.AllOf = nil
+	if aggregatedDef.Properties == nil {
+		aggregatedDef.Properties = make(map[string]v1beta1.JSONSchemaProps)
 	}
+	// The properties of the definition itself shadow the ones of the
+	// members, the other ones are the candidates for their key.
+	candidates := map[string][]promotedProperty{}
 	for _, allOfDef := range definition.AllOf {
 		var newDef *v1beta1.JSONSchemaProps
+		var newFields map[string]promotedField
 		if allOfDef.Ref != nil && len(*allOfDef.Ref) > 0 {
 			// If the definition has $ref url, fetch the referred resource
 			// after flattening it.
 			nameOfRef := getNameFromURL(*allOfDef.Ref)
 			def := defs[nameOfRef]
-			newDef = recursiveFlatten(defs, &def, nameOfRef, visited)
+			var err error
+			if newDef, newFields, err = recursiveFlatten(defs, &def, nameOfRef, tagged, path, strict, maxDepth); err != nil {
+				return nil, nil, err
+			}
 		} else {
 			newDef = &allOfDef
 		}
-		mergeDefinitions(aggregatedDef, newDef)
+		for propKey, prop := range newDef.Properties {
+			if _, ok := definition.Properties[propKey]; ok {
+				continue
+			}
+			field := newFields[propKey]
+			field.depth++
+			candidates[propKey] = append(candidates[propKey], promotedProperty{
+				promotedField: field,
+				prop:          prop,
+				required:      containsString(newDef.Required, propKey),
+			})
+		}
+		mergeDescription(aggregatedDef, newDef)
+	}
+
+	keys := make([]string, 0, len(candidates))
+	for propKey := range candidates {
+		keys = append(keys, propKey)
+	}
+	sort.Strings(keys)
+	for _, propKey := range keys {
+		winner, ok := dominantField(candidates[propKey])
+		if !ok {
+			msg := fmt.Sprintf("property %q of %s is promoted from several embedded structs with the same precedence, encoding/json leaves it out", propKey, defName)
+			if strict {
+				return nil, nil, fmt.Errorf("%s", msg)
+			}
+			log.Printf("%s", msg)
+			continue
+		}
+		aggregatedDef.Properties[propKey] = winner.prop
+		if winner.required {
+			aggregatedDef.Required = append(aggregatedDef.Required, propKey)
+		}
+		fields[propKey] = winner.promotedField
 	}
+	return aggregatedDef, fields, nil
+}
 
-	delete(*visited, defName)
-	return aggregatedDef
+// promotedProperty is a property of an allOf member for a key the definition
+// doesn't have itself.
+type promotedProperty struct {
+	promotedField
+	prop     v1beta1.JSONSchemaProps
+	required bool
 }
 
-// Merges the properties from the 'rhsDef' to the 'lhsDef'.
-// Also transfers the description as well.
-func mergeDefinitions(lhsDef *v1beta1.JSONSchemaProps, rhsDef *v1beta1.JSONSchemaProps) {
-	if lhsDef == nil || rhsDef == nil {
-		return
+// dominantField returns the property encoding/json keeps among the ones
+// promoted for the same key: the shallowest one, and among those the only one
+// named by a json tag. It returns false if there is no such property, the key
+// is left out then.
+func dominantField(candidates []promotedProperty) (promotedProperty, bool) {
+	var shallowest []promotedProperty
+	for _, c := range candidates {
+		switch {
+		case len(shallowest) == 0 || c.depth < shallowest[0].depth:
+			shallowest = []promotedProperty{c}
+		case c.depth == shallowest[0].depth:
+			shallowest = append(shallowest, c)
+		}
+	}
+	if len(shallowest) == 1 {
+		return shallowest[0], true
 	}
-	// At this point, both defs will not have any 'AnyOf' defs.
-	// 1. Add all the properties from rhsDef to lhsDef
-	if lhsDef.Properties == nil {
-		lhsDef.Properties = make(map[string]v1beta1.JSONSchemaProps)
+	var tagged []promotedProperty
+	for _, c := range shallowest {
+		if c.tagged {
+			tagged = append(tagged, c)
+		}
+	}
+	if len(tagged) == 1 {
+		return tagged[0], true
 	}
-	for propKey := range rhsDef.Properties {
-		lhsDef.Properties[propKey] = rhsDef.Properties[propKey]
+	return promotedProperty{}, false
+}
+
+// mergeDescription appends the description of 'rhsDef' to the one of
+// 'lhsDef', so merging the allOf members in order keeps all of their
+// documentation.
+func mergeDescription(lhsDef *v1beta1.JSONSchemaProps, rhsDef *v1beta1.JSONSchemaProps) {
+	switch {
+	case rhsDef.Description == "" || strings.Contains(lhsDef.Description, rhsDef.Description):
+	case lhsDef.Description == "":
+		lhsDef.Description = rhsDef.Description
+	default:
+		lhsDef.Description += " " + rhsDef.Description
 	}
-	// 2. Transfer the description
-	lhsDef.Description = rhsDef.Description
-	// 3. Merge required fields
-	lhsDef.Required = append(lhsDef.Required, rhsDef.Required...)
 }
 
-// Flattens the schema by inlining 'allOf' tags.
-func flattenAllOf(defs v1beta1.JSONSchemaDefinitions) {
+// Flattens the schema by inlining 'allOf' tags, see recursiveFlatten.
+func flattenAllOf(defs v1beta1.JSONSchemaDefinitions, tagged map[string]map[string]bool, strict bool, maxDepth int) error {
+	// The members are flattened from the definitions as parsed, the depth of
+	// their fields is lost once flattened.
+	parsed := make(v1beta1.JSONSchemaDefinitions, len(defs))
+	names := make([]string, 0, len(defs))
 	for nameOfDef := range defs {
-		visited := make(map[string]bool)
+		parsed[nameOfDef] = defs[nameOfDef]
+		names = append(names, nameOfDef)
+	}
+	// Go through the definitions in order, so the same cycle is always
+	// reported the same way.
+	sort.Strings(names)
+	for _, nameOfDef := range names {
 		def := defs[nameOfDef]
-		defs[nameOfDef] = *recursiveFlatten(defs, &def, nameOfDef, &visited)
+		flattened, _, err := recursiveFlatten(parsed, &def, nameOfDef, tagged, nil, strict, maxDepth)
+		if err != nil {
+			return err
+		}
+		defs[nameOfDef] = *flattened
 	}
+
+	// The anonymous structs embedding others, e.g. the type of a field
+	// struct{ Base `json:",inline"` }, have their allOf flattened as well.
+	var err error
+	walkDefinitionMap(defs, "#/definitions", func(path string, def *v1beta1.JSONSchemaProps) {
+		if err != nil || len(def.AllOf) == 0 {
+			return
+		}
+		var flattened *v1beta1.JSONSchemaProps
+		if flattened, _, err = recursiveFlatten(parsed, def, path, tagged, nil, strict, maxDepth); err == nil {
+			*def = *flattened
+		}
+	})
+	return err
 }
@@ -0,0 +1,242 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+func TestFlattenAllOfCycle(t *testing.T) {
+	tests := []struct {
+		name string
+		defs v1beta1.JSONSchemaDefinitions
+		want string
+	}{
+		{
+			name: "two definitions",
+			defs: v1beta1.JSONSchemaDefinitions{
+				"A": {AllOf: []v1beta1.JSONSchemaProps{{Ref: ref("B")}}},
+				"B": {AllOf: []v1beta1.JSONSchemaProps{{Ref: ref("A")}}},
+			},
+			want: "cycle detected: A -> B -> A",
+		},
+		{
+			name: "self",
+			defs: v1beta1.JSONSchemaDefinitions{
+				"A": {AllOf: []v1beta1.JSONSchemaProps{{Ref: ref("A")}}},
+			},
+			want: "cycle detected: A -> A",
+		},
+		{
+			name: "after a member",
+			defs: v1beta1.JSONSchemaDefinitions{
+				"A": {AllOf: []v1beta1.JSONSchemaProps{{Ref: ref("B")}}},
+				"B": {AllOf: []v1beta1.JSONSchemaProps{{Ref: ref("C")}}},
+				"C": {AllOf: []v1beta1.JSONSchemaProps{{Ref: ref("B")}}},
+			},
+			want: "cycle detected: B -> C -> B",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := flattenAllOf(tt.defs, nil, false, 32)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("flattenAllOf() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlattenAllOfDescriptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		def     v1beta1.JSONSchemaProps
+		members v1beta1.JSONSchemaDefinitions
+		want    string
+	}{
+		{
+			name:    "in member order",
+			def:     v1beta1.JSONSchemaProps{AllOf: []v1beta1.JSONSchemaProps{{Ref: ref("M1")}, {Ref: ref("M2")}}},
+			members: v1beta1.JSONSchemaDefinitions{"M1": {Description: "First."}, "M2": {Description: "Second."}},
+			want:    "First. Second.",
+		},
+		{
+			name:    "own first",
+			def:     v1beta1.JSONSchemaProps{Description: "Own.", AllOf: []v1beta1.JSONSchemaProps{{Ref: ref("M2")}, {Ref: ref("M1")}}},
+			members: v1beta1.JSONSchemaDefinitions{"M1": {Description: "First."}, "M2": {Description: "Second."}},
+			want:    "Own. Second. First.",
+		},
+		{
+			name:    "empty member",
+			def:     v1beta1.JSONSchemaProps{AllOf: []v1beta1.JSONSchemaProps{{Ref: ref("M1")}, {Ref: ref("M2")}}},
+			members: v1beta1.JSONSchemaDefinitions{"M1": {}, "M2": {Description: "Second."}},
+			want:    "Second.",
+		},
+		{
+			name:    "same description",
+			def:     v1beta1.JSONSchemaProps{AllOf: []v1beta1.JSONSchemaProps{{Ref: ref("M1")}, {Ref: ref("M2")}}},
+			members: v1beta1.JSONSchemaDefinitions{"M1": {Description: "Same."}, "M2": {Description: "Same."}},
+			want:    "Same.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defs := tt.members
+			defs["T"] = tt.def
+			if err := flattenAllOf(defs, nil, false, 32); err != nil {
+				t.Fatal(err)
+			}
+			if got := defs["T"].Description; got != tt.want {
+				t.Errorf("description %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlattenAllOfConflicts(t *testing.T) {
+	str := v1beta1.JSONSchemaProps{Type: "string"}
+	tests := []struct {
+		name    string
+		members v1beta1.JSONSchemaDefinitions
+		own     map[string]v1beta1.JSONSchemaProps
+		tagged  map[string]map[string]bool
+		wantErr bool
+		// want is the type of name, empty if it is left out.
+		want string
+	}{
+		{
+			name: "conflicting types",
+			members: v1beta1.JSONSchemaDefinitions{
+				"M1": {Properties: map[string]v1beta1.JSONSchemaProps{"name": str}},
+				"M2": {Properties: map[string]v1beta1.JSONSchemaProps{"name": {Type: "integer"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "identical",
+			members: v1beta1.JSONSchemaDefinitions{
+				"M1": {Properties: map[string]v1beta1.JSONSchemaProps{"name": str}},
+				"M2": {Properties: map[string]v1beta1.JSONSchemaProps{"name": {Type: "string", Description: "Other doc."}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "shadowed by the definition",
+			members: v1beta1.JSONSchemaDefinitions{
+				"M1": {Properties: map[string]v1beta1.JSONSchemaProps{"name": str}},
+				"M2": {Properties: map[string]v1beta1.JSONSchemaProps{"name": {Type: "integer"}}},
+			},
+			own:  map[string]v1beta1.JSONSchemaProps{"name": {Type: "boolean"}},
+			want: "boolean",
+		},
+		{
+			name: "tagged",
+			members: v1beta1.JSONSchemaDefinitions{
+				"M1": {Properties: map[string]v1beta1.JSONSchemaProps{"name": str}},
+				"M2": {Properties: map[string]v1beta1.JSONSchemaProps{"name": {Type: "integer"}}},
+			},
+			tagged: map[string]map[string]bool{"M2": {"name": true}},
+			want:   "integer",
+		},
+		{
+			name: "both tagged",
+			members: v1beta1.JSONSchemaDefinitions{
+				"M1": {Properties: map[string]v1beta1.JSONSchemaProps{"name": str}},
+				"M2": {Properties: map[string]v1beta1.JSONSchemaProps{"name": {Type: "integer"}}},
+			},
+			tagged:  map[string]map[string]bool{"M1": {"name": true}, "M2": {"name": true}},
+			wantErr: true,
+		},
+		{
+			name: "shallower",
+			members: v1beta1.JSONSchemaDefinitions{
+				"M1": {AllOf: []v1beta1.JSONSchemaProps{{Ref: ref("M3")}}},
+				"M2": {Properties: map[string]v1beta1.JSONSchemaProps{"name": {Type: "integer"}}},
+				"M3": {Properties: map[string]v1beta1.JSONSchemaProps{"name": str}},
+			},
+			tagged: map[string]map[string]bool{"M3": {"name": true}},
+			want:   "integer",
+		},
+	}
+	for _, tt := range tests {
+		for _, strict := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/strict %v", tt.name, strict), func(t *testing.T) {
+				defs := v1beta1.JSONSchemaDefinitions{}
+				for name, def := range tt.members {
+					defs[name] = *def.DeepCopy()
+				}
+				defs["T"] = v1beta1.JSONSchemaProps{
+					Properties: tt.own,
+					AllOf:      []v1beta1.JSONSchemaProps{{Ref: ref("M1")}, {Ref: ref("M2")}},
+				}
+				err := flattenAllOf(defs, tt.tagged, strict, 32)
+				if strict && tt.wantErr {
+					if err == nil || !strings.Contains(err.Error(), `property "name" of T`) {
+						t.Errorf("flattenAllOf() = %v, want a conflict on name", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("flattenAllOf() = %v", err)
+				}
+				if got := defs["T"].Properties["name"].Type; got != tt.want {
+					t.Errorf("name is a %q, want a %q", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestFlattenAllOfChain(t *testing.T) {
+	src := `package api
+
+type C struct {
+	C string ` + "`json:\"c\"`" + `
+}
+
+type B struct {
+	C
+	B string ` + "`json:\"b\"`" + `
+}
+
+type A struct {
+	B
+	A string ` + "`json:\"a\"`" + `
+}
+`
+	op := testGenerator(t, map[string]string{"types.go": src}, "A")
+	op.Flatten = true
+	schema, err := op.GenerateSchema()
+	if err != nil {
+		t.Fatalf("GenerateSchema() = %v", err)
+	}
+	def := definition(t, schema, "A")
+	if len(def.AllOf) > 0 {
+		t.Errorf("A has allOf %v, want it flattened", def.AllOf)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if _, ok := def.Properties[name]; !ok {
+			t.Errorf("A has no %s property", name)
+		}
+	}
+	// The embedded structs aren't referred to anymore.
+	if len(schema.Definitions) != 1 {
+		t.Errorf("the definitions are %v, want only A", schema.Definitions)
+	}
+}
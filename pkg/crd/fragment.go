@@ -0,0 +1,64 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// fragmentMarker replaces the schema of a field with the one of a JSON file,
+// e.g. +schemagen:ref=schemas/legacy.json, for the fields whose schema can't
+// be told from their type. A relative path is relative to the directory of
+// the Go file. The refs of the fragment are written as they are.
+const fragmentMarker = "schemagen:ref"
+
+// fragmentPath returns the path of the fragment the marker of field points
+// at, or an empty string.
+func (f *file) fragmentPath(field *ast.Field) string {
+	for _, c := range f.commentMap[field] {
+		path := Comments(strings.Split(c.Text(), "\n")).getTag(fragmentMarker, "=")
+		if path = strings.TrimSpace(path); path != "" {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(f.dir, path)
+			}
+			return path
+		}
+	}
+	return ""
+}
+
+// loadFragment reads the schema of the fragment at path. Keywords the schema
+// of a CRD doesn't know are an error, likely a typo.
+func (f *file) loadFragment(path string) (*v1beta1.JSONSchemaProps, error) {
+	b, err := afero.ReadFile(f.fs, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema fragment %q: %v", path, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	def := &v1beta1.JSONSchemaProps{}
+	if err := dec.Decode(def); err != nil {
+		return nil, fmt.Errorf("failed to parse schema fragment %q: %v", path, err)
+	}
+	return def, nil
+}
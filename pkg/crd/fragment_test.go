@@ -0,0 +1,90 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFragmentMarker(t *testing.T) {
+	tests := []struct {
+		name     string
+		marker   string
+		fragment string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:     "fragment",
+			marker:   "schemas/legacy.json",
+			fragment: `{"type": "object", "properties": {"v": {"type": "integer"}}, "required": ["v"]}`,
+			want:     `{"description":"Blob is a legacy blob.","type":"object","required":["v"],"properties":{"v":{"type":"integer"}}}`,
+		},
+		{
+			name:     "own description",
+			marker:   "schemas/legacy.json",
+			fragment: `{"description": "A blob.", "type": "string"}`,
+			want:     `{"description":"A blob.","type":"string"}`,
+		},
+		{
+			name:     "absolute path",
+			marker:   "/src/example.com/api/schemas/legacy.json",
+			fragment: `{"type": "string"}`,
+			want:     `{"description":"Blob is a legacy blob.","type":"string"}`,
+		},
+		{
+			name:    "missing",
+			marker:  "schemas/missing.json",
+			wantErr: `failed to read schema fragment "/src/example.com/api/schemas/missing.json"`,
+		},
+		{
+			name:     "malformed",
+			marker:   "schemas/legacy.json",
+			fragment: `{"type": `,
+			wantErr:  `failed to parse schema fragment "/src/example.com/api/schemas/legacy.json"`,
+		},
+		{
+			name:     "unknown keyword",
+			marker:   "schemas/legacy.json",
+			fragment: `{"typ": "string"}`,
+			wantErr:  `unknown field "typ"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package api\n\ntype T struct {\n\t// Blob is a legacy blob.\n\t// +schemagen:ref=" + tt.marker + "\n\tBlob []byte `json:\"blob\"`\n}\n"
+			files := map[string]string{"types.go": src}
+			if tt.fragment != "" {
+				files["schemas/legacy.json"] = tt.fragment
+			}
+			op := testGenerator(t, files, "T")
+			op.Flatten = true
+			schema, err := op.GenerateSchema()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("GenerateSchema() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateSchema() = %v", err)
+			}
+			if got := compactJSON(t, definition(t, schema, "T").Properties["blob"]); got != tt.want {
+				t.Errorf("blob is %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
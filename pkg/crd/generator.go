@@ -15,17 +15,28 @@
 package crd
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"go/ast"
 	"go/build"
+	"go/constant"
+	"go/doc"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"io/ioutil"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/ghodss/yaml"
 	"github.com/spf13/afero"
@@ -37,89 +48,165 @@ import (
 
 const (
 	defPrefix = "#/definitions/"
-	inlineTag = "inline"
+	// componentsPrefix replaces defPrefix in the refs of the openapi3 output.
+	componentsPrefix = "#/components/schemas/"
+	// defsPrefix replaces defPrefix in the refs from schema version 2019-09.
+	defsPrefix = "#/$defs/"
+	inlineTag  = "inline"
+	// stringTag is the option encoding/json writes the numbers and booleans
+	// quoted with, e.g. `json:"count,string"`.
+	stringTag = "string"
 )
 
-// Checks whether the typeName represents a simple json type
+// fieldTag is the parsed json (or yaml) tag of a struct field.
+// For example, for the struct
+//
+//	type MyType struct {
+//	  MyField string `json:"myField,omitempty"`
+//	}
+//
+// the tag of MyField has the name "myField" and the options "omitempty".
+type fieldTag struct {
+	// name is the key of the field, empty if the tag doesn't set one.
+	name string
+	// options are the comma-separated options following the name.
+	options tagOptions
+	// ignored is set for fields tagged with "-", which are never serialized.
+	ignored bool
+}
+
+// tagOptions is the comma-separated list of options of a struct tag.
+type tagOptions []string
 
-// Removes a character by replacing it with a space
-func removeChar(str string, removedStr string) string {
-	return strings.Replace(str, removedStr, " ", -1)
+// contains returns true if the options contain the given option.
+func (o tagOptions) contains(option string) bool {
+	for _, opt := range o {
+		if opt == option {
+			return true
+		}
+	}
+	return false
 }
 
-// This is a hacky function that does the one job of
-// extracting the tag values in the structs
-// Example struct:
-// type MyType struct {
-//   MyField string `yaml:"myField,omitempty"`
-// }
-//
-// From the above example struct, we need to extract
-// and return this: ("myField", "omitempty")
-func extractFromTag(tag *ast.BasicLit) (string, string) {
+// parseFieldTag parses the json tag of a struct field, or the yaml tag if
+// there is no json tag. The name is everything before the first comma, so
+// `json:",omitempty"` has no name, and `json:"-,"` is the name "-".
+func parseFieldTag(tag *ast.BasicLit) fieldTag {
 	if tag == nil || tag.Value == "" {
-		return "", ""
+		return fieldTag{}
 	}
-	tagValue := tag.Value
-
-	tagValue = removeChar(tagValue, "`")
-	tagValue = removeChar(tagValue, `"`)
-	tagValue = strings.TrimSpace(tagValue)
-
-	var tagContent, tagKey string
-	fmt.Sscanf(tagValue, `%s %s`, &tagKey, &tagContent)
-
-	if tagKey != "json:" && tagKey != "yaml:" {
-		return "", ""
+	rawTag, err := strconv.Unquote(tag.Value)
+	if err != nil {
+		return fieldTag{}
+	}
+	structTag := reflect.StructTag(rawTag)
+	value, ok := structTag.Lookup("json")
+	if !ok {
+		value, ok = structTag.Lookup("yaml")
+	}
+	if !ok {
+		return fieldTag{}
+	}
+	if strings.TrimSpace(value) == "-" {
+		return fieldTag{ignored: true}
 	}
 
-	if strings.Contains(tagContent, ",") {
-		splitContent := strings.Split(tagContent, ",")
-		return splitContent[0], splitContent[1]
+	parts := strings.Split(value, ",")
+	ft := fieldTag{name: strings.TrimSpace(parts[0])}
+	for _, opt := range parts[1:] {
+		if opt = strings.TrimSpace(opt); opt != "" {
+			ft.options = append(ft.options, opt)
+		}
 	}
-	return tagContent, ""
+	return ft
 }
 
 // exprToSchema converts ast.Expr to JSONSchemaProps
-func (f *file) exprToSchema(t ast.Expr, doc string, comments []*ast.CommentGroup) (*v1beta1.JSONSchemaProps, []TypeReference) {
+func (f *file) exprToSchema(t ast.Expr, doc string, comments []*ast.CommentGroup) (*v1beta1.JSONSchemaProps, []TypeReference, error) {
 	var def *v1beta1.JSONSchemaProps
 	var externalTypeRefs []TypeReference
+	var err error
 
 	switch tt := t.(type) {
 	case *ast.Ident:
-		def = f.identToSchema(tt, comments)
+		def, err = f.identToSchema(tt, comments)
 	case *ast.ArrayType:
-		def, externalTypeRefs = f.arrayTypeToSchema(tt, doc, comments)
+		def, externalTypeRefs, err = f.arrayTypeToSchema(tt, doc, comments)
 	case *ast.MapType:
-		def = f.mapTypeToSchema(tt, doc, comments)
+		def, externalTypeRefs, err = f.mapTypeToSchema(tt, doc, comments)
 	case *ast.SelectorExpr:
-		def, externalTypeRefs = f.selectorExprToSchema(tt, comments)
+		def, externalTypeRefs, err = f.selectorExprToSchema(tt, comments)
 	case *ast.StarExpr:
-		def, externalTypeRefs = f.exprToSchema(tt.X, "", comments)
+		// Pointers are unwrapped wherever they are, e.g. the items of
+		// []*Foo and the array of *[]Foo are both described by Foo. The
+		// nullability of a pointer field is decided by fieldOptionality.
+		def, externalTypeRefs, err = f.exprToSchema(tt.X, "", comments)
+		if err == nil && f.options.NullablePointers {
+			def.Nullable = true
+		}
 	case *ast.StructType:
-		def, externalTypeRefs = f.structTypeToSchema(tt)
-	case *ast.InterfaceType: // TODO: handle interface if necessary.
-		return &v1beta1.JSONSchemaProps{}, []TypeReference{}
+		def, externalTypeRefs, err = f.structTypeToSchema(tt)
+	case *ast.InterfaceType:
+		def, err := f.interfaceToSchema(tt)
+		return def, []TypeReference{}, err
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		// Each instantiation is generated once, when the package is
+		// parsed, and shared through refs by all the fields using it.
+		def, err = f.instanceToSchema(tt, comments)
+	case *ast.ChanType, *ast.FuncType:
+		return nil, nil, fmt.Errorf("%s types can't be serialized to JSON, unexport the field or tag it json:\"-\"", kindOfUnserializable(tt))
+	default:
+		return nil, nil, fmt.Errorf("unsupported type %T", t)
+	}
+	if err != nil {
+		return nil, nil, err
 	}
 	def.Description = filterDescription(doc)
 
-	return def, externalTypeRefs
+	return def, externalTypeRefs, nil
+}
+
+// kindOfUnserializable returns "channel" or "function" if t is such a type,
+// which encoding/json can't marshal, and "" otherwise.
+func kindOfUnserializable(t ast.Expr) string {
+	switch t.(type) {
+	case *ast.ChanType:
+		return "channel"
+	case *ast.FuncType:
+		return "function"
+	}
+	return ""
 }
 
 // identToSchema converts ast.Ident to JSONSchemaProps.
-func (f *file) identToSchema(ident *ast.Ident, comments []*ast.CommentGroup) *v1beta1.JSONSchemaProps {
+func (f *file) identToSchema(ident *ast.Ident, comments []*ast.CommentGroup) (*v1beta1.JSONSchemaProps, error) {
+	if arg, ok := f.typeArgs[ident.Name]; ok {
+		def := arg.def.DeepCopy()
+		return def, processMarkersInComments(def, f.report, comments...)
+	}
+	if isAnyType(ident) {
+		return f.emptySchema(), nil
+	}
 	def := &v1beta1.JSONSchemaProps{}
 	if isSimpleType(ident.Name) {
 		def.Type = jsonifyType(ident.Name)
+		if !f.options.OmitNumberFormats {
+			def.Format = jsonifyFormat(ident.Name)
+		}
+		if isUnsignedType(ident.Name) {
+			minimum := 0.0
+			def.Minimum = &minimum
+		}
+	} else if known, ok := f.knownType(f.pkgPath, ident.Name); ok {
+		def = known
 	} else {
 		def.Ref = getPrefixedDefLink(ident.Name, f.pkgPrefix)
 	}
-	processMarkersInComments(def, comments...)
-	return def
+	return def, processMarkersInComments(def, f.report, comments...)
 }
 
 // identToSchema converts ast.SelectorExpr to JSONSchemaProps.
-func (f *file) selectorExprToSchema(selectorType *ast.SelectorExpr, comments []*ast.CommentGroup) (*v1beta1.JSONSchemaProps, []TypeReference) {
+func (f *file) selectorExprToSchema(selectorType *ast.SelectorExpr, comments []*ast.CommentGroup) (*v1beta1.JSONSchemaProps, []TypeReference, error) {
 	pkgAlias := selectorType.X.(*ast.Ident).Name
 	typeName := selectorType.Sel.Name
 
@@ -128,56 +215,58 @@ func (f *file) selectorExprToSchema(selectorType *ast.SelectorExpr, comments []*
 		PackageName: f.importPaths[pkgAlias],
 	}
 
-	time := TypeReference{TypeName: "Time", PackageName: "k8s.io/apimachinery/pkg/apis/meta/v1"}
-	duration := TypeReference{TypeName: "Duration", PackageName: "k8s.io/apimachinery/pkg/apis/meta/v1"}
-	quantity := TypeReference{TypeName: "Quantity", PackageName: "k8s.io/apimachinery/pkg/api/resource"}
 	unstructured := TypeReference{TypeName: "Unstructured", PackageName: "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"}
 	rawExtension := TypeReference{TypeName: "RawExtension", PackageName: "k8s.io/apimachinery/pkg/runtime"}
-	intOrString := TypeReference{TypeName: "IntOrString", PackageName: "k8s.io/apimachinery/pkg/util/intstr"}
-
-	switch typ {
-	case time:
-		return &v1beta1.JSONSchemaProps{
-			Type:   "string",
-			Format: "date-time",
-		}, []TypeReference{}
-	case duration:
-		return &v1beta1.JSONSchemaProps{
-			Type: "string",
-		}, []TypeReference{}
-	case quantity:
-		return &v1beta1.JSONSchemaProps{
-			Type: "string",
-		}, []TypeReference{}
-	case unstructured, rawExtension:
-		return &v1beta1.JSONSchemaProps{
-			Type: "object",
-		}, []TypeReference{}
-	case intOrString:
-		return &v1beta1.JSONSchemaProps{
-			AnyOf: []v1beta1.JSONSchemaProps{
-				{
-					Type: "string",
-				},
-				{
-					Type: "integer",
-				},
-			},
-		}, []TypeReference{}
-	}
+	rawMessage := TypeReference{TypeName: "RawMessage", PackageName: "encoding/json"}
 
-	def := &v1beta1.JSONSchemaProps{
-		Ref: getPrefixedDefLink(typeName, f.importPaths[pkgAlias]),
+	var def *v1beta1.JSONSchemaProps
+	externalTypeRefs := []TypeReference{}
+	known, isKnown := f.knownType(typ.PackageName, typ.TypeName)
+	switch {
+	case isKnown:
+		def = known
+	case typ == unstructured, typ == rawExtension:
+		def = f.emptySchema()
+		def.Type = "object"
+	case typ == rawMessage:
+		// Any JSON value, like interface{}.
+		def = f.emptySchema()
+	default:
+		def = &v1beta1.JSONSchemaProps{
+			Ref: getPrefixedDefLink(typeName, f.importPaths[pkgAlias]),
+		}
+		externalTypeRefs = []TypeReference{{TypeName: typeName, PackageName: pkgAlias}}
 	}
-	processMarkersInComments(def, comments...)
-	return def, []TypeReference{{TypeName: typeName, PackageName: pkgAlias}}
+	// Markers apply to the well-known types too, e.g. a Pattern on a Duration.
+	return def, externalTypeRefs, processMarkersInComments(def, f.report, comments...)
 }
 
 // arrayTypeToSchema converts ast.ArrayType to JSONSchemaProps by examining the elements in the array.
-func (f *file) arrayTypeToSchema(arrayType *ast.ArrayType, doc string, comments []*ast.CommentGroup) (*v1beta1.JSONSchemaProps, []TypeReference) {
+// The items are converted like any other type, so the ones of []map[string]int
+// are objects with additionalProperties, and nested slices are nested arrays.
+func (f *file) arrayTypeToSchema(arrayType *ast.ArrayType, doc string, comments []*ast.CommentGroup) (*v1beta1.JSONSchemaProps, []TypeReference, error) {
+	// Like encoding/json, a byte slice is a base64 encoded string.
+	if isByteSlice(arrayType) {
+		def := &v1beta1.JSONSchemaProps{
+			Type:        "string",
+			Format:      "byte",
+			Description: doc,
+		}
+		return def, nil, processMarkersInComments(def, f.report, comments...)
+	}
+
 	// not passing doc down to exprToSchema
-	items, extRefs := f.exprToSchema(arrayType.Elt, "", comments)
-	processMarkersInComments(items, comments...)
+	items, extRefs, err := f.exprToSchema(arrayType.Elt, "", itemComments(comments))
+	if err != nil {
+		return nil, nil, err
+	}
+	// The item markers of nested arrays, e.g. [][]string, apply to the
+	// innermost items only, the nested array has set them already.
+	if items.Type != "array" {
+		if err := processMarkersInComments(items, f.report, itemComments(comments)...); err != nil {
+			return nil, nil, err
+		}
+	}
 
 	def := &v1beta1.JSONSchemaProps{
 		Type:        "array",
@@ -185,51 +274,187 @@ func (f *file) arrayTypeToSchema(arrayType *ast.ArrayType, doc string, comments
 		Description: doc,
 	}
 
+	// A fixed-size array always holds exactly its length of items.
+	var n int64
+	var known bool
+	if arrayType.Len != nil {
+		if n, known = arrayLength(arrayType); known {
+			def.MinItems = &n
+			def.MaxItems = &n
+		} else {
+			log.Printf("can't work out the length of array %s, leaving its size unbounded", types.ExprString(arrayType))
+		}
+	}
+	if hasTupleMarker(comments) {
+		tupleSchema(def, n, known)
+	}
+	if err := processArrayMarkersInComments(def, f.report, comments...); err != nil {
+		return nil, nil, err
+	}
+
 	// TODO: clear the schema on the parent level, since it is on the children level.
 
-	return def, extRefs
+	return def, extRefs, nil
 }
 
-// mapTypeToSchema converts ast.MapType to JSONSchemaProps.
-func (f *file) mapTypeToSchema(mapType *ast.MapType, doc string, comments []*ast.CommentGroup) *v1beta1.JSONSchemaProps {
-	def := &v1beta1.JSONSchemaProps{}
-	switch mapType.Value.(type) {
+// isByteSlice tells if arrayType is []byte. Fixed-size byte arrays are
+// encoded as arrays of numbers.
+func isByteSlice(arrayType *ast.ArrayType) bool {
+	elt, ok := arrayType.Elt.(*ast.Ident)
+	return ok && arrayType.Len == nil && (elt.Name == byteType || elt.Name == "uint8")
+}
+
+// arrayLength returns the length of a fixed-size array. It returns false for
+// lengths that can't be worked out from the file alone.
+func arrayLength(arrayType *ast.ArrayType) (int64, bool) {
+	v := constValue(arrayType.Len)
+	if v.Kind() != constant.Int {
+		return 0, false
+	}
+	return constant.Int64Val(v)
+}
+
+// constValue evaluates a constant expression made of integer literals,
+// constants declared in the same file and arithmetic on them. The result is
+// unknown for anything else, e.g. constants declared with iota or in other
+// files.
+func constValue(expr ast.Expr) constant.Value {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		return constant.MakeFromLiteral(e.Value, e.Kind, 0)
+	case *ast.ParenExpr:
+		return constValue(e.X)
 	case *ast.Ident:
-		valueType := mapType.Value.(*ast.Ident)
-		if def.AdditionalProperties == nil {
-			def.AdditionalProperties = &v1beta1.JSONSchemaPropsOrBool{}
+		if e.Obj == nil || e.Obj.Kind != ast.Con {
+			break
+		}
+		spec, ok := e.Obj.Decl.(*ast.ValueSpec)
+		if !ok {
+			break
 		}
-		def.AdditionalProperties.Schema = new(v1beta1.JSONSchemaProps)
+		for i, name := range spec.Names {
+			if name.Name == e.Name && i < len(spec.Values) {
+				return constValue(spec.Values[i])
+			}
+		}
+	case *ast.BinaryExpr:
+		return intBinaryOp(constValue(e.X), e.Op, constValue(e.Y))
+	}
+	return constant.MakeUnknown()
+}
 
-		if isSimpleType(valueType.Name) {
-			def.AdditionalProperties.Schema.Type = valueType.Name
-		} else {
-			def.AdditionalProperties.Schema.Ref = getPrefixedDefLink(valueType.Name, f.pkgPrefix)
+// intBinaryOp returns x op y for integer constants. It is unknown for the
+// other constants.
+func intBinaryOp(x constant.Value, op token.Token, y constant.Value) constant.Value {
+	if x.Kind() != constant.Int || y.Kind() != constant.Int {
+		return constant.MakeUnknown()
+	}
+	switch op {
+	case token.SHL, token.SHR:
+		if s, ok := constant.Uint64Val(y); ok {
+			return constant.Shift(x, op, uint(s))
+		}
+	case token.ADD, token.SUB, token.MUL, token.REM, token.AND, token.OR, token.XOR, token.AND_NOT:
+		return constant.BinaryOp(x, op, y)
+	case token.QUO:
+		// QUO_ASSIGN asks for the truncated integer division.
+		if constant.Sign(y) != 0 {
+			return constant.BinaryOp(x, token.QUO_ASSIGN, y)
 		}
-	case *ast.InterfaceType:
-		// No op
-		panic("Map Interface Type")
 	}
-	def.Type = "object"
-	def.Description = doc
-	processMarkersInComments(def, comments...)
-	return def
+	return constant.MakeUnknown()
+}
+
+// mapTypeToSchema converts ast.MapType to JSONSchemaProps. The values are
+// described by additionalProperties, whatever their type is, e.g. an array for
+// map[string][]string.
+func (f *file) mapTypeToSchema(mapType *ast.MapType, doc string, comments []*ast.CommentGroup) (*v1beta1.JSONSchemaProps, []TypeReference, error) {
+	if err := checkMapKey(mapType.Key); err != nil {
+		return nil, nil, err
+	}
+
+	// Markers on the field describe the map itself, not its values.
+	value, extRefs, err := f.exprToSchema(mapType.Value, "", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	def := &v1beta1.JSONSchemaProps{
+		Type:                 "object",
+		AdditionalProperties: &v1beta1.JSONSchemaPropsOrBool{Allows: true, Schema: value},
+		Description:          doc,
+	}
+	// A free-form map keeps its unknown fields itself, e.g. in a CRD where
+	// additionalProperties can't be combined with them.
+	if isAnyType(mapType.Value) && value.XPreserveUnknownFields != nil {
+		def.AdditionalProperties = nil
+		def.XPreserveUnknownFields = value.XPreserveUnknownFields
+	}
+	return def, extRefs, processMarkersInComments(def, f.report, comments...)
+}
+
+// checkMapKey returns an error when keys of the given type can't be object
+// keys. Named types are accepted, since whether they are string kinded isn't
+// known from the syntax alone.
+func checkMapKey(key ast.Expr) error {
+	switch k := key.(type) {
+	case *ast.Ident:
+		if k.Name == "string" || !isSimpleType(k.Name) {
+			return nil
+		}
+	case *ast.SelectorExpr:
+		return nil
+	}
+	return fmt.Errorf("unsupported map key type %s, JSON object keys must be strings", types.ExprString(key))
 }
 
 // structTypeToSchema converts ast.StructType to JSONSchemaProps by examining each field in the struct.
-func (f *file) structTypeToSchema(structType *ast.StructType) (*v1beta1.JSONSchemaProps, []TypeReference) {
+func (f *file) structTypeToSchema(structType *ast.StructType) (*v1beta1.JSONSchemaProps, []TypeReference, error) {
 	def := &v1beta1.JSONSchemaProps{
 		Type: "object",
 	}
 	externalTypeRefs := []TypeReference{}
+	// keyFields are the fields having each key so far.
+	keyFields := map[string]*ast.Field{}
 	for _, field := range structType.Fields.List {
-		yamlName, option := extractFromTag(field.Tag)
-
-		if (yamlName == "" && option != inlineTag) || yamlName == "-" {
+		tag := parseFieldTag(field.Tag)
+		yamlName, inline, ok := fieldKey(field, tag)
+		switch {
+		case !ok && tag.ignored:
+			f.report.skipField(skippedIgnored)
+			continue
+		case !ok:
+			f.report.skipField(skippedUnexported)
+			continue
+		case f.ignoredField(field):
+			f.report.skipField(skippedMarker)
 			continue
 		}
 
-		if option != inlineTag && option != "omitempty" {
+		if other, ok := keyFields[yamlName]; ok && !inline {
+			// Like encoding/json, the field naming the key in its tag wins
+			// over the one named after it.
+			otherTagged := parseFieldTag(other.Tag).name != ""
+			if otherTagged == (tag.name != "") {
+				return nil, nil, fmt.Errorf("fields %s and %s both have the key %q, encoding/json leaves both out", fieldGoName(other), fieldGoName(field), yamlName)
+			}
+			if otherTagged {
+				continue
+			}
+			var required []string
+			for _, name := range def.Required {
+				if name != yamlName {
+					required = append(required, name)
+				}
+			}
+			def.Required = required
+		}
+		if !inline {
+			keyFields[yamlName] = field
+		}
+
+		required, nullable := f.fieldOptionality(field, tag)
+		if !inline && required {
 			def.Required = append(def.Required, yamlName)
 		}
 
@@ -237,47 +462,278 @@ func (f *file) structTypeToSchema(structType *ast.StructType) (*v1beta1.JSONSche
 			def.Properties = make(map[string]v1beta1.JSONSchemaProps)
 		}
 
-		propDef, propExternalTypeDefs := f.exprToSchema(field.Type, field.Doc.Text(), f.commentMap[field])
+		// The pointer of the field itself is up to fieldOptionality.
+		fieldType := field.Type
+		if star, ok := fieldType.(*ast.StarExpr); ok {
+			fieldType = star.X
+		}
+		var propDef *v1beta1.JSONSchemaProps
+		var propExternalTypeDefs []TypeReference
+		typeOverride, err := f.typeOverride(field)
+		switch fragment := f.fragmentPath(field); {
+		case err != nil:
+		case fragment != "":
+			propDef, err = f.loadFragment(fragment)
+			if err == nil && propDef.Description == "" {
+				propDef.Description = filterDescription(fieldDoc(field))
+			}
+		case typeOverride != "":
+			// The Go type isn't walked, only the markers of the field
+			// apply.
+			propDef = &v1beta1.JSONSchemaProps{Type: typeOverride, Description: filterDescription(fieldDoc(field))}
+			err = processMarkersInComments(propDef, f.report, f.commentMap[field]...)
+		default:
+			propDef, propExternalTypeDefs, err = f.exprToSchema(fieldType, fieldDoc(field), f.commentMap[field])
+		}
+		if err == nil {
+			err = checkBounds(propDef)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("field %s: %v", yamlName, err)
+		}
+		if tag.options.contains(stringTag) {
+			quoteScalar(propDef, yamlName)
+		}
+		types, err := f.scalarTypes(field)
+		if err != nil {
+			return nil, nil, fmt.Errorf("field %s: %v", yamlName, err)
+		}
+		if len(types) > 0 {
+			scalarUnion(propDef, types)
+			// The schema of the type isn't referred to anymore.
+			propExternalTypeDefs = nil
+		}
 
 		externalTypeRefs = append(externalTypeRefs, propExternalTypeDefs...)
 
-		if option == inlineTag {
+		if inline {
 			def.AllOf = append(def.AllOf, *propDef)
 			continue
 		}
+		propDef.Title = f.title(fieldGoName(field), f.commentMap[field])
+		if nullable {
+			propDef.Nullable = true
+		}
 
 		def.Properties[yamlName] = *propDef
 	}
 
-	return def, externalTypeRefs
+	return def, externalTypeRefs, nil
+}
+
+// scalarPatterns are the patterns of the quoted numbers and booleans, see
+// quoteScalar.
+var scalarPatterns = map[string]string{
+	"integer": `^-?[0-9]+$`,
+	"number":  `^-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?$`,
+	"boolean": `^(true|false)$`,
+}
+
+// quoteScalar turns def, the schema of a field tagged with the string option,
+// into the one of the string encoding/json writes its value as. The
+// validation of the number is lost but for the pattern, the enum and default
+// values are quoted too. The type behind a ref isn't known while parsing, it
+// is left as is.
+func quoteScalar(def *v1beta1.JSONSchemaProps, name string) {
+	pattern, ok := scalarPatterns[def.Type]
+	if !ok {
+		if def.Ref != nil {
+			log.Printf("Ignoring the string option of field %s, the type %s isn't known to be a number or a boolean", name, getNameFromURL(*def.Ref))
+		}
+		return
+	}
+	quoted := v1beta1.JSONSchemaProps{
+		Type:        "string",
+		Pattern:     pattern,
+		Description: def.Description,
+		Title:       def.Title,
+		Nullable:    def.Nullable,
+	}
+	for _, e := range def.Enum {
+		quoted.Enum = append(quoted.Enum, v1beta1.JSON{Raw: []byte(strconv.Quote(string(e.Raw)))})
+	}
+	if def.Default != nil {
+		quoted.Default = &v1beta1.JSON{Raw: []byte(strconv.Quote(string(def.Default.Raw)))}
+	}
+	*def = quoted
+}
+
+// fieldKey returns the key of a struct field in the JSON object, and whether
+// the fields of its type are promoted instead. It returns false for the fields
+// that aren't serialized.
+func fieldKey(field *ast.Field, tag fieldTag) (string, bool, bool) {
+	if tag.ignored {
+		return "", false, false
+	}
+	// Like encoding/json, an embedded struct without a name in its tag
+	// has its fields promoted. Given a name it is a regular property.
+	embedded := len(field.Names) == 0
+	inline := tag.options.contains(inlineTag) || (embedded && tag.name == "")
+
+	// Without a name in the tag the Go field name is the key, like
+	// encoding/json does.
+	key := tag.name
+	if key == "" && !inline {
+		if !field.Names[0].IsExported() {
+			return "", false, false
+		}
+		key = field.Names[0].Name
+	}
+	return key, inline, true
+}
+
+// taggedKeys returns the keys of the fields of st named by their json tag.
+// They win over the fields promoted from embedded structs at the same depth
+// having the same key, see dominantField.
+func taggedKeys(st *ast.StructType) map[string]bool {
+	keys := map[string]bool{}
+	for _, field := range st.Fields.List {
+		tag := parseFieldTag(field.Tag)
+		if key, inline, ok := fieldKey(field, tag); ok && !inline && tag.name != "" {
+			keys[key] = true
+		}
+	}
+	return keys
+}
+
+// fieldOptionality tells if a struct field is required and if its schema
+// is nullable, the rules of both are kept here so they don't drift apart.
+// Without markers, and with the default options:
+//
+//	type     omitempty  required  nullable
+//	value    no         yes       no
+//	value    yes        no        no
+//	pointer  no         no        yes
+//	pointer  yes        no        yes
+//
+// A pointer can always be left out, nil is written as null unless the field
+// is tagged omitempty, and null is read as nil in any case. The pointers are
+// only nullable with NullablePointers. A +required or +optional marker on the
+// field always decides if it is required, otherwise every field is optional
+// with OptionalByDefault, and every value is required with AllFieldsRequired.
+func (f *file) fieldOptionality(field *ast.Field, tag fieldTag) (required, nullable bool) {
+	_, isPointer := field.Type.(*ast.StarExpr)
+	nullable = isPointer && f.options.NullablePointers
+	for _, c := range f.commentMap[field] {
+		for _, line := range strings.Split(c.Text(), "\n") {
+			switch strings.TrimSpace(line) {
+			case "+required", "+kubebuilder:validation:Required":
+				return true, nullable
+			case "+optional", "+kubebuilder:validation:Optional":
+				return false, nullable
+			}
+		}
+	}
+	if f.options.OptionalByDefault {
+		return false, nullable
+	}
+	if f.options.AllFieldsRequired {
+		return !isPointer, nullable
+	}
+	return !tag.options.contains("omitempty") && !isPointer, nullable
+}
+
+// scalarOrObjectMarker marks a type whose custom UnmarshalJSON accepts either
+// a scalar or the object, e.g. +schemagen:scalarOrObject=string.
+const scalarOrObjectMarker = "schemagen:scalarOrObject"
+
+// scalarOrObject returns the schema accepting either a value of the scalar
+// type or the object described by def.
+func scalarOrObject(def *v1beta1.JSONSchemaProps, scalar string) (*v1beta1.JSONSchemaProps, error) {
+	if def.Type != "object" {
+		return nil, fmt.Errorf("+%s can only be used on a struct type", scalarOrObjectMarker)
+	}
+	scalarType := scalar
+	if isSimpleType(scalar) {
+		scalarType = jsonifyType(scalar)
+	}
+	switch scalarType {
+	case "string", "integer", "number", "boolean":
+	default:
+		return nil, fmt.Errorf("+%s=%s is not a scalar type", scalarOrObjectMarker, scalar)
+	}
+
+	object := *def
+	object.Description = ""
+	return &v1beta1.JSONSchemaProps{
+		Description: def.Description,
+		AnyOf: []v1beta1.JSONSchemaProps{
+			{Type: scalarType},
+			object,
+		},
+	}, nil
+}
+
+// fieldDoc returns the doc comment of a struct field. A trailing line comment
+// is used when there is no comment above the field.
+func fieldDoc(field *ast.Field) string {
+	if field.Doc != nil {
+		return field.Doc.Text()
+	}
+	return field.Comment.Text()
 }
 
 func getReachableTypes(startingTypes map[string]bool, definitions v1beta1.JSONSchemaDefinitions) map[string]bool {
 	pruner := DefinitionPruner{definitions, startingTypes}
-	prunedTypes := pruner.Prune(true)
+	// Unknown types are ignored, so there is no error.
+	prunedTypes, _ := pruner.Prune(true)
 	return prunedTypes
 }
 
 type file struct {
+	// options are the options the generation was started with.
+	options *SingleVersionOptions
+	// lister is shared by the parsers of all the packages.
+	lister *packageLister
+	// pkgPath is the import path of the package being parsed.
+	pkgPath string
+	// sources holds where the types are declared, by definition name, when
+	// EmitSourceInfo is set. It is shared by the parsers of all the packages.
+	sources map[string]sourceInfo
 	// name prefix of the package
 	pkgPrefix string
 	// importPaths contains a map from import alias to the import path for the file.
 	importPaths map[string]string
 	// commentMap is comment mapping for this file.
 	commentMap ast.CommentMap
+	// report counts what the generation saw. It is shared by the parsers of
+	// all the packages.
+	report *coverageReport
+	// dir is the directory of the file, the paths of its markers are
+	// relative to.
+	dir string
+	// typeArgs are the arguments of the type parameters of the generic type
+	// being parsed, by parameter name.
+	typeArgs map[string]typeArg
+	// depth is how many instantiations the generic type being parsed is
+	// nested in.
+	depth int
+	// instances holds the instantiations of the generic types of the
+	// package, by definition name. It is shared by the parsers of all its
+	// files.
+	instances map[string]*instance
+
+	fs afero.Fs
 }
 
 func (pr *prsr) parseTypesInFile(filePath string, curPkgPrefix string, skipCRD bool) (
-	v1beta1.JSONSchemaDefinitions, ExternalReferences, crdSpecByKind) {
+	v1beta1.JSONSchemaDefinitions, ExternalReferences, crdSpecByKind, enumValues, error) {
 	// Open the input go file and parse the Abstract Syntax Tree
 	fset := token.NewFileSet()
 	srcFile, err := pr.fs.Open(filePath)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, nil, nil, err
 	}
+	defer srcFile.Close()
 	node, err := parser.ParseFile(fset, filePath, srcFile, parser.ParseComments)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, nil, nil, err
+	}
+
+	// A doc holding only markers, e.g. +groupName, doesn't describe the
+	// package, the doc of another file may.
+	if pr.packageDoc == "" && node.Doc != nil {
+		pr.packageDoc = doc.Synopsis(filterDescription(node.Doc.Text()))
 	}
 
 	if !skipCRD {
@@ -313,13 +769,22 @@ func (pr *prsr) parseTypesInFile(filePath string, curPkgPrefix string, skipCRD b
 	cmap := ast.NewCommentMap(fset, node, node.Comments)
 
 	f := &file{
+		options:     pr.options,
+		pkgPath:     pr.pkgPath,
 		pkgPrefix:   curPkgPrefix,
 		importPaths: importPaths,
 		commentMap:  cmap,
+		report:      pr.report,
+		dir:         filepath.Dir(filePath),
+		instances:   pr.instances,
+		fs:          pr.fs,
 	}
 
 	crdSpecs := crdSpecByKind{}
 	for i := range node.Decls {
+		if err := pr.ctx.Err(); err != nil {
+			return nil, nil, nil, nil, err
+		}
 		declaration, ok := node.Decls[i].(*ast.GenDecl)
 		if !ok {
 			continue
@@ -350,27 +815,114 @@ func (pr *prsr) parseTypesInFile(filePath string, curPkgPrefix string, skipCRD b
 		ts := declaration.Specs[0]
 		typeSpec, ok := ts.(*ast.TypeSpec)
 		if !ok {
-			fmt.Printf("spec type is: %T\n", ts)
+			fmt.Fprintf(os.Stderr, "spec type is: %T\n", ts)
 			continue
 		}
 
 		typeName := typeSpec.Name.Name
 		typeDescription := declaration.Doc.Text()
 
-		fmt.Println("Generating schema definition for type:", typeName)
-		def, refTypes := f.exprToSchema(typeSpec.Type, typeDescription, []*ast.CommentGroup{})
-		definitions[getFullName(typeName, curPkgPrefix)] = *def
-		externalRefs[getFullName(typeName, curPkgPrefix)] = refTypes
+		// Like the fields, values of these types can't be serialized, there
+		// is nothing to describe.
+		if kind := kindOfUnserializable(typeSpec.Type); kind != "" {
+			log.Printf("Skipping %s, %s types can't be serialized to JSON", typeName, kind)
+			continue
+		}
 
+		fmt.Fprintln(os.Stderr, "Generating schema definition for type:", typeName)
+		var def *v1beta1.JSONSchemaProps
+		var refTypes []TypeReference
+		if _, ok := typeSpec.Type.(*ast.InterfaceType); ok {
+			// AnonymousInterfacePolicy doesn't apply, a named interface
+			// accepts any value.
+			def, refTypes = f.emptySchema(), []TypeReference{}
+			if pr.options.AutoDiscoverImplementations {
+				def, err = pr.implementationsSchema(typeName, curPkgPrefix, def)
+			}
+			def.Description = filterDescription(typeDescription)
+		} else {
+			tf := f
+			if params := typeParamNames(typeSpec); len(params) > 0 {
+				pr.generics[typeName] = genericType{spec: typeSpec, decl: node.Decls[i], file: f}
+				tf = f.openGeneric(params)
+			}
+			// The validation markers of a named scalar, slice or map type
+			// apply to all its values, e.g. the bounds of a percentage.
+			def, refTypes, err = tf.exprToSchema(typeSpec.Type, typeDescription, f.commentMap[node.Decls[i]])
+			if err == nil {
+				err = checkBounds(def)
+			}
+		}
+		if err != nil {
+			return nil, nil, nil, nil, fmt.Errorf("type %s: %v", typeName, err)
+		}
 		var comments []string
 		for _, c := range f.commentMap[node.Decls[i]] {
 			comments = append(comments, strings.Split(c.Text(), "\n")...)
 		}
 
+		if _, ok := typeSpec.Type.(*ast.StructType); ok && Comments(comments).hasTag(closedMarker) {
+			if err := closeStruct(def, typeName, Comments(comments).getTag(closedMarker, "="), pr.options.Flatten, pr.report); err != nil {
+				return nil, nil, nil, nil, fmt.Errorf("type %s: %v", typeName, err)
+			}
+		}
+		// Like encoding/json, unexported fields and the ones tagged "-" are
+		// skipped, a struct having only those is any object, without
+		// properties. closeObjects only sees the objects with properties,
+		// such a struct accepts no key with DisallowUnknownFields.
+		if st, ok := typeSpec.Type.(*ast.StructType); ok && len(def.Properties) == 0 && len(def.AllOf) == 0 && def.AdditionalProperties == nil {
+			if pr.options.DisallowUnknownFields {
+				def.AdditionalProperties = &v1beta1.JSONSchemaPropsOrBool{Allows: false}
+			} else if len(st.Fields.List) > 0 {
+				pr.fieldless = append(pr.fieldless, getFullName(typeName, curPkgPrefix))
+			}
+		}
+		if st, ok := typeSpec.Type.(*ast.StructType); ok && isUnion(comments) {
+			if err := f.unionSchema(def, st); err != nil {
+				return nil, nil, nil, nil, fmt.Errorf("type %s: %v", typeName, err)
+			}
+		}
+		if scalar := Comments(comments).getTag(scalarOrObjectMarker, "="); scalar != "" {
+			if def, err = scalarOrObject(def, scalar); err != nil {
+				return nil, nil, nil, nil, fmt.Errorf("type %s: %v", typeName, err)
+			}
+		}
+
+		def.Title = f.title(typeName, f.commentMap[node.Decls[i]])
+
+		defPath := defPrefix + getFullName(typeName, curPkgPrefix)
+		pr.suppressions.add(defPath, Comments(comments).getTag(nowarnMarker, "="))
+		f.collectSuppressions(typeSpec.Type, defPath, pr.suppressions)
+		if err := collectExtensions(comments, getFullName(typeName, curPkgPrefix), pr.extensions); err != nil {
+			return nil, nil, nil, nil, fmt.Errorf("type %s: %v", typeName, err)
+		}
+		collectComment(comments, getFullName(typeName, curPkgPrefix), pr.extensions)
+
+		definitions[getFullName(typeName, curPkgPrefix)] = *def
+		if st, ok := typeSpec.Type.(*ast.StructType); ok {
+			pr.tagged[getFullName(typeName, curPkgPrefix)] = taggedKeys(st)
+		}
+		if typeSpec.Assign.IsValid() {
+			pr.aliases[getFullName(typeName, curPkgPrefix)] = true
+		}
+		externalRefs[getFullName(typeName, curPkgPrefix)] = refTypes
+		if pr.options.EmitSourceInfo {
+			pos := fset.Position(typeSpec.Pos())
+			pr.sources[getFullName(typeName, curPkgPrefix)] = sourceInfo{
+				File: path.Join(pr.pkgPath, filepath.Base(pos.Filename)),
+				Line: pos.Line,
+			}
+		}
+
 		if !skipCRD {
 			crdSpec := parseCRDs(comments)
 			if crdSpec != nil {
 				crdSpec.Names.Kind = typeName
+				// Like in Kubernetes, the version defaults to the name of the
+				// package, e.g. v1 for .../apis/v1.
+				if len(crdSpec.Versions[0].Name) == 0 {
+					crdSpec.Versions[0].Name = GetVersion(pr.pkgPath)
+				}
 				gk := schema.GroupKind{Kind: typeName}
 				crdSpecs[gk] = crdSpec
 				// TODO: validate the CRD spec for one version.
@@ -385,7 +937,84 @@ func (pr *prsr) parseTypesInFile(filePath string, curPkgPrefix string, skipCRD b
 		}
 	}
 
-	return definitions, externalRefs, crdSpecs
+	pr.marshalers.collect(node, curPkgPrefix)
+	return definitions, externalRefs, crdSpecs, collectEnumValues(node, curPkgPrefix, pr.options.IotaEnums), nil
+}
+
+// newPrsr returns the parser of the packages with the given options, whose
+// state is shared by the parsers of the packages they depend on.
+func newPrsr(ctx context.Context, op *SingleVersionOptions, lister *packageLister) *prsr {
+	return &prsr{
+		options:      op,
+		lister:       lister,
+		sources:      map[string]sourceInfo{},
+		suppressions: suppressions{},
+		extensions:   definitionKeywords{},
+		report:       &coverageReport{},
+		aliases:      map[string]bool{},
+		marshalers:   marshalers{},
+		enumDocs:     map[string]string{},
+		tagged:       map[string]map[string]bool{},
+		packages:     map[string]string{},
+		ctx:          ctx,
+		fs:           op.fs,
+	}
+}
+
+// join adds the state other gathered while parsing its packages to the one
+// of pr. It returns an error if they parsed different packages with the same
+// definition prefix, see addPackage.
+func (pr *prsr) join(other *prsr) error {
+	for name, source := range other.sources {
+		pr.sources[name] = source
+	}
+	for path, categories := range other.suppressions {
+		for category := range categories {
+			pr.suppressions.add(path, category)
+		}
+	}
+	for name, keywords := range other.extensions {
+		for keyword, value := range keywords {
+			pr.extensions.set(name, keyword, value)
+		}
+	}
+	pr.report.add(other.report)
+	for name := range other.aliases {
+		pr.aliases[name] = true
+	}
+	for name, text := range other.marshalers {
+		// A MarshalJSON method wins over a MarshalText one, see collect.
+		if prev, ok := pr.marshalers[name]; ok && !prev {
+			continue
+		}
+		pr.marshalers[name] = text
+	}
+	for name, doc := range other.enumDocs {
+		pr.enumDocs[name] = doc
+	}
+	for name, keys := range other.tagged {
+		pr.tagged[name] = keys
+	}
+	for _, pkgPath := range other.packages {
+		if err := pr.addPackage(pkgPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addPackage records the package with the given import path as parsed. It
+// returns an error if another package has the same definition prefix, e.g.
+// example.com/a.b and example.com/a/b, their definitions would collide.
+func (pr *prsr) addPackage(pkgPath string) error {
+	prefix := getPkgPrefix(pkgPath)
+	if other, ok := pr.packages[prefix]; ok && other != pkgPath {
+		paths := []string{other, pkgPath}
+		sort.Strings(paths)
+		return fmt.Errorf("packages %q and %q have the same definition prefix %q", paths[0], paths[1], prefix)
+	}
+	pr.packages[prefix] = pkgPath
+	return nil
 }
 
 // processTopLevelMarkers process top-level (not tied to a struct field) markers.
@@ -411,35 +1040,129 @@ func (pr *prsr) processTopLevelMarkers(comments []*ast.CommentGroup) {
 	}
 }
 
+// buildContext returns the context selecting the files of the packages, see
+// BuildTags, GOOS and GOARCH.
+func (op *SingleVersionOptions) buildContext() build.Context {
+	ctx := build.Default
+	ctx.BuildTags = op.BuildTags
+	if len(op.GOOS) > 0 {
+		ctx.GOOS = op.GOOS
+	}
+	if len(op.GOARCH) > 0 {
+		ctx.GOARCH = op.GOARCH
+	}
+	return ctx
+}
+
 // mock this in testing.
-var listFiles = func(pkgPath string) (string, []string, error) {
-	pkg, err := build.Import(pkgPath, "", 0)
+var listFiles = func(pkgPath string, ctx build.Context) (string, []string, error) {
+	// A directory, e.g. of a module that isn't published yet, is read as is.
+	if isLocalPackage(pkgPath) {
+		pkg, err := ctx.ImportDir(pkgPath, 0)
+		return pkg.Dir, pkg.GoFiles, err
+	}
+	pkg, err := ctx.Import(pkgPath, "", 0)
 	return pkg.Dir, pkg.GoFiles, err
 }
 
+// isLocalPackage tells if pkgPath is the directory of a package rather than
+// its import path, i.e. it is absolute, starts with ./ or ../, or is an
+// existing directory.
+func isLocalPackage(pkgPath string) bool {
+	if filepath.IsAbs(pkgPath) || build.IsLocalImport(pkgPath) {
+		return true
+	}
+	info, err := os.Stat(pkgPath)
+	return err == nil && info.IsDir()
+}
+
+// packageLister lists the files of every package once. The same package can be
+// reached from several others while walking the types.
+type packageLister struct {
+	mu       sync.Mutex
+	packages map[string]*listedPackage
+}
+
+type listedPackage struct {
+	once  sync.Once
+	dir   string
+	files []string
+	err   error
+}
+
+// list returns the directory and the Go files of the package, see listFiles.
+func (l *packageLister) list(pkgPath string, ctx build.Context) (string, []string, error) {
+	l.mu.Lock()
+	if l.packages == nil {
+		l.packages = make(map[string]*listedPackage)
+	}
+	pkg, ok := l.packages[pkgPath]
+	if !ok {
+		pkg = &listedPackage{}
+		l.packages[pkgPath] = pkg
+	}
+	l.mu.Unlock()
+
+	pkg.once.Do(func() {
+		pkg.dir, pkg.files, pkg.err = listFiles(pkgPath, ctx)
+	})
+	return pkg.dir, pkg.files, pkg.err
+}
+
 func (pr *prsr) parseTypesInPackage(pkgName string, referencedTypes map[string]bool, rootPackage, skipCRD bool) (
-	v1beta1.JSONSchemaDefinitions, crdSpecByKind) {
+	v1beta1.JSONSchemaDefinitions, crdSpecByKind, error) {
 	pkgDefs := make(v1beta1.JSONSchemaDefinitions)
 	pkgExternalTypes := make(ExternalReferences)
 	pkgCRDSpecs := make(crdSpecByKind)
+	pkgEnums := make(enumValues)
 
-	pkgDir, listOfFiles, err := listFiles(pkgName)
+	if err := pr.ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	pr.pkgPath = pkgName
+	pkgDir, listOfFiles, err := pr.lister.list(pkgName, pr.options.buildContext())
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, fmt.Errorf("failed to list files of package %q: %v", pkgName, err)
 	}
 
-	pkgPrefix := strings.Replace(pkgName, "/", ".", -1)
+	pkgPrefix := getPkgPrefix(pkgName)
 	if rootPackage {
 		pkgPrefix = ""
+	} else if err := pr.addPackage(pkgName); err != nil {
+		return nil, nil, err
 	}
-	fmt.Println("pkgPrefix=", pkgPrefix)
+	fmt.Fprintln(os.Stderr, "pkgPrefix=", pkgPrefix)
+	pr.generics, pr.instances = map[string]genericType{}, map[string]*instance{}
+	pr.fieldless = nil
 	for _, fileName := range listOfFiles {
-		fmt.Println("Processing file ", fileName)
-		fileDefs, fileExternalRefs, fileCRDSpecs := pr.parseTypesInFile(filepath.Join(pkgDir, fileName), pkgPrefix, skipCRD)
-		mergeDefs(pkgDefs, fileDefs)
+		fmt.Fprintln(os.Stderr, "Processing file ", fileName)
+		fileDefs, fileExternalRefs, fileCRDSpecs, fileEnums, err := pr.parseTypesInFile(filepath.Join(pkgDir, fileName), pkgPrefix, skipCRD)
+		if err != nil {
+			return nil, nil, err
+		}
+		mergeDefs(pkgDefs, fileDefs, pr.options.Verbose)
 		mergeExternalRefs(pkgExternalTypes, fileExternalRefs)
-		mergeCRDSpecs(pkgCRDSpecs, fileCRDSpecs)
+		mergeCRDSpecs(pkgCRDSpecs, fileCRDSpecs, pr.options.Verbose)
+		mergeEnumValues(pkgEnums, fileEnums)
 	}
+	// The methods of a type may live in another file of the package, so the
+	// text marshalers are only known once every file has been parsed.
+	for _, name := range pr.fieldless {
+		if !pr.marshalers[name] {
+			pr.report.warnf("%s has no serialized fields, any object is accepted", name)
+		}
+	}
+	instanceDefs, instanceRefs, err := pr.instantiate(pkgDefs, pkgPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	mergeDefs(pkgDefs, instanceDefs, pr.options.Verbose)
+	mergeExternalRefs(pkgExternalTypes, instanceRefs)
+	// The constants of a type may live in another file of the package, so the
+	// enums are only added once every file has been parsed. Types from other
+	// packages get their enums when their own package is parsed.
+	addEnumValues(pkgDefs, pkgEnums, pr.enumDocs)
+	pr.report.parsed += len(pkgDefs)
 
 	// Add pkg prefix to referencedTypes
 	newReferencedTypes := make(map[string]bool)
@@ -449,22 +1172,27 @@ func (pr *prsr) parseTypesInPackage(pkgName string, referencedTypes map[string]b
 	}
 	referencedTypes = newReferencedTypes
 
-	fmt.Println("referencedTypes")
-	debugPrint(referencedTypes)
+	if err := debugPrint(pr.options.Verbose, "referencedTypes", referencedTypes); err != nil {
+		return nil, nil, err
+	}
 
 	allReachableTypes := getReachableTypes(referencedTypes, pkgDefs)
 	for key := range pkgDefs {
 		if _, exists := allReachableTypes[key]; !exists {
 			delete(pkgDefs, key)
 			delete(pkgExternalTypes, key)
+			pr.report.pruned++
 		}
 	}
-	fmt.Println("allReachableTypes")
-	debugPrint(allReachableTypes)
-	fmt.Println("pkgDefs")
-	debugPrint(pkgDefs)
-	fmt.Println("pkgExternalTypes")
-	debugPrint(pkgExternalTypes)
+	if err := debugPrint(pr.options.Verbose, "allReachableTypes", allReachableTypes); err != nil {
+		return nil, nil, err
+	}
+	if err := debugPrint(pr.options.Verbose, "pkgDefs", pkgDefs); err != nil {
+		return nil, nil, err
+	}
+	if err := debugPrint(pr.options.Verbose, "pkgExternalTypes", pkgExternalTypes); err != nil {
+		return nil, nil, err
+	}
 
 	uniquePkgTypeRefs := make(map[string]map[string]bool)
 	for _, item := range pkgExternalTypes {
@@ -476,36 +1204,344 @@ func (pr *prsr) parseTypesInPackage(pkgName string, referencedTypes map[string]b
 		}
 	}
 
-	for childPkgName := range uniquePkgTypeRefs {
+	// Walk the child packages in a fixed order, so the schema doesn't depend
+	// on the order they are referred to in.
+	for _, childPkgName := range sortedKeys(uniquePkgTypeRefs) {
 		childTypes := uniquePkgTypeRefs[childPkgName]
-		childPkgPr := prsr{fs: pr.fs}
-		childDefs, _ := childPkgPr.parseTypesInPackage(childPkgName, childTypes, false, true)
-		mergeDefs(pkgDefs, childDefs)
+		childPkgPr := prsr{options: pr.options, lister: pr.lister, sources: pr.sources, suppressions: pr.suppressions, extensions: pr.extensions, report: pr.report, aliases: pr.aliases, marshalers: pr.marshalers, enumDocs: pr.enumDocs, tagged: pr.tagged, packages: pr.packages, ctx: pr.ctx, fs: pr.fs}
+		childDefs, _, err := childPkgPr.parseTypesInPackage(childPkgName, childTypes, false, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		mergeDefs(pkgDefs, childDefs, pr.options.Verbose)
 	}
 
-	return pkgDefs, pkgCRDSpecs
+	return pkgDefs, pkgCRDSpecs, nil
 }
 
 type SingleVersionOptions struct {
-	// InputPackage is the path of the input package that contains source files.
+	// InputPackage is the import path of the input package that contains source
+	// files, or its directory, e.g. ./apis/v1.
 	InputPackage string
-	// Types is a list of target types.
+	// InputPackages are more input packages, whose types are combined with
+	// the ones of InputPackage in one schema. Their definitions are named
+	// after their package like the ones of the dependencies, e.g.
+	// "example.com.foo.Bar", so types with the same name don't collide.
+	InputPackages []string
+	// Types is a list of target types. The types of the InputPackages are
+	// named by their definition name, e.g. "example.com.foo.Bar".
 	Types []string
+	// ExcludeTypes are the definitions left out of the schema, e.g. of
+	// internal types, by their name like Types. The properties of these
+	// types are left out too, as if their fields had a +schemagen:ignore
+	// marker.
+	ExcludeTypes []string
+	// PruneUnreachable removes the definitions the root of the schema doesn't
+	// refer to, directly or not, once the Transforms are applied and the
+	// types inlined. The definitions unreachable from the requested types are
+	// always left out of the parsed ones, this also catches the ones that
+	// became unreachable later, e.g. when a transform dropped their refs.
+	PruneUnreachable bool
+	// Concurrency is how many of InputPackage and InputPackages are parsed
+	// at once, GOMAXPROCS if zero. Their definitions are merged in the same
+	// order whatever it is, so it doesn't change the schema.
+	Concurrency int
 	// Flatten contains if we use a flattened structure or a embedded structure.
 	Flatten bool
+	// DeduplicateDefinitions keeps a single definition of the types having the
+	// same schema but for their description and title, the first one by
+	// name, and points the refs at it. It only applies with Flatten.
+	DeduplicateDefinitions bool
+	// Inline writes the requested types in the root schema rather than in
+	// the definitions of the embedded structure, so the schema has no refs.
+	// A single type is the root schema itself. The types of a cycle are still
+	// written in the definitions, the refs closing the cycle point at them.
+	// It can't be used with Flatten.
+	Inline bool
+	// OptionalByDefault makes the fields optional unless they have a
+	// +required marker. By default a field is required unless it is tagged
+	// omitempty, is a pointer or has an +optional marker.
+	OptionalByDefault bool
+	// AllFieldsRequired makes the fields that aren't pointers required even
+	// if they are tagged omitempty, for the APIs that don't follow the
+	// omitempty convention. The pointers stay optional, and the +required
+	// and +optional markers still win. It can't be used with
+	// OptionalByDefault.
+	AllFieldsRequired bool
+	// NullablePointers marks the schema of pointers as nullable, so an
+	// explicit null is accepted wherever the field can be left out, and
+	// for the nil items of a slice or values of a map of pointers. It is
+	// nullable in a CRD and an OpenAPI 3 document, and null is added to the
+	// type of a JSON schema, e.g. ["string","null"].
+	NullablePointers bool
+	// OmitNumberFormats leaves out the OpenAPI formats telling the width of
+	// the integers and numbers, e.g. int32 or double, for JSON schema
+	// consumers that don't know them.
+	OmitNumberFormats bool
+	// IotaEnums sets the enum of the integer types to the values of their
+	// constants declared with iota, e.g. PhasePending Phase = iota. By default
+	// only the constants with a literal value are enum values.
+	IotaEnums bool
+	// EnumMergePolicy decides how the Enum marker of a field is combined with
+	// the values discovered from the constants of its type. It is one of
+	// EnumMergeMarkerWins (the default), EnumMergeDiscoveryWins,
+	// EnumMergeIntersect or EnumMergeUnion. The fields keeping an enum get
+	// the definition of their type embedded, an enum next to a ref being
+	// ignored.
+	EnumMergePolicy string
+	// BuildTags are the build tags used to select the files of the packages.
+	BuildTags []string
+	// GOOS and GOARCH are the platform the files of the packages are selected
+	// for, e.g. to describe the types declared in the _linux.go files on
+	// another system. They default to the ones of the Go environment.
+	GOOS   string
+	GOARCH string
+	// BuildTagSets generates one schema per set of build tags, e.g. for types
+	// that differ between platforms. Each schema is written next to
+	// OutputPath, with the tags added to the file name.
+	BuildTagSets [][]string
+	// Strict turns the warnings about likely mistakes in the input into
+	// errors, e.g. for embedded structs defining the same property differently.
+	// Refs to unknown types are an error too, instead of being skipped.
+	Strict bool
+	// Lint logs the likely mistakes found in the generated schema, see
+	// LintSchema. A +schemagen:nowarn=<category> marker on a type or a field
+	// suppresses the warnings of that category about it.
+	Lint bool
+	// WarningsAsErrors fails the generation if any warning was logged, e.g.
+	// for CI, with the warnings in the error. The warnings of Lint count
+	// too.
+	WarningsAsErrors bool
+	// InlineThreshold inlines the definitions having less properties than it
+	// in a flattened schema, instead of referring to them. Definitions taking
+	// part in a cycle are never inlined. Zero keeps all the refs.
+	InlineThreshold int
+	// PropagateDeprecation notes in the description of a property that its
+	// type is deprecated, i.e. has a "Deprecated:" paragraph in its doc.
+	PropagateDeprecation bool
+	// AnonymousInterfacePolicy decides what happens to fields typed by an
+	// anonymous interface with methods, e.g. interface{ Foo() }. It is either
+	// AnonymousInterfacePermissive (the default) or AnonymousInterfaceError.
+	AnonymousInterfacePolicy string
+	// EmptySchemaStyle is how a schema accepting any value, e.g. for an
+	// interface{} field, is written. It is one of EmptySchemaEmpty (the
+	// default), EmptySchemaTrue or EmptySchemaPreserveUnknownFields.
+	EmptySchemaStyle string
+	// KnownTypes adds to or overrides the schemas of the types used instead
+	// of walking them, by import path and type name, e.g.
+	// "k8s.io/apimachinery/pkg/api/resource.Quantity". By default the
+	// metav1 time and duration types, resource.Quantity, time.Time,
+	// time.Duration and the standard library types with a custom JSON form,
+	// e.g. net.IP or big.Int, are known. The types of the parsed packages
+	// with a MarshalText method and no MarshalJSON one are strings.
+	KnownTypes map[string]KnownType
+	// TypeConverters give the schema of the types they know instead of
+	// walking them, e.g. of opaque handles. Each type a field or a type
+	// refers to, of this package or another one, is given to them in turn
+	// and the first one returning true wins. They take precedence over
+	// KnownTypes.
+	TypeConverters []TypeConverter
+	// AutoDiscoverImplementations writes a named interface as the oneOf of
+	// the types of its package implementing it, instead of any value. The
+	// package is type checked from source to find them.
+	AutoDiscoverImplementations bool
+	// DisallowUnknownFields sets additionalProperties to false on the objects
+	// with properties, and on the struct types without serialized fields, so
+	// unknown fields are rejected. Objects composed with
+	// allOf, e.g. from an inline embedded struct in an anonymous struct, get
+	// unevaluatedProperties instead when the SchemaVersion is 2019-09 or
+	// later, and are left open before. A struct type is closed or kept open
+	// on its own with a +schemagen:closed or +schemagen:closed=false marker.
+	DisallowUnknownFields bool
+
+	// MaxDepth bounds how deep the types refer to each other while they are
+	// embedded or their allOf flattened, deeper ones are an error naming the
+	// chain of types. It defaults to DefaultMaxDepth.
+	MaxDepth int
+
+	// Verbose logs the informational messages, e.g. about the types found
+	// again in another file or package, of which the first one is kept, and
+	// the types found in each package.
+	Verbose bool
+
+	// EmitSourceInfo adds x-source to the definitions, with the Go file and
+	// the line where their type is declared.
+	EmitSourceInfo bool
+
+	// EmitTitles sets the title of the definitions to the name of their Go
+	// type, and the one of the properties to the name of their Go field. A
+	// +schemagen:title=<title> marker on a type or a field sets its title
+	// in any case.
+	EmitTitles bool
+
+	// Report logs how many types were requested, found in the packages,
+	// parsed, pruned as unreachable and generated, how many fields of the
+	// parsed structs were skipped, the refs left to no definition, e.g. to
+	// the types of other packages, and how many warnings were logged.
+	Report bool
 
 	// fs is provided FS. We can use afero.NewMemFs() for testing.
 	fs afero.Fs
+	// sources holds where the types are declared, by definition name.
+	sources map[string]sourceInfo
+	// suppressions holds the lint warnings suppressed by markers.
+	suppressions suppressions
+	// extensions holds the vendor extensions and comments set by markers.
+	extensions definitionKeywords
+	// report counts what the generation saw.
+	report *coverageReport
+	// packageDoc is the first sentence of the doc of InputPackage.
+	packageDoc string
+}
+
+// sourceInfo is where a type is declared. File is the import path of the
+// package joined with the file name.
+type sourceInfo struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// DefaultMaxDepth is the MaxDepth of the types when it isn't set.
+const DefaultMaxDepth = 100
+
+// maxDepth returns MaxDepth, or DefaultMaxDepth if it isn't set.
+func (op *SingleVersionOptions) maxDepth() int {
+	if op.MaxDepth > 0 {
+		return op.MaxDepth
+	}
+	return DefaultMaxDepth
 }
 
+// DefaultIndent is the indentation of the JSON output when Indent isn't set.
+const DefaultIndent = "  "
+
+// indent returns Indent, or DefaultIndent if it isn't set.
+func (op *WriterOptions) indent() string {
+	if len(op.Indent) > 0 {
+		return op.Indent
+	}
+	return DefaultIndent
+}
+
+// StdoutPath is the OutputPath writing the schema to the standard output.
+// The diagnostics go to the standard error, the schema is the only output.
+const StdoutPath = "-"
+
 type WriterOptions struct {
-	// OutputPath is the path that the schema will be written to.
+	// OutputPath is the path that the schema will be written to, StdoutPath
+	// writes it to the standard output.
 	OutputPath string
-	// OutputFormat should be either json or yaml. Default to json
+	// SplitOutput writes one file per requested type, or per CRD kind, in
+	// the OutputPath directory instead, named after the type or the
+	// lowercase kind, e.g. Foo.json or widget.yaml. Every file holds the
+	// definitions its type refers to, the shared ones are written in each.
+	SplitOutput bool
+	// OutputFormat should be either json, yaml, openapi3 or flat. Default to
+	// json. openapi3 writes an OpenAPI 3 document in JSON, with the
+	// definitions as its component schemas. flat writes the requested types
+	// inlined in JSON, without any definition or ref, and fails on the
+	// types that can't be, e.g. recursive ones.
 	OutputFormat string
+	// Indent is the indentation of the JSON output, DefaultIndent if empty.
+	// Compact writes every JSON document on a single line instead. They
+	// don't apply to the yaml output format.
+	Indent  string
+	Compact bool
+	// NamespaceDefinitions groups the definitions of every package into a
+	// nested object, e.g. definitions["k8s.io.api.core.v1"]["PodSpec"], and
+	// points the refs at "#/definitions/k8s.io.api.core.v1/PodSpec".
+	NamespaceDefinitions bool
+	// NamingStrategy is how the definitions of the types of the other
+	// packages are named, and the refs to them, either NamingFull (the
+	// default), NamingPackage or NamingShort. The types of InputPackage are
+	// named after their type alone in any case. Two definitions given the
+	// same name are an error. Types, ExcludeTypes and the examples still
+	// name the definitions by their full name.
+	NamingStrategy string
+	// Transforms are applied in order to the generated schema before it is
+	// written. They can rewrite the schema in place, e.g. to add extensions or
+	// rename definitions. The first error aborts the generation. The CRDs
+	// have no definitions, the transforms are applied to the schema of every
+	// version of them.
+	Transforms []func(*v1beta1.JSONSchemaProps) error
+	// MaxDescriptionLength truncates the descriptions longer than it, in
+	// characters, at a word boundary ending with "...", e.g. for the
+	// registries limiting the length of the descriptions of a CRD. It
+	// applies after the Transforms, to the schema of every version of the
+	// CRDs too. Zero keeps them whole.
+	MaxDescriptionLength int
+	// MetaSchemaPath is the path of an optional JSON schema the output must
+	// conform to, e.g. to enforce custom schema conventions.
+	MetaSchemaPath string
+	// ExamplesDir is the directory of the examples of the definitions, one
+	// JSON file named after each definition, e.g. Foo.json. They are added to
+	// the examples of the definitions that are in the output, and must conform
+	// to the schema of their definition.
+	ExamplesDir string
+	// SchemaVersion is the JSON schema version the output targets. It is one
+	// of SchemaVersionDraft04 (the default), SchemaVersionDraft06,
+	// SchemaVersionDraft07, SchemaVersion201909 or SchemaVersion202012.
+	// It is the $schema of the output. From draft-06, exclusiveMaximum and
+	// exclusiveMinimum are the bounds themselves rather than booleans, and
+	// from 2019-09 the definitions are in $defs.
+	SchemaVersion string
+	// SchemaID is the id of the schema, e.g. the URL it is published at. It
+	// is written as $id from draft-06 and as id before. It isn't written in a
+	// CRD or an OpenAPI 3 document, which only hold the definitions.
+	SchemaID string
+	// Title and Description label the root of the schema, e.g. for the
+	// documentation generated from it. They replace the ones of the type
+	// when it is inlined in the root, see Inline. With several root types,
+	// the definitions keep their own titles, see EmitTitles. Like SchemaID,
+	// they aren't written in a CRD or an OpenAPI 3 document.
+	Title       string
+	Description string
+	// OmitPackageDescription leaves the root of the schema without a
+	// description when neither Description nor the inlined type sets one.
+	// By default it is the first sentence of the doc of InputPackage.
+	OmitPackageDescription bool
+	// DefinitionRefPrefix replaces "#/definitions/" in the refs to the
+	// definitions, e.g. "#/components/schemas/" for a consumer moving the
+	// definitions there. The definitions are still written in definitions
+	// ($defs from 2019-09), where the refs don't point anymore.
+	DefinitionRefPrefix string
+	// RefBaseURI points the refs to the definitions at the schemas hosted
+	// under it, one per type, e.g. with https://schemas.example.com/v1/ the
+	// ref "#/definitions/Foo" becomes
+	// "https://schemas.example.com/v1/Foo.json", the file SplitOutput writes
+	// Foo in. The requested types are written in the root schema, like with
+	// Inline, and the definitions aren't written, every type referred to must
+	// be hosted too. It can't be used with DefinitionRefPrefix, nor for a
+	// CRD.
+	RefBaseURI string
+	// CanonicalKeyOrder writes the keywords of every schema object in a fixed
+	// order, "$schema", "$ref", "type" and "description" first and the other
+	// ones alphabetically, instead of the order of the JSONSchemaProps fields.
+	// It only applies to the json and openapi3 output formats.
+	CanonicalKeyOrder bool
+	// CRDVersion is the version of the CRDs written, either CRDVersionV1 (the
+	// default) or CRDVersionV1beta1. The default values of the fields are
+	// left out of v1beta1 CRDs.
+	CRDVersion string
+	// CRDOutputPath also writes the CRDs of the types to this path, from the
+	// same parsing as the schema written to OutputPath, in json if it ends
+	// with .json and in yaml otherwise. The transforms apply to both. The
+	// schema can't be flattened then, nor the unknown fields disallowed, as
+	// in a CRD, and the empty schemas preserve the unknown fields by
+	// default. It only applies to a SingleVersionGenerator.
+	CRDOutputPath string
 
-	defs     v1beta1.JSONSchemaDefinitions
 	crdSpecs crdSpecByKind
+	// trueEmptySchemas writes the empty subschemas as true.
+	trueEmptySchemas bool
+	// nullable writes the nullable schemas with a null type when the output
+	// is a JSON schema, see nullableTypes.
+	nullable bool
+	// keywords are added to the definitions when the schema is written.
+	keywords definitionKeywords
+	// unevaluatedProperties closes the objects composed with allOf with
+	// unevaluatedProperties when the schema is written.
+	unevaluatedProperties bool
 }
 
 type SingleVersionGenerator struct {
@@ -521,38 +1557,384 @@ type toplevelGeneratorOptions struct {
 
 type prsr struct {
 	generatorOptions *toplevelGeneratorOptions
+	// options are the options the generation was started with.
+	options *SingleVersionOptions
+	// lister is shared by the parsers of all the packages.
+	lister *packageLister
+	// pkgPath is the import path of the package being parsed.
+	pkgPath string
+	// sources holds where the types are declared, by definition name, when
+	// EmitSourceInfo is set. It is shared by the parsers of all the packages.
+	sources map[string]sourceInfo
+	// checked is the package being parsed, once type checked.
+	checked *types.Package
+	// packageDoc is the first sentence of the doc of the package, from the
+	// first of its files having one.
+	packageDoc string
+	// suppressions holds the lint warnings suppressed by the markers of the
+	// types and fields. It is shared by the parsers of all the packages.
+	suppressions suppressions
+	// extensions holds the vendor extensions and comments set by the markers
+	// of the types. It is shared by the parsers of all the packages.
+	extensions definitionKeywords
+	// report counts what the generation saw. It is shared by the parsers of
+	// all the packages.
+	report *coverageReport
+	// aliases holds the definitions of the type aliases, e.g. type X = Y. It
+	// is shared by the parsers of all the packages.
+	aliases map[string]bool
+	// marshalers holds the types with a custom JSON form, see marshalers. It
+	// is shared by the parsers of all the packages.
+	marshalers marshalers
+	// enumDocs holds the doc of the values of the enum definitions, see
+	// describeEnumFields. It is shared by the parsers of all the packages.
+	enumDocs map[string]string
+	// tagged holds the keys of the properties of the struct definitions
+	// named by a json tag, see taggedKeys. It is shared by the parsers of all
+	// the packages.
+	tagged map[string]map[string]bool
+	// packages holds the import paths of the packages parsed, by the prefix
+	// of their definitions, see addPackage. It is shared by the parsers of
+	// all the packages.
+	packages map[string]string
+	// generics holds the generic types of the package being parsed, by
+	// name, and instances their instantiations, see instantiate.
+	generics  map[string]genericType
+	instances map[string]*instance
+	// fieldless holds the structs of the package being parsed having fields,
+	// none of them serialized. They are warned about once every file is
+	// parsed, unless they are text marshalers.
+	fieldless []string
+	// ctx stops the parsing once done.
+	ctx context.Context
 
 	fs afero.Fs
 }
 
 func (op *SingleVersionGenerator) Generate() {
+	if err := op.GenerateContext(context.Background()); err != nil {
+		log.Panic(err)
+	}
+}
+
+// GenerateContext is Generate returning the errors of the generation, and
+// stopping with the error of ctx once it is done, see GenerateSchemaContext.
+// Writing the schema still panics on failure.
+func (op *SingleVersionGenerator) GenerateContext(ctx context.Context) error {
 	if len(op.InputPackage) == 0 || len(op.OutputPath) == 0 {
-		log.Panic("Both input path and output paths need to be set")
+		return fmt.Errorf("both input path and output paths need to be set")
+	}
+
+	if len(op.BuildTagSets) > 0 {
+		for _, tags := range op.BuildTagSets {
+			tagged := *op
+			tagged.BuildTagSets = nil
+			tagged.BuildTags = tags
+			tagged.OutputPath = taggedOutputPath(op.OutputPath, tags)
+			if len(op.CRDOutputPath) > 0 {
+				tagged.CRDOutputPath = taggedOutputPath(op.CRDOutputPath, tags)
+			}
+			if err := tagged.GenerateContext(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if op.SplitOutput && !op.outputCRD {
+		if op.OutputPath == StdoutPath {
+			return fmt.Errorf("the output can't be split when it is written to the standard output")
+		}
+		if len(op.CRDOutputPath) > 0 {
+			return fmt.Errorf("the CRDs can't be written with a split output, each file would hold them")
+		}
+		for _, typeName := range op.Types {
+			single := *op
+			single.SplitOutput = false
+			single.Types = []string{typeName}
+			single.OutputPath = filepath.Join(op.OutputPath, typeName+outputExt(op.OutputFormat))
+			if err := single.GenerateContext(ctx); err != nil {
+				return fmt.Errorf("type %s: %v", typeName, err)
+			}
+		}
+		return nil
+	}
+
+	schema, err := op.GenerateSchemaContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := op.write(op.outputCRD, schema); err != nil {
+		return err
+	}
+	if len(op.CRDOutputPath) > 0 && !op.outputCRD {
+		return op.writeCRDs()
+	}
+	return nil
+}
+
+// writeCRDs writes the CRDs parsed with the schema to CRDOutputPath, see
+// CRDOutputPath.
+func (op *SingleVersionGenerator) writeCRDs() error {
+	crds, err := op.crdWriter()
+	if err != nil {
+		return err
+	}
+	return crds.write(true, nil)
+}
+
+// crdWriter returns the options writing the CRDs parsed with the schema to
+// CRDOutputPath, with their transformed schemas.
+func (op *SingleVersionGenerator) crdWriter() (*WriterOptions, error) {
+	if len(op.crdSpecs) == 0 {
+		return nil, fmt.Errorf("none of the types %s is a CRD, there are no CRDs to write", strings.Join(op.Types, ", "))
+	}
+	// The schemas of the CRDs share their nested schemas with the
+	// definitions, already transformed.
+	specs := crdSpecByKind{}
+	for gk, spec := range op.crdSpecs {
+		specs[gk] = spec.DeepCopy()
+	}
+	crds := op.WriterOptions
+	crds.OutputPath, crds.SplitOutput, crds.crdSpecs = op.CRDOutputPath, false, specs
+	crds.OutputFormat = "yaml"
+	if strings.HasSuffix(op.CRDOutputPath, ".json") {
+		crds.OutputFormat = "json"
+	}
+	// Both only apply to the schema.
+	crds.MetaSchemaPath, crds.CanonicalKeyOrder = "", false
+	if err := crds.transformCRDs(specs); err != nil {
+		return nil, err
+	}
+	return &crds, nil
+}
+
+// taggedOutputPath adds the build tags to the name of the output file,
+// e.g. "schema.json" becomes "schema.linux_amd64.json". The schemas written
+// to the standard output follow each other.
+func taggedOutputPath(outputPath string, tags []string) string {
+	if outputPath == StdoutPath {
+		return outputPath
+	}
+	suffix := "default"
+	if len(tags) > 0 {
+		suffix = strings.Join(tags, "_")
+	}
+	ext := filepath.Ext(outputPath)
+	return strings.TrimSuffix(outputPath, ext) + "." + suffix + ext
+}
+
+// outputExt returns the extension of the files written in format.
+func outputExt(format string) string {
+	if strings.ToLower(format) == "yaml" {
+		return ".yaml"
+	}
+	return ".json"
+}
+
+// GenerateSchema parses the input package and returns the schema of the
+// requested types, with the transforms applied. Nothing is written to disk.
+func (op *SingleVersionGenerator) GenerateSchema() (*v1beta1.JSONSchemaProps, error) {
+	return op.GenerateSchemaContext(context.Background())
+}
+
+// GenerateSchemaContext is GenerateSchema stopping with the error of ctx once
+// it is done, checked before every package and type parsed.
+func (op *SingleVersionGenerator) GenerateSchemaContext(ctx context.Context) (*v1beta1.JSONSchemaProps, error) {
+	if len(op.InputPackage) == 0 {
+		return nil, fmt.Errorf("input path needs to be set")
 	}
 
 	if op.fs == nil {
 		op.fs = afero.NewOsFs()
 	}
 
+	if err := checkEmptySchemaStyle(op.EmptySchemaStyle); err != nil {
+		return nil, err
+	}
+	if err := checkAnonymousInterfacePolicy(op.AnonymousInterfacePolicy); err != nil {
+		return nil, err
+	}
+	if err := checkSchemaVersion(op.SchemaVersion); err != nil {
+		return nil, err
+	}
+	if err := checkNamingStrategy(op.NamingStrategy); err != nil {
+		return nil, err
+	}
+	if op.OptionalByDefault && op.AllFieldsRequired {
+		return nil, fmt.Errorf("the fields can't be both optional by default and all required")
+	}
+	if len(op.RefBaseURI) > 0 && len(op.DefinitionRefPrefix) > 0 {
+		return nil, fmt.Errorf("a definition ref prefix can't be used with a ref base URI, the refs point at the hosted schemas")
+	}
+	if strings.ToLower(op.OutputFormat) == openAPI3Format {
+		if err := checkOpenAPI(&op.SingleVersionOptions, &op.WriterOptions); err != nil {
+			return nil, err
+		}
+	}
+	flat := strings.ToLower(op.OutputFormat) == flatFormat && !op.outputCRD
+	if flat {
+		if err := checkFlat(&op.SingleVersionOptions); err != nil {
+			return nil, err
+		}
+		op.Inline = true
+	}
+
 	if op.outputCRD {
 		// if generating CRD, we should always embed schemas.
 		op.Flatten = false
+		switch op.EmptySchemaStyle {
+		case EmptySchemaTrue:
+			return nil, fmt.Errorf("empty schema style %q can't be used in a CRD", EmptySchemaTrue)
+		case "":
+			// Free-form values would be pruned by the API server otherwise.
+			op.EmptySchemaStyle = EmptySchemaPreserveUnknownFields
+		}
+		if op.DisallowUnknownFields {
+			return nil, fmt.Errorf("unknown fields can't be disallowed in a CRD, the API server prunes them")
+		}
+		if len(op.RefBaseURI) > 0 {
+			return nil, fmt.Errorf("the refs of a CRD can't point at a base URI, its schemas are embedded")
+		}
+	}
+	if len(op.CRDOutputPath) > 0 && !op.outputCRD {
+		// The CRDs are parsed with the schema, the options of their
+		// schemas apply to it too.
+		if op.Flatten {
+			return nil, fmt.Errorf("the schema can't be flattened when the CRDs are written too, their schemas are embedded")
+		}
+		if op.DisallowUnknownFields {
+			return nil, fmt.Errorf("unknown fields can't be disallowed when the CRDs are written too, the API server prunes them")
+		}
+		switch op.EmptySchemaStyle {
+		case EmptySchemaTrue:
+			return nil, fmt.Errorf("empty schema style %q can't be used when the CRDs are written too", EmptySchemaTrue)
+		case "":
+			op.EmptySchemaStyle = EmptySchemaPreserveUnknownFields
+		}
 	}
+	if op.Inline && op.Flatten {
+		return nil, fmt.Errorf("the types of a flattened schema can't be inlined")
+	}
+	op.trueEmptySchemas = op.EmptySchemaStyle == EmptySchemaTrue
+	op.nullable = op.NullablePointers
+	op.unevaluatedProperties = op.DisallowUnknownFields && schemaVersionAtLeast(op.SchemaVersion, SchemaVersion201909)
 
-	op.defs, op.crdSpecs = op.parse()
+	defs, crdSpecs, err := op.parse(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRequestedTypes(defs, op.Types); err != nil {
+		return nil, err
+	}
+	// The only version of each CRD is the one it is stored as.
+	if err := setStorageVersions(crdSpecs); err != nil {
+		return nil, err
+	}
+	op.crdSpecs = crdSpecs
 
-	op.write(op.outputCRD, op.Types)
+	if op.DisallowUnknownFields {
+		closeObjects(defs, op.unevaluatedProperties, op.report)
+	}
+
+	schema := rootSchema(defs, op.Types)
+	schema.Schema = v1beta1.JSONSchemaURL(schemaURI(op.SchemaVersion))
+	schema.ID = op.SchemaID
+	// The hosted schema of a type is the type itself, not a ref to it.
+	if (op.Inline || len(op.RefBaseURI) > 0) && !op.outputCRD {
+		inlineRoot(schema)
+	}
+	if len(op.Title) > 0 {
+		schema.Title = op.Title
+	}
+	if len(op.Description) > 0 {
+		schema.Description = op.Description
+	}
+	if len(schema.Description) == 0 && !op.OmitPackageDescription {
+		schema.Description = op.packageDoc
+	}
+	if err := op.applyTransforms(schema); err != nil {
+		return nil, err
+	}
+	if op.outputCRD {
+		// The root of each CRD schema is a copy of its definition, the
+		// transforms must be applied to it too.
+		specs := crdSpecByKind{}
+		for gk, spec := range op.crdSpecs {
+			specs[gk] = spec.DeepCopy()
+		}
+		if err := op.transformCRDs(specs); err != nil {
+			return nil, err
+		}
+		op.crdSpecs = specs
+	}
+	if op.PruneUnreachable {
+		op.report.pruned += pruneUnreachable(schema)
+	}
+	if flat {
+		if err := flatSchema(schema); err != nil {
+			return nil, err
+		}
+	}
+	if op.Lint {
+		for _, w := range LintSchema(schema) {
+			if op.suppressions.suppressed(w) {
+				continue
+			}
+			op.report.warnf("%s", w)
+		}
+	}
+	if op.Report {
+		op.report.generated = len(schema.Definitions)
+		op.report.resolve(schema)
+		log.Printf("Coverage: %s", op.report)
+	}
+	if op.WarningsAsErrors && len(op.report.warnings) > 0 {
+		return nil, fmt.Errorf("%d warnings, failing with WarningsAsErrors:\n%s", len(op.report.warnings), strings.Join(op.report.warnings, "\n"))
+	}
+
+	op.keywords = definitionKeywords{}
+	if len(op.ExamplesDir) > 0 {
+		if op.keywords, err = loadExamples(op.ExamplesDir, schema); err != nil {
+			return nil, err
+		}
+	}
+	for name, source := range op.sources {
+		op.keywords.set(name, "x-source", source)
+	}
+	dropComments(op.extensions, op.SchemaVersion, op.OutputFormat)
+	if err := addExtensions(op.keywords, op.extensions, schema.Definitions); err != nil {
+		return nil, err
+	}
+	if err := renameDefinitions(schema, op.keywords, op.NamingStrategy); err != nil {
+		return nil, err
+	}
+	return schema, nil
 }
 
-func (pr *prsr) linkCRDSpec(defs v1beta1.JSONSchemaDefinitions, crdSpecs crdSpecByKind) crdSpecByKind {
+// rootSchema returns the schema that accepts any of the given types.
+func rootSchema(defs v1beta1.JSONSchemaDefinitions, types []string) *v1beta1.JSONSchemaProps {
+	schema := &v1beta1.JSONSchemaProps{Definitions: defs}
+	schema.Type = "object"
+	schema.AnyOf = []v1beta1.JSONSchemaProps{}
+	for _, typeName := range types {
+		schema.AnyOf = append(schema.AnyOf, v1beta1.JSONSchemaProps{Ref: getDefLink(typeName)})
+	}
+	return schema
+}
+
+func (pr *prsr) linkCRDSpec(defs v1beta1.JSONSchemaDefinitions, crdSpecs crdSpecByKind) (crdSpecByKind, error) {
 	rtCRDSpecs := crdSpecByKind{}
 	for gk := range crdSpecs {
-		if pr.generatorOptions != nil {
-			crdSpecs[gk].Group = pr.generatorOptions.group
-			rtCRDSpecs[schema.GroupKind{Group: pr.generatorOptions.group, Kind: gk.Kind}] = crdSpecs[gk]
-		} else {
-			rtCRDSpecs[gk] = crdSpecs[gk]
+		if pr.generatorOptions == nil || len(pr.generatorOptions.group) == 0 {
+			return nil, fmt.Errorf("CRD %s has no group, set it with the +groupName marker of its package", gk.Kind)
+		}
+		if len(crdSpecs[gk].Names.Plural) == 0 {
+			return nil, fmt.Errorf("CRD %s has no plural name, set it with the +kubebuilder:resource:path marker", gk.Kind)
 		}
+		crdSpecs[gk].Group = pr.generatorOptions.group
+		rtCRDSpecs[schema.GroupKind{Group: pr.generatorOptions.group, Kind: gk.Kind}] = crdSpecs[gk]
 
 		if len(crdSpecs[gk].Versions) == 0 {
 			log.Printf("no version for CRD %q", gk)
@@ -566,101 +1948,401 @@ func (pr *prsr) linkCRDSpec(defs v1beta1.JSONSchemaDefinitions, crdSpecs crdSpec
 			log.Printf("can't get json shchema for %q", gk)
 			continue
 		}
+		// The types of a cycle are kept as refs by embedSchema, but a CRD has
+		// no definitions to refer to.
+		seen := map[string]bool{}
+		var refs []string
+		walkDefinition(&def, func(d *v1beta1.JSONSchemaProps) {
+			if d.Ref == nil {
+				return
+			}
+			name := getNameFromURL(*d.Ref)
+			if name == "" {
+				name = *d.Ref
+			}
+			if !seen[name] {
+				seen[name] = true
+				refs = append(refs, name)
+			}
+		})
+		sort.Strings(refs)
+		for _, name := range refs {
+			pr.report.warnf("CRD %s refers to %s, recursive types can't be written in a CRD", gk.Kind, name)
+		}
 		crdSpecs[gk].Versions[0].Schema = &v1beta1.CustomResourceValidation{
 			OpenAPIV3Schema: &def,
 		}
 	}
-	return rtCRDSpecs
+	return rtCRDSpecs, nil
 }
 
-func (op *SingleVersionOptions) parse() (v1beta1.JSONSchemaDefinitions, crdSpecByKind) {
+func (op *SingleVersionOptions) parse(ctx context.Context) (v1beta1.JSONSchemaDefinitions, crdSpecByKind, error) {
 	startingPointMap := make(map[string]bool)
 	for i := range op.Types {
 		startingPointMap[op.Types[i]] = true
 	}
-	pr := prsr{fs: op.fs}
-	defs, crdSpecs := pr.parseTypesInPackage(op.InputPackage, startingPointMap, true, false)
+	pr := newPrsr(ctx, op, &packageLister{})
+	pr.report.requested = len(startingPointMap)
+
+	// The input packages are parsed at once, each into its own state, and
+	// merged in order, so the schema is the same as if they were parsed one
+	// after the other.
+	pkgs := append([]string{op.InputPackage}, op.InputPackages...)
+	type parsedPackage struct {
+		pr       *prsr
+		defs     v1beta1.JSONSchemaDefinitions
+		crdSpecs crdSpecByKind
+		err      error
+	}
+	parsed := make([]parsedPackage, len(pkgs))
+	sem := make(chan struct{}, op.concurrency())
+	var wg sync.WaitGroup
+	for i, pkgName := range pkgs {
+		wg.Add(1)
+		go func(i int, pkgName string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			pkgPr := newPrsr(ctx, op, pr.lister)
+			if i == 0 {
+				parsed[i].defs, parsed[i].crdSpecs, parsed[i].err = pkgPr.parseTypesInPackage(pkgName, startingPointMap, true, false)
+			} else {
+				parsed[i].defs, parsed[i].crdSpecs, parsed[i].err = pkgPr.parseTypesInPackage(pkgName, packageTypes(op.Types, pkgName), false, false)
+			}
+			parsed[i].pr = pkgPr
+		}(i, pkgName)
+	}
+	wg.Wait()
+	// The group of the CRDs is the one of the +groupName marker of the
+	// InputPackage.
+	pr.generatorOptions = parsed[0].pr.generatorOptions
+	pr.packageDoc = parsed[0].pr.packageDoc
+	defs, crdSpecs := v1beta1.JSONSchemaDefinitions{}, crdSpecByKind{}
+	for _, p := range parsed {
+		if p.err != nil {
+			return nil, nil, p.err
+		}
+		mergeDefs(defs, p.defs, op.Verbose)
+		mergeCRDSpecs(crdSpecs, p.crdSpecs, op.Verbose)
+		if err := pr.join(p.pr); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// The definitions are checked as parsed, before they are transformed and
+	// the unreachable ones are pruned.
+	if err := checkDefinitions(defs, startingPointMap, op.Strict); err != nil {
+		return nil, nil, err
+	}
+
+	for name := range startingPointMap {
+		if _, ok := defs[name]; ok {
+			pr.report.found++
+		}
+	}
+	useMarshalers(defs, pr.marshalers, pr.report)
+	resolveAliases(defs, pr.aliases)
+	excludeTypes(defs, op.ExcludeTypes)
+
+	// The doc of the values is added before the fields with an Enum marker
+	// embed the enum definitions.
+	describeEnumFields(defs, pr.enumDocs)
+	if err := mergeFieldEnums(defs, op.EnumMergePolicy); err != nil {
+		return nil, nil, err
+	}
 
 	// flattenAllOf only flattens allOf tags
-	flattenAllOf(defs)
+	if err := flattenAllOf(defs, pr.tagged, op.Strict, op.maxDepth()); err != nil {
+		return nil, nil, err
+	}
 
 	reachableTypes := getReachableTypes(startingPointMap, defs)
 	for key := range defs {
 		if _, exists := reachableTypes[key]; !exists {
 			delete(defs, key)
+			pr.report.pruned++
 		}
 	}
 
-	checkDefinitions(defs, startingPointMap)
+	op.sources = pr.sources
+	op.suppressions = pr.suppressions
+	op.extensions = pr.extensions
+	op.report = pr.report
+	op.packageDoc = pr.packageDoc
+
+	if op.PropagateDeprecation {
+		propagateDeprecation(defs)
+	}
+
+	if op.Flatten && op.DeduplicateDefinitions {
+		if err := deduplicateDefinitions(defs, startingPointMap); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if op.Flatten && op.InlineThreshold > 0 {
+		inlineSmallDefinitions(defs, op.InlineThreshold, startingPointMap)
+	}
 
 	if !op.Flatten {
-		defs = embedSchema(defs, startingPointMap)
+		var err error
+		if defs, err = embedSchema(defs, startingPointMap, op.maxDepth()); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	linked, err := pr.linkCRDSpec(defs, crdSpecs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return defs, linked, nil
+}
+
+// concurrency returns how many packages are parsed at once, Concurrency, or
+// GOMAXPROCS if it isn't set.
+func (op *SingleVersionOptions) concurrency() int {
+	if op.Concurrency > 0 {
+		return op.Concurrency
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// packageTypes returns the types of the package with the given import path
+// among the types, by their name in the package.
+func packageTypes(types []string, pkgPath string) map[string]bool {
+	pkgTypes := make(map[string]bool)
+	prefix := getPkgPrefix(pkgPath) + "."
+	for _, typeName := range types {
+		if strings.HasPrefix(typeName, prefix) {
+			pkgTypes[strings.TrimPrefix(typeName, prefix)] = true
+		}
+	}
+	return pkgTypes
+}
 
-		newDefs := v1beta1.JSONSchemaDefinitions{}
-		for name := range startingPointMap {
-			newDefs[name] = defs[name]
+// applyTransforms runs the transforms of op on schema, in order, and then
+// truncates its descriptions, see MaxDescriptionLength.
+func (op *WriterOptions) applyTransforms(schema *v1beta1.JSONSchemaProps) error {
+	for i, transform := range op.Transforms {
+		if err := transform(schema); err != nil {
+			return fmt.Errorf("transform %d failed: %v", i, err)
 		}
-		defs = newDefs
 	}
+	if op.MaxDescriptionLength > 0 {
+		truncateDescriptions(schema, op.MaxDescriptionLength)
+	}
+	return nil
+}
 
-	return defs, pr.linkCRDSpec(defs, crdSpecs)
+// transformCRDs runs the transforms of op on the schema of every version of
+// the CRDs, see applyTransforms.
+func (op *WriterOptions) transformCRDs(specs crdSpecByKind) error {
+	for _, gk := range specs.sortedKinds() {
+		for _, version := range specs[gk].Versions {
+			if version.Schema == nil || version.Schema.OpenAPIV3Schema == nil {
+				continue
+			}
+			if err := op.applyTransforms(version.Schema.OpenAPIV3Schema); err != nil {
+				return fmt.Errorf("CRD %s version %s: %v", gk.Kind, version.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// write writes the CRDs if outputCRD is set, and schema otherwise.
+func (op *WriterOptions) write(outputCRD bool, schema *v1beta1.JSONSchemaProps) error {
+	toSerilizeList, names, format, err := op.documents(outputCRD, schema)
+	if err != nil {
+		return err
+	}
+	if op.SplitOutput && outputCRD {
+		if op.OutputPath == StdoutPath {
+			return fmt.Errorf("the output can't be split when it is written to the standard output")
+		}
+		for i := range toSerilizeList {
+			writeOutput(filepath.Join(op.OutputPath, names[i]), op.encodeDocuments(toSerilizeList[i:i+1], format))
+		}
+		return nil
+	}
+	writeOutput(op.OutputPath, op.encodeDocuments(toSerilizeList, format))
+	return nil
 }
 
-func (op *WriterOptions) write(outputCRD bool, types []string) {
+// documents returns the documents of the output, the CRDs if outputCRD is set
+// and schema otherwise, the names of their files when the output is split
+// and the output format.
+func (op *WriterOptions) documents(outputCRD bool, schema *v1beta1.JSONSchemaProps) ([]interface{}, []string, string, error) {
+	format := strings.ToLower(op.OutputFormat)
+	switch format {
+	// default to json
+	case "json", "", "yaml":
+	case openAPI3Format, flatFormat:
+		if outputCRD {
+			return nil, nil, "", fmt.Errorf("output format %q can't be used for CRDs", op.OutputFormat)
+		}
+	default:
+		return nil, nil, "", fmt.Errorf("unsupported output format %q, must be either json, yaml, %s or %s", op.OutputFormat, openAPI3Format, flatFormat)
+	}
+	if outputCRD {
+		if err := checkCRDVersion(op.CRDVersion); err != nil {
+			return nil, nil, "", err
+		}
+	}
+	if op.CanonicalKeyOrder && format == "yaml" {
+		return nil, nil, "", fmt.Errorf("the canonical key order can't be kept in the yaml output format")
+	}
+
 	var toSerilizeList []interface{}
+	// names are the files of the documents when the output is split.
+	var names []string
 	if outputCRD {
-		for gk, spec := range op.crdSpecs {
+		for _, gk := range op.crdSpecs.sortedKinds() {
+			names = append(names, strings.ToLower(gk.Kind)+outputExt(format))
+			spec := op.crdSpecs[gk]
 			crd := &v1beta1.CustomResourceDefinition{
 				TypeMeta: metav1.TypeMeta{
 					APIVersion: "apiextensions.k8s.io/v1beta1",
 					Kind:       "CustomResourceDefinition",
 				},
 				ObjectMeta: metav1.ObjectMeta{
-					Name:   strings.ToLower(gk.Kind),
+					Name:   spec.Names.Plural + "." + spec.Group,
 					Labels: map[string]string{"controller-tools.k8s.io": "1.0"},
 				},
 				Spec: *spec,
 			}
-			toSerilizeList = append(toSerilizeList, crd)
+			if op.CRDVersion == CRDVersionV1beta1 {
+				stripDefaults(crd)
+				toSerilizeList = append(toSerilizeList, crd)
+				continue
+			}
+			v1CRD, err := toV1CRD(crd)
+			if err != nil {
+				return nil, nil, "", err
+			}
+			toSerilizeList = append(toSerilizeList, v1CRD)
 		}
 	} else {
-		schema := v1beta1.JSONSchemaProps{Definitions: op.defs}
-		schema.Type = "object"
-		schema.AnyOf = []v1beta1.JSONSchemaProps{}
-		for _, typeName := range types {
-			schema.AnyOf = append(schema.AnyOf, v1beta1.JSONSchemaProps{Ref: getDefLink(typeName)})
+		if op.NamespaceDefinitions {
+			ns, err := namespaceDefinitions(schema)
+			if err != nil {
+				return nil, nil, "", err
+			}
+			toSerilizeList = []interface{}{ns}
+		} else {
+			toSerilizeList = []interface{}{schema}
+		}
+		// Some of the output can't be held by JSONSchemaProps, it is added to
+		// the generic JSON form of the schema.
+		// The keywords of the versions from draft-06 differ from the ones
+		// of JSONSchemaProps.
+		laterVersion := schemaVersionAtLeast(op.SchemaVersion, SchemaVersionDraft06)
+		deprecated := hasDeprecatedProperties(schema)
+		examples := format != openAPI3Format && hasFieldExamples(schema)
+		if op.trueEmptySchemas || len(op.keywords) > 0 || laterVersion || op.CanonicalKeyOrder || format == openAPI3Format || len(op.DefinitionRefPrefix) > 0 || len(op.RefBaseURI) > 0 || op.nullable || deprecated || examples {
+			generic, err := toGeneric(toSerilizeList[0])
+			if err != nil {
+				return nil, nil, "", err
+			}
+			groups := definitionGroups(schema.Definitions, op.NamespaceDefinitions)
+			addKeywords(generic, op.keywords, op.NamespaceDefinitions)
+			if examples {
+				examplesKeyword(generic, groups)
+			}
+			if op.unevaluatedProperties {
+				addUnevaluatedProperties(generic, groups)
+			}
+			if op.nullable && format != openAPI3Format {
+				nullableTypes(generic, groups)
+			}
+			if laterVersion {
+				numericExclusiveBounds(generic, groups)
+				idKeyword(generic)
+			}
+			if deprecated {
+				keyword := "x-deprecated"
+				if format == openAPI3Format || schemaVersionAtLeast(op.SchemaVersion, SchemaVersion201909) {
+					keyword = "deprecated"
+				}
+				deprecatedProperties(generic, groups, keyword)
+			}
+			if op.trueEmptySchemas {
+				trueEmptySchemas(generic, groups)
+			}
+			// useDefs leaves the refs with another prefix as they are.
+			if len(op.DefinitionRefPrefix) > 0 {
+				rebaseRefs(generic, groups, op.DefinitionRefPrefix)
+			}
+			if len(op.RefBaseURI) > 0 {
+				hostedRefs(generic, groups, op.RefBaseURI)
+			}
+			if schemaVersionAtLeast(op.SchemaVersion, SchemaVersion201909) {
+				useDefs(generic, groups)
+			}
+			if schemaVersionAtLeast(op.SchemaVersion, SchemaVersion202012) {
+				prefixItems(generic, groups)
+			}
+			toSerilizeList[0] = generic
+			switch {
+			case format == openAPI3Format:
+				toSerilizeList[0] = openAPIDocument(generic, op.CanonicalKeyOrder)
+			case op.CanonicalKeyOrder:
+				toSerilizeList[0] = canonicalSchema(generic, groups)
+			}
 		}
-		toSerilizeList = []interface{}{schema}
 	}
 
-	// TODO: create dir is not exist.
-	out, err := os.Create(op.OutputPath)
-	if err != nil {
-		log.Panic(err)
+	if len(op.MetaSchemaPath) > 0 {
+		for i := range toSerilizeList {
+			if err := validateAgainstMetaSchema(toSerilizeList[i], op.MetaSchemaPath); err != nil {
+				return nil, nil, "", err
+			}
+		}
 	}
 
-	for i := range toSerilizeList {
-		switch strings.ToLower(op.OutputFormat) {
-		// default to json
-		case "json", "":
-			enc := json.NewEncoder(out)
-			enc.SetIndent("", "  ")
-			err = enc.Encode(toSerilizeList[i])
-			if err2 := out.Close(); err == nil {
-				err = err2
-			}
-			if err != nil {
-				log.Panic(err)
-			}
-		case "yaml":
-			m, err := yaml.Marshal(toSerilizeList[i])
+	return toSerilizeList, names, format, nil
+}
+
+// encodeDocuments encodes docs in format, one after the other.
+func (op *WriterOptions) encodeDocuments(docs []interface{}, format string) []byte {
+	var buf bytes.Buffer
+	for i := range docs {
+		if format == "yaml" {
+			m, err := yaml.Marshal(docs[i])
 			if err != nil {
 				log.Panic(err)
 			}
-			err = ioutil.WriteFile(op.OutputPath, m, 0644)
-			if err != nil {
-				log.Panic(err)
+			// Separate the documents so multiple CRDs can be applied from one file.
+			if i > 0 {
+				buf.WriteString("---\n")
 			}
+			buf.Write(m)
+			continue
+		}
+		enc := json.NewEncoder(&buf)
+		if !op.Compact {
+			enc.SetIndent("", op.indent())
 		}
+		if err := enc.Encode(docs[i]); err != nil {
+			log.Panic(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// writeOutput writes data to path, creating its directory if needed, or to
+// the standard output for StdoutPath.
+func writeOutput(path string, data []byte) {
+	if path == StdoutPath {
+		if _, err := os.Stdout.Write(data); err != nil {
+			log.Panic(err)
+		}
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Panic(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		log.Panic(err)
 	}
 }
@@ -0,0 +1,1976 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/spf13/afero"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// testPackages lists the Go files of the packages, by import path, from a
+// memory FS instead of the GOPATH, until the test ends. The files are selected
+// by their build constraints.
+func testPackages(t *testing.T, packages map[string]map[string]string) afero.Fs {
+	fs := afero.NewMemMapFs()
+	dirs := map[string][]string{}
+	for pkgPath, files := range packages {
+		dir := path.Join("/src", pkgPath)
+		for name, src := range files {
+			if err := afero.WriteFile(fs, path.Join(dir, name), []byte(src), 0644); err != nil {
+				t.Fatal(err)
+			}
+			dirs[pkgPath] = append(dirs[pkgPath], name)
+		}
+		sort.Strings(dirs[pkgPath])
+	}
+	list := listFiles
+	listFiles = func(pkgPath string, ctx build.Context) (string, []string, error) {
+		// The build constraints of the files are matched like go/build
+		// does, reading them from fs.
+		ctx.OpenFile = func(path string) (io.ReadCloser, error) { return fs.Open(path) }
+		dir := path.Join("/src", pkgPath)
+		var files []string
+		for _, name := range dirs[pkgPath] {
+			match, err := ctx.MatchFile(dir, name)
+			if err != nil {
+				return "", nil, err
+			}
+			if match {
+				files = append(files, name)
+			}
+		}
+		return dir, files, nil
+	}
+	t.Cleanup(func() { listFiles = list })
+	return fs
+}
+
+// testGenerator returns the generator of the schema of types in the package
+// holding the files, see testPackages.
+func testGenerator(t *testing.T, files map[string]string, types ...string) *SingleVersionGenerator {
+	op := &SingleVersionGenerator{}
+	op.InputPackage = "example.com/api"
+	op.Types = types
+	op.fs = testPackages(t, map[string]map[string]string{op.InputPackage: files})
+	return op
+}
+
+// generateJSON returns the schema of op in JSON.
+func generateJSON(t *testing.T, op *SingleVersionGenerator) string {
+	t.Helper()
+	schema, err := op.GenerateSchema()
+	if err != nil {
+		t.Fatalf("GenerateSchema() = %v", err)
+	}
+	b, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// generateOutput returns the output of op, as written to OutputPath.
+func generateOutput(t *testing.T, op *SingleVersionGenerator) string {
+	t.Helper()
+	schema, err := op.GenerateSchema()
+	if err != nil {
+		t.Fatalf("GenerateSchema() = %v", err)
+	}
+	docs, _, format, err := op.documents(op.outputCRD, schema)
+	if err != nil {
+		t.Fatalf("documents() = %v", err)
+	}
+	return string(op.encodeDocuments(docs, format))
+}
+
+// outputError returns the error of op writing its output, once the schema
+// is generated.
+func outputError(t *testing.T, op *SingleVersionGenerator) error {
+	t.Helper()
+	schema, err := op.GenerateSchema()
+	if err != nil {
+		t.Fatalf("GenerateSchema() = %v", err)
+	}
+	_, _, _, err = op.documents(op.outputCRD, schema)
+	return err
+}
+
+// generateDefinition returns the definition named name of the schema of op.
+func generateDefinition(t *testing.T, op *SingleVersionGenerator, name string) v1beta1.JSONSchemaProps {
+	t.Helper()
+	schema, err := op.GenerateSchema()
+	if err != nil {
+		t.Fatalf("GenerateSchema() = %v", err)
+	}
+	return definition(t, schema, name)
+}
+
+// definition returns the definition named name of schema, failing the test
+// if there is none.
+func definition(t *testing.T, schema *v1beta1.JSONSchemaProps, name string) v1beta1.JSONSchemaProps {
+	t.Helper()
+	def, ok := schema.Definitions[name]
+	if !ok {
+		var names []string
+		for name := range schema.Definitions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		t.Fatalf("no definition %s among %v", name, names)
+	}
+	return def
+}
+
+func TestUnsignedFields(t *testing.T) {
+	op := testGenerator(t, map[string]string{"types.go": `package api
+
+type Counters struct {
+	Hits uint64 ` + "`json:\"hits\"`" + `
+	// +kubebuilder:validation:Minimum=1
+	Port uint16 ` + "`json:\"port\"`" + `
+	Delta int8 ` + "`json:\"delta\"`" + `
+}
+`}, "Counters")
+	schema, err := op.GenerateSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	props := definition(t, schema, "Counters").Properties
+	tests := []struct {
+		name    string
+		format  string
+		minimum *float64
+	}{
+		{name: "hits", minimum: float64Ptr(0)},
+		{name: "port", format: "int32", minimum: float64Ptr(1)},
+		{name: "delta", format: "int32"},
+	}
+	for _, tt := range tests {
+		prop := props[tt.name]
+		if prop.Type != "integer" || prop.Format != tt.format {
+			t.Errorf("%s is %s with format %q, want an integer with format %q", tt.name, prop.Type, prop.Format, tt.format)
+		}
+		switch {
+		case tt.minimum == nil && prop.Minimum != nil:
+			t.Errorf("%s has minimum %v, want none", tt.name, *prop.Minimum)
+		case tt.minimum != nil && (prop.Minimum == nil || *prop.Minimum != *tt.minimum):
+			t.Errorf("%s has minimum %v, want %v", tt.name, prop.Minimum, *tt.minimum)
+		}
+	}
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
+
+func TestConcurrentParsing(t *testing.T) {
+	packages := map[string]map[string]string{
+		"example.com/api": {"types.go": `// +groupName=example.com
+package api
+
+import "example.com/shared"
+
+// +kubebuilder:resource:path=widgets
+type Widget struct {
+	Name shared.Name ` + "`json:\"name\"`" + `
+}
+`},
+		"example.com/b": {"types.go": `package b
+
+import "example.com/shared"
+
+type Gadget struct {
+	Name shared.Name ` + "`json:\"name\"`" + `
+}
+`},
+		"example.com/c": {"types.go": `package c
+
+type Gizmo struct {
+	Size int ` + "`json:\"size\"`" + `
+}
+`},
+		"example.com/shared": {"types.go": `package shared
+
+// Name is shared.
+type Name string
+`},
+	}
+	var outputs []string
+	for _, concurrency := range []int{1, 2, 4} {
+		op := &SingleVersionGenerator{}
+		op.InputPackage = "example.com/api"
+		op.InputPackages = []string{"example.com/b", "example.com/c"}
+		op.Types = []string{"Widget", "example.com.b.Gadget", "example.com.c.Gizmo"}
+		op.Concurrency = concurrency
+		op.fs = testPackages(t, packages)
+		outputs = append(outputs, generateJSON(t, op))
+		for gk := range op.crdSpecs {
+			if gk.Group != "example.com" {
+				t.Errorf("concurrency %d: CRD %s has group %q, want the one of the input package", concurrency, gk.Kind, gk.Group)
+			}
+		}
+		if len(op.crdSpecs) != 1 {
+			t.Errorf("concurrency %d: %d CRDs, want Widget", concurrency, len(op.crdSpecs))
+		}
+	}
+	for i := 1; i < len(outputs); i++ {
+		if outputs[i] != outputs[0] {
+			t.Errorf("the schema depends on the concurrency:\n%s\n%s", outputs[0], outputs[i])
+		}
+	}
+}
+
+func TestNamingIndependentOfOrder(t *testing.T) {
+	decls := []string{
+		"type A struct {\n\tB B `json:\"b\"`\n\tX x.Thing `json:\"x\"`\n}\n",
+		"type B struct {\n\tC []C `json:\"c\"`\n\tY y.Thing `json:\"y\"`\n}\n",
+		"type C struct {\n\tName string `json:\"name\"`\n}\n",
+		"type D map[string]C\n",
+	}
+	header := "package api\n\nimport (\n\tx \"example.com/a\"\n\ty \"example.com/b\"\n)\n\n"
+	var want string
+	for seed := int64(0); seed < 5; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		files := map[string]string{}
+		for i, j := range r.Perm(len(decls)) {
+			files[fmt.Sprintf("%c.go", 'a'+i)] = header + decls[j]
+		}
+		op := &SingleVersionGenerator{}
+		op.InputPackage = "example.com/api"
+		op.Types = []string{"A", "D"}
+		op.Flatten = true
+		op.fs = testPackages(t, map[string]map[string]string{
+			"example.com/api": files,
+			"example.com/a":   {"thing.go": "package x\n\ntype Thing struct {\n\tX int `json:\"x\"`\n}\n"},
+			"example.com/b":   {"thing.go": "package y\n\ntype Thing struct {\n\tY int `json:\"y\"`\n}\n"},
+		})
+		list := listFiles
+		listFiles = func(pkgPath string, ctx build.Context) (string, []string, error) {
+			dir, names, err := list(pkgPath, ctx)
+			shuffled := make([]string, len(names))
+			for i, j := range r.Perm(len(names)) {
+				shuffled[i] = names[j]
+			}
+			return dir, shuffled, err
+		}
+		got := generateJSON(t, op)
+		if seed == 0 {
+			want = got
+		} else if got != want {
+			t.Errorf("seed %d: the schema depends on the order of the types:\n%s\n%s", seed, want, got)
+		}
+	}
+}
+
+func TestPackagePrefixCollision(t *testing.T) {
+	op := &SingleVersionGenerator{}
+	op.InputPackage = "example.com/api"
+	op.Types = []string{"A"}
+	op.Flatten = true
+	// Both import paths have the definition prefix example.com.a.b.
+	op.fs = testPackages(t, map[string]map[string]string{
+		"example.com/api": {"a.go": "package api\n\nimport (\n\tx \"example.com/a.b\"\n\ty \"example.com/a/b\"\n)\n\ntype A struct {\n\tX x.Thing `json:\"x\"`\n\tY y.Thing `json:\"y\"`\n}\n"},
+		"example.com/a.b": {"thing.go": "package x\n\ntype Thing struct {\n\tX int `json:\"x\"`\n}\n"},
+		"example.com/a/b": {"thing.go": "package y\n\ntype Thing struct {\n\tY int `json:\"y\"`\n}\n"},
+	})
+	_, err := op.GenerateSchema()
+	if err == nil || !strings.Contains(err.Error(), `"example.com/a.b" and "example.com/a/b"`) {
+		t.Errorf("GenerateSchema() = %v, want an error about the packages example.com/a.b and example.com/a/b", err)
+	}
+}
+
+const twoCRDsSource = `// +groupName=example.com
+package api
+
+// +kubebuilder:resource:path=widgets
+type Widget struct {
+	Size int ` + "`json:\"size\"`" + `
+}
+
+// +kubebuilder:resource:path=gadgets
+type Gadget struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+`
+
+func TestYAMLDocuments(t *testing.T) {
+	tests := []struct {
+		name      string
+		outputCRD bool
+		documents int
+	}{
+		{name: "schema", documents: 1},
+		{name: "CRDs", outputCRD: true, documents: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": twoCRDsSource}, "Widget", "Gadget")
+			op.OutputFormat = "YAML"
+			op.outputCRD = tt.outputCRD
+			out := generateOutput(t, op)
+			if got := len(strings.Split(out, "---\n")); got != tt.documents {
+				t.Errorf("%d documents, want %d:\n%s", got, tt.documents, out)
+			}
+			if strings.HasPrefix(strings.TrimSpace(out), "{") {
+				t.Errorf("the output is JSON:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestUnknownOutputFormat(t *testing.T) {
+	op := testGenerator(t, map[string]string{"types.go": twoCRDsSource}, "Widget")
+	op.OutputFormat = "xml"
+	if outputError(t, op) == nil {
+		t.Error("the xml output format was accepted")
+	}
+}
+
+func TestDeterministicOutput(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		outputCRD bool
+	}{
+		{name: "JSON schema", format: "JSON"},
+		{name: "YAML schema", format: "YAML"},
+		{name: "JSON CRDs", format: "JSON", outputCRD: true},
+		{name: "YAML CRDs", format: "YAML", outputCRD: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var first string
+			for i := 0; i < 10; i++ {
+				op := testGenerator(t, map[string]string{"types.go": twoCRDsSource}, "Widget", "Gadget")
+				op.OutputFormat = tt.format
+				op.outputCRD = tt.outputCRD
+				out := generateOutput(t, op)
+				if i == 0 {
+					first = out
+				} else if out != first {
+					t.Fatalf("run %d wrote:\n%s\nwant, as the first run:\n%s", i, out, first)
+				}
+			}
+			if tt.outputCRD && strings.Index(first, "gadgets") > strings.Index(first, "widgets") {
+				t.Errorf("the Widget CRD is written before the Gadget one:\n%s", first)
+			}
+		})
+	}
+}
+
+func TestPointerCollections(t *testing.T) {
+	src := `package api
+
+type T struct {
+	Names  *[]string       ` + "`json:\"names\"`" + `
+	Counts *map[string]int ` + "`json:\"counts\"`" + `
+	Items  []*string       ` + "`json:\"items\"`" + `
+}
+`
+	for _, nullable := range []bool{false, true} {
+		op := testGenerator(t, map[string]string{"types.go": src}, "T")
+		op.NullablePointers = nullable
+		def := generateDefinition(t, op, "T")
+		if len(def.Required) != 1 || def.Required[0] != "items" {
+			t.Errorf("nullable %v: required %v, want [items]", nullable, def.Required)
+		}
+		names, counts := def.Properties["names"], def.Properties["counts"]
+		if names.Type != "array" || names.Items == nil || names.Items.Schema.Type != "string" {
+			t.Errorf("nullable %v: names is %+v, want an array of strings", nullable, names)
+		}
+		if counts.Type != "object" || counts.AdditionalProperties == nil || counts.AdditionalProperties.Schema.Type != "integer" {
+			t.Errorf("nullable %v: counts is %+v, want an object of integers", nullable, counts)
+		}
+		if names.Nullable != nullable || counts.Nullable != nullable {
+			t.Errorf("names nullable %v and counts %v, want %v", names.Nullable, counts.Nullable, nullable)
+		}
+		if items := def.Properties["items"]; items.Nullable || items.Items.Schema.Nullable != nullable {
+			t.Errorf("nullable %v: items is %+v, want an array of nullable strings", nullable, items)
+		}
+	}
+}
+
+func TestPointerRefs(t *testing.T) {
+	tests := []struct {
+		typ      string
+		nullable bool
+		want     string
+		required bool
+	}{
+		{typ: "[]*Foo", want: `{"type":"array","items":{"$ref":"#/definitions/Foo"}}`, required: true},
+		{typ: "*[]Foo", want: `{"type":"array","items":{"$ref":"#/definitions/Foo"}}`},
+		{typ: "*[]*Foo", want: `{"type":"array","items":{"$ref":"#/definitions/Foo"}}`},
+		{typ: "map[string]*Foo", want: `{"type":"object","additionalProperties":{"$ref":"#/definitions/Foo"}}`, required: true},
+		{typ: "[]*Foo", nullable: true, want: `{"type":"array","items":{"$ref":"#/definitions/Foo","nullable":true}}`, required: true},
+		{typ: "*[]*Foo", nullable: true, want: `{"type":"array","items":{"$ref":"#/definitions/Foo","nullable":true},"nullable":true}`},
+	}
+	for _, tt := range tests {
+		src := "package api\n\ntype Foo struct {\n\tA string `json:\"a\"`\n}\n\ntype T struct {\n\tF " + tt.typ + " `json:\"f\"`\n}\n"
+		op := testGenerator(t, map[string]string{"types.go": src}, "T")
+		op.Flatten = true
+		op.NullablePointers = tt.nullable
+		def := generateDefinition(t, op, "T")
+		if got := compactJSON(t, def.Properties["f"]); got != tt.want {
+			t.Errorf("%s (nullable %v) is %s, want %s", tt.typ, tt.nullable, got, tt.want)
+		}
+		if required := len(def.Required) == 1; required != tt.required {
+			t.Errorf("%s: required %v, want required %v", tt.typ, def.Required, tt.required)
+		}
+	}
+}
+
+func TestDescriptions(t *testing.T) {
+	op := testGenerator(t, map[string]string{"types.go": `package api
+
+// T is a type
+// described on two lines.
+type T struct {
+	// Name is the name.
+	//
+	// +kubebuilder:validation:MinLength=1
+	Name string ` + "`json:\"name\"`" + `
+	Size int ` + "`json:\"size\"`" + ` // Size is trailing.
+	Bare bool ` + "`json:\"bare\"`" + `
+}
+`}, "T")
+	def := generateDefinition(t, op, "T")
+	if def.Description != "T is a type described on two lines." {
+		t.Errorf("T is described as %q", def.Description)
+	}
+	tests := map[string]string{
+		"name": "Name is the name.",
+		"size": "Size is trailing.",
+		"bare": "",
+	}
+	for name, want := range tests {
+		if got := def.Properties[name].Description; got != want {
+			t.Errorf("%s is described as %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	src := "package api\n\ntype T struct {\n\tName string `json:\"name\"`\n}\n"
+	rename := func(schema *v1beta1.JSONSchemaProps) error {
+		schema.Title = "renamed"
+		return nil
+	}
+	validate := func(schema *v1beta1.JSONSchemaProps) error {
+		if schema.Title != "renamed" {
+			return fmt.Errorf("not renamed yet")
+		}
+		return nil
+	}
+	tests := []struct {
+		name       string
+		transforms []func(*v1beta1.JSONSchemaProps) error
+		wantErr    string
+	}{
+		{name: "in order", transforms: []func(*v1beta1.JSONSchemaProps) error{rename, validate}},
+		{name: "out of order", transforms: []func(*v1beta1.JSONSchemaProps) error{validate, rename}, wantErr: "transform 0 failed: not renamed yet"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.Transforms = tt.transforms
+			schema, err := op.GenerateSchema()
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("GenerateSchema() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if schema.Title != "renamed" {
+				t.Errorf("title %q, want the one of the transform", schema.Title)
+			}
+		})
+	}
+}
+
+func TestTransformsOutput(t *testing.T) {
+	// mark describes the string schemas, wherever they are nested, and
+	// titles the object ones.
+	mark := func(schema *v1beta1.JSONSchemaProps) error {
+		walkDefinition(schema, func(def *v1beta1.JSONSchemaProps) {
+			switch def.Type {
+			case "string":
+				def.Description = "transformed"
+			case "object":
+				def.Title = "transformed"
+			}
+		})
+		return nil
+	}
+	tests := []struct {
+		name      string
+		outputCRD bool
+		crds      bool
+		ref       string
+	}{
+		{name: "schema", ref: "#/definitions/Widget/properties/size"},
+		{name: "CRD", outputCRD: true, ref: "#/spec/versions/0/schema/openAPIV3Schema/properties/size"},
+		{name: "CRD output path", crds: true, ref: "#/spec/versions/0/schema/openAPIV3Schema/properties/size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := strings.Replace(widgetSource, "Size int", "Size string", 1)
+			op := testGenerator(t, map[string]string{"types.go": src}, "Widget")
+			op.outputCRD = tt.outputCRD
+			op.Transforms = []func(*v1beta1.JSONSchemaProps) error{mark}
+			var out []byte
+			if tt.crds {
+				dir := t.TempDir()
+				op.OutputPath = filepath.Join(dir, "schema.json")
+				op.CRDOutputPath = filepath.Join(dir, "crds.json")
+				if err := op.GenerateContext(context.Background()); err != nil {
+					t.Fatalf("GenerateContext() = %v", err)
+				}
+				var err error
+				if out, err = ioutil.ReadFile(op.CRDOutputPath); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				op.Flatten = !tt.outputCRD
+				out = []byte(generateOutput(t, op))
+			}
+			var generic interface{}
+			if err := json.Unmarshal(out, &generic); err != nil {
+				t.Fatal(err)
+			}
+			size, _ := resolveRef(generic, tt.ref).(map[string]interface{})
+			if size["description"] != "transformed" {
+				t.Errorf("size is %v, want the description of the transform", size)
+			}
+			widget, _ := resolveRef(generic, strings.TrimSuffix(tt.ref, "/properties/size")).(map[string]interface{})
+			if widget["title"] != "transformed" {
+				t.Errorf("Widget is %v, want the title of the transform", widget)
+			}
+		})
+	}
+}
+
+func TestCRDOutputPath(t *testing.T) {
+	dir := t.TempDir()
+	op := testGenerator(t, map[string]string{"types.go": twoCRDsSource}, "Widget", "Gadget")
+	op.OutputPath = filepath.Join(dir, "schema.json")
+	op.CRDOutputPath = filepath.Join(dir, "crds.yaml")
+	if err := op.GenerateContext(context.Background()); err != nil {
+		t.Fatalf("GenerateContext() = %v", err)
+	}
+	b, err := ioutil.ReadFile(op.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema v1beta1.JSONSchemaProps
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+	crds, err := ioutil.ReadFile(op.CRDOutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := strings.Split(strings.TrimPrefix(string(crds), "---\n"), "\n---\n")
+	if len(docs) != 2 {
+		t.Fatalf("%d CRDs, want the Widget and Gadget ones:\n%s", len(docs), crds)
+	}
+	// The schemas of the CRDs are the definitions of the schema.
+	for _, doc := range docs {
+		var crd interface{}
+		if err := yaml.Unmarshal([]byte(doc), &crd); err != nil {
+			t.Fatal(err)
+		}
+		kind, _ := resolveRef(crd, "#/spec/names/kind").(string)
+		props, _ := resolveRef(crd, "#/spec/versions/0/schema/openAPIV3Schema/properties").(map[string]interface{})
+		def := definition(t, &schema, kind)
+		if len(props) != len(def.Properties) {
+			t.Errorf("the CRD of %s has the properties %v, want the ones of its definition", kind, props)
+		}
+		for prop := range def.Properties {
+			if _, ok := props[prop]; !ok {
+				t.Errorf("the CRD of %s has no property %s", kind, prop)
+			}
+		}
+	}
+
+	tests := []struct {
+		name    string
+		src     string
+		types   []string
+		options func(*SingleVersionGenerator)
+		wantErr string
+	}{
+		{
+			name:    "no CRD",
+			src:     "package api\n\ntype Part struct {\n\tName string `json:\"name\"`\n}\n",
+			types:   []string{"Part"},
+			wantErr: "none of the types Part is a CRD",
+		},
+		{name: "split", src: twoCRDsSource, types: []string{"Widget"}, options: func(op *SingleVersionGenerator) { op.SplitOutput = true }, wantErr: "the CRDs can't be written with a split output"},
+		{name: "flatten", src: twoCRDsSource, types: []string{"Widget"}, options: func(op *SingleVersionGenerator) { op.Flatten = true }, wantErr: "the schema can't be flattened when the CRDs are written too"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			op := testGenerator(t, map[string]string{"types.go": tt.src}, tt.types...)
+			op.OutputPath = filepath.Join(dir, "schema.json")
+			op.CRDOutputPath = filepath.Join(dir, "crds.yaml")
+			if tt.options != nil {
+				tt.options(op)
+			}
+			if err := op.GenerateContext(context.Background()); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("GenerateContext() = %v, want an error containing %q", err, tt.wantErr)
+			}
+			if _, err := os.Stat(op.CRDOutputPath); err == nil {
+				t.Errorf("the CRDs are written")
+			}
+		})
+	}
+}
+
+func TestRequiredFields(t *testing.T) {
+	src := `package api
+
+type T struct {
+	Name    string            ` + "`json:\"name\"`" + `
+	Size    int               ` + "`json:\"size\"`" + `
+	Ptr     *string           ` + "`json:\"ptr\"`" + `
+	Labels  map[string]string ` + "`json:\"labels,omitempty\"`" + `
+	Skipped string            ` + "`json:\"-\"`" + `
+	GoName  string            ` + "`json:\",omitempty\"`" + `
+	Untagged bool
+}
+`
+	tests := []struct {
+		name    string
+		options func(*SingleVersionGenerator)
+		want    []string
+	}{
+		{name: "tags", want: []string{"name", "size", "Untagged"}},
+		{name: "optional by default", options: func(op *SingleVersionGenerator) { op.OptionalByDefault = true }},
+		{name: "all required", options: func(op *SingleVersionGenerator) { op.AllFieldsRequired = true }, want: []string{"name", "size", "labels", "GoName", "Untagged"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			if tt.options != nil {
+				tt.options(op)
+			}
+			def := generateDefinition(t, op, "T")
+			if strings.Join(def.Required, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("required %v, want %v", def.Required, tt.want)
+			}
+			if _, ok := def.Properties["Skipped"]; ok {
+				t.Error("the field tagged - is a property")
+			}
+			if _, ok := def.Properties["GoName"]; !ok {
+				t.Error("the field tagged ,omitempty isn't named after the Go field")
+			}
+		})
+	}
+}
+
+func TestFieldOptionality(t *testing.T) {
+	tests := []struct {
+		field             string
+		nullablePointers  bool
+		optionalByDefault bool
+		allFieldsRequired bool
+		required          bool
+		nullable          bool
+	}{
+		{field: "F string `json:\"f\"`", required: true},
+		{field: "F string `json:\"f,omitempty\"`"},
+		{field: "F *string `json:\"f\"`"},
+		{field: "F *string `json:\"f,omitempty\"`"},
+		{field: "F string `json:\"f\"`", nullablePointers: true, required: true},
+		{field: "F string `json:\"f,omitempty\"`", nullablePointers: true},
+		{field: "F *string `json:\"f\"`", nullablePointers: true, nullable: true},
+		{field: "F *string `json:\"f,omitempty\"`", nullablePointers: true, nullable: true},
+		{field: "F string `json:\"f\"`", optionalByDefault: true},
+		{field: "// +required\n\tF *string `json:\"f,omitempty\"`", nullablePointers: true, required: true, nullable: true},
+		{field: "// +optional\n\tF string `json:\"f\"`"},
+		{field: "// +kubebuilder:validation:Required\n\tF string `json:\"f\"`", optionalByDefault: true, required: true},
+		{field: "F string `json:\"f,omitempty\"`", allFieldsRequired: true, required: true},
+		{field: "F []string `json:\"f,omitempty\"`", allFieldsRequired: true, required: true},
+		{field: "F *string `json:\"f\"`", allFieldsRequired: true},
+		{field: "// +optional\n\tF string `json:\"f,omitempty\"`", allFieldsRequired: true},
+		{field: "// +required\n\tF *string `json:\"f\"`", allFieldsRequired: true, required: true},
+	}
+	for _, tt := range tests {
+		name := fmt.Sprintf("%s/nullable pointers %v/optional by default %v/all required %v", tt.field, tt.nullablePointers, tt.optionalByDefault, tt.allFieldsRequired)
+		t.Run(name, func(t *testing.T) {
+			src := "package api\n\ntype T struct {\n\t" + tt.field + "\n}\n"
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.Flatten = true
+			op.NullablePointers = tt.nullablePointers
+			op.OptionalByDefault = tt.optionalByDefault
+			op.AllFieldsRequired = tt.allFieldsRequired
+			def := generateDefinition(t, op, "T")
+			if required := len(def.Required) == 1 && def.Required[0] == "f"; required != tt.required {
+				t.Errorf("required %v, want %v", def.Required, tt.required)
+			}
+			if nullable := def.Properties["f"].Nullable; nullable != tt.nullable {
+				t.Errorf("nullable %v, want %v", nullable, tt.nullable)
+			}
+		})
+	}
+	op := testGenerator(t, map[string]string{"types.go": "package api\n\ntype T struct {\n\tF string `json:\"f\"`\n}\n"}, "T")
+	op.OptionalByDefault, op.AllFieldsRequired = true, true
+	if _, err := op.GenerateSchema(); err == nil {
+		t.Error("GenerateSchema() = nil, want an error for OptionalByDefault with AllFieldsRequired")
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		fields   string
+		want     string
+		required string
+		wantErr  string
+	}{
+		{
+			name:    "both tagged",
+			fields:  "A string `json:\"name\"`\n\tB string `json:\"name\"`",
+			wantErr: `fields A and B both have the key "name", encoding/json leaves both out`,
+		},
+		{
+			name:     "tagged last",
+			fields:   "Name string\n\tOther int `json:\"Name,omitempty\"`",
+			want:     `{"type":"integer","format":"int64"}`,
+			required: "",
+		},
+		{
+			name:     "tagged first",
+			fields:   "Other int `json:\"Name\"`\n\tName string `json:\",omitempty\"`",
+			want:     `{"type":"integer","format":"int64"}`,
+			required: "Name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package api\n\ntype T struct {\n\t" + tt.fields + "\n}\n"
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.Flatten = true
+			schema, err := op.GenerateSchema()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("GenerateSchema() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateSchema() = %v", err)
+			}
+			def := definition(t, schema, "T")
+			if len(def.Properties) != 1 {
+				t.Errorf("the properties are %v, want only Name", def.Properties)
+			}
+			if got := compactJSON(t, def.Properties["Name"]); got != tt.want {
+				t.Errorf("Name is %s, want %s", got, tt.want)
+			}
+			if got := strings.Join(def.Required, ","); got != tt.required {
+				t.Errorf("required %q, want %q", got, tt.required)
+			}
+		})
+	}
+}
+
+func TestPromotedDuplicateKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		decls  string
+		want   string
+		strict bool
+	}{
+		{
+			name:  "same depth",
+			decls: "type A struct {\n\tName string `json:\"name\"`\n}\n\ntype B struct {\n\tName int `json:\"name\"`\n}\n",
+		},
+		{
+			name:  "tagged",
+			decls: "type A struct {\n\tName string\n}\n\ntype B struct {\n\tOther int `json:\"Name\"`\n}\n",
+			want:  `{"type":"integer","format":"int64"}`,
+		},
+		{
+			name:  "shallower",
+			decls: "type A struct {\n\tC\n}\n\ntype B struct {\n\tName int `json:\"name\"`\n}\n\ntype C struct {\n\tName string `json:\"name\"`\n}\n",
+			want:  `{"type":"integer","format":"int64"}`,
+		},
+		{
+			name:   "strict",
+			decls:  "type A struct {\n\tName string `json:\"name\"`\n}\n\ntype B struct {\n\tName int `json:\"name\"`\n}\n",
+			strict: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package api\n\ntype T struct {\n\tA\n\tB\n}\n\n" + tt.decls
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.Flatten = true
+			op.Strict = tt.strict
+			schema, err := op.GenerateSchema()
+			if tt.strict {
+				if err == nil || !strings.Contains(err.Error(), `property "name" of T is promoted from several embedded structs`) {
+					t.Fatalf("GenerateSchema() = %v, want an error about name", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateSchema() = %v", err)
+			}
+			def := definition(t, schema, "T")
+			var got string
+			for key, prop := range def.Properties {
+				got = compactJSON(t, prop)
+				if strings.ToLower(key) != "name" {
+					t.Errorf("T has the property %s, want only the name", key)
+				}
+			}
+			if got != tt.want {
+				t.Errorf("the name of T is %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFieldTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want fieldTag
+	}{
+		{tag: "`json:\"replicas\"`", want: fieldTag{name: "replicas"}},
+		{tag: "`json:\",omitempty\"`", want: fieldTag{options: tagOptions{"omitempty"}}},
+		{tag: "`json:\" name , omitempty ,string\"`", want: fieldTag{name: "name", options: tagOptions{"omitempty", "string"}}},
+		{tag: "`json:\"-\"`", want: fieldTag{ignored: true}},
+		{tag: "`json:\"-,\"`", want: fieldTag{name: "-"}},
+		{tag: "`yaml:\"fromYAML\"`", want: fieldTag{name: "fromYAML"}},
+		{tag: "`json:\"fromJSON\" yaml:\"fromYAML\"`", want: fieldTag{name: "fromJSON"}},
+		{tag: "`protobuf:\"bytes,1\"`", want: fieldTag{}},
+		{tag: "", want: fieldTag{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			var lit *ast.BasicLit
+			if tt.tag != "" {
+				lit = &ast.BasicLit{Kind: token.STRING, Value: tt.tag}
+			}
+			if got := parseFieldTag(lit); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFieldTag(%s) = %+v, want %+v", tt.tag, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPropertyKeys(t *testing.T) {
+	op := testGenerator(t, map[string]string{"types.go": `package api
+
+type T struct {
+	ReplicaCount int    ` + "`json:\"replicas\"`" + `
+	Name         string ` + "`json:\",omitempty\"`" + `
+	Secret       string ` + "`json:\"-\"`" + `
+	Dash         string ` + "`json:\"-,\"`" + `
+	hidden       string
+}
+`}, "T")
+	def := generateDefinition(t, op, "T")
+	var keys []string
+	for key := range def.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if want := "-,Name,replicas"; strings.Join(keys, ",") != want {
+		t.Errorf("properties %v, want %s", keys, want)
+	}
+	if want := "replicas,-"; strings.Join(def.Required, ",") != want {
+		t.Errorf("required %v, want %s", def.Required, want)
+	}
+}
+
+func TestGenerateSchemaWritesNothing(t *testing.T) {
+	op := testGenerator(t, map[string]string{"types.go": "package api\n\ntype T struct {\n\tName string `json:\"name\"`\n}\n"}, "T")
+	op.OutputPath = filepath.Join(t.TempDir(), "schema.json")
+	schema, err := op.GenerateSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.AnyOf) != 1 || schema.AnyOf[0].Ref == nil || *schema.AnyOf[0].Ref != "#/definitions/T" {
+		t.Errorf("the root is %+v, want a ref to T", schema)
+	}
+	if _, err := os.Stat(op.OutputPath); !os.IsNotExist(err) {
+		t.Errorf("GenerateSchema() wrote %s", op.OutputPath)
+	}
+}
+
+func TestSplitOutput(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		outputCRD bool
+		files     map[string]string
+	}{
+		{name: "json", files: map[string]string{"Widget.json": `"size"`, "Gadget.json": `"name"`}},
+		{name: "yaml", format: "yaml", files: map[string]string{"Widget.yaml": "size:", "Gadget.yaml": "name:"}},
+		{name: "CRDs", outputCRD: true, files: map[string]string{"widget.json": `"widgets"`, "gadget.json": `"gadgets"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "schemas")
+			op := testGenerator(t, map[string]string{"types.go": twoCRDsSource}, "Widget", "Gadget")
+			op.OutputFormat = tt.format
+			op.OutputPath = dir
+			op.SplitOutput = true
+			op.outputCRD = tt.outputCRD
+			if err := op.GenerateContext(context.Background()); err != nil {
+				t.Fatalf("GenerateContext() = %v", err)
+			}
+			entries, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.files) {
+				t.Errorf("%d files written, want %d", len(entries), len(tt.files))
+			}
+			// Each file only holds its own type.
+			for name, want := range tt.files {
+				b, err := ioutil.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for other, unwanted := range tt.files {
+					if other != name && strings.Contains(string(b), unwanted) {
+						t.Errorf("%s holds %s of %s", name, unwanted, other)
+					}
+				}
+				if !strings.Contains(string(b), want) {
+					t.Errorf("%s doesn't hold %s:\n%s", name, want, b)
+				}
+			}
+		})
+	}
+	op := testGenerator(t, map[string]string{"types.go": twoCRDsSource}, "Widget", "Gadget")
+	op.OutputPath = StdoutPath
+	op.SplitOutput = true
+	if err := op.GenerateContext(context.Background()); err == nil {
+		t.Error("GenerateContext() = nil, want an error splitting the standard output")
+	}
+}
+
+func TestIndent(t *testing.T) {
+	src := "package api\n\ntype T struct {\n\tName string `json:\"name\"`\n}\n"
+	tests := []struct {
+		name    string
+		indent  string
+		compact bool
+		format  string
+		prefix  string
+	}{
+		{name: "default", prefix: "{\n  \""},
+		{name: "tabs", indent: "\t", prefix: "{\n\t\""},
+		{name: "four spaces", indent: "    ", prefix: "{\n    \""},
+		{name: "compact", compact: true, prefix: "{\""},
+		{name: "compact wins", indent: "    ", compact: true, prefix: "{\""},
+		{name: "yaml", indent: "\t", compact: true, format: "yaml", prefix: "$schema:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.Indent = tt.indent
+			op.Compact = tt.compact
+			op.OutputFormat = tt.format
+			out := generateOutput(t, op)
+			if !strings.HasPrefix(out, tt.prefix) {
+				t.Errorf("the output starts with %q, want %q", out[:len(tt.prefix)], tt.prefix)
+			}
+			// A compact document is a single line, ended by a newline.
+			if lines := strings.Count(out, "\n"); tt.compact && tt.format == "" && lines != 1 {
+				t.Errorf("the compact output has %d lines:\n%s", lines, out)
+			}
+		})
+	}
+}
+
+func TestBuildContext(t *testing.T) {
+	files := map[string]string{
+		"types.go":         "package api\n\ntype T struct {\n\tName string `json:\"name\"`\n\tP    P      `json:\"p\"`\n}\n",
+		"types_linux.go":   "package api\n\ntype P struct {\n\tLinux string `json:\"linux\"`\n}\n",
+		"types_windows.go": "package api\n\ntype P struct {\n\tWindows string `json:\"windows\"`\n}\n",
+		"arm64.go":         "//go:build arm64\n\npackage api\n\ntype Extra struct {\n\tArm string `json:\"arm\"`\n}\n",
+		"custom.go":        "//go:build custom\n\npackage api\n\ntype Extra struct {\n\tCustom string `json:\"custom\"`\n}\n",
+	}
+	tests := []struct {
+		name   string
+		goos   string
+		goarch string
+		tags   []string
+		types  []string
+		want   map[string]string
+	}{
+		{name: "linux", goos: "linux", types: []string{"T"}, want: map[string]string{"P": "linux"}},
+		{name: "windows", goos: "windows", types: []string{"T"}, want: map[string]string{"P": "windows"}},
+		{name: "arch", goos: "linux", goarch: "arm64", types: []string{"T", "Extra"}, want: map[string]string{"P": "linux", "Extra": "arm"}},
+		{name: "tags", goos: "windows", goarch: "amd64", tags: []string{"custom"}, types: []string{"T", "Extra"}, want: map[string]string{"P": "windows", "Extra": "custom"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := testGenerator(t, files, tt.types...)
+			op.Flatten = true
+			op.GOOS, op.GOARCH = tt.goos, tt.goarch
+			op.BuildTags = tt.tags
+			schema, err := op.GenerateSchema()
+			if err != nil {
+				t.Fatalf("GenerateSchema() = %v", err)
+			}
+			for name, property := range tt.want {
+				props := definition(t, schema, name).Properties
+				if _, ok := props[property]; !ok || len(props) != 1 {
+					t.Errorf("%s has the properties %v, want only %q", name, props, property)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildTagSets(t *testing.T) {
+	op := testGenerator(t, map[string]string{
+		"a.go": "//go:build featurea\n\npackage api\n\ntype T struct {\n\tA string `json:\"a\"`\n}\n",
+		"b.go": "//go:build !featurea\n\npackage api\n\ntype T struct {\n\tB string `json:\"b\"`\n}\n",
+	}, "T")
+	dir := t.TempDir()
+	op.OutputPath = filepath.Join(dir, "schema.json")
+	op.BuildTagSets = [][]string{{"featurea"}, nil}
+	if err := op.GenerateContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"schema.featurea.json": "a",
+		"schema.default.json":  "b",
+	}
+	for name, property := range tests {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var schema v1beta1.JSONSchemaProps
+		if err := json.Unmarshal(b, &schema); err != nil {
+			t.Fatal(err)
+		}
+		props := definition(t, &schema, "T").Properties
+		if _, ok := props[property]; !ok || len(props) != 1 {
+			t.Errorf("%s has the properties %v, want only %q", name, props, property)
+		}
+	}
+}
+
+// fieldSchema returns the schema of the field F of type typ in JSON.
+func fieldSchema(t *testing.T, decls, typ string) (string, error) {
+	t.Helper()
+	src := "package api\n\n" + decls + "\ntype T struct {\n\tF " + typ + " `json:\"f\"`\n}\n"
+	op := testGenerator(t, map[string]string{"types.go": src}, "T")
+	schema, err := op.GenerateSchema()
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(definition(t, schema, "T").Properties["f"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b), nil
+}
+
+func TestMaps(t *testing.T) {
+	tests := []struct {
+		typ  string
+		want string
+	}{
+		{"map[string]string", `{"type":"object","additionalProperties":{"type":"string"}}`},
+		{"map[string]V", `{"type":"object","additionalProperties":{"type":"object","required":["a"],"properties":{"a":{"type":"string"}}}}`},
+		{"map[string]map[string]int", `{"type":"object","additionalProperties":{"type":"object","additionalProperties":{"type":"integer","format":"int64"}}}`},
+		{"map[string][]bool", `{"type":"object","additionalProperties":{"type":"array","items":{"type":"boolean"}}}`},
+		{"map[Key]string", `{"type":"object","additionalProperties":{"type":"string"}}`},
+	}
+	for _, test := range tests {
+		got, err := fieldSchema(t, "type V struct {\n\tA string `json:\"a\"`\n}\n\ntype Key string\n", test.typ)
+		if err != nil {
+			t.Errorf("%s: %v", test.typ, err)
+		} else if got != test.want {
+			t.Errorf("%s is %s, want %s", test.typ, got, test.want)
+		}
+	}
+	for _, typ := range []string{"map[int]string", "map[bool]string", "map[string]map[float64]string"} {
+		if _, err := fieldSchema(t, "", typ); err == nil || !strings.Contains(err.Error(), "unsupported map key type") {
+			t.Errorf("%s: got error %v, want an unsupported map key type", typ, err)
+		}
+	}
+}
+
+func TestUnserializableFields(t *testing.T) {
+	tests := []struct {
+		field   string
+		wantErr string
+	}{
+		{field: "C chan int `json:\"c\"`", wantErr: "channel types can't be serialized to JSON"},
+		{field: "F func() error `json:\"f\"`", wantErr: "function types can't be serialized to JSON"},
+		{field: "C <-chan string", wantErr: "channel types can't be serialized to JSON"},
+		{field: "c chan int"},
+		{field: "f func()"},
+		{field: "C chan int `json:\"-\"`"},
+		{field: "F func() `json:\"-\"`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			// The declared function type is skipped rather than failing.
+			src := "package api\n\ntype Handler func(string)\n\ntype T struct {\n\tName string `json:\"name\"`\n\t" + tt.field + "\n}\n"
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.Flatten = true
+			schema, err := op.GenerateSchema()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("GenerateSchema() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateSchema() = %v", err)
+			}
+			if props := definition(t, schema, "T").Properties; len(props) != 1 {
+				t.Errorf("T has the properties %v, want only name", props)
+			}
+			if _, ok := schema.Definitions["Handler"]; ok {
+				t.Error("the function type Handler has a definition")
+			}
+		})
+	}
+}
+
+func TestStringOption(t *testing.T) {
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{"Count int `json:\"f,string\"`", `{"type":"string","pattern":"^-?[0-9]+$"}`},
+		{"Ratio float64 `json:\"f,string\"`", `{"type":"string","pattern":"^-?[0-9]+(\\.[0-9]+)?([eE][-+]?[0-9]+)?$"}`},
+		{"Enabled bool `json:\"f,string\"`", `{"type":"string","pattern":"^(true|false)$"}`},
+		{"Count *int `json:\"f,omitempty,string\"`", `{"type":"string","pattern":"^-?[0-9]+$"}`},
+		{"// +kubebuilder:validation:Enum=1;2\n\t// +kubebuilder:default=1\n\tLevel int `json:\"f,string\"`", `{"type":"string","default":"1","pattern":"^-?[0-9]+$","enum":["1","2"]}`},
+		// The option only applies to numbers and booleans.
+		{"Name string `json:\"f,string\"`", `{"type":"string"}`},
+		{"Count int `json:\"f\"`", `{"type":"integer","format":"int64"}`},
+	}
+	for _, test := range tests {
+		src := "package api\n\ntype T struct {\n\t" + test.field + "\n}\n"
+		op := testGenerator(t, map[string]string{"types.go": src}, "T")
+		op.Flatten = true
+		if got := compactJSON(t, generateDefinition(t, op, "T").Properties["f"]); got != test.want {
+			t.Errorf("%s is %s, want %s", test.field, got, test.want)
+		}
+	}
+}
+
+func TestSlicesOfMaps(t *testing.T) {
+	tests := []struct {
+		typ  string
+		want string
+	}{
+		{"[]map[string]int", `{"type":"array","items":{"type":"object","additionalProperties":{"type":"integer","format":"int64"}}}`},
+		{"map[string][]string", `{"type":"object","additionalProperties":{"type":"array","items":{"type":"string"}}}`},
+		{"[]map[string][]string", `{"type":"array","items":{"type":"object","additionalProperties":{"type":"array","items":{"type":"string"}}}}`},
+		{"map[string][]map[string]bool", `{"type":"object","additionalProperties":{"type":"array","items":{"type":"object","additionalProperties":{"type":"boolean"}}}}`},
+		{"[][]map[string]int", `{"type":"array","items":{"type":"array","items":{"type":"object","additionalProperties":{"type":"integer","format":"int64"}}}}`},
+	}
+	for _, test := range tests {
+		got, err := fieldSchema(t, "", test.typ)
+		if err != nil {
+			t.Errorf("%s: %v", test.typ, err)
+		} else if got != test.want {
+			t.Errorf("%s is %s, want %s", test.typ, got, test.want)
+		}
+	}
+}
+
+func TestFixedSizeArrays(t *testing.T) {
+	tests := []struct {
+		typ  string
+		want string
+	}{
+		{"[3]float64", `{"type":"array","maxItems":3,"minItems":3,"items":{"type":"number","format":"double"}}`},
+		{"[4]int", `{"type":"array","maxItems":4,"minItems":4,"items":{"type":"integer","format":"int64"}}`},
+		{"[16]byte", `{"type":"array","maxItems":16,"minItems":16,"items":{"type":"integer","format":"int32","minimum":0}}`},
+		{"[Size]string", `{"type":"array","maxItems":4,"minItems":4,"items":{"type":"string"}}`},
+		{"[2 * (Size + 1)]bool", `{"type":"array","maxItems":10,"minItems":10,"items":{"type":"boolean"}}`},
+		{"[]string", `{"type":"array","items":{"type":"string"}}`},
+		{"[]byte", `{"type":"string","format":"byte"}`},
+	}
+	for _, test := range tests {
+		got, err := fieldSchema(t, "const Size = 4\n", test.typ)
+		if err != nil {
+			t.Errorf("%s: %v", test.typ, err)
+		} else if got != test.want {
+			t.Errorf("%s is %s, want %s", test.typ, got, test.want)
+		}
+	}
+}
+
+func TestSlices(t *testing.T) {
+	tests := []struct {
+		typ  string
+		want string
+	}{
+		{"[]byte", `{"type":"string","format":"byte"}`},
+		{"[]uint8", `{"type":"string","format":"byte"}`},
+		{"[]string", `{"type":"array","items":{"type":"string"}}`},
+		{"[]float64", `{"type":"array","items":{"type":"number","format":"double"}}`},
+		{"[]float32", `{"type":"array","items":{"type":"number","format":"float"}}`},
+		{"[]int64", `{"type":"array","items":{"type":"integer","format":"int64"}}`},
+		{"[]rune", `{"type":"array","items":{"type":"integer","format":"int32"}}`},
+		{"[][]byte", `{"type":"array","items":{"type":"string","format":"byte"}}`},
+	}
+	for _, test := range tests {
+		got, err := fieldSchema(t, "", test.typ)
+		if err != nil {
+			t.Errorf("%s: %v", test.typ, err)
+		} else if got != test.want {
+			t.Errorf("%s is %s, want %s", test.typ, got, test.want)
+		}
+	}
+}
+
+func TestNestedSlices(t *testing.T) {
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{"F [][]string", `{"type":"array","items":{"type":"array","items":{"type":"string"}}}`},
+		{"F [][][]int32", `{"type":"array","items":{"type":"array","items":{"type":"array","items":{"type":"integer","format":"int32"}}}}`},
+		{"F [][]Foo", `{"type":"array","items":{"type":"array","items":{"$ref":"#/definitions/Foo"}}}`},
+		{"F [][]*Foo", `{"type":"array","items":{"type":"array","items":{"$ref":"#/definitions/Foo"}}}`},
+		// The item markers apply to the innermost items, the array ones to
+		// the field itself.
+		{
+			"// +kubebuilder:validation:MaxLength=5\n\t// +kubebuilder:validation:MaxItems=2\n\tF [][]string",
+			`{"type":"array","maxItems":2,"items":{"type":"array","items":{"type":"string","maxLength":5}}}`,
+		},
+	}
+	for _, test := range tests {
+		src := "package api\n\ntype Foo struct {\n\tA string `json:\"a\"`\n}\n\ntype T struct {\n\t" + test.field + " `json:\"f\"`\n}\n"
+		op := testGenerator(t, map[string]string{"types.go": src}, "T")
+		op.Flatten = true
+		b, err := json.Marshal(generateDefinition(t, op, "T").Properties["f"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); got != test.want {
+			t.Errorf("%s is %s, want %s", test.field, got, test.want)
+		}
+	}
+}
+
+func TestBytesAndRunes(t *testing.T) {
+	tests := []struct {
+		typ  string
+		want string
+	}{
+		{"byte", `{"type":"integer","format":"int32","minimum":0}`},
+		{"rune", `{"type":"integer","format":"int32"}`},
+		{"*byte", `{"type":"integer","format":"int32","minimum":0}`},
+		{"[]byte", `{"type":"string","format":"byte"}`},
+		{"*[]byte", `{"type":"string","format":"byte"}`},
+		{"map[string][]byte", `{"type":"object","additionalProperties":{"type":"string","format":"byte"}}`},
+	}
+	for _, test := range tests {
+		got, err := fieldSchema(t, "", test.typ)
+		if err != nil {
+			t.Errorf("%s: %v", test.typ, err)
+		} else if got != test.want {
+			t.Errorf("%s is %s, want %s", test.typ, got, test.want)
+		}
+	}
+}
+
+func TestNumberFormats(t *testing.T) {
+	src := `package api
+
+type T struct {
+	I   int     ` + "`json:\"i\"`" + `
+	I32 int32   ` + "`json:\"i32\"`" + `
+	I64 int64   ` + "`json:\"i64\"`" + `
+	U16 uint16  ` + "`json:\"u16\"`" + `
+	F32 float32 ` + "`json:\"f32\"`" + `
+	F64 float64 ` + "`json:\"f64\"`" + `
+	B   []byte  ` + "`json:\"b\"`" + `
+}
+`
+	tests := []struct {
+		omit bool
+		want map[string]string
+	}{
+		{want: map[string]string{"i": "int64", "i32": "int32", "i64": "int64", "u16": "int32", "f32": "float", "f64": "double", "b": "byte"}},
+		{omit: true, want: map[string]string{"i": "", "i32": "", "i64": "", "u16": "", "f32": "", "f64": "", "b": "byte"}},
+	}
+	for _, tt := range tests {
+		op := testGenerator(t, map[string]string{"types.go": src}, "T")
+		op.OmitNumberFormats = tt.omit
+		def := generateDefinition(t, op, "T")
+		for name, want := range tt.want {
+			if got := def.Properties[name].Format; got != want {
+				t.Errorf("OmitNumberFormats %v: %s has format %q, want %q", tt.omit, name, got, want)
+			}
+		}
+	}
+}
+
+func TestFloatFields(t *testing.T) {
+	src := `package api
+
+type T struct {
+	// +kubebuilder:validation:Enum=0.5;1.5
+	Ratio float32 ` + "`json:\"ratio\"`" + `
+	// +kubebuilder:default=2.5
+	Scale float64 ` + "`json:\"scale\"`" + `
+	Weights map[string]float64 ` + "`json:\"weights\"`" + `
+}
+`
+	op := testGenerator(t, map[string]string{"types.go": src}, "T")
+	def := generateDefinition(t, op, "T")
+	tests := map[string]string{
+		"ratio":   `{"type":"number","format":"float","enum":[0.5,1.5]}`,
+		"scale":   `{"type":"number","format":"double","default":2.5}`,
+		"weights": `{"type":"object","additionalProperties":{"type":"number","format":"double"}}`,
+	}
+	for name, want := range tests {
+		if got := compactJSON(t, def.Properties[name]); got != want {
+			t.Errorf("%s is %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestEmbeddedStructs(t *testing.T) {
+	src := `package api
+
+type Meta struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+
+type Spec struct {
+	Size int ` + "`json:\"size\"`" + `
+}
+
+type Status struct {
+	Ready bool ` + "`json:\"ready\"`" + `
+}
+
+type T struct {
+	Meta
+	Spec   ` + "`json:\"spec,omitempty\"`" + `
+	Status ` + "`json:\"status\"`" + `
+}
+`
+	op := testGenerator(t, map[string]string{"types.go": src}, "T")
+	def := generateDefinition(t, op, "T")
+	var keys []string
+	for key := range def.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if want := []string{"name", "spec", "status"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("properties %v, want %v", keys, want)
+	}
+	sort.Strings(def.Required)
+	if want := []string{"name", "status"}; !reflect.DeepEqual(def.Required, want) {
+		t.Errorf("required %v, want %v", def.Required, want)
+	}
+}
+
+func TestScalarOrObject(t *testing.T) {
+	tests := []struct {
+		marker  string
+		typ     string
+		want    string
+		wantErr bool
+	}{
+		{marker: "string", typ: "struct{ Name string }", want: "string"},
+		{marker: "int32", typ: "struct{ Name string }", want: "integer"},
+		{marker: "boolean", typ: "struct{ Name string }", want: "boolean"},
+		{marker: "Other", typ: "struct{ Name string }", wantErr: true},
+		{marker: "string", typ: "string", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.marker+" "+tt.typ, func(t *testing.T) {
+			src := "package api\n\n// Ref is a name or an object.\n// +schemagen:scalarOrObject=" + tt.marker + "\ntype Ref " + tt.typ + "\n"
+			op := testGenerator(t, map[string]string{"types.go": src}, "Ref")
+			schema, err := op.GenerateSchema()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("GenerateSchema() = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateSchema() = %v", err)
+			}
+			def := definition(t, schema, "Ref")
+			if len(def.AnyOf) != 2 || def.AnyOf[0].Type != tt.want || def.AnyOf[1].Type != "object" {
+				t.Fatalf("Ref is %+v, want anyOf a %s and an object", def, tt.want)
+			}
+			if _, ok := def.AnyOf[1].Properties["Name"]; !ok {
+				t.Errorf("the object is %+v, want the properties of the struct", def.AnyOf[1])
+			}
+			if def.Description != "Ref is a name or an object." || def.AnyOf[1].Description != "" {
+				t.Errorf("descriptions %q and %q, want only the one of Ref", def.Description, def.AnyOf[1].Description)
+			}
+		})
+	}
+}
+
+func TestListFilesOnce(t *testing.T) {
+	op := &SingleVersionGenerator{}
+	op.InputPackage = "example.com/api"
+	op.Types = []string{"T"}
+	op.fs = testPackages(t, map[string]map[string]string{
+		"example.com/api": {
+			"a.go": "package api\n\nimport \"example.com/other\"\n\ntype T struct {\n\tO other.O `json:\"o\"`\n\tU U `json:\"u\"`\n}\n",
+			"b.go": "package api\n\nimport \"example.com/common\"\n\ntype U struct {\n\tC common.C `json:\"c\"`\n}\n",
+		},
+		"example.com/other": {
+			"types.go": "package other\n\nimport \"example.com/common\"\n\ntype O struct {\n\tC common.C `json:\"c\"`\n\tD common.D `json:\"d\"`\n}\n",
+		},
+		"example.com/common": {
+			"types.go": "package common\n\ntype C struct {\n\tName string `json:\"name\"`\n}\n\ntype D struct {\n\tSize int `json:\"size\"`\n}\n",
+		},
+	})
+	var mu sync.Mutex
+	calls := map[string]int{}
+	list := listFiles
+	listFiles = func(pkgPath string, ctx build.Context) (string, []string, error) {
+		mu.Lock()
+		calls[pkgPath]++
+		mu.Unlock()
+		return list(pkgPath, ctx)
+	}
+	defer func() { listFiles = list }()
+
+	generateJSON(t, op)
+	want := map[string]int{"example.com/api": 1, "example.com/other": 1, "example.com/common": 1}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("listed %v, want every package once", calls)
+	}
+}
+
+func TestEmitSourceInfo(t *testing.T) {
+	op := &SingleVersionGenerator{}
+	op.InputPackage = "example.com/api"
+	op.Types = []string{"T"}
+	op.Flatten = true
+	op.EmitSourceInfo = true
+	op.fs = testPackages(t, map[string]map[string]string{
+		"example.com/api": {
+			"doc.go":   "package api\n",
+			"types.go": "package api\n\nimport \"example.com/other\"\n\n// T is the root.\ntype T struct {\n\tO other.O `json:\"o\"`\n}\n",
+		},
+		"example.com/other": {
+			"types.go": "package other\n\ntype P int\n\ntype O struct {\n\tName string `json:\"name\"`\n}\n",
+		},
+	})
+	var schema struct {
+		Definitions map[string]struct {
+			Source interface{} `json:"x-source"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal([]byte(generateOutput(t, op)), &schema); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"T":                   `{"file":"example.com/api/types.go","line":6}`,
+		"example.com.other.O": `{"file":"example.com/other/types.go","line":5}`,
+	}
+	for name, want := range tests {
+		if got := compactJSON(t, schema.Definitions[name].Source); got != want {
+			t.Errorf("the source of %s is %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestPromotedFields(t *testing.T) {
+	src := `package api
+
+type Base struct {
+	Name  int    ` + "`json:\"name\"`" + `
+	Extra string ` + "`json:\"extra,omitempty\"`" + `
+}
+
+type T struct {
+	Base
+	Name string ` + "`json:\"name\"`" + `
+	X    struct {
+		Base ` + "`json:\",inline\"`" + `
+		Own  bool ` + "`json:\"own\"`" + `
+	} ` + "`json:\"x\"`" + `
+}
+`
+	for _, flatten := range []bool{false, true} {
+		op := testGenerator(t, map[string]string{"types.go": src}, "T")
+		op.Flatten = flatten
+		def := generateDefinition(t, op, "T")
+		tests := []struct {
+			name     string
+			def      v1beta1.JSONSchemaProps
+			types    map[string]string
+			required []string
+		}{
+			{
+				name:     "T",
+				def:      def,
+				types:    map[string]string{"name": "string", "extra": "string", "x": "object"},
+				required: []string{"name", "x"},
+			},
+			{
+				name:     "T.x",
+				def:      def.Properties["x"],
+				types:    map[string]string{"name": "integer", "extra": "string", "own": "boolean"},
+				required: []string{"name", "own"},
+			},
+		}
+		for _, tt := range tests {
+			if len(tt.def.AllOf) > 0 {
+				t.Errorf("flatten %v: %s has allOf %v, want the fields promoted", flatten, tt.name, tt.def.AllOf)
+			}
+			types := map[string]string{}
+			for key, prop := range tt.def.Properties {
+				types[key] = prop.Type
+			}
+			if !reflect.DeepEqual(types, tt.types) {
+				t.Errorf("flatten %v: %s has the properties %v, want %v", flatten, tt.name, types, tt.types)
+			}
+			required := append([]string{}, tt.def.Required...)
+			sort.Strings(required)
+			if !reflect.DeepEqual(required, tt.required) {
+				t.Errorf("flatten %v: %s requires %v, want %v", flatten, tt.name, required, tt.required)
+			}
+		}
+	}
+}
+
+func TestInputPackages(t *testing.T) {
+	op := &SingleVersionGenerator{}
+	op.InputPackage = "example.com/api"
+	op.InputPackages = []string{"example.com/other"}
+	op.Types = []string{"Spec", "example.com.other.Spec"}
+	op.Flatten = true
+	op.fs = testPackages(t, map[string]map[string]string{
+		"example.com/api":   {"types.go": "package api\n\ntype Spec struct {\n\tName string `json:\"name\"`\n}\n"},
+		"example.com/other": {"types.go": "package other\n\ntype Spec struct {\n\tSize int `json:\"size\"`\n}\n\ntype Unused struct{}\n"},
+	})
+	schema, err := op.GenerateSchema()
+	if err != nil {
+		t.Fatalf("GenerateSchema() = %v", err)
+	}
+	tests := map[string]string{
+		"Spec":                   "name",
+		"example.com.other.Spec": "size",
+	}
+	for name, property := range tests {
+		if _, ok := definition(t, schema, name).Properties[property]; !ok {
+			t.Errorf("%s has no property %s", name, property)
+		}
+	}
+	if len(schema.Definitions) != len(tests) {
+		t.Errorf("%d definitions, want %d", len(schema.Definitions), len(tests))
+	}
+	if len(schema.AnyOf) != len(tests) {
+		t.Errorf("the root schema is any of %v, want both types", schema.AnyOf)
+	}
+}
+
+func TestRequiredMarkers(t *testing.T) {
+	kinds := []struct {
+		name, typ, tag string
+	}{
+		{name: "value", typ: "string"},
+		{name: "omitempty", typ: "string", tag: ",omitempty"},
+		{name: "pointer", typ: "*string"},
+	}
+	markers := []struct {
+		name, comment string
+	}{
+		{name: "none"},
+		{name: "required", comment: "// +required\n\t"},
+		{name: "optional", comment: "// +optional\n\t"},
+		{name: "kubebuilder", comment: "// +kubebuilder:validation:Required\n\t"},
+	}
+	// The fields are named after their kind and marker, e.g. value-required.
+	src := "package api\n\ntype T struct {\n"
+	for i, kind := range kinds {
+		for j, marker := range markers {
+			src += fmt.Sprintf("\t%sF%d%d %s `json:\"%s-%s%s\"`\n", marker.comment, i, j, kind.typ, kind.name, marker.name, kind.tag)
+		}
+	}
+	src += "}\n"
+
+	tests := []struct {
+		mode    string
+		options func(*SingleVersionGenerator)
+		want    []string
+	}{
+		{
+			mode: "tags",
+			want: []string{"omitempty-kubebuilder", "omitempty-required", "pointer-kubebuilder", "pointer-required",
+				"value-kubebuilder", "value-none", "value-required"},
+		},
+		{
+			mode:    "optional by default",
+			options: func(op *SingleVersionGenerator) { op.OptionalByDefault = true },
+			want: []string{"omitempty-kubebuilder", "omitempty-required", "pointer-kubebuilder", "pointer-required",
+				"value-kubebuilder", "value-required"},
+		},
+		{
+			mode:    "all required",
+			options: func(op *SingleVersionGenerator) { op.AllFieldsRequired = true },
+			want: []string{"omitempty-kubebuilder", "omitempty-none", "omitempty-required", "pointer-kubebuilder", "pointer-required",
+				"value-kubebuilder", "value-none", "value-required"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			if tt.options != nil {
+				tt.options(op)
+			}
+			def := generateDefinition(t, op, "T")
+			if len(def.Properties) != len(kinds)*len(markers) {
+				t.Fatalf("%d properties, want %d", len(def.Properties), len(kinds)*len(markers))
+			}
+			required := append([]string{}, def.Required...)
+			sort.Strings(required)
+			if !reflect.DeepEqual(required, tt.want) {
+				t.Errorf("required %v, want %v", required, tt.want)
+			}
+		})
+	}
+}
+
+func TestStdoutOutput(t *testing.T) {
+	for _, format := range []string{"json", "yaml"} {
+		t.Run(format, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": "package api\n\ntype T struct {\n\tName string `json:\"name\"`\n}\n"}, "T")
+			op.OutputPath = StdoutPath
+			op.OutputFormat = format
+			var err error
+			var stdout string
+			stderr := captureStderr(t, func() {
+				stdout = captureStdout(t, func() { err = op.GenerateContext(context.Background()) })
+			})
+			if err != nil {
+				t.Fatalf("GenerateContext() = %v", err)
+			}
+			var schema v1beta1.JSONSchemaProps
+			if err := yaml.Unmarshal([]byte(stdout), &schema); err != nil {
+				t.Fatalf("the standard output isn't a schema: %v\n%s", err, stdout)
+			}
+			definition(t, &schema, "T")
+			if !strings.Contains(stderr, "Generating schema definition for type: T") {
+				t.Errorf("the diagnostics aren't on the standard error:\n%s", stderr)
+			}
+		})
+	}
+}
+
+func TestUnexportedFields(t *testing.T) {
+	src := `package api
+
+type Mixed struct {
+	name  string
+	Count int ` + "`json:\"count\"`" + `
+}
+
+type Hidden struct {
+	name  string
+	Count int ` + "`json:\"-\"`" + `
+}
+
+type Empty struct{}
+
+type T struct {
+	Mixed  Mixed  ` + "`json:\"mixed\"`" + `
+	Hidden Hidden ` + "`json:\"hidden\"`" + `
+	Empty  Empty  ` + "`json:\"empty\"`" + `
+}
+`
+	tests := []struct {
+		name   string
+		want   string
+		warned bool
+		// closed is the definition with DisallowUnknownFields, which is
+		// never warned about.
+		closed string
+	}{
+		{
+			name:   "Mixed",
+			want:   `{"type":"object","required":["count"],"properties":{"count":{"type":"integer","format":"int64"}}}`,
+			closed: `{"type":"object","required":["count"],"properties":{"count":{"type":"integer","format":"int64"}},"additionalProperties":false}`,
+		},
+		{name: "Hidden", want: `{"type":"object"}`, warned: true, closed: `{"type":"object","additionalProperties":false}`},
+		{name: "Empty", want: `{"type":"object"}`, closed: `{"type":"object","additionalProperties":false}`},
+	}
+	for _, disallow := range []bool{false, true} {
+		op := testGenerator(t, map[string]string{"types.go": src}, "T")
+		op.Flatten = true
+		op.DisallowUnknownFields = disallow
+		schema, err := op.GenerateSchema()
+		if err != nil {
+			t.Fatalf("GenerateSchema() = %v", err)
+		}
+		for _, tt := range tests {
+			want, wantWarned := tt.want, tt.warned
+			if disallow {
+				want, wantWarned = tt.closed, false
+			}
+			if got := compactJSON(t, definition(t, schema, tt.name)); got != want {
+				t.Errorf("disallow %v: %s is %s, want %s", disallow, tt.name, got, want)
+			}
+			warned := false
+			for _, w := range op.report.warnings {
+				warned = warned || strings.Contains(w, tt.name+" has no serialized fields")
+			}
+			if warned != wantWarned {
+				t.Errorf("disallow %v: %s warned %v, want %v: %q", disallow, tt.name, warned, wantWarned, op.report.warnings)
+			}
+		}
+	}
+}
+
+func TestGenerateCanceled(t *testing.T) {
+	packages := map[string]map[string]string{
+		"example.com/api":   {"types.go": "package api\n\nimport \"example.com/other\"\n\ntype T struct {\n\tO other.O `json:\"o\"`\n}\n"},
+		"example.com/other": {"types.go": "package other\n\ntype O struct {\n\tName string `json:\"name\"`\n}\n"},
+	}
+	tests := []struct {
+		name     string
+		cancelAt string
+	}{
+		{name: "before the run"},
+		{name: "listing the input package", cancelAt: "example.com/api"},
+		{name: "listing a dependency", cancelAt: "example.com/other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := &SingleVersionGenerator{}
+			op.InputPackage = "example.com/api"
+			op.Types = []string{"T"}
+			op.fs = testPackages(t, packages)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelAt == "" {
+				cancel()
+			}
+			list := listFiles
+			listFiles = func(pkgPath string, bctx build.Context) (string, []string, error) {
+				if pkgPath == tt.cancelAt {
+					cancel()
+				}
+				return list(pkgPath, bctx)
+			}
+			defer func() { listFiles = list }()
+
+			if _, err := op.GenerateSchemaContext(ctx); !errors.Is(err, context.Canceled) {
+				t.Errorf("GenerateSchemaContext() = %v, want %v", err, context.Canceled)
+			}
+		})
+	}
+}
+
+func TestRecursiveTypes(t *testing.T) {
+	src := `// +groupName=example.com
+package api
+
+// +kubebuilder:resource:path=nodes
+type Node struct {
+	Children []Node ` + "`json:\"children\"`" + `
+	Next     *Node  ` + "`json:\"next\"`" + `
+}
+
+// +kubebuilder:resource:path=as
+type A struct {
+	B *B ` + "`json:\"b\"`" + `
+}
+
+type B struct {
+	A *A ` + "`json:\"a\"`" + `
+}
+`
+	tests := []struct {
+		typ       string
+		flatten   bool
+		outputCRD bool
+		refs      []string
+		// warning is the ref the CRD keeps, whatever the output.
+		warning string
+	}{
+		{typ: "Node", refs: []string{"Node"}, warning: "Node"},
+		{typ: "Node", flatten: true, refs: []string{"Node"}, warning: "Node"},
+		{typ: "Node", outputCRD: true, refs: []string{"Node"}, warning: "Node"},
+		{typ: "A", refs: []string{"A"}, warning: "A"},
+		{typ: "A", flatten: true, refs: []string{"A", "B"}, warning: "B"},
+		{typ: "A", outputCRD: true, refs: []string{"A"}, warning: "A"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/flatten %v/CRD %v", tt.typ, tt.flatten, tt.outputCRD), func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, tt.typ)
+			op.Flatten = tt.flatten
+			op.outputCRD = tt.outputCRD
+			out := generateOutput(t, op)
+			for _, name := range tt.refs {
+				if !strings.Contains(out, `"#/definitions/`+name+`"`) {
+					t.Errorf("no ref to %s:\n%s", name, out)
+				}
+			}
+			want := fmt.Sprintf("CRD %s refers to %s, recursive types can't be written in a CRD", tt.typ, tt.warning)
+			if !reflect.DeepEqual(op.report.warnings, []string{want}) {
+				t.Errorf("warnings %q, want %q", op.report.warnings, want)
+			}
+		})
+	}
+}
+
+func TestLocalPackage(t *testing.T) {
+	abs, err := filepath.Abs("testdata/local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		pkg   string
+		local bool
+	}{
+		{pkg: "./testdata/local", local: true},
+		{pkg: "testdata/local", local: true},
+		{pkg: abs, local: true},
+		{pkg: "../crd/testdata/local", local: true},
+		{pkg: "example.com/api"},
+	}
+	for _, tt := range tests {
+		if got := isLocalPackage(tt.pkg); got != tt.local {
+			t.Errorf("isLocalPackage(%q) = %v, want %v", tt.pkg, got, tt.local)
+		}
+		if !tt.local {
+			continue
+		}
+		op := &SingleVersionGenerator{}
+		op.InputPackage = tt.pkg
+		op.Types = []string{"Widget"}
+		def := generateDefinition(t, op, "Widget")
+		if len(def.Properties) != 2 || len(def.Required) != 1 || def.Required[0] != "name" {
+			t.Errorf("%s: Widget is %+v, want name and size", tt.pkg, def)
+		}
+		for gk := range op.crdSpecs {
+			if gk.Group != "example.com" || gk.Kind != "Widget" {
+				t.Errorf("%s: CRD %v, want the Widget one of the +groupName marker", tt.pkg, gk)
+			}
+		}
+		if len(op.crdSpecs) != 1 {
+			t.Errorf("%s: %d CRDs, want Widget", tt.pkg, len(op.crdSpecs))
+		}
+	}
+}
+
+func TestJoinMarshalers(t *testing.T) {
+	tests := []struct {
+		name      string
+		pr, other marshalers
+		want      marshalers
+	}{
+		{name: "new", pr: marshalers{}, other: marshalers{"J": false, "T": true}, want: marshalers{"J": false, "T": true}},
+		{name: "JSON wins", pr: marshalers{"A": true, "B": false}, other: marshalers{"A": false, "B": true}, want: marshalers{"A": false, "B": false}},
+		{name: "text kept", pr: marshalers{"A": true}, other: marshalers{"A": true}, want: marshalers{"A": true}},
+	}
+	for _, tt := range tests {
+		pr := newPrsr(context.Background(), &SingleVersionOptions{}, &packageLister{})
+		other := newPrsr(context.Background(), &SingleVersionOptions{}, &packageLister{})
+		pr.marshalers, other.marshalers = tt.pr, tt.other
+		if err := pr.join(other); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(pr.marshalers, tt.want) {
+			t.Errorf("%s: marshalers %v, want %v", tt.name, pr.marshalers, tt.want)
+		}
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	// chain returns the types T0 to Tn, each of them referring to the next
+	// one with a field, or embedding it.
+	chain := func(n int, embedded bool) string {
+		var src strings.Builder
+		src.WriteString("package api\n")
+		for i := 0; i < n; i++ {
+			field := fmt.Sprintf("Next T%d `json:\"next\"`", i+1)
+			if embedded {
+				field = fmt.Sprintf("T%d", i+1)
+			}
+			fmt.Fprintf(&src, "\ntype T%d struct {\n\t%s\n\tF%d string `json:\"f%d\"`\n}\n", i, field, i, i)
+		}
+		fmt.Fprintf(&src, "\ntype T%d struct {\n\tLast string `json:\"last\"`\n}\n", n)
+		return src.String()
+	}
+	tests := []struct {
+		name     string
+		src      string
+		maxDepth int
+		wantErr  string
+	}{
+		{name: "fields within", src: chain(5, false), maxDepth: 10},
+		{name: "fields deeper", src: chain(20, false), maxDepth: 10, wantErr: "types nested deeper than 10: T0 -> T1 -> "},
+		{name: "embedded within", src: chain(5, true), maxDepth: 10},
+		{name: "embedded deeper", src: chain(20, true), maxDepth: 10, wantErr: "allOf nested deeper than 10: T0 -> T1 -> "},
+		{name: "default", src: chain(DefaultMaxDepth+10, false), wantErr: fmt.Sprintf("types nested deeper than %d", DefaultMaxDepth)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": tt.src}, "T0")
+			op.MaxDepth = tt.maxDepth
+			_, err := op.GenerateSchema()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("GenerateSchema() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("GenerateSchema() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
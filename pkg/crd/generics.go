@@ -0,0 +1,251 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"sort"
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// genericType is the declaration of a generic type of the package being
+// parsed, e.g. type List[T any] struct{ Items []T }. Its own definition has
+// type parameters accepting any value, each instantiation, e.g. List[Foo],
+// has its own definition, see instantiate.
+type genericType struct {
+	spec *ast.TypeSpec
+	// decl holds spec, its comments are the ones of the type.
+	decl ast.Decl
+	// file is the file declaring the type, its imports resolve the types
+	// of spec.
+	file *file
+}
+
+// instance is an instantiation of a generic type, see instanceToSchema.
+type instance struct {
+	// generic is the name of the generic type, e.g. List for List[Foo].
+	generic string
+	// args are the type arguments, in the order of the type parameters.
+	args []typeArg
+	// depth is how many instantiations it is nested in, e.g. 2 for the
+	// List[Foo] of the definition of Tree[Foo], see MaxDepth.
+	depth int
+}
+
+// typeArg is the type argument of a type parameter.
+type typeArg struct {
+	// name is how the argument shows up in the name of the instantiation,
+	// e.g. Foo in List[Foo], see typeArgName.
+	name string
+	def  *v1beta1.JSONSchemaProps
+	// refs are the types of other packages def refers to, by import path.
+	refs []TypeReference
+	// open is set for the parameters of the definition of the generic type
+	// itself, accepting any value.
+	open bool
+}
+
+// typeParamNames returns the names of the type parameters of spec, in order.
+func typeParamNames(spec *ast.TypeSpec) []string {
+	var names []string
+	if spec.TypeParams == nil {
+		return names
+	}
+	for _, field := range spec.TypeParams.List {
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+	}
+	return names
+}
+
+// typeArguments returns the generic type and the type arguments of t, e.g.
+// List and Foo for List[Foo], or false if t isn't an instantiation.
+func typeArguments(t ast.Expr) (ast.Expr, []ast.Expr, bool) {
+	switch tt := t.(type) {
+	case *ast.IndexExpr:
+		return tt.X, []ast.Expr{tt.Index}, true
+	case *ast.IndexListExpr:
+		return tt.X, tt.Indices, true
+	}
+	return nil, nil, false
+}
+
+// openGeneric returns f with the type parameters params accepting any value,
+// to parse the definition of a generic type.
+func (f *file) openGeneric(params []string) *file {
+	open := *f
+	open.typeArgs = map[string]typeArg{}
+	for _, param := range params {
+		open.typeArgs[param] = typeArg{name: param, def: f.emptySchema(), open: true}
+	}
+	return &open
+}
+
+// instanceToSchema returns the ref to the definition of the instantiation t,
+// e.g. List[Foo], and adds it to the instances of the package. The
+// instantiations of the type parameters of a generic type in its own
+// definition refer to it, they accept any value too.
+func (f *file) instanceToSchema(t ast.Expr, comments []*ast.CommentGroup) (*v1beta1.JSONSchemaProps, error) {
+	generic, args, _ := typeArguments(t)
+	ident, ok := generic.(*ast.Ident)
+	if !ok {
+		return nil, fmt.Errorf("generic type %s isn't supported, only the ones of the package itself are", types.ExprString(generic))
+	}
+
+	inst := &instance{generic: ident.Name, depth: f.depth + 1}
+	open := true
+	var names []string
+	for _, arg := range args {
+		def, refs, err := f.exprToSchema(arg, "", nil)
+		if err != nil {
+			return nil, fmt.Errorf("type argument %s: %v", types.ExprString(arg), err)
+		}
+		for i := range refs {
+			refs[i].PackageName = f.importPaths[refs[i].PackageName]
+		}
+		// The refs of the arguments of the instantiation being parsed
+		// aren't returned with their schemas.
+		for _, outer := range f.typeArgs {
+			refs = append(refs, outer.refs...)
+		}
+		name := f.typeArgName(arg)
+		param, isParam := arg.(*ast.Ident)
+		open = open && isParam && f.typeArgs[param.Name].open
+		inst.args = append(inst.args, typeArg{name: name, def: def, refs: refs})
+		names = append(names, name)
+	}
+
+	def := &v1beta1.JSONSchemaProps{}
+	if open {
+		def.Ref = getPrefixedDefLink(ident.Name, f.pkgPrefix)
+	} else {
+		name := getFullName(fmt.Sprintf("%s[%s]", ident.Name, strings.Join(names, ",")), f.pkgPrefix)
+		if _, ok := f.instances[name]; !ok {
+			f.instances[name] = inst
+		}
+		def.Ref = getDefLink(name)
+	}
+	return def, processMarkersInComments(def, f.report, comments...)
+}
+
+// typeArgName returns how the type argument t shows up in the name of an
+// instantiation, the name of its definition for a named type, e.g.
+// List[example.com.pkg.Foo], and a Go type for the other ones, e.g.
+// List[[]string]. The pointers are left out like in its schema.
+func (f *file) typeArgName(t ast.Expr) string {
+	switch tt := t.(type) {
+	case *ast.Ident:
+		if arg, ok := f.typeArgs[tt.Name]; ok {
+			return arg.name
+		}
+		if isSimpleType(tt.Name) || isAnyType(tt) {
+			return tt.Name
+		}
+		return getFullName(tt.Name, f.pkgPrefix)
+	case *ast.StarExpr:
+		return f.typeArgName(tt.X)
+	case *ast.ArrayType:
+		return "[]" + f.typeArgName(tt.Elt)
+	case *ast.MapType:
+		return "map[" + f.typeArgName(tt.Key) + "]" + f.typeArgName(tt.Value)
+	case *ast.SelectorExpr:
+		if pkg, ok := tt.X.(*ast.Ident); ok {
+			return getFullName(tt.Sel.Name, f.importPaths[pkg.Name])
+		}
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		generic, args, _ := typeArguments(tt)
+		var names []string
+		for _, arg := range args {
+			names = append(names, f.typeArgName(arg))
+		}
+		return fmt.Sprintf("%s[%s]", f.typeArgName(generic), strings.Join(names, ","))
+	}
+	return types.ExprString(t)
+}
+
+// instantiate returns the definitions of the instantiations of the generic
+// types of the package, and the types of other packages they refer to, once
+// every file of the package is parsed. open are the definitions of the
+// generic types themselves, whose title and additionalProperties, e.g. from
+// a +schemagen:closed marker, the instantiations share.
+func (pr *prsr) instantiate(open v1beta1.JSONSchemaDefinitions, pkgPrefix string) (v1beta1.JSONSchemaDefinitions, ExternalReferences, error) {
+	defs := v1beta1.JSONSchemaDefinitions{}
+	externalRefs := ExternalReferences{}
+	for {
+		// Parsing an instantiation can add more of them.
+		var pending []string
+		for name := range pr.instances {
+			if _, ok := defs[name]; !ok {
+				pending = append(pending, name)
+			}
+		}
+		if len(pending) == 0 {
+			return defs, externalRefs, nil
+		}
+		sort.Strings(pending)
+
+		for _, name := range pending {
+			inst := pr.instances[name]
+			generic, ok := pr.generics[inst.generic]
+			if !ok {
+				return nil, nil, fmt.Errorf("%s isn't a generic type of package %s", inst.generic, pr.pkgPath)
+			}
+			params := typeParamNames(generic.spec)
+			if len(params) != len(inst.args) {
+				return nil, nil, fmt.Errorf("%s: %s has %d type parameters", name, inst.generic, len(params))
+			}
+			if inst.depth > pr.options.maxDepth() {
+				return nil, nil, fmt.Errorf("generic types nested deeper than %d: %s", pr.options.maxDepth(), name)
+			}
+
+			f := *generic.file
+			f.typeArgs = map[string]typeArg{}
+			f.depth = inst.depth
+			var refs []TypeReference
+			for i, param := range params {
+				f.typeArgs[param] = inst.args[i]
+				refs = append(refs, inst.args[i].refs...)
+			}
+			doc := generic.file.commentMap[generic.decl]
+			def, bodyRefs, err := f.exprToSchema(generic.spec.Type, generic.decl.(*ast.GenDecl).Doc.Text(), doc)
+			if err == nil {
+				err = checkBounds(def)
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("type %s: %v", name, err)
+			}
+			for _, ref := range bodyRefs {
+				ref.PackageName = generic.file.importPaths[ref.PackageName]
+				refs = append(refs, ref)
+			}
+			if openDef, ok := open[getFullName(inst.generic, pkgPrefix)]; ok {
+				def.Title = openDef.Title
+				if def.AdditionalProperties == nil {
+					def.AdditionalProperties = openDef.AdditionalProperties
+				}
+			}
+			defs[name] = *def
+			if st, ok := generic.spec.Type.(*ast.StructType); ok {
+				pr.tagged[name] = taggedKeys(st)
+			}
+			externalRefs[name] = refs
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenericTypes(t *testing.T) {
+	op := testGenerator(t, map[string]string{"types.go": `package api
+
+type List[T any] struct {
+	Items []T ` + "`json:\"items\"`" + `
+}
+
+type Pair[K comparable, V any] struct {
+	Key   K ` + "`json:\"key\"`" + `
+	Value V ` + "`json:\"value\"`" + `
+}
+
+type Foo struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+
+type Holder struct {
+	Foos   List[Foo]         ` + "`json:\"foos\"`" + `
+	Names  List[string]      ` + "`json:\"names\"`" + `
+	Pair   Pair[string, Foo] ` + "`json:\"pair\"`" + `
+	Nested List[List[int]]   ` + "`json:\"nested\"`" + `
+}
+`}, "Holder", "List")
+	op.Flatten = true
+	schema, err := op.GenerateSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "Holder", want: `{"type":"object","required":["foos","names","pair","nested"],"properties":{"foos":{"$ref":"#/definitions/List[Foo]"},"names":{"$ref":"#/definitions/List[string]"},"nested":{"$ref":"#/definitions/List[List[int]]"},"pair":{"$ref":"#/definitions/Pair[string,Foo]"}}}`},
+		// The type parameter of the generic type itself accepts any value.
+		{name: "List", want: `{"type":"object","required":["items"],"properties":{"items":{"type":"array","items":{}}}}`},
+		{name: "List[Foo]", want: `{"type":"object","required":["items"],"properties":{"items":{"type":"array","items":{"$ref":"#/definitions/Foo"}}}}`},
+		{name: "List[string]", want: `{"type":"object","required":["items"],"properties":{"items":{"type":"array","items":{"type":"string"}}}}`},
+		{name: "List[List[int]]", want: `{"type":"object","required":["items"],"properties":{"items":{"type":"array","items":{"$ref":"#/definitions/List[int]"}}}}`},
+		{name: "List[int]", want: `{"type":"object","required":["items"],"properties":{"items":{"type":"array","items":{"type":"integer","format":"int64"}}}}`},
+		{name: "Pair[string,Foo]", want: `{"type":"object","required":["key","value"],"properties":{"key":{"type":"string"},"value":{"$ref":"#/definitions/Foo"}}}`},
+	}
+	for _, tt := range tests {
+		if got := compactJSON(t, definition(t, schema, tt.name)); got != tt.want {
+			t.Errorf("%s is %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSharedInstantiation(t *testing.T) {
+	op := testGenerator(t, map[string]string{"types.go": `package api
+
+type List[T any] struct {
+	Items []T ` + "`json:\"items\"`" + `
+}
+
+type Foo struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+
+type Holder struct {
+	First  List[Foo] ` + "`json:\"first\"`" + `
+	Second List[Foo] ` + "`json:\"second\"`" + `
+	Other  List[string] ` + "`json:\"other\"`" + `
+}
+`}, "Holder")
+	op.Flatten = true
+	schema, err := op.GenerateSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder := definition(t, schema, "Holder")
+	first, second := holder.Properties["first"].Ref, holder.Properties["second"].Ref
+	if first == nil || second == nil || *first != *second {
+		t.Fatalf("first refers to %v and second to %v, want the same definition", first, second)
+	}
+	definition(t, schema, getNameFromURL(*first))
+	var instances []string
+	for name := range schema.Definitions {
+		if strings.HasPrefix(name, "List[") {
+			instances = append(instances, name)
+		}
+	}
+	if len(instances) != 2 {
+		t.Errorf("instantiations %v, want one of List[Foo] and one of List[string]", instances)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// typeCheck type checks the package being parsed from source. It is done
+// once per package, since only AutoDiscoverImplementations needs it.
+func (pr *prsr) typeCheck() (*types.Package, error) {
+	if pr.checked != nil && pr.checked.Path() == pr.pkgPath {
+		return pr.checked, nil
+	}
+	dir, fileNames, err := pr.lister.list(pr.pkgPath, pr.options.buildContext())
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, fileName := range fileNames {
+		path := filepath.Join(dir, fileName)
+		src, err := pr.fs.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		file, err := parser.ParseFile(fset, path, src, 0)
+		src.Close()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		// Keep going on errors, e.g. in the imported packages, the
+		// declarations of the package itself are still known.
+		Error: func(error) {},
+	}
+	pkg, err := conf.Check(pr.pkgPath, fset, files, nil)
+	if pkg == nil {
+		return nil, err
+	}
+	pr.checked = pkg
+	return pkg, nil
+}
+
+// implementations returns the names of the types of the package being parsed
+// that implement the interface named ifaceName, directly or through a
+// pointer, in lexical order. Nothing is returned for an empty interface,
+// every type implements it.
+func (pr *prsr) implementations(ifaceName string) ([]string, error) {
+	pkg, err := pr.typeCheck()
+	if err != nil {
+		return nil, fmt.Errorf("failed to type check package %q: %v", pr.pkgPath, err)
+	}
+	obj := pkg.Scope().Lookup(ifaceName)
+	if obj == nil {
+		return nil, fmt.Errorf("interface %s not found in package %q", ifaceName, pr.pkgPath)
+	}
+	iface, ok := obj.Type().Underlying().(*types.Interface)
+	if !ok || iface.Empty() {
+		return nil, nil
+	}
+
+	var names []string
+	// Names are sorted.
+	for _, name := range pkg.Scope().Names() {
+		typeName, ok := pkg.Scope().Lookup(name).(*types.TypeName)
+		if !ok || types.IsInterface(typeName.Type()) {
+			continue
+		}
+		if types.Implements(typeName.Type(), iface) || types.Implements(types.NewPointer(typeName.Type()), iface) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// implementationsSchema returns the oneOf of the implementations of the
+// interface named ifaceName, see AutoDiscoverImplementations. def, accepting
+// any value, is returned when there is no implementation.
+func (pr *prsr) implementationsSchema(ifaceName, pkgPrefix string, def *v1beta1.JSONSchemaProps) (*v1beta1.JSONSchemaProps, error) {
+	names, err := pr.implementations(ifaceName)
+	if err != nil || len(names) == 0 {
+		return def, err
+	}
+	oneOf := &v1beta1.JSONSchemaProps{}
+	for _, name := range names {
+		oneOf.OneOf = append(oneOf.OneOf, v1beta1.JSONSchemaProps{Ref: getDefLink(getFullName(name, pkgPrefix))})
+	}
+	return oneOf, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAutoDiscoverImplementations(t *testing.T) {
+	src := `package api
+
+// Shape is a shape.
+type Shape interface {
+	Area() float64
+}
+
+type Circle struct {
+	Radius float64 ` + "`json:\"radius\"`" + `
+}
+
+func (c Circle) Area() float64 { return 3 * c.Radius * c.Radius }
+
+type Square struct {
+	Side float64 ` + "`json:\"side\"`" + `
+}
+
+func (s *Square) Area() float64 { return s.Side * s.Side }
+
+type Line struct {
+	Length float64 ` + "`json:\"length\"`" + `
+}
+
+type T struct {
+	Shape Shape ` + "`json:\"shape\"`" + `
+}
+`
+	tests := []struct {
+		discover bool
+		want     []string
+	}{
+		{discover: false},
+		{discover: true, want: []string{"#/definitions/Circle", "#/definitions/Square"}},
+	}
+	for _, tt := range tests {
+		op := testGenerator(t, map[string]string{"types.go": src}, "T")
+		op.Flatten = true
+		op.AutoDiscoverImplementations = tt.discover
+		schema, err := op.GenerateSchema()
+		if err != nil {
+			t.Fatalf("GenerateSchema() = %v", err)
+		}
+		shape := definition(t, schema, "Shape")
+		var refs []string
+		for _, branch := range shape.OneOf {
+			refs = append(refs, *branch.Ref)
+		}
+		if !reflect.DeepEqual(refs, tt.want) {
+			t.Errorf("discover %v: Shape is the oneOf %v, want %v", tt.discover, refs, tt.want)
+		}
+		for _, ref := range tt.want {
+			definition(t, schema, getNameFromURL(ref))
+		}
+		if _, ok := schema.Definitions["Line"]; ok {
+			t.Errorf("discover %v: Line is defined, want only the implementations", tt.discover)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// inlineSmallDefinitions replaces the refs to the definitions having less
+// than threshold properties with the definitions themselves. Definitions
+// taking part in a cycle are always kept as refs. The definitions no longer
+// referenced from the starting types are removed.
+func inlineSmallDefinitions(defs v1beta1.JSONSchemaDefinitions, threshold int, startingTypes map[string]bool) {
+	small := map[string]bool{}
+	for name, def := range defs {
+		if len(def.Properties) < threshold && !inCycle(defs, name) {
+			small[name] = true
+		}
+	}
+	if len(small) == 0 {
+		return
+	}
+
+	// The small definitions are acyclic, so inlining them recursively ends.
+	inline := func(def *v1beta1.JSONSchemaProps) {
+		if def.Ref == nil || !small[getNameFromURL(*def.Ref)] {
+			return
+		}
+		inlined := defs[getNameFromURL(*def.Ref)]
+		// What is set next to the ref, e.g. the doc of a field, wins.
+		if len(def.Description) > 0 {
+			inlined.Description = def.Description
+		}
+		if len(def.Enum) > 0 {
+			inlined.Enum = def.Enum
+		}
+		inlined.Nullable = inlined.Nullable || def.Nullable
+		*def = inlined
+	}
+	for name := range defs {
+		def := defs[name]
+		walkDefinition(&def, inline)
+		defs[name] = def
+	}
+
+	reachableTypes := getReachableTypes(startingTypes, defs)
+	for name := range defs {
+		if !reachableTypes[name] {
+			delete(defs, name)
+		}
+	}
+}
+
+// inCycle returns true if the definition named name refers to itself,
+// directly or through other definitions.
+func inCycle(defs v1beta1.JSONSchemaDefinitions, name string) bool {
+	visited := map[string]bool{}
+	def := defs[name]
+	queue := processDefinition(&def)
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if cur == name {
+			return true
+		}
+		if visited[cur] {
+			continue
+		}
+		visited[cur] = true
+		if curDef, ok := defs[cur]; ok {
+			queue = append(queue, processDefinition(&curDef)...)
+		}
+	}
+	return false
+}
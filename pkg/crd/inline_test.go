@@ -0,0 +1,124 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInlineThreshold(t *testing.T) {
+	src := `package api
+
+type Small struct {
+	A string ` + "`json:\"a\"`" + `
+}
+
+type Large struct {
+	A string ` + "`json:\"a\"`" + `
+	B string ` + "`json:\"b\"`" + `
+}
+
+type Node struct {
+	Next *Node ` + "`json:\"next\"`" + `
+}
+
+type T struct {
+	// S is small.
+	S Small ` + "`json:\"s\"`" + `
+	L Large ` + "`json:\"l\"`" + `
+	N Node  ` + "`json:\"n\"`" + `
+}
+`
+	tests := []struct {
+		property string
+		ref      string
+		inlined  bool
+	}{
+		{property: "s", ref: "Small", inlined: true},
+		{property: "l", ref: "Large"},
+		{property: "n", ref: "Node"},
+	}
+	op := testGenerator(t, map[string]string{"types.go": src}, "T")
+	op.Flatten = true
+	op.InlineThreshold = 2
+	schema, err := op.GenerateSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	props := definition(t, schema, "T").Properties
+	for _, tt := range tests {
+		prop := props[tt.property]
+		_, defined := schema.Definitions[tt.ref]
+		if tt.inlined {
+			if prop.Ref != nil || prop.Type != "object" || defined {
+				t.Errorf("%s is %+v and %s is defined %v, want it inlined", tt.property, prop, tt.ref, defined)
+			}
+			continue
+		}
+		if prop.Ref == nil || *prop.Ref != "#/definitions/"+tt.ref || !defined {
+			t.Errorf("%s is %+v and %s is defined %v, want a ref", tt.property, prop, tt.ref, defined)
+		}
+	}
+	if desc := props["s"].Description; desc != "S is small." {
+		t.Errorf("s has the description %q, want the doc of the field", desc)
+	}
+}
+
+func TestInline(t *testing.T) {
+	src := `package api
+
+type T struct {
+	U  U            ` + "`json:\"u\"`" + `
+	Us []U          ` + "`json:\"us\"`" + `
+	M  map[string]U ` + "`json:\"m\"`" + `
+}
+
+type U struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+
+type Node struct {
+	Value string ` + "`json:\"value\"`" + `
+	Next  *Node  ` + "`json:\"next\"`" + `
+}
+`
+	tests := []struct {
+		types []string
+		refs  bool
+	}{
+		{types: []string{"T"}},
+		{types: []string{"T", "U"}},
+		{types: []string{"Node"}, refs: true},
+	}
+	for _, tt := range tests {
+		op := testGenerator(t, map[string]string{"types.go": src}, tt.types...)
+		op.Inline = true
+		out := generateOutput(t, op)
+		if refs := strings.Contains(out, `"$ref"`); refs != tt.refs {
+			t.Errorf("%v: refs %v, want %v:\n%s", tt.types, refs, tt.refs, out)
+		}
+		if defs := strings.Contains(out, `"definitions"`); defs != tt.refs {
+			t.Errorf("%v: definitions %v, want %v:\n%s", tt.types, defs, tt.refs, out)
+		}
+	}
+
+	op := testGenerator(t, map[string]string{"types.go": src}, "T")
+	op.Inline = true
+	op.Flatten = true
+	if _, err := op.GenerateSchema(); err == nil {
+		t.Error("GenerateSchema() = nil, want an error inlining a flattened schema")
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// definitionKeywords holds keywords JSONSchemaProps has no field for, e.g.
+// examples, by definition name. They are added to the definitions when the
+// schema is written.
+type definitionKeywords map[string]map[string]interface{}
+
+// set sets keyword to value on the definition named defName.
+func (k definitionKeywords) set(defName, keyword string, value interface{}) {
+	if k[defName] == nil {
+		k[defName] = map[string]interface{}{}
+	}
+	k[defName][keyword] = value
+}
+
+// toGeneric returns the generic JSON form of doc.
+func toGeneric(doc interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var generic map[string]interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return nil, err
+	}
+	return generic, nil
+}
+
+// definitionGroups returns the package prefixes the definitions are grouped
+// by when they are namespaced, see NamespaceDefinitions.
+func definitionGroups(defs v1beta1.JSONSchemaDefinitions, namespaced bool) map[string]bool {
+	groups := map[string]bool{}
+	if !namespaced {
+		return groups
+	}
+	for name := range defs {
+		if prefix, _ := splitFullName(name); prefix != "" {
+			groups[prefix] = true
+		}
+	}
+	return groups
+}
+
+// addKeywords adds the keywords to the definitions of the generic JSON form
+// of a schema. Keywords of definitions that aren't in the schema, e.g. because
+// they were embedded, are dropped.
+func addKeywords(generic map[string]interface{}, keywords definitionKeywords, namespaced bool) {
+	defs, _ := generic["definitions"].(map[string]interface{})
+	for name, kws := range keywords {
+		container, key := defs, name
+		if prefix, typeName := splitFullName(name); namespaced && prefix != "" {
+			container, _ = defs[prefix].(map[string]interface{})
+			key = typeName
+		}
+		def, ok := container[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for keyword, value := range kws {
+			def[keyword] = value
+		}
+	}
+}
+
+// walkGeneric calls fn on the generic JSON form of a schema and then on every
+// schema nested in it. groups are the entries of the definitions holding the
+// definitions of a package, see NamespaceDefinitions.
+func walkGeneric(schema map[string]interface{}, groups map[string]bool, fn func(map[string]interface{})) {
+	fn(schema)
+	for key, value := range schema {
+		switch key {
+		case "definitions", "$defs":
+			defs, _ := value.(map[string]interface{})
+			for name, def := range defs {
+				if !groups[name] {
+					walkGenericValue(def, fn)
+					continue
+				}
+				pkgDefs, _ := def.(map[string]interface{})
+				for _, pkgDef := range pkgDefs {
+					walkGenericValue(pkgDef, fn)
+				}
+			}
+		case "properties", "patternProperties":
+			if m, ok := value.(map[string]interface{}); ok {
+				for _, sub := range m {
+					walkGenericValue(sub, fn)
+				}
+			}
+		case "allOf", "anyOf", "oneOf", "items", "prefixItems":
+			if a, ok := value.([]interface{}); ok {
+				for _, sub := range a {
+					walkGenericValue(sub, fn)
+				}
+				continue
+			}
+			walkGenericValue(value, fn)
+		case "not", "additionalProperties", "additionalItems":
+			walkGenericValue(value, fn)
+		}
+	}
+}
+
+// walkGenericValue walks value if it is a schema object, boolean schemas
+// are skipped.
+func walkGenericValue(value interface{}, fn func(map[string]interface{})) {
+	if m, ok := value.(map[string]interface{}); ok {
+		walkGeneric(m, nil, fn)
+	}
+}
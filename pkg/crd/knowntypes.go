@@ -0,0 +1,105 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+
+// KnownType is the schema of a type whose JSON form is a scalar, e.g. a
+// string for a resource.Quantity, used instead of walking the type.
+type KnownType struct {
+	// Type is the JSON type, e.g. string.
+	Type string
+	// Format is the optional format, e.g. date-time.
+	Format string
+	// Pattern is the optional regular expression of the values.
+	Pattern string
+	// AnyFormat are the formats one of which the values have, e.g. ipv4
+	// and ipv6, instead of Format.
+	AnyFormat []string
+	// IntOrString accepts an integer or a string instead of Type, e.g. for
+	// an intstr.IntOrString, marked with x-kubernetes-int-or-string as a CRD
+	// requires.
+	IntOrString bool
+}
+
+// TypeConverter returns the schema of the types it knows, e.g. of a type
+// with a custom MarshalJSON, and false for the other ones.
+type TypeConverter func(TypeReference) (*v1beta1.JSONSchemaProps, bool)
+
+// quantityPattern matches the serialized resource.Quantity values.
+const quantityPattern = `^(\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))(([KMGTPE]i)|[numkMGTPE]|([eE](\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))))?$`
+
+// ipFormats are the formats of an IP address.
+var ipFormats = []string{"ipv4", "ipv6"}
+
+// defaultKnownTypes are the known types by import path and type name, see
+// SingleVersionOptions.KnownTypes. Most of them have a custom JSON form, e.g.
+// the standard library types implementing json.Marshaler or
+// encoding.TextMarshaler. A url.URL isn't one, encoding/json writes it as an
+// object.
+var defaultKnownTypes = map[string]KnownType{
+	"k8s.io/apimachinery/pkg/apis/meta/v1.Time":       {Type: "string", Format: "date-time"},
+	"k8s.io/apimachinery/pkg/apis/meta/v1.MicroTime":  {Type: "string", Format: "date-time"},
+	"k8s.io/apimachinery/pkg/apis/meta/v1.Duration":   {Type: "string"},
+	"k8s.io/apimachinery/pkg/api/resource.Quantity":   {Type: "string", Pattern: quantityPattern},
+	"k8s.io/apimachinery/pkg/util/intstr.IntOrString": {IntOrString: true},
+	"time.Time":            {Type: "string", Format: "date-time"},
+	"time.Duration":        {Type: "integer", Format: "int64"},
+	"encoding/json.Number": {Type: "number"},
+	"math/big.Int":         {Type: "integer"},
+	"math/big.Float":       {Type: "string"},
+	"math/big.Rat":         {Type: "string"},
+	"net.IP":               {Type: "string", AnyFormat: ipFormats},
+	"net/netip.Addr":       {Type: "string", AnyFormat: ipFormats},
+	"net/netip.AddrPort":   {Type: "string"},
+	"net/netip.Prefix":     {Type: "string", Format: "cidr"},
+	"regexp.Regexp":        {Type: "string"},
+}
+
+// knownType returns the schema of the type named typeName of the package with
+// the given import path if it is a known type. The TypeConverters of the
+// options take precedence over the KnownTypes, which take precedence over the
+// default ones.
+func (f *file) knownType(pkgPath, typeName string) (*v1beta1.JSONSchemaProps, bool) {
+	for _, convert := range f.options.TypeConverters {
+		if def, ok := convert(TypeReference{TypeName: typeName, PackageName: pkgPath}); ok {
+			// The markers of a field are set on the schema, it isn't shared.
+			return def.DeepCopy(), true
+		}
+	}
+	name := pkgPath + "." + typeName
+	known, ok := f.options.KnownTypes[name]
+	if !ok {
+		known, ok = defaultKnownTypes[name]
+	}
+	if !ok {
+		return nil, false
+	}
+	if known.IntOrString {
+		return &v1beta1.JSONSchemaProps{
+			AnyOf:        []v1beta1.JSONSchemaProps{{Type: "string"}, {Type: "integer"}},
+			XIntOrString: true,
+		}, true
+	}
+	def := &v1beta1.JSONSchemaProps{
+		Type:    known.Type,
+		Format:  known.Format,
+		Pattern: known.Pattern,
+	}
+	for _, format := range known.AnyFormat {
+		def.AnyOf = append(def.AnyOf, v1beta1.JSONSchemaProps{Format: format})
+	}
+	return def, true
+}
@@ -0,0 +1,197 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+func TestKnownTypes(t *testing.T) {
+	src := `package api
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type Color struct {
+	R, G, B uint8
+}
+
+type T struct {
+	Quantity resource.Quantity ` + "`json:\"quantity\"`" + `
+	Timeout  metav1.Duration   ` + "`json:\"timeout\"`" + `
+	Created  metav1.Time       ` + "`json:\"created\"`" + `
+	Delay    time.Duration     ` + "`json:\"delay\"`" + `
+	Color    Color             ` + "`json:\"color\"`" + `
+	// +kubebuilder:validation:MaxLength=8
+	Hex Color ` + "`json:\"hex\"`" + `
+}
+`
+	op := testGenerator(t, map[string]string{"types.go": src}, "T")
+	op.KnownTypes = map[string]KnownType{
+		"example.com/api.Color": {Type: "string", Pattern: "^#[0-9a-f]{6}$"},
+		"time.Duration":         {Type: "string", Format: "duration"},
+	}
+	def := generateDefinition(t, op, "T")
+	tests := map[string]string{
+		"quantity": `{"type":"string","pattern":` + compactJSON(t, quantityPattern) + `}`,
+		"timeout":  `{"type":"string"}`,
+		"created":  `{"type":"string","format":"date-time"}`,
+		"delay":    `{"type":"string","format":"duration"}`,
+		"color":    `{"type":"string","pattern":"^#[0-9a-f]{6}$"}`,
+		"hex":      `{"type":"string","maxLength":8,"pattern":"^#[0-9a-f]{6}$"}`,
+	}
+	for name, want := range tests {
+		if got := compactJSON(t, def.Properties[name]); got != want {
+			t.Errorf("%s is %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestAddressTypes(t *testing.T) {
+	src := `package api
+
+import (
+	"net"
+	"net/netip"
+)
+
+type T struct {
+	IP       net.IP         ` + "`json:\"ip\"`" + `
+	Addr     netip.Addr     ` + "`json:\"addr\"`" + `
+	AddrPort netip.AddrPort ` + "`json:\"addrPort\"`" + `
+	Prefix   netip.Prefix   ` + "`json:\"prefix\"`" + `
+	IPs      []net.IP       ` + "`json:\"ips\"`" + `
+}
+`
+	def := generateDefinition(t, testGenerator(t, map[string]string{"types.go": src}, "T"), "T")
+	ip := `{"type":"string","anyOf":[{"format":"ipv4"},{"format":"ipv6"}]}`
+	tests := map[string]string{
+		"ip":       ip,
+		"addr":     ip,
+		"addrPort": `{"type":"string"}`,
+		"prefix":   `{"type":"string","format":"cidr"}`,
+		"ips":      `{"type":"array","items":` + ip + `}`,
+	}
+	for name, want := range tests {
+		if got := compactJSON(t, def.Properties[name]); got != want {
+			t.Errorf("%s is %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestTypeConverters(t *testing.T) {
+	src := `package api
+
+import "time"
+
+type Handle struct {
+	id int
+}
+
+type T struct {
+	Handle  Handle        ` + "`json:\"handle\"`" + `
+	// +kubebuilder:validation:MinLength=2
+	Named   Handle        ` + "`json:\"named\"`" + `
+	Handles []Handle      ` + "`json:\"handles\"`" + `
+	Delay   time.Duration ` + "`json:\"delay\"`" + `
+	Count   int           ` + "`json:\"count\"`" + `
+}
+`
+	var seen []TypeReference
+	op := testGenerator(t, map[string]string{"types.go": src}, "T")
+	op.KnownTypes = map[string]KnownType{
+		"example.com/api.Handle": {Type: "integer"},
+		"time.Duration":          {Type: "string", Format: "duration"},
+	}
+	op.TypeConverters = []TypeConverter{
+		func(ref TypeReference) (*v1beta1.JSONSchemaProps, bool) {
+			seen = append(seen, ref)
+			return nil, false
+		},
+		func(ref TypeReference) (*v1beta1.JSONSchemaProps, bool) {
+			if ref == (TypeReference{TypeName: "Handle", PackageName: "example.com/api"}) {
+				return &v1beta1.JSONSchemaProps{Type: "string", Pattern: "^h-"}, true
+			}
+			return nil, false
+		},
+		func(ref TypeReference) (*v1beta1.JSONSchemaProps, bool) {
+			return &v1beta1.JSONSchemaProps{Type: "boolean"}, true
+		},
+	}
+	def := generateDefinition(t, op, "T")
+	tests := map[string]string{
+		"handle":  `{"type":"string","pattern":"^h-"}`,
+		"named":   `{"type":"string","minLength":2,"pattern":"^h-"}`,
+		"handles": `{"type":"array","items":{"type":"string","pattern":"^h-"}}`,
+		"delay":   `{"type":"boolean"}`,
+		"count":   `{"type":"integer","format":"int64"}`,
+	}
+	for name, want := range tests {
+		if got := compactJSON(t, def.Properties[name]); got != want {
+			t.Errorf("%s is %s, want %s", name, got, want)
+		}
+	}
+	if len(seen) == 0 {
+		t.Error("the first converter wasn't given any type")
+	}
+}
+
+func TestIntOrString(t *testing.T) {
+	src := `// +groupName=example.com
+package api
+
+import "k8s.io/apimachinery/pkg/util/intstr"
+
+type Port struct {
+	value string
+}
+
+// +kubebuilder:resource:path=ts
+type T struct {
+	Port     intstr.IntOrString  ` + "`json:\"port\"`" + `
+	Optional *intstr.IntOrString ` + "`json:\"optional,omitempty\"`" + `
+	Named    Port                ` + "`json:\"named\"`" + `
+}
+`
+	const intOrString = `{"anyOf":[{"type":"string"},{"type":"integer"}],"x-kubernetes-int-or-string":true}`
+	tests := []struct {
+		name      string
+		outputCRD bool
+		props     string
+	}{
+		{name: "JSON schema", props: "#/definitions/T/properties"},
+		{name: "CRD", outputCRD: true, props: "#/spec/versions/0/schema/openAPIV3Schema/properties"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.Flatten = !tt.outputCRD
+			op.outputCRD = tt.outputCRD
+			op.KnownTypes = map[string]KnownType{"example.com/api.Port": {IntOrString: true}}
+			props := resolveRef(generateGeneric(t, op), tt.props)
+			for _, name := range []string{"port", "optional", "named"} {
+				if got := compactJSON(t, resolveRef(props, "#/"+name)); got != intOrString {
+					t.Errorf("%s is %s, want %s", name, got, intOrString)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/redborian/go-types-to-jsonschema/pkg/crd"
+)
+
+// TestLibrary uses the package like another program would, through its
+// exported API only.
+func TestLibrary(t *testing.T) {
+	op := &crd.SingleVersionGenerator{}
+	op.InputPackage = "./testdata/local"
+	op.Types = []string{"Widget"}
+	op.Flatten = true
+	schema, err := op.GenerateSchema()
+	if err != nil {
+		t.Fatalf("GenerateSchema() = %v", err)
+	}
+	if _, ok := schema.Definitions["Widget"]; !ok {
+		t.Fatalf("the definitions are %v, want Widget", schema.Definitions)
+	}
+	if warnings := crd.LintSchema(schema); len(warnings) > 0 {
+		t.Errorf("LintSchema() = %v, want no warnings", warnings)
+	}
+
+	dir := t.TempDir()
+	op.OutputPath = filepath.Join(dir, "schema.json")
+	if err := op.GenerateContext(context.Background()); err != nil {
+		t.Fatalf("GenerateContext() = %v", err)
+	}
+	instance := filepath.Join(dir, "widget.json")
+	if err := ioutil.WriteFile(instance, []byte(`{"name": "w", "size": 3}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	violations, err := crd.Validate(op.OutputPath, instance)
+	if err != nil || len(violations) > 0 {
+		t.Errorf("Validate() = %v, %v, want the widget valid", violations, err)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// Categories of the lint warnings.
+const (
+	// LintRequiredDefault is a required property with a default, which is
+	// never used since the property can't be left out.
+	LintRequiredDefault = "required-default"
+	// LintSingleValueEnum is an enum with a single value, a const is clearer.
+	LintSingleValueEnum = "single-value-enum"
+	// LintInvalidPattern is a pattern that doesn't compile.
+	LintInvalidPattern = "invalid-pattern"
+	// LintMinGreaterThanMax is a lower bound greater than its upper bound.
+	LintMinGreaterThanMax = "min-greater-than-max"
+	// LintClosedEmptyObject is an object allowing neither properties nor
+	// additional properties, only {} is valid.
+	LintClosedEmptyObject = "closed-empty-object"
+)
+
+// Warning is a likely mistake found in a schema.
+type Warning struct {
+	// Path is the JSON pointer of the schema the warning is about, e.g.
+	// "#/definitions/Foo/properties/bar".
+	Path string
+	// Category is one of the Lint constants.
+	Category string
+	// Message explains the warning.
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s (%s)", w.Path, w.Message, w.Category)
+}
+
+// LintSchema returns the likely mistakes found in schema, e.g. bounds where
+// the minimum is greater than the maximum. They are sorted by path.
+func LintSchema(schema *v1beta1.JSONSchemaProps) []Warning {
+	var warnings []Warning
+	walkDefinitionPath(schema, "#", func(path string, def *v1beta1.JSONSchemaProps) {
+		warn := func(category, format string, args ...interface{}) {
+			warnings = append(warnings, Warning{Path: path, Category: category, Message: fmt.Sprintf(format, args...)})
+		}
+
+		for _, name := range def.Required {
+			if prop, ok := def.Properties[name]; ok && prop.Default != nil {
+				warn(LintRequiredDefault, "property %q is required, its default is never used", name)
+			}
+		}
+		if len(def.Enum) == 1 {
+			warn(LintSingleValueEnum, "enum has the single value %s, consider a const", def.Enum[0].Raw)
+		}
+		// Go regular expressions are close enough to the ECMA 262 ones of
+		// JSON schema to catch most mistakes.
+		if len(def.Pattern) > 0 {
+			if _, err := regexp.Compile(def.Pattern); err != nil {
+				warn(LintInvalidPattern, "pattern %q doesn't compile: %v", def.Pattern, err)
+			}
+		}
+		for _, conflict := range boundConflicts(def) {
+			warn(LintMinGreaterThanMax, "%s", conflict)
+		}
+		if ap := def.AdditionalProperties; ap != nil && !ap.Allows && ap.Schema == nil &&
+			len(def.Properties) == 0 && len(def.PatternProperties) == 0 {
+			warn(LintClosedEmptyObject, "object has no properties and doesn't allow additional ones")
+		}
+	})
+	// The properties are visited in random order.
+	sort.SliceStable(warnings, func(i, j int) bool { return warnings[i].Path < warnings[j].Path })
+	return warnings
+}
+
+// boundConflicts returns the lower bounds of def greater than their upper
+// bound, which no value satisfies.
+func boundConflicts(def *v1beta1.JSONSchemaProps) []string {
+	var conflicts []string
+	if def.Minimum != nil && def.Maximum != nil && *def.Minimum > *def.Maximum {
+		conflicts = append(conflicts, fmt.Sprintf("minimum %v is greater than maximum %v", *def.Minimum, *def.Maximum))
+	}
+	if def.MinLength != nil && def.MaxLength != nil && *def.MinLength > *def.MaxLength {
+		conflicts = append(conflicts, fmt.Sprintf("minLength %d is greater than maxLength %d", *def.MinLength, *def.MaxLength))
+	}
+	if def.MinItems != nil && def.MaxItems != nil && *def.MinItems > *def.MaxItems {
+		conflicts = append(conflicts, fmt.Sprintf("minItems %d is greater than maxItems %d", *def.MinItems, *def.MaxItems))
+	}
+	if def.MinProperties != nil && def.MaxProperties != nil && *def.MinProperties > *def.MaxProperties {
+		conflicts = append(conflicts, fmt.Sprintf("minProperties %d is greater than maxProperties %d", *def.MinProperties, *def.MaxProperties))
+	}
+	return conflicts
+}
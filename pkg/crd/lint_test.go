@@ -0,0 +1,100 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+func TestLintSchema(t *testing.T) {
+	one, two := 1.0, 2.0
+	var short, long int64 = 1, 2
+	tests := []struct {
+		name string
+		def  v1beta1.JSONSchemaProps
+		want []string
+	}{
+		{
+			name: "clean",
+			def: v1beta1.JSONSchemaProps{
+				Type:       "object",
+				Required:   []string{"a"},
+				Properties: map[string]v1beta1.JSONSchemaProps{"a": {Type: "string", Pattern: "^a+$", MinLength: &short, MaxLength: &long}},
+			},
+		},
+		{
+			name: "required default",
+			def: v1beta1.JSONSchemaProps{
+				Type:       "object",
+				Required:   []string{"a"},
+				Properties: map[string]v1beta1.JSONSchemaProps{"a": {Type: "string", Default: &v1beta1.JSON{Raw: []byte(`"x"`)}}},
+			},
+			want: []string{LintRequiredDefault},
+		},
+		{
+			name: "single value enum",
+			def:  v1beta1.JSONSchemaProps{Type: "string", Enum: []v1beta1.JSON{{Raw: []byte(`"x"`)}}},
+			want: []string{LintSingleValueEnum},
+		},
+		{
+			name: "invalid pattern",
+			def:  v1beta1.JSONSchemaProps{Type: "string", Pattern: "(a"},
+			want: []string{LintInvalidPattern},
+		},
+		{
+			name: "minimum greater than maximum",
+			def:  v1beta1.JSONSchemaProps{Type: "number", Minimum: &two, Maximum: &one},
+			want: []string{LintMinGreaterThanMax},
+		},
+		{
+			name: "minLength greater than maxLength",
+			def:  v1beta1.JSONSchemaProps{Type: "string", MinLength: &long, MaxLength: &short},
+			want: []string{LintMinGreaterThanMax},
+		},
+		{
+			name: "minItems greater than maxItems",
+			def:  v1beta1.JSONSchemaProps{Type: "array", MinItems: &long, MaxItems: &short},
+			want: []string{LintMinGreaterThanMax},
+		},
+		{
+			name: "closed empty object",
+			def:  v1beta1.JSONSchemaProps{Type: "object", AdditionalProperties: &v1beta1.JSONSchemaPropsOrBool{Allows: false}},
+			want: []string{LintClosedEmptyObject},
+		},
+		{
+			name: "nested",
+			def: v1beta1.JSONSchemaProps{
+				Type:       "object",
+				Properties: map[string]v1beta1.JSONSchemaProps{"a": {Type: "string", Pattern: "[", Enum: []v1beta1.JSON{{Raw: []byte(`"["`)}}}},
+			},
+			want: []string{LintSingleValueEnum, LintInvalidPattern},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := &v1beta1.JSONSchemaProps{Definitions: v1beta1.JSONSchemaDefinitions{"T": tt.def}}
+			var got []string
+			for _, w := range LintSchema(schema) {
+				got = append(got, w.Category)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LintSchema() categories %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
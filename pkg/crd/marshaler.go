@@ -0,0 +1,83 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"go/ast"
+	"sort"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// marshalers tells, by definition name, if the types with a custom JSON form
+// are text marshalers. Like encoding/json, a type with a MarshalText method
+// and no MarshalJSON one is a string. The JSON form of the other ones isn't
+// known.
+type marshalers map[string]bool
+
+// collect records the types of the file having a MarshalJSON or MarshalText
+// method. The methods of a type can be declared in several files.
+func (m marshalers) collect(node *ast.File, pkgPrefix string) {
+	for _, decl := range node.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Recv == nil || len(funcDecl.Recv.List) != 1 {
+			continue
+		}
+		recv := funcDecl.Recv.List[0].Type
+		if star, ok := recv.(*ast.StarExpr); ok {
+			recv = star.X
+		}
+		ident, ok := recv.(*ast.Ident)
+		if !ok {
+			continue
+		}
+		name := getFullName(ident.Name, pkgPrefix)
+		switch funcDecl.Name.Name {
+		case "MarshalJSON":
+			m[name] = false
+		case "MarshalText":
+			if _, ok := m[name]; !ok {
+				m[name] = true
+			}
+		}
+	}
+}
+
+// useMarshalers writes the definitions of the text marshalers as strings, and
+// warns about the other types with a custom JSON form, whose schema is the one
+// of their Go type. Those can be given a schema with KnownTypes or
+// TypeConverters.
+func useMarshalers(defs v1beta1.JSONSchemaDefinitions, m marshalers, report *coverageReport) {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		def, ok := defs[name]
+		if !ok {
+			continue
+		}
+		if !m[name] {
+			report.warnf("%s has a MarshalJSON method, its schema may not match its JSON form", name)
+			continue
+		}
+		defs[name] = v1beta1.JSONSchemaProps{
+			Type:        "string",
+			Description: def.Description,
+			Title:       def.Title,
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshalers(t *testing.T) {
+	types := `package api
+
+import (
+	"encoding/json"
+	"math/big"
+	"net"
+	"time"
+)
+
+// Level is a level.
+type Level struct {
+	value int
+}
+
+type Opaque struct {
+	Value int ` + "`json:\"value\"`" + `
+}
+
+type Both struct {
+	Value int ` + "`json:\"value\"`" + `
+}
+
+type T struct {
+	Level  Level       ` + "`json:\"level\"`" + `
+	Opaque Opaque      ` + "`json:\"opaque\"`" + `
+	Both   *Both       ` + "`json:\"both\"`" + `
+	IP     net.IP      ` + "`json:\"ip\"`" + `
+	Number json.Number ` + "`json:\"number\"`" + `
+	Big    big.Int     ` + "`json:\"big\"`" + `
+	Time   time.Time   ` + "`json:\"time\"`" + `
+}
+`
+	// The methods are declared in another file than the types.
+	methods := `package api
+
+func (l Level) MarshalText() ([]byte, error) { return nil, nil }
+
+func (o *Opaque) MarshalJSON() ([]byte, error) { return nil, nil }
+
+func (b Both) MarshalText() ([]byte, error) { return nil, nil }
+
+func (b Both) MarshalJSON() ([]byte, error) { return nil, nil }
+`
+	op := testGenerator(t, map[string]string{"types.go": types, "methods.go": methods}, "T")
+	op.Flatten = true
+	schema, err := op.GenerateSchema()
+	if err != nil {
+		t.Fatalf("GenerateSchema() = %v", err)
+	}
+	defs := map[string]string{
+		"Level":  `{"description":"Level is a level.","type":"string"}`,
+		"Opaque": `{"type":"object","required":["value"],"properties":{"value":{"type":"integer","format":"int64"}}}`,
+		"Both":   `{"type":"object","required":["value"],"properties":{"value":{"type":"integer","format":"int64"}}}`,
+	}
+	for name, want := range defs {
+		if got := compactJSON(t, definition(t, schema, name)); got != want {
+			t.Errorf("%s is %s, want %s", name, got, want)
+		}
+	}
+	props := map[string]string{
+		"ip":     `{"type":"string","anyOf":[{"format":"ipv4"},{"format":"ipv6"}]}`,
+		"number": `{"type":"number"}`,
+		"big":    `{"type":"integer"}`,
+		"time":   `{"type":"string","format":"date-time"}`,
+	}
+	t0 := definition(t, schema, "T")
+	for name, want := range props {
+		if got := compactJSON(t, t0.Properties[name]); got != want {
+			t.Errorf("%s is %s, want %s", name, got, want)
+		}
+	}
+	want := []string{
+		"Both has a MarshalJSON method, its schema may not match its JSON form",
+		"Opaque has a MarshalJSON method, its schema may not match its JSON form",
+	}
+	if !reflect.DeepEqual(op.report.warnings, want) {
+		t.Errorf("warnings %q, want %q", op.report.warnings, want)
+	}
+}
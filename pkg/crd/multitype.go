@@ -0,0 +1,101 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"fmt"
+	"go/ast"
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// typesMarker replaces the schema of a field with the oneOf of the scalar
+// types listed, e.g. +schemagen:types=boolean,string for a field accepting
+// true, false or "auto". It generalizes the int-or-string of
+// intstr.IntOrString.
+const typesMarker = "schemagen:types"
+
+// typeMarker replaces the schema of a field with the one of the JSON type
+// given, e.g. +schemagen:type=string for a custom numeric type written as a
+// string to keep its precision. Unlike typesMarker, the Go type isn't walked
+// at all, the validation markers of the field still apply.
+const typeMarker = "schemagen:type"
+
+// typeOverride returns the JSON type the marker of field sets, or an empty
+// string.
+func (f *file) typeOverride(field *ast.Field) (string, error) {
+	for _, c := range f.commentMap[field] {
+		typ := Comments(strings.Split(c.Text(), "\n")).getTag(typeMarker, "=")
+		switch typ = strings.TrimSpace(typ); typ {
+		case "":
+			continue
+		case "string", "integer", "number", "boolean", "object", "array":
+			return typ, nil
+		default:
+			return "", fmt.Errorf("+%s=%s is not a JSON type", typeMarker, typ)
+		}
+	}
+	return "", nil
+}
+
+// scalarTypes returns the types listed by the marker of field, or nil.
+func (f *file) scalarTypes(field *ast.Field) ([]string, error) {
+	for _, c := range f.commentMap[field] {
+		list := Comments(strings.Split(c.Text(), "\n")).getTag(typesMarker, "=")
+		if list = strings.TrimSpace(list); list == "" {
+			continue
+		}
+		var types []string
+		seen := map[string]bool{}
+		for _, t := range strings.Split(list, ",") {
+			t = strings.TrimSpace(t)
+			switch t {
+			case "string", "integer", "number", "boolean":
+			default:
+				return nil, fmt.Errorf("+%s=%s: %q is not a scalar type", typesMarker, list, t)
+			}
+			if seen[t] {
+				return nil, fmt.Errorf("+%s=%s: %s is listed twice", typesMarker, list, t)
+			}
+			seen[t] = true
+			types = append(types, t)
+		}
+		// An integer would match both, failing the oneOf.
+		if seen["integer"] && seen["number"] {
+			return nil, fmt.Errorf("+%s=%s: an integer is a number already", typesMarker, list)
+		}
+		return types, nil
+	}
+	return nil, nil
+}
+
+// scalarUnion turns def into the oneOf of types. The description, the title,
+// the default, example and enum values are kept, the rest of what was
+// inferred from the Go type isn't.
+func scalarUnion(def *v1beta1.JSONSchemaProps, types []string) {
+	union := v1beta1.JSONSchemaProps{
+		Description: def.Description,
+		Title:       def.Title,
+		Default:     def.Default,
+		Example:     def.Example,
+		Enum:        def.Enum,
+		Nullable:    def.Nullable,
+	}
+	for _, t := range types {
+		union.OneOf = append(union.OneOf, v1beta1.JSONSchemaProps{Type: t})
+	}
+	*def = union
+}
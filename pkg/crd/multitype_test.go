@@ -0,0 +1,159 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestScalarTypesMarker(t *testing.T) {
+	tests := []struct {
+		name    string
+		marker  string
+		typ     string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "boolean or string",
+			marker: "// Auto is true, false or auto.\n\t// +schemagen:types=boolean,string\n\t// +kubebuilder:default=auto",
+			typ:    "string",
+			want:   `{"description":"Auto is true, false or auto.","default":"auto","oneOf":[{"type":"boolean"},{"type":"string"}]}`,
+		},
+		{
+			name:   "struct type",
+			marker: "// +schemagen:types=integer,string",
+			typ:    "Size",
+			want:   `{"oneOf":[{"type":"integer"},{"type":"string"}]}`,
+		},
+		{
+			name:   "spaces",
+			marker: "// +schemagen:types= number , boolean ",
+			typ:    "float64",
+			want:   `{"oneOf":[{"type":"number"},{"type":"boolean"}]}`,
+		},
+		{
+			name:    "not scalar",
+			marker:  "// +schemagen:types=boolean,object",
+			typ:     "string",
+			wantErr: `"object" is not a scalar type`,
+		},
+		{
+			name:    "unknown",
+			marker:  "// +schemagen:types=bool,string",
+			typ:     "string",
+			wantErr: `"bool" is not a scalar type`,
+		},
+		{
+			name:    "twice",
+			marker:  "// +schemagen:types=string,string",
+			typ:     "string",
+			wantErr: "string is listed twice",
+		},
+		{
+			name:    "integer and number",
+			marker:  "// +schemagen:types=integer,number",
+			typ:     "string",
+			wantErr: "an integer is a number already",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package api\n\ntype Size struct {\n\tValue int `json:\"value\"`\n}\n\n" +
+				"type T struct {\n\t" + tt.marker + "\n\tF " + tt.typ + " `json:\"f\"`\n}\n"
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			schema, err := op.GenerateSchema()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("GenerateSchema() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateSchema() = %v", err)
+			}
+			b, err := json.Marshal(definition(t, schema, "T").Properties["f"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("f is %s, want %s", b, tt.want)
+			}
+			// Size is only referred to by the replaced schema.
+			if _, ok := schema.Definitions["Size"]; ok && tt.typ == "Size" {
+				t.Errorf("Size is still defined, nothing refers to it")
+			}
+		})
+	}
+}
+
+func TestTypeMarker(t *testing.T) {
+	tests := []struct {
+		name    string
+		marker  string
+		typ     string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "numeric as string",
+			marker: "// Amount is a decimal.\n\t// +schemagen:type=string\n\t// +kubebuilder:validation:Pattern=^[0-9.]+$",
+			typ:    "Decimal",
+			want:   `{"description":"Amount is a decimal.","type":"string","pattern":"^[0-9.]+$"}`,
+		},
+		{
+			name:   "struct as object",
+			marker: "// +schemagen:type=object",
+			typ:    "Money",
+			want:   `{"type":"object"}`,
+		},
+		{
+			name:    "not a type",
+			marker:  "// +schemagen:type=float",
+			typ:     "Decimal",
+			wantErr: "+schemagen:type=float is not a JSON type",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package api\n\ntype Decimal float64\n\ntype Money struct {\n\tAmount Decimal `json:\"amount\"`\n}\n\n" +
+				"type T struct {\n\t" + tt.marker + "\n\tF " + tt.typ + " `json:\"f\"`\n}\n"
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			schema, err := op.GenerateSchema()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("GenerateSchema() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateSchema() = %v", err)
+			}
+			b, err := json.Marshal(definition(t, schema, "T").Properties["f"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("f is %s, want %s", b, tt.want)
+			}
+			// The Go type isn't walked.
+			if _, ok := schema.Definitions[tt.typ]; ok {
+				t.Errorf("%s is defined, the field doesn't refer to it", tt.typ)
+			}
+		})
+	}
+}
@@ -15,18 +15,25 @@
 package crd
 
 import (
+	"context"
 	"fmt"
 	"go/build"
 	"io/ioutil"
 	"log"
+	"os"
 	"path/filepath"
 
 	"github.com/spf13/afero"
 )
 
 type MultiVersionOptions struct {
-	// InputPackage is the path of the input package that contains source files.
+	// InputPackage is the import path of the input package that contains source
+	// files, or its directory, e.g. ./apis/v1.
 	InputPackage string
+	// InputPackages are the packages of the versions, e.g. .../v1alpha1 and
+	// .../v1, used instead of the child packages of InputPackage. The CRDs
+	// of the same kind in several of them are written as one CRD.
+	InputPackages []string
 	// Types is a list of target types.
 	Types []string
 
@@ -40,7 +47,7 @@ type MultiVersionGenerator struct {
 }
 
 func (op *MultiVersionGenerator) Generate() {
-	if len(op.InputPackage) == 0 || len(op.OutputPath) == 0 {
+	if (len(op.InputPackage) == 0 && len(op.InputPackages) == 0) || len(op.OutputPath) == 0 {
 		log.Panic("Both input path and output paths need to be set")
 	}
 
@@ -48,17 +55,30 @@ func (op *MultiVersionGenerator) Generate() {
 		op.fs = afero.NewOsFs()
 	}
 
-	op.crdSpecs = op.parse()
+	crdSpecs, err := op.parse()
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := op.transformCRDs(crdSpecs); err != nil {
+		log.Panic(err)
+	}
+	op.crdSpecs = crdSpecs
 
-	op.write(true, op.Types)
+	if err := op.write(true, nil); err != nil {
+		log.Panic(err)
+	}
 }
 
 func listDirs(path string) ([]string, error) {
-	pkg, err := build.Import(path, "", 0)
-	if err != nil {
-		return nil, err
+	dir := path
+	if !isLocalPackage(path) {
+		pkg, err := build.Import(path, "", 0)
+		if err != nil {
+			return nil, err
+		}
+		dir = pkg.Dir
 	}
-	infos, err := ioutil.ReadDir(pkg.Dir)
+	infos, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
@@ -71,33 +91,49 @@ func listDirs(path string) ([]string, error) {
 	return dirs, nil
 }
 
-func (op *MultiVersionOptions) parse() crdSpecByKind {
+// parse parses the CRD specs of every version, i.e. of every child package
+// of the input package or of the input packages, and merges them.
+func (op *MultiVersionOptions) parse() (crdSpecByKind, error) {
 	startingPointMap := make(map[string]bool)
 	for i := range op.Types {
 		startingPointMap[op.Types[i]] = true
 	}
 
-	dirs, err := listDirs(op.InputPackage)
-	if err != nil {
-		panic(err)
+	pkgs := op.InputPackages
+	if len(pkgs) == 0 {
+		dirs, err := listDirs(op.InputPackage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the versions of package %q: %v", op.InputPackage, err)
+		}
+		fmt.Fprintln(os.Stderr, dirs)
+		for _, dir := range dirs {
+			pkgs = append(pkgs, filepath.Join(op.InputPackage, dir))
+		}
 	}
-	fmt.Println(dirs)
 
 	crdSpecs := crdSpecByKind{}
-	for _, dir := range dirs {
+	for _, pkg := range pkgs {
 		singleVer := SingleVersionOptions{
 			Types:        op.Types,
-			InputPackage: filepath.Join(op.InputPackage, dir),
+			InputPackage: pkg,
 			Flatten:      false,
-			fs:           op.fs,
+			// Free-form values would be pruned by the API server otherwise.
+			EmptySchemaStyle: EmptySchemaPreserveUnknownFields,
+			fs:               op.fs,
+		}
+		_, crdSingleVersionSpecs, err := singleVer.parse(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse version %q: %v", pkg, err)
 		}
-		_, crdSingleVersionSpecs := singleVer.parse()
 		// merge crd versions
 		err = mergeCRDVersions(crdSpecs, crdSingleVersionSpecs)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
 
-	return crdSpecs
+	if err := setStorageVersions(crdSpecs); err != nil {
+		return nil, err
+	}
+	return crdSpecs, nil
 }
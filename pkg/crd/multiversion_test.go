@@ -0,0 +1,117 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMultiVersionParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		op   MultiVersionOptions
+		want string
+	}{
+		{
+			name: "missing directory",
+			op:   MultiVersionOptions{InputPackage: "/nonexistent/apis"},
+			want: `failed to list the versions of package "/nonexistent/apis"`,
+		},
+		{
+			name: "invalid version",
+			op:   MultiVersionOptions{InputPackages: []string{"example.com/api/v1", "example.com/api/v2"}},
+			want: `failed to parse version "example.com/api/v2"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := tt.op
+			op.Types = []string{"T"}
+			op.fs = testPackages(t, map[string]map[string]string{
+				"example.com/api/v1": {"types.go": "package v1\n\ntype T struct{}\n"},
+				"example.com/api/v2": {"types.go": "package v2\n\ntype T struct {\n"},
+			})
+			if _, err := op.parse(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parse() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultiVersionStorage(t *testing.T) {
+	version := func(name, markers string) string {
+		return "// +groupName=example.com\npackage " + name + "\n\n// +kubebuilder:resource:path=widgets\n" + markers + "type Widget struct {\n\tSize int `json:\"size\"`\n}\n"
+	}
+	tests := []struct {
+		name     string
+		v1alpha1 string
+		v1       string
+		storage  string
+		wantErr  string
+	}{
+		{name: "marked", v1alpha1: version("v1alpha1", ""), v1: version("v1", "// +kubebuilder:storageversion\n"), storage: "v1"},
+		{name: "crd marker", v1alpha1: version("v1alpha1", "// +kubebuilder:crd:storage=true\n"), v1: version("v1", ""), storage: "v1alpha1"},
+		{name: "none", v1alpha1: version("v1alpha1", ""), v1: version("v1", ""), wantErr: "CRD Widget has no storage version"},
+		{
+			name:     "several",
+			v1alpha1: version("v1alpha1", "// +kubebuilder:storageversion\n"),
+			v1:       version("v1", "// +kubebuilder:storageversion\n"),
+			wantErr:  "CRD Widget has several storage versions",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := MultiVersionOptions{
+				InputPackages: []string{"example.com/apis/v1alpha1", "example.com/apis/v1"},
+				Types:         []string{"Widget"},
+			}
+			op.fs = testPackages(t, map[string]map[string]string{
+				"example.com/apis/v1alpha1": {"types.go": tt.v1alpha1},
+				"example.com/apis/v1":       {"types.go": tt.v1},
+			})
+			specs, err := op.parse()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parse() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse() = %v", err)
+			}
+			if len(specs) != 1 {
+				t.Fatalf("%d CRDs, want a single Widget one", len(specs))
+			}
+			for _, spec := range specs {
+				var names, storage []string
+				for _, v := range spec.Versions {
+					names = append(names, v.Name)
+					if v.Storage {
+						storage = append(storage, v.Name)
+					}
+				}
+				sort.Strings(names)
+				if strings.Join(names, ",") != "v1,v1alpha1" {
+					t.Errorf("versions %v, want v1 and v1alpha1", names)
+				}
+				if len(storage) != 1 || storage[0] != tt.storage {
+					t.Errorf("storage versions %v, want %s", storage, tt.storage)
+				}
+			}
+		})
+	}
+}
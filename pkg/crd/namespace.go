@@ -0,0 +1,103 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// namespacedSchema is a schema whose definitions are grouped by package.
+type namespacedSchema struct {
+	v1beta1.JSONSchemaProps
+	// Definitions holds the definitions of the root package directly, and one
+	// nested object of definitions per other package, keyed by package prefix.
+	Definitions map[string]interface{} `json:"definitions,omitempty"`
+}
+
+// splitFullName splits a definition name into the package prefix and the
+// type name. It is the reverse of getFullName: the type name has no dots,
+// but the type arguments of an instantiation can, e.g.
+// example.com.api.List[example.com.other.Foo].
+func splitFullName(name string) (string, string) {
+	typeStart := len(name)
+	if i := strings.Index(name, "["); i >= 0 {
+		typeStart = i
+	}
+	i := strings.LastIndex(name[:typeStart], ".")
+	if i < 0 {
+		return "", name
+	}
+	return name[:i], name[i+1:]
+}
+
+// namespacedRef turns "#/definitions/pkg.prefix.Type" into
+// "#/definitions/pkg.prefix/Type".
+func namespacedRef(ref string) string {
+	if !strings.HasPrefix(ref, defPrefix) {
+		return ref
+	}
+	prefix, typeName := splitFullName(strings.TrimPrefix(ref, defPrefix))
+	if prefix == "" {
+		return ref
+	}
+	return defPrefix + prefix + "/" + typeName
+}
+
+// namespaceDefinitions nests the definitions of a copy of schema by package
+// and points all the refs at their new location. It returns an error if the
+// name of a definition of the root package is the prefix of a package.
+func namespaceDefinitions(schema *v1beta1.JSONSchemaProps) (*namespacedSchema, error) {
+	schema = schema.DeepCopy()
+	rewriteRef := func(def *v1beta1.JSONSchemaProps) {
+		if def.Ref != nil {
+			ref := namespacedRef(*def.Ref)
+			def.Ref = &ref
+		}
+	}
+
+	defs := schema.Definitions
+	schema.Definitions = nil
+	walkDefinition(schema, rewriteRef)
+
+	ns := &namespacedSchema{
+		JSONSchemaProps: *schema,
+		Definitions:     map[string]interface{}{},
+	}
+	for name := range defs {
+		def := defs[name]
+		walkDefinition(&def, rewriteRef)
+
+		prefix, typeName := splitFullName(name)
+		if prefix == "" {
+			if _, ok := ns.Definitions[name]; ok {
+				return nil, fmt.Errorf("definition %q collides with the namespace of a package", name)
+			}
+			ns.Definitions[name] = def
+			continue
+		}
+		if _, ok := ns.Definitions[prefix]; !ok {
+			ns.Definitions[prefix] = v1beta1.JSONSchemaDefinitions{}
+		}
+		pkgDefs, ok := ns.Definitions[prefix].(v1beta1.JSONSchemaDefinitions)
+		if !ok {
+			return nil, fmt.Errorf("definition %q collides with the namespace of a package", prefix)
+		}
+		pkgDefs[typeName] = def
+	}
+	return ns, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+func TestSplitFullName(t *testing.T) {
+	tests := []struct {
+		name, prefix, typeName string
+	}{
+		{name: "Foo", typeName: "Foo"},
+		{name: "example.com.api.Foo", prefix: "example.com.api", typeName: "Foo"},
+		{name: "List[example.com.other.Foo]", typeName: "List[example.com.other.Foo]"},
+		{name: "example.com.api.Map[string,example.com.other.Foo]", prefix: "example.com.api", typeName: "Map[string,example.com.other.Foo]"},
+	}
+	for _, tt := range tests {
+		prefix, typeName := splitFullName(tt.name)
+		if prefix != tt.prefix || typeName != tt.typeName {
+			t.Errorf("splitFullName(%q) = %q, %q, want %q, %q", tt.name, prefix, typeName, tt.prefix, tt.typeName)
+		}
+	}
+}
+
+func TestNamespacedRef(t *testing.T) {
+	tests := map[string]string{
+		"#/definitions/Foo":                   "#/definitions/Foo",
+		"#/definitions/example.com.other.Foo": "#/definitions/example.com.other/Foo",
+		"https://example.com/foo.json":        "https://example.com/foo.json",
+	}
+	for ref, want := range tests {
+		if got := namespacedRef(ref); got != want {
+			t.Errorf("namespacedRef(%q) = %q, want %q", ref, got, want)
+		}
+	}
+}
+
+func TestNamespaceDefinitionsCopy(t *testing.T) {
+	ref := "#/definitions/example.com.other.O"
+	schema := &v1beta1.JSONSchemaProps{Definitions: v1beta1.JSONSchemaDefinitions{
+		"T":                   {Properties: map[string]v1beta1.JSONSchemaProps{"o": {Ref: &ref}}},
+		"example.com.other.O": {Type: "object"},
+	}}
+	ns, err := namespaceDefinitions(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ns.Definitions["T"].(v1beta1.JSONSchemaProps).Properties["o"].Ref; *got != "#/definitions/example.com.other/O" {
+		t.Errorf("T.o refers to %q in the namespaced schema", *got)
+	}
+	if got := schema.Definitions["T"].Properties["o"].Ref; *got != ref {
+		t.Errorf("T.o refers to %q in the schema, want it unchanged", *got)
+	}
+}
+
+func TestNamespaceCollision(t *testing.T) {
+	schema := &v1beta1.JSONSchemaProps{Definitions: v1beta1.JSONSchemaDefinitions{
+		"api":     {Type: "object"},
+		"api.Foo": {Type: "object"},
+	}}
+	if _, err := namespaceDefinitions(schema); err == nil {
+		t.Error("the definition api and the namespace of the package api were both kept")
+	}
+}
+
+// resolveRef returns the value the local ref points at in doc, nil if there
+// is none.
+func resolveRef(doc interface{}, ref string) interface{} {
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			doc = d[token]
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(d) {
+				return nil
+			}
+			doc = d[i]
+		default:
+			return nil
+		}
+	}
+	return doc
+}
+
+func TestNamespaceDefinitions(t *testing.T) {
+	op := &SingleVersionGenerator{}
+	op.InputPackage = "example.com/api"
+	op.Types = []string{"T"}
+	op.Flatten = true
+	op.NamespaceDefinitions = true
+	op.fs = testPackages(t, map[string]map[string]string{
+		"example.com/api":   {"types.go": "package api\n\nimport \"example.com/other\"\n\ntype T struct {\n\tO other.O `json:\"o\"`\n}\n"},
+		"example.com/other": {"types.go": "package other\n\ntype O struct {\n\tName string `json:\"name\"`\n}\n"},
+	})
+	var doc interface{}
+	if err := json.Unmarshal([]byte(generateOutput(t, op)), &doc); err != nil {
+		t.Fatal(err)
+	}
+	ref, _ := resolveRef(doc, "#/definitions/T/properties/o/$ref").(string)
+	if ref != "#/definitions/example.com.other/O" {
+		t.Fatalf("T.o refers to %q", ref)
+	}
+	o, ok := resolveRef(doc, ref).(map[string]interface{})
+	if !ok || resolveRef(o, "#/properties/name/type") != "string" {
+		t.Errorf("%s resolves to %v, want O", ref, o)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// Naming strategies of the definitions, see NamingStrategy.
+const (
+	// NamingFull names the definitions of the other packages after their
+	// import path, e.g. "k8s.io.api.core.v1.PodSpec".
+	NamingFull = "full"
+	// NamingPackage names them after their package, e.g. "v1.PodSpec".
+	NamingPackage = "package"
+	// NamingShort names them after their type alone, e.g. "PodSpec".
+	NamingShort = "short"
+)
+
+// checkNamingStrategy returns an error for an unknown strategy.
+func checkNamingStrategy(strategy string) error {
+	switch strategy {
+	case "", NamingFull, NamingPackage, NamingShort:
+		return nil
+	}
+	return fmt.Errorf("unknown naming strategy %q, must be one of %q, %q or %q",
+		strategy, NamingFull, NamingPackage, NamingShort)
+}
+
+// definitionName returns the name of the definition named fullName, e.g.
+// "k8s.io.api.core.v1.PodSpec", under strategy. The generic type and the
+// type arguments of an instantiation, e.g. "List[k8s.io.api.core.v1.Pod]",
+// are named on their own.
+func definitionName(fullName, strategy string) string {
+	switch {
+	case strings.HasPrefix(fullName, "[]"):
+		return "[]" + definitionName(strings.TrimPrefix(fullName, "[]"), strategy)
+	case strings.HasPrefix(fullName, "map["):
+		key, value := splitTypeArgs(strings.TrimPrefix(fullName, "map"))
+		return "map[" + definitionName(strings.Join(key, ","), strategy) + "]" + definitionName(value, strategy)
+	case strings.HasSuffix(fullName, "]"):
+		i := strings.Index(fullName, "[")
+		args, _ := splitTypeArgs(fullName[i:])
+		for j := range args {
+			args[j] = definitionName(args[j], strategy)
+		}
+		return definitionName(fullName[:i], strategy) + "[" + strings.Join(args, ",") + "]"
+	}
+	prefix, typeName := splitFullName(fullName)
+	switch {
+	case prefix == "":
+		return typeName
+	case strategy == NamingShort:
+		return typeName
+	case strategy == NamingPackage:
+		_, pkgName := splitFullName(prefix)
+		return pkgName + "." + typeName
+	}
+	return fullName
+}
+
+// renameDefinitions renames the definitions of schema under strategy, and
+// points the refs and keywords at their new names. Definitions given the
+// same name are an error.
+func renameDefinitions(schema *v1beta1.JSONSchemaProps, keywords definitionKeywords, strategy string) error {
+	if strategy == "" || strategy == NamingFull || len(schema.Definitions) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(schema.Definitions))
+	for name := range schema.Definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	renamed := map[string]string{}
+	owners := map[string]string{}
+	for _, name := range names {
+		newName := definitionName(name, strategy)
+		if owner, ok := owners[newName]; ok {
+			return fmt.Errorf("definitions %s and %s are both named %s by the %s naming strategy", owner, name, newName, strategy)
+		}
+		owners[newName] = name
+		renamed[name] = newName
+	}
+
+	walkDefinition(schema, func(def *v1beta1.JSONSchemaProps) {
+		if def.Ref == nil || !strings.HasPrefix(*def.Ref, defPrefix) {
+			return
+		}
+		if newName, ok := renamed[strings.TrimPrefix(*def.Ref, defPrefix)]; ok {
+			def.Ref = getDefLink(newName)
+		}
+	})
+	defs := v1beta1.JSONSchemaDefinitions{}
+	for name, def := range schema.Definitions {
+		defs[renamed[name]] = def
+	}
+	schema.Definitions = defs
+	old := map[string]map[string]interface{}{}
+	for name, kws := range keywords {
+		if _, ok := renamed[name]; ok {
+			old[name] = kws
+			delete(keywords, name)
+		}
+	}
+	for name, kws := range old {
+		keywords[renamed[name]] = kws
+	}
+	return nil
+}
+
+// splitTypeArgs splits the type arguments in brackets at the start of s, e.g.
+// "[a,List[b,c]]d" into "a" and "List[b,c]", and returns what follows them.
+func splitTypeArgs(s string) ([]string, string) {
+	var args []string
+	depth, start := 0, 1
+	for i, c := range s {
+		switch c {
+		case '[':
+			depth++
+		case ',':
+			if depth == 1 {
+				args = append(args, s[start:i])
+				start = i + 1
+			}
+		case ']':
+			depth--
+			if depth == 0 {
+				return append(args, s[start:i]), s[i+1:]
+			}
+		}
+	}
+	return append(args, s[start:]), ""
+}
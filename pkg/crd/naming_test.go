@@ -0,0 +1,124 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDefinitionName(t *testing.T) {
+	tests := []struct {
+		fullName string
+		strategy string
+		want     string
+	}{
+		{"Foo", NamingShort, "Foo"},
+		{"k8s.io.api.core.v1.PodSpec", NamingFull, "k8s.io.api.core.v1.PodSpec"},
+		{"k8s.io.api.core.v1.PodSpec", NamingPackage, "v1.PodSpec"},
+		{"k8s.io.api.core.v1.PodSpec", NamingShort, "PodSpec"},
+		{"List[k8s.io.api.core.v1.Pod]", NamingPackage, "List[v1.Pod]"},
+		{"Pair[k8s.io.api.core.v1.Pod,example.com.other.Node]", NamingShort, "Pair[Pod,Node]"},
+		{"List[[]k8s.io.api.core.v1.Pod]", NamingShort, "List[[]Pod]"},
+		{"List[map[string]k8s.io.api.core.v1.Pod]", NamingPackage, "List[map[string]v1.Pod]"},
+	}
+	for _, tt := range tests {
+		if got := definitionName(tt.fullName, tt.strategy); got != tt.want {
+			t.Errorf("definitionName(%q, %q) = %q, want %q", tt.fullName, tt.strategy, got, tt.want)
+		}
+	}
+}
+
+func TestNamingStrategy(t *testing.T) {
+	packages := func(other string) map[string]map[string]string {
+		return map[string]map[string]string{
+			"example.com/api": {"types.go": `package api
+
+import (
+	core "example.com/core/v1"
+	other "example.com/` + other + `"
+)
+
+type T struct {
+	Pod   core.PodSpec  ` + "`json:\"pod\"`" + `
+	Other other.PodSpec ` + "`json:\"other\"`" + `
+}
+`},
+			"example.com/core/v1":  {"types.go": "package v1\n\ntype PodSpec struct {\n\tImage string `json:\"image\"`\n}\n"},
+			"example.com/" + other: {"types.go": "package " + other[strings.LastIndex(other, "/")+1:] + "\n\ntype PodSpec struct {\n\tName string `json:\"name\"`\n}\n"},
+		}
+	}
+	tests := []struct {
+		name     string
+		strategy string
+		other    string
+		want     string
+		wantErr  string
+	}{
+		{name: "default", other: "other/v2", want: "T,example.com.core.v1.PodSpec,example.com.other.v2.PodSpec"},
+		{name: "full", strategy: NamingFull, other: "other/v1", want: "T,example.com.core.v1.PodSpec,example.com.other.v1.PodSpec"},
+		{name: "package", strategy: NamingPackage, other: "other/v2", want: "T,v1.PodSpec,v2.PodSpec"},
+		{
+			name:     "package collision",
+			strategy: NamingPackage,
+			other:    "other/v1",
+			wantErr:  "definitions example.com.core.v1.PodSpec and example.com.other.v1.PodSpec are both named v1.PodSpec by the package naming strategy",
+		},
+		{
+			name:     "short collision",
+			strategy: NamingShort,
+			other:    "other/v2",
+			wantErr:  "are both named PodSpec by the short naming strategy",
+		},
+		{name: "unknown", strategy: "camel", other: "other/v2", wantErr: `unknown naming strategy "camel"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := &SingleVersionGenerator{}
+			op.InputPackage = "example.com/api"
+			op.Types = []string{"T"}
+			op.Flatten = true
+			op.NamingStrategy = tt.strategy
+			op.fs = testPackages(t, packages(tt.other))
+			schema, err := op.GenerateSchema()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("GenerateSchema() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateSchema() = %v", err)
+			}
+			var names []string
+			for name := range schema.Definitions {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if got := strings.Join(names, ","); got != tt.want {
+				t.Errorf("the definitions are %s, want %s", got, tt.want)
+			}
+			// The refs follow the definitions.
+			for key, prop := range definition(t, schema, "T").Properties {
+				if prop.Ref == nil {
+					t.Errorf("%s isn't a ref", key)
+					continue
+				}
+				definition(t, schema, getNameFromURL(*prop.Ref))
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"go/ast"
+	"strings"
+)
+
+// nowarnMarker suppresses the lint warnings of the given categories on a type
+// or a field, e.g. +schemagen:nowarn=single-value-enum,required-default.
+const nowarnMarker = "schemagen:nowarn"
+
+// suppressions holds the lint categories suppressed by +schemagen:nowarn, by
+// JSON pointer of the schema of the type or field the marker is on, e.g.
+// "#/definitions/Foo/properties/bar". A suppression covers the schemas nested
+// in that one too. The pointers are the ones of the definitions, so in an
+// embedded schema only the suppressions on the requested types apply to the
+// types embedded in them.
+type suppressions map[string]map[string]bool
+
+// add suppresses the comma-separated categories at path.
+func (s suppressions) add(path, categories string) {
+	for _, category := range strings.Split(categories, ",") {
+		category = strings.TrimSpace(category)
+		if category == "" {
+			continue
+		}
+		if s[path] == nil {
+			s[path] = map[string]bool{}
+		}
+		s[path][category] = true
+	}
+}
+
+// suppressed tells if w is covered by a suppression of its category.
+func (s suppressions) suppressed(w Warning) bool {
+	for path, categories := range s {
+		if categories[w.Category] && (w.Path == path || strings.HasPrefix(w.Path, path+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectSuppressions adds the suppressions of the fields of the struct
+// described by expr, whose schema is at path. The fields of the anonymous
+// structs nested in it are visited too.
+func (f *file) collectSuppressions(expr ast.Expr, path string, s suppressions) {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		f.collectSuppressions(t.X, path, s)
+	case *ast.ArrayType:
+		f.collectSuppressions(t.Elt, path+"/items", s)
+	case *ast.MapType:
+		f.collectSuppressions(t.Value, path+"/additionalProperties", s)
+	case *ast.StructType:
+		for _, field := range t.Fields.List {
+			key, inline, ok := fieldKey(field, parseFieldTag(field.Tag))
+			if !ok {
+				continue
+			}
+			// The fields of an inline struct are properties of this one.
+			if inline {
+				f.collectSuppressions(field.Type, path, s)
+				continue
+			}
+			fieldPath := path + "/properties/" + key
+			for _, c := range f.commentMap[field] {
+				s.add(fieldPath, Comments(strings.Split(c.Text(), "\n")).getTag(nowarnMarker, "="))
+			}
+			f.collectSuppressions(field.Type, fieldPath, s)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestNowarn(t *testing.T) {
+	src := `package api
+
+// +kubebuilder:validation:Enum=a
+// +schemagen:nowarn=single-value-enum
+type Mode string
+
+type T struct {
+	// +kubebuilder:validation:Enum=x
+	// +schemagen:nowarn=single-value-enum
+	A string ` + "`json:\"a\"`" + `
+	// +kubebuilder:validation:Enum=y
+	B string ` + "`json:\"b\"`" + `
+	// +kubebuilder:validation:Pattern=(
+	// +schemagen:nowarn=single-value-enum
+	C string ` + "`json:\"c\"`" + `
+	// +schemagen:nowarn=invalid-pattern
+	D struct {
+		// +kubebuilder:validation:Pattern=[
+		E string ` + "`json:\"e\"`" + `
+	} ` + "`json:\"d\"`" + `
+	M Mode ` + "`json:\"m\"`" + `
+}
+`
+	op := testGenerator(t, map[string]string{"types.go": src}, "T")
+	op.Flatten = true
+	op.Lint = true
+	if _, err := op.GenerateSchema(); err != nil {
+		t.Fatal(err)
+	}
+	want := []Warning{
+		{Path: "#/definitions/T/properties/b", Category: LintSingleValueEnum},
+		{Path: "#/definitions/T/properties/c", Category: LintInvalidPattern},
+	}
+	warnings := append([]string{}, op.report.warnings...)
+	sort.Strings(warnings)
+	if len(warnings) != len(want) {
+		t.Fatalf("warnings %q, want %d", warnings, len(want))
+	}
+	for i, w := range want {
+		if !strings.HasPrefix(warnings[i], w.Path+": ") || !strings.HasSuffix(warnings[i], "("+w.Category+")") {
+			t.Errorf("warning %q, want a %s one at %s", warnings[i], w.Category, w.Path)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+// nullAnnotations are the keywords kept next to the anyOf of a nullable
+// schema wrapped by nullableTypes.
+var nullAnnotations = []string{"description", "title", "default"}
+
+// nullableTypes replaces the nullable of OpenAPI, which JSON schema doesn't
+// know, in the generic JSON form of a schema. null is added to the type, e.g.
+// ["string","null"], and to the enum. A schema without type restricting the
+// values, e.g. a ref, becomes the anyOf of itself and {"type":"null"}. groups
+// are the entries of the definitions holding the definitions of a package,
+// see NamespaceDefinitions.
+func nullableTypes(generic map[string]interface{}, groups map[string]bool) {
+	walkGeneric(generic, groups, func(schema map[string]interface{}) {
+		nullable, _ := schema["nullable"].(bool)
+		delete(schema, "nullable")
+		if !nullable {
+			return
+		}
+		if typ, ok := schema["type"].(string); ok {
+			schema["type"] = []interface{}{typ, "null"}
+			if enum, ok := schema["enum"].([]interface{}); ok {
+				schema["enum"] = append(enum, nil)
+			}
+			return
+		}
+		if !restrictsNull(schema) {
+			return
+		}
+		value := map[string]interface{}{}
+		for key, v := range schema {
+			value[key] = v
+			delete(schema, key)
+		}
+		for _, key := range nullAnnotations {
+			if v, ok := value[key]; ok {
+				schema[key] = v
+				delete(value, key)
+			}
+		}
+		schema["anyOf"] = []interface{}{value, map[string]interface{}{"type": "null"}}
+	})
+}
+
+// restrictsNull tells if a schema without type may reject null.
+func restrictsNull(schema map[string]interface{}) bool {
+	for _, key := range []string{"$ref", "enum", "allOf", "anyOf", "oneOf", "not"} {
+		if _, ok := schema[key]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import "testing"
+
+func TestNullableTypes(t *testing.T) {
+	src := `// +groupName=example.com
+package api
+
+type U struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+
+// +kubebuilder:resource:path=ts
+type T struct {
+	Value    string  ` + "`json:\"value\"`" + `
+	Optional *string ` + "`json:\"optional\"`" + `
+	// Mode is a mode.
+	// +kubebuilder:validation:Enum=a;b
+	Mode *string ` + "`json:\"mode\"`" + `
+	U    *U      ` + "`json:\"u\"`" + `
+}
+`
+	tests := []struct {
+		name      string
+		format    string
+		outputCRD bool
+		props     string
+		want      map[string]string
+	}{
+		{
+			name:  "JSON schema",
+			props: "#/definitions/T/properties",
+			want: map[string]string{
+				"value":    `{"type":"string"}`,
+				"optional": `{"type":["string","null"]}`,
+				"mode":     `{"description":"Mode is a mode.","enum":["a","b",null],"type":["string","null"]}`,
+				"u":        `{"anyOf":[{"$ref":"#/definitions/U"},{"type":"null"}]}`,
+			},
+		},
+		{
+			name:   "OpenAPI 3",
+			format: openAPI3Format,
+			props:  "#/components/schemas/T/properties",
+			want: map[string]string{
+				"value":    `{"type":"string"}`,
+				"optional": `{"nullable":true,"type":"string"}`,
+				"mode":     `{"description":"Mode is a mode.","enum":["a","b"],"nullable":true,"type":"string"}`,
+				"u":        `{"$ref":"#/components/schemas/U","nullable":true}`,
+			},
+		},
+		{
+			name:      "CRD",
+			outputCRD: true,
+			props:     "#/spec/versions/0/schema/openAPIV3Schema/properties",
+			want: map[string]string{
+				"value":    `{"type":"string"}`,
+				"optional": `{"nullable":true,"type":"string"}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.Flatten = !tt.outputCRD
+			op.NullablePointers = true
+			op.OutputFormat = tt.format
+			op.outputCRD = tt.outputCRD
+			props := resolveRef(generateGeneric(t, op), tt.props)
+			for name, want := range tt.want {
+				if got := compactJSON(t, resolveRef(props, "#/"+name)); got != want {
+					t.Errorf("%s is %s, want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
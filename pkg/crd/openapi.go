@@ -0,0 +1,72 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import "fmt"
+
+const (
+	// openAPI3Format is the output format writing an OpenAPI 3 document.
+	openAPI3Format = "openapi3"
+	// openAPIVersion is the version of the OpenAPI documents written.
+	openAPIVersion = "3.0.0"
+)
+
+// checkOpenAPI returns an error for the options whose output can't be part of
+// an OpenAPI 3 document.
+func checkOpenAPI(op *SingleVersionOptions, wop *WriterOptions) error {
+	if wop.NamespaceDefinitions {
+		return fmt.Errorf("definitions can't be namespaced in format %q, component schemas can't be nested", openAPI3Format)
+	}
+	if schemaVersionAtLeast(wop.SchemaVersion, SchemaVersionDraft06) {
+		return fmt.Errorf("schema version %q can't be used in format %q, OpenAPI 3 schemas are draft-04 like", wop.SchemaVersion, openAPI3Format)
+	}
+	if op.EmptySchemaStyle == EmptySchemaTrue {
+		return fmt.Errorf("empty schema style %q can't be used in format %q, OpenAPI 3 has no boolean schemas", EmptySchemaTrue, openAPI3Format)
+	}
+	if op.Inline {
+		return fmt.Errorf("the types can't be inlined in format %q, the document only holds definitions", openAPI3Format)
+	}
+	if len(wop.DefinitionRefPrefix) > 0 {
+		return fmt.Errorf("a definition ref prefix can't be used in format %q, the refs point at the component schemas", openAPI3Format)
+	}
+	if len(wop.RefBaseURI) > 0 {
+		return fmt.Errorf("a ref base URI can't be used in format %q, the refs point at the component schemas", openAPI3Format)
+	}
+	return nil
+}
+
+// openAPIDocument returns the OpenAPI 3 document holding the definitions of
+// the generic JSON form of a schema as its component schemas, with the refs
+// pointed at them. The root schema isn't part of the document. With
+// canonical, the keys of the schemas are in the canonical order, see
+// CanonicalKeyOrder.
+func openAPIDocument(generic map[string]interface{}, canonical bool) map[string]interface{} {
+	rebaseRefs(generic, nil, componentsPrefix)
+	defs, _ := generic["definitions"].(map[string]interface{})
+	if defs == nil {
+		defs = map[string]interface{}{}
+	}
+	if canonical {
+		for name, def := range defs {
+			defs[name] = canonicalValue(def)
+		}
+	}
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"components": map[string]interface{}{
+			"schemas": defs,
+		},
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenAPI3Golden(t *testing.T) {
+	src := `package api
+
+// Widget is a widget.
+type Widget struct {
+	Name  string ` + "`json:\"name\"`" + `
+	Parts []Part ` + "`json:\"parts,omitempty\"`" + `
+}
+
+// Part is a part of a widget.
+type Part struct {
+	Size int ` + "`json:\"size\"`" + `
+}
+`
+	op := testGenerator(t, map[string]string{"types.go": src}, "Widget")
+	op.Flatten = true
+	op.OutputFormat = openAPI3Format
+	got := generateOutput(t, op)
+	golden := filepath.Join("testdata", "openapi3.json")
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("the output differs from %s:\n%s", golden, got)
+	}
+}
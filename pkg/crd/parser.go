@@ -17,9 +17,11 @@ limitations under the License.
 package crd
 
 import (
+	"encoding/json"
 	"fmt"
 	"go/ast"
 	"log"
+	"math"
 	"strconv"
 	"strings"
 
@@ -27,11 +29,16 @@ import (
 )
 
 // filterDescription parse comments above each field in the type definition.
+// The lines of the comment are joined with spaces, blank lines and marker
+// lines are dropped.
 func filterDescription(res string) string {
 	var temp strings.Builder
 	var desc string
 	for _, comment := range strings.Split(res, "\n") {
 		comment = strings.Trim(comment, " ")
+		if len(comment) == 0 {
+			continue
+		}
 		if !(strings.Contains(comment, "+kubebuilder") || strings.HasPrefix(comment, "+")) {
 			temp.WriteString(comment)
 			temp.WriteString(" ")
@@ -41,66 +48,360 @@ func filterDescription(res string) string {
 	return desc
 }
 
-func processMarkersInComments(def *v1beta1.JSONSchemaProps, commentGroups ...*ast.CommentGroup) {
+// processMarkersInComments sets the default, example and validation of def
+// from the markers in the comments. It returns an error for the malformed
+// markers and the ones no value satisfies, e.g. a MultipleOf of 0.
+func processMarkersInComments(def *v1beta1.JSONSchemaProps, report *coverageReport, commentGroups ...*ast.CommentGroup) error {
 	for _, commentGroup := range commentGroups {
 		for _, comment := range strings.Split(commentGroup.Text(), "\n") {
-			getValidation(comment, def)
+			if strings.TrimSpace(comment) == listTypeSetMarker && def.Type != "array" {
+				log.Printf("Ignoring %s, it only applies to arrays", comment)
+				continue
+			}
+			value, isDefault := defaultMarkerValue(comment)
+			example, isExample := markerValue(comment, exampleMarker)
+			byteLength, isByteLength := markerValue(comment, byteLengthMarker)
+			var err error
+			switch {
+			case isDefault:
+				def.Default, err = defaultValue(value, def.Type)
+			case isExample:
+				def.Example, err = markerJSON("example", example, def.Type)
+			case isByteLength:
+				err = setByteLength(def, byteLength)
+			default:
+				err = getValidation(comment, def, report)
+			}
+			if err != nil {
+				return err
+			}
 		}
 	}
+	return nil
+}
+
+// checkBounds returns an error if the markers of def, or of the schemas
+// nested in it, e.g. its items, set bounds no value satisfies.
+func checkBounds(def *v1beta1.JSONSchemaProps) error {
+	var conflicts []string
+	walkDefinition(def, func(def *v1beta1.JSONSchemaProps) {
+		conflicts = append(conflicts, boundConflicts(def)...)
+	})
+	if len(conflicts) > 0 {
+		return fmt.Errorf("conflicting markers, %s", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// defaultMarker sets the default value of a field, e.g.
+// +kubebuilder:default=3 or +kubebuilder:default={"name":"foo"}.
+const defaultMarker = "+kubebuilder:default="
+
+// exampleMarker sets the example of a field, written like its default value,
+// e.g. +kubebuilder:example=3 or +kubebuilder:example={"name":"foo"}. It is
+// example in a CRD and an OpenAPI 3 document, and examples in a JSON schema.
+const exampleMarker = "+kubebuilder:example="
+
+// byteLengthMarker bounds the length of a string in bytes of its UTF-8
+// encoding, e.g. +schema:validation:ByteLength=64, where the MaxLength marker
+// counts characters like JSON schema. See maxByteLength.
+const byteLengthMarker = "+schema:validation:ByteLength="
+
+// defaultMarkerValue returns the value of the default marker in comment.
+func defaultMarkerValue(comment string) (string, bool) {
+	return markerValue(comment, defaultMarker)
+}
+
+// markerValue returns the value of the marker in comment, e.g. of
+// defaultMarker.
+func markerValue(comment, marker string) (string, bool) {
+	comment = strings.TrimSpace(comment)
+	if !strings.HasPrefix(comment, marker) {
+		return "", false
+	}
+	return strings.TrimPrefix(comment, marker), true
+}
+
+// defaultValue converts the value of a default marker to the JSON value of a
+// schema of type typ, see markerJSON.
+func defaultValue(value, typ string) (*v1beta1.JSON, error) {
+	return markerJSON("default", value, typ)
+}
+
+// markerJSON converts the value of the marker named name, e.g. default, to
+// the JSON value of a schema of type typ. A string doesn't need to be quoted,
+// other values are JSON. The value of a ref, whose type isn't known here, is
+// a string when it isn't JSON. The error of a value that doesn't match typ
+// quotes the marker, with where the JSON is malformed if it is.
+func markerJSON(name, value, typ string) (*v1beta1.JSON, error) {
+	var v interface{}
+	err := json.Unmarshal([]byte(value), &v)
+	switch typ {
+	case "string":
+		if _, ok := v.(string); err != nil || !ok {
+			v, err = value, nil
+		}
+	case "integer":
+		if f, ok := v.(float64); err == nil && (!ok || f != float64(int64(f))) {
+			err = fmt.Errorf("not an integer")
+		}
+	case "number":
+		if _, ok := v.(float64); err == nil && !ok {
+			err = fmt.Errorf("not a number")
+		}
+	case "boolean":
+		if _, ok := v.(bool); err == nil && !ok {
+			err = fmt.Errorf("not a boolean")
+		}
+	case "object":
+		if _, ok := v.(map[string]interface{}); err == nil && !ok {
+			err = fmt.Errorf("not an object")
+		}
+	case "array":
+		if _, ok := v.([]interface{}); err == nil && !ok {
+			err = fmt.Errorf("not an array")
+		}
+	case "":
+		if err != nil {
+			v, err = value, nil
+		}
+	}
+	if syntaxErr, ok := err.(*json.SyntaxError); ok {
+		err = fmt.Errorf("%v, at offset %d of the value", err, syntaxErr.Offset)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value for a field of %s type in +kubebuilder:%s=%s: %v", name, typ, name, value, err)
+	}
+	raw, err := json.Marshal(v)
+	return &v1beta1.JSON{Raw: raw}, err
+}
+
+// arrayMarkers are the validation markers of an array itself rather than of
+// its items. The default and example markers are ones too.
+var arrayMarkers = map[string]bool{
+	"MaxItems":    true,
+	"MinItems":    true,
+	"UniqueItems": true,
+}
+
+// listTypeSetMarker marks a slice holding a set, its items are unique.
+const listTypeSetMarker = "+listType=set"
+
+// isArrayMarker tells if comment is a marker of an array rather than of its
+// items, see arrayMarkers.
+func isArrayMarker(comment string) bool {
+	comment = strings.TrimSpace(comment)
+	if comment == listTypeSetMarker || strings.HasPrefix(comment, defaultMarker) || strings.HasPrefix(comment, exampleMarker) {
+		return true
+	}
+	if !strings.HasPrefix(comment, "+kubebuilder:validation:") {
+		return false
+	}
+	name := strings.SplitN(strings.TrimPrefix(comment, "+kubebuilder:validation:"), "=", 2)[0]
+	return arrayMarkers[name]
+}
+
+// processArrayMarkersInComments sets the validation of an array from the
+// markers of the array itself, see isArrayMarker.
+func processArrayMarkersInComments(def *v1beta1.JSONSchemaProps, report *coverageReport, commentGroups ...*ast.CommentGroup) error {
+	for _, commentGroup := range commentGroups {
+		for _, comment := range strings.Split(commentGroup.Text(), "\n") {
+			value, isDefault := defaultMarkerValue(comment)
+			example, isExample := markerValue(comment, exampleMarker)
+			var err error
+			switch {
+			case isDefault:
+				def.Default, err = defaultValue(value, def.Type)
+			case isExample:
+				def.Example, err = markerJSON("example", example, def.Type)
+			case strings.TrimSpace(comment) == listTypeSetMarker:
+				def.UniqueItems = true
+			case isArrayMarker(comment):
+				err = getValidation(comment, def, report)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// itemComments returns the comments without the markers of the array itself,
+// which don't apply to its items.
+func itemComments(commentGroups []*ast.CommentGroup) []*ast.CommentGroup {
+	var groups []*ast.CommentGroup
+	for _, commentGroup := range commentGroups {
+		items := &ast.CommentGroup{}
+		for _, c := range commentGroup.List {
+			if !isArrayMarker(strings.TrimPrefix(c.Text, "//")) {
+				items.List = append(items.List, c)
+			}
+		}
+		if len(items.List) > 0 {
+			groups = append(groups, items)
+		}
+	}
+	return groups
+}
+
+// validationMarkers are the +kubebuilder:validation markers getValidation
+// knows about. Other ones are ignored with a warning.
+var validationMarkers = map[string]bool{
+	"Maximum":          true,
+	"ExclusiveMaximum": true,
+	"Minimum":          true,
+	"ExclusiveMinimum": true,
+	"MaxLength":        true,
+	"MinLength":        true,
+	"Pattern":          true,
+	"MaxItems":         true,
+	"MinItems":         true,
+	"UniqueItems":      true,
+	"MaxProperties":    true,
+	"MinProperties":    true,
+	"MultipleOf":       true,
+	"Enum":             true,
+	"Format":           true,
+}
+
+// knownFormats are the formats of the Format marker that JSON schema, OpenAPI
+// or Kubernetes define. Other ones are set with a warning.
+var knownFormats = map[string]bool{
+	// JSON schema
+	"date-time": true, "date": true, "time": true, "duration": true,
+	"email": true, "idn-email": true, "hostname": true, "idn-hostname": true,
+	"ipv4": true, "ipv6": true, "uri": true, "uri-reference": true,
+	"iri": true, "iri-reference": true, "uri-template": true, "uuid": true,
+	"json-pointer": true, "relative-json-pointer": true, "regex": true,
+	// OpenAPI
+	"int32": true, "int64": true, "float": true, "double": true,
+	"byte": true, "binary": true, "password": true,
+	// Kubernetes
+	"bsonobjectid": true, "cidr": true, "mac": true, "uuid3": true,
+	"uuid4": true, "uuid5": true, "isbn": true, "isbn10": true,
+	"isbn13": true, "creditcard": true, "ssn": true, "hexcolor": true,
+	"rgbcolor": true, "datetime": true,
+}
+
+// setByteLength sets the anyOf of def accepting the strings at most value
+// bytes long, see byteLengthMarker. It returns an error for a value that
+// isn't a positive int or zero, and for a def having an anyOf already.
+func setByteLength(def *v1beta1.JSONSchemaProps, value string) error {
+	// The type of a ref isn't known while parsing, it may be a string.
+	if def.Type != "string" && def.Ref == nil {
+		log.Printf("Ignoring %s%s, it only applies to strings", byteLengthMarker, value)
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("could not parse int from %s%s: %v", byteLengthMarker, value, err)
+	}
+	if n < 0 {
+		return fmt.Errorf("the byte length can't be negative in %s%s", byteLengthMarker, value)
+	}
+	if len(def.AnyOf) > 0 {
+		return fmt.Errorf("%s%s can't be combined with the anyOf of its type", byteLengthMarker, value)
+	}
+	def.AnyOf = maxByteLength(n)
+	return nil
+}
+
+// maxByteLength returns the anyOf accepting the strings at most n bytes long
+// in UTF-8. JSON schema only counts characters, and the number of bytes of a
+// string can't be matched exactly by a pattern of a sensible size. The ASCII
+// strings of up to n characters are accepted, and the other ones of up to n/4
+// characters, which can't be longer than n bytes. A longer string with a
+// character out of ASCII is rejected even if it fits. The Pattern of the
+// field, if any, applies as well.
+func maxByteLength(n int) []v1beta1.JSONSchemaProps {
+	ascii, chars := int64(n), int64(n/4)
+	return []v1beta1.JSONSchemaProps{
+		{Pattern: `^[\x00-\x7F]*$`, MaxLength: &ascii},
+		{MaxLength: &chars},
+	}
 }
 
 // This method is ported from controller-tools, it can removed when things are moved back.
 // getValidation parses the validation tags from the comment and sets the
-// validation rules on the given JSONSchemaProps.
+// validation rules on the given JSONSchemaProps. It returns an error for the
+// markers no value satisfies, see checkBounds.
 // TODO: reduce the cyclomatic complexity and remove next line
 //// nolint: gocyclo
-func getValidation(comment string, props *v1beta1.JSONSchemaProps) {
+func getValidation(comment string, props *v1beta1.JSONSchemaProps, report *coverageReport) error {
 	const arrayType = "array"
+	const objectType = "object"
 	comment = strings.TrimLeft(comment, " ")
 	if !strings.HasPrefix(comment, "+kubebuilder:validation:") {
-		return
+		return nil
 	}
 	c := strings.Replace(comment, "+kubebuilder:validation:", "", -1)
-	parts := strings.Split(c, "=")
+	// Only split on the first "=", a Pattern can contain more of them.
+	parts := strings.SplitN(c, "=", 2)
+	// Whether a field is required is decided with the other fields of its
+	// struct.
+	if parts[0] == "Required" || parts[0] == "Optional" {
+		return nil
+	}
+	if !validationMarkers[parts[0]] {
+		log.Printf("Ignoring unknown validation marker: %s", comment)
+		return nil
+	}
 	if len(parts) != 2 {
 		log.Fatalf("Expected +kubebuilder:validation:<key>=<value> actual: %s", comment)
-		return
+		return nil
 	}
 	switch parts[0] {
 	case "Maximum":
 		f, err := strconv.ParseFloat(parts[1], 64)
 		if err != nil {
 			log.Fatalf("Could not parse float from %s: %v", comment, err)
-			return
+			return nil
 		}
 		props.Maximum = &f
 	case "ExclusiveMaximum":
+		// A number is the bound itself, like in JSON schema draft-06 and
+		// later, e.g. ExclusiveMaximum=5 for maximum 5 excluded.
+		if f, err := strconv.ParseFloat(parts[1], 64); err == nil {
+			props.Maximum = &f
+			props.ExclusiveMaximum = true
+			break
+		}
 		b, err := strconv.ParseBool(parts[1])
 		if err != nil {
-			log.Fatalf("Could not parse bool from %s: %v", comment, err)
-			return
+			log.Fatalf("Could not parse bool or float from %s: %v", comment, err)
+			return nil
 		}
 		props.ExclusiveMaximum = b
 	case "Minimum":
 		f, err := strconv.ParseFloat(parts[1], 64)
 		if err != nil {
 			log.Fatalf("Could not parse float from %s: %v", comment, err)
-			return
+			return nil
 		}
 		props.Minimum = &f
 	case "ExclusiveMinimum":
+		// A number is the bound itself, like in JSON schema draft-06 and
+		// later, e.g. ExclusiveMinimum=0 for minimum 0 excluded.
+		if f, err := strconv.ParseFloat(parts[1], 64); err == nil {
+			props.Minimum = &f
+			props.ExclusiveMinimum = true
+			break
+		}
 		b, err := strconv.ParseBool(parts[1])
 		if err != nil {
-			log.Fatalf("Could not parse bool from %s: %v", comment, err)
-			return
+			log.Fatalf("Could not parse bool or float from %s: %v", comment, err)
+			return nil
 		}
 		props.ExclusiveMinimum = b
 	case "MaxLength":
+		// Like in JSON schema, the lengths are in characters, not in the
+		// bytes of the UTF-8 encoding, see byteLengthMarker.
 		i, err := strconv.Atoi(parts[1])
 		v := int64(i)
 		if err != nil {
 			log.Fatalf("Could not parse int from %s: %v", comment, err)
-			return
+			return nil
 		}
 		props.MaxLength = &v
 	case "MinLength":
@@ -108,77 +409,154 @@ func getValidation(comment string, props *v1beta1.JSONSchemaProps) {
 		v := int64(i)
 		if err != nil {
 			log.Fatalf("Could not parse int from %s: %v", comment, err)
-			return
+			return nil
 		}
 		props.MinLength = &v
 	case "Pattern":
 		props.Pattern = parts[1]
 	case "MaxItems":
-		if props.Type == arrayType {
-			i, err := strconv.Atoi(parts[1])
-			v := int64(i)
-			if err != nil {
-				log.Fatalf("Could not parse int from %s: %v", comment, err)
-				return
-			}
-			props.MaxItems = &v
+		if props.Type != arrayType {
+			log.Printf("Ignoring %s, it only applies to arrays", comment)
+			return nil
 		}
+		i, err := strconv.Atoi(parts[1])
+		v := int64(i)
+		if err != nil {
+			log.Fatalf("Could not parse int from %s: %v", comment, err)
+			return nil
+		}
+		props.MaxItems = &v
 	case "MinItems":
-		if props.Type == arrayType {
-			i, err := strconv.Atoi(parts[1])
-			v := int64(i)
-			if err != nil {
-				log.Fatalf("Could not parse int from %s: %v", comment, err)
-				return
-			}
-			props.MinItems = &v
+		if props.Type != arrayType {
+			log.Printf("Ignoring %s, it only applies to arrays", comment)
+			return nil
 		}
+		i, err := strconv.Atoi(parts[1])
+		v := int64(i)
+		if err != nil {
+			log.Fatalf("Could not parse int from %s: %v", comment, err)
+			return nil
+		}
+		props.MinItems = &v
 	case "UniqueItems":
-		if props.Type == arrayType {
-			b, err := strconv.ParseBool(parts[1])
-			if err != nil {
-				log.Fatalf("Could not parse bool from %s: %v", comment, err)
-				return
-			}
-			props.UniqueItems = b
+		if props.Type != arrayType {
+			log.Printf("Ignoring %s, it only applies to arrays", comment)
+			return nil
+		}
+		b, err := strconv.ParseBool(parts[1])
+		if err != nil {
+			log.Fatalf("Could not parse bool from %s: %v", comment, err)
+			return nil
+		}
+		props.UniqueItems = b
+	case "MaxProperties":
+		// The type of a ref isn't known while parsing, it may be an object.
+		if props.Type != objectType && props.Ref == nil {
+			log.Printf("Ignoring %s, it only applies to objects", comment)
+			return nil
+		}
+		i, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return fmt.Errorf("could not parse int from %s: %v", comment, err)
+		}
+		v := int64(i)
+		props.MaxProperties = &v
+	case "MinProperties":
+		if props.Type != objectType && props.Ref == nil {
+			log.Printf("Ignoring %s, it only applies to objects", comment)
+			return nil
+		}
+		i, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return fmt.Errorf("could not parse int from %s: %v", comment, err)
 		}
+		v := int64(i)
+		props.MinProperties = &v
 	case "MultipleOf":
+		if props.Type != "integer" && props.Type != "number" && props.Ref == nil {
+			log.Printf("Ignoring %s, it only applies to integers and numbers", comment)
+			return nil
+		}
 		f, err := strconv.ParseFloat(parts[1], 64)
 		if err != nil {
 			log.Fatalf("Could not parse float from %s: %v", comment, err)
-			return
+			return nil
+		}
+		// No value is a multiple of 0, JSON schema requires it positive.
+		if f <= 0 {
+			return fmt.Errorf("MultipleOf must be positive in %s", comment)
 		}
 		props.MultipleOf = &f
 	case "Enum":
 		if props.Type != arrayType {
-			value := strings.Split(parts[1], ",")
+			// Values are separated by ";" like in controller-tools, "," is
+			// still accepted when there is no ";".
+			sep := ","
+			if strings.Contains(parts[1], ";") {
+				sep = ";"
+			}
+			value := strings.Split(parts[1], sep)
 			enums := []v1beta1.JSON{}
 			for _, s := range value {
-				checkType(props, s, &enums)
+				checkType(props, strings.TrimSpace(s), &enums)
 			}
 			props.Enum = enums
 		}
 	case "Format":
+		if !knownFormats[parts[1]] {
+			report.warnf("unknown format in %s, validators may ignore it", comment)
+		}
 		props.Format = parts[1]
-	default:
-		log.Fatalf("Unsupport validation: %s", comment)
 	}
+	return nil
 }
 
 // check type of enum element value to match type of field
+// The type of a field referring to a definition isn't known while parsing, its
+// values are kept as strings and converted by mergeFieldEnums.
 func checkType(props *v1beta1.JSONSchemaProps, s string, enums *[]v1beta1.JSON) {
-	switch props.Type {
+	v, ok, err := enumJSON(s, props.Type)
+	if err != nil {
+		log.Fatalf("Invalid enum value [%v] for a field of %s type: %v", s, props.Type, err)
+	}
+	if ok {
+		*enums = append(*enums, v)
+	}
+}
+
+// enumJSON converts s, a value of an enum marker, to the JSON value of a
+// schema of type typ. Numbers are written the way JSON does, e.g. 0x1F is 31,
+// and the values JSON can't hold, like NaN, are an error. It is false for the
+// types enum values aren't converted to, e.g. objects.
+func enumJSON(s, typ string) (v1beta1.JSON, bool, error) {
+	var v interface{}
+	switch typ {
 	case "integer":
-		if _, err := strconv.ParseInt(s, 0, 64); err != nil {
-			log.Fatalf("Invalid integer value [%v] for a field of integer type", s)
+		i, err := strconv.ParseInt(s, 0, 64)
+		if err != nil {
+			return v1beta1.JSON{}, false, err
+		}
+		v = i
+	case "number":
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return v1beta1.JSON{}, false, err
 		}
-		*enums = append(*enums, v1beta1.JSON{Raw: []byte(fmt.Sprintf("%v", s))})
-	case "float", "number":
-		if _, err := strconv.ParseFloat(s, 64); err != nil {
-			log.Fatalf("Invalid float value [%v] for a field of float type", s)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return v1beta1.JSON{}, false, fmt.Errorf("%v isn't a JSON number", f)
 		}
-		*enums = append(*enums, v1beta1.JSON{Raw: []byte(fmt.Sprintf("%v", s))})
-	case "string":
-		*enums = append(*enums, v1beta1.JSON{Raw: []byte(`"` + s + `"`)})
+		v = f
+	case "boolean":
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return v1beta1.JSON{}, false, err
+		}
+		v = b
+	case "string", "":
+		v = s
+	default:
+		return v1beta1.JSON{}, false, nil
 	}
+	raw, err := json.Marshal(v)
+	return v1beta1.JSON{Raw: raw}, err == nil, err
 }
@@ -0,0 +1,483 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+func TestEnumJSON(t *testing.T) {
+	tests := []struct {
+		value   string
+		typ     string
+		want    string
+		wantErr bool
+	}{
+		{value: "42", typ: "integer", want: "42"},
+		{value: "0x1F", typ: "integer", want: "31"},
+		{value: "+5", typ: "integer", want: "5"},
+		{value: "1.5", typ: "integer", wantErr: true},
+		{value: ".5", typ: "number", want: "0.5"},
+		{value: "1e3", typ: "number", want: "1000"},
+		{value: "NaN", typ: "number", wantErr: true},
+		{value: "Inf", typ: "number", wantErr: true},
+		{value: "-Inf", typ: "number", wantErr: true},
+		{value: "true", typ: "boolean", want: "true"},
+		{value: "yes", typ: "boolean", wantErr: true},
+		{value: `a "b"`, typ: "string", want: `"a \"b\""`},
+		{value: "a", typ: "", want: `"a"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ+"/"+tt.value, func(t *testing.T) {
+			got, ok, err := enumJSON(tt.value, tt.typ)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("enumJSON(%q, %q) = %s, want an error", tt.value, tt.typ, got.Raw)
+				}
+				return
+			}
+			if err != nil || !ok {
+				t.Fatalf("enumJSON(%q, %q) = %v, %v, want %s", tt.value, tt.typ, ok, err, tt.want)
+			}
+			if string(got.Raw) != tt.want {
+				t.Errorf("enumJSON(%q, %q) = %s, want %s", tt.value, tt.typ, got.Raw, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertEnumValues(t *testing.T) {
+	values := []v1beta1.JSON{{Raw: []byte(`"0x10"`)}, {Raw: []byte(`"1_000"`)}}
+	got := convertEnumValues(values, "integer")
+	want := []string{"16", "1000"}
+	if len(got) != len(want) {
+		t.Fatalf("convertEnumValues() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if string(got[i].Raw) != want[i] {
+			t.Errorf("convertEnumValues()[%d] = %s, want %s", i, got[i].Raw, want[i])
+		}
+	}
+}
+
+func TestGetValidationMultipleOf(t *testing.T) {
+	tests := []struct {
+		comment string
+		typ     string
+		want    float64
+		wantErr bool
+	}{
+		{comment: "+kubebuilder:validation:MultipleOf=3", typ: "integer", want: 3},
+		{comment: "+kubebuilder:validation:MultipleOf=0.5", typ: "number", want: 0.5},
+		{comment: "+kubebuilder:validation:MultipleOf=0", typ: "integer", wantErr: true},
+		{comment: "+kubebuilder:validation:MultipleOf=-2", typ: "number", wantErr: true},
+		{comment: "+kubebuilder:validation:MultipleOf=0", typ: "string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ+"/"+tt.comment, func(t *testing.T) {
+			props := &v1beta1.JSONSchemaProps{Type: tt.typ}
+			err := getValidation(tt.comment, props, nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("getValidation(%q) = nil, want an error", tt.comment)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getValidation(%q) = %v", tt.comment, err)
+			}
+			switch {
+			case tt.want == 0 && props.MultipleOf != nil:
+				t.Errorf("MultipleOf = %v, want it unset", *props.MultipleOf)
+			case tt.want != 0 && (props.MultipleOf == nil || *props.MultipleOf != tt.want):
+				t.Errorf("MultipleOf = %v, want %v", props.MultipleOf, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetValidationProperties(t *testing.T) {
+	tests := []struct {
+		comment string
+		typ     string
+		want    int64
+		wantErr bool
+	}{
+		{comment: "+kubebuilder:validation:MaxProperties=5", typ: "object", want: 5},
+		{comment: "+kubebuilder:validation:MinProperties=1", typ: "object", want: 1},
+		{comment: "+kubebuilder:validation:MaxProperties=five", typ: "object", wantErr: true},
+		{comment: "+kubebuilder:validation:MinProperties=", typ: "object", wantErr: true},
+		{comment: "+kubebuilder:validation:MaxProperties=5", typ: "string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ+"/"+tt.comment, func(t *testing.T) {
+			props := &v1beta1.JSONSchemaProps{Type: tt.typ}
+			err := getValidation(tt.comment, props, nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("getValidation(%q) = nil, want an error", tt.comment)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getValidation(%q) = %v", tt.comment, err)
+			}
+			got := props.MaxProperties
+			if got == nil {
+				got = props.MinProperties
+			}
+			switch {
+			case tt.want == 0 && got != nil:
+				t.Errorf("%q set the bound %d, want it ignored", tt.comment, *got)
+			case tt.want != 0 && (got == nil || *got != tt.want):
+				t.Errorf("%q set the bound %v, want %d", tt.comment, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetValidationFormat(t *testing.T) {
+	tests := []struct {
+		comment string
+		pattern string
+		want    string
+		warning bool
+	}{
+		{comment: "+kubebuilder:validation:Format=uuid", want: "uuid"},
+		{comment: "+kubebuilder:validation:Format=email", want: "email"},
+		{comment: "+kubebuilder:validation:Format=date-time", want: "date-time"},
+		{comment: "+kubebuilder:validation:Format=cidr", want: "cidr"},
+		{comment: "+kubebuilder:validation:Format=uuid", pattern: "^[0-9a-f-]+$", want: "uuid"},
+		{comment: "+kubebuilder:validation:Format=zipcode", want: "zipcode", warning: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.comment, func(t *testing.T) {
+			props := &v1beta1.JSONSchemaProps{Type: "string", Pattern: tt.pattern}
+			report := &coverageReport{}
+			if err := getValidation(tt.comment, props, report); err != nil {
+				t.Fatalf("getValidation(%q) = %v", tt.comment, err)
+			}
+			if props.Format != tt.want {
+				t.Errorf("Format = %q, want %q", props.Format, tt.want)
+			}
+			// The format composes with the pattern, which is kept.
+			if props.Pattern != tt.pattern {
+				t.Errorf("Pattern = %q, want %q", props.Pattern, tt.pattern)
+			}
+			if warned := len(report.warnings) > 0; warned != tt.warning {
+				t.Errorf("warnings %v, want a warning: %v", report.warnings, tt.warning)
+			}
+		})
+	}
+}
+
+func TestSetByteLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		props   v1beta1.JSONSchemaProps
+		want    int
+		wantErr bool
+	}{
+		{name: "bounded", value: "8", props: v1beta1.JSONSchemaProps{Type: "string"}, want: 2},
+		{name: "negative", value: "-1", props: v1beta1.JSONSchemaProps{Type: "string"}, wantErr: true},
+		{name: "not an int", value: "8B", props: v1beta1.JSONSchemaProps{Type: "string"}, wantErr: true},
+		{name: "anyOf", value: "8", props: v1beta1.JSONSchemaProps{Type: "string", AnyOf: []v1beta1.JSONSchemaProps{{}}}, wantErr: true},
+		{name: "not a string", value: "8", props: v1beta1.JSONSchemaProps{Type: "integer"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			props := tt.props
+			err := setByteLength(&props, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("setByteLength(%q) = nil, want an error", tt.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("setByteLength(%q) = %v", tt.value, err)
+			}
+			if len(props.AnyOf) != tt.want {
+				t.Errorf("anyOf = %v, want %d schemas", props.AnyOf, tt.want)
+			}
+		})
+	}
+}
+
+func TestByteLengthMarker(t *testing.T) {
+	src := `package api
+
+type T struct {
+	// +kubebuilder:validation:MaxLength=8
+	Chars string ` + "`json:\"chars\"`" + `
+	// +schema:validation:ByteLength=%s
+	Bytes string ` + "`json:\"bytes\"`" + `
+}
+`
+	op := testGenerator(t, map[string]string{"types.go": fmt.Sprintf(src, "8")}, "T")
+	def := generateDefinition(t, op, "T")
+	tests := map[string]string{
+		"chars": `{"type":"string","maxLength":8}`,
+		"bytes": `{"type":"string","anyOf":[{"maxLength":8,"pattern":"^[\\x00-\\x7F]*$"},{"maxLength":2}]}`,
+	}
+	for name, want := range tests {
+		if got := compactJSON(t, def.Properties[name]); got != want {
+			t.Errorf("%s is %s, want %s", name, got, want)
+		}
+	}
+
+	op = testGenerator(t, map[string]string{"types.go": fmt.Sprintf(src, "8B")}, "T")
+	if _, err := op.GenerateSchema(); err == nil || !strings.Contains(err.Error(), "+schema:validation:ByteLength=8B") {
+		t.Errorf("GenerateSchema() = %v, want an error about the ByteLength marker", err)
+	}
+}
+
+func TestMarkerJSON(t *testing.T) {
+	tests := []struct {
+		value   string
+		typ     string
+		want    string
+		wantErr string
+	}{
+		{value: "3", typ: "integer", want: "3"},
+		{value: "foo", typ: "string", want: `"foo"`},
+		{value: `{"name":"foo"}`, typ: "object", want: `{"name":"foo"}`},
+		{value: "foo", typ: "", want: `"foo"`},
+		{value: "1.5", typ: "integer", wantErr: "+kubebuilder:default=1.5: not an integer"},
+		{value: `{"name":}`, typ: "object", wantErr: `+kubebuilder:default={"name":}: invalid character '}' looking for beginning of value, at offset 9 of the value`},
+		{value: "[1,", typ: "array", wantErr: "+kubebuilder:default=[1,"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ+"/"+tt.value, func(t *testing.T) {
+			got, err := defaultValue(tt.value, tt.typ)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("defaultValue(%q) = %v, want an error containing %q", tt.value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("defaultValue(%q) = %v", tt.value, err)
+			}
+			if string(got.Raw) != tt.want {
+				t.Errorf("defaultValue(%q) = %s, want %s", tt.value, got.Raw, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationMarkers(t *testing.T) {
+	src := `package api
+
+type T struct {
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +kubebuilder:validation:MultipleOf=2
+	Count int ` + "`json:\"count\"`" + `
+	// +kubebuilder:validation:MinLength=3
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:validation:Pattern=^[a-z]+$
+	// +kubebuilder:validation:Unknown=1
+	Name string ` + "`json:\"name\"`" + `
+}
+`
+	op := testGenerator(t, map[string]string{"types.go": src}, "T")
+	def := generateDefinition(t, op, "T")
+	tests := map[string]string{
+		"count": `{"type":"integer","format":"int64","maximum":100,"minimum":1,"multipleOf":2}`,
+		"name":  `{"type":"string","maxLength":63,"minLength":3,"pattern":"^[a-z]+$"}`,
+	}
+	for name, want := range tests {
+		b, err := json.Marshal(def.Properties[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); got != want {
+			t.Errorf("%s is %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestArrayMarkers(t *testing.T) {
+	src := `package api
+
+type T struct {
+	// +listType=set
+	Tags []string ` + "`json:\"tags\"`" + `
+	// +kubebuilder:validation:UniqueItems=true
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=3
+	// +kubebuilder:validation:MaxLength=5
+	Names []string ` + "`json:\"names\"`" + `
+	// +kubebuilder:validation:UniqueItems=true
+	// +listType=set
+	Name string ` + "`json:\"name\"`" + `
+}
+`
+	op := testGenerator(t, map[string]string{"types.go": src}, "T")
+	def := generateDefinition(t, op, "T")
+	tests := map[string]string{
+		"tags":  `{"type":"array","uniqueItems":true,"items":{"type":"string"}}`,
+		"names": `{"type":"array","maxItems":3,"minItems":1,"uniqueItems":true,"items":{"type":"string","maxLength":5}}`,
+		"name":  `{"type":"string"}`,
+	}
+	for name, want := range tests {
+		if got := compactJSON(t, def.Properties[name]); got != want {
+			t.Errorf("%s is %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestDefaultMarkers(t *testing.T) {
+	src := `package api
+
+type Port struct {
+	Name   string ` + "`json:\"name\"`" + `
+	Number int    ` + "`json:\"number\"`" + `
+}
+
+type T struct {
+	// +kubebuilder:default=web
+	Name string ` + "`json:\"name\"`" + `
+	// +kubebuilder:default=3
+	Replicas int ` + "`json:\"replicas\"`" + `
+	// +kubebuilder:default=0.5
+	Ratio float64 ` + "`json:\"ratio\"`" + `
+	// +kubebuilder:default=true
+	Enabled bool ` + "`json:\"enabled\"`" + `
+	// +kubebuilder:default={"name":"http","number":80}
+	Port Port ` + "`json:\"port\"`" + `
+	// +kubebuilder:default=["a","b"]
+	Tags []string ` + "`json:\"tags\"`" + `
+	// +kubebuilder:default={"a":"b"}
+	Labels map[string]string ` + "`json:\"labels\"`" + `
+}
+`
+	op := testGenerator(t, map[string]string{"types.go": src}, "T")
+	def := generateDefinition(t, op, "T")
+	tests := map[string]string{
+		"name":     `"web"`,
+		"replicas": `3`,
+		"ratio":    `0.5`,
+		"enabled":  `true`,
+		"port":     `{"name":"http","number":80}`,
+		"tags":     `["a","b"]`,
+		"labels":   `{"a":"b"}`,
+	}
+	for name, want := range tests {
+		prop := def.Properties[name]
+		if prop.Default == nil {
+			t.Errorf("%s has no default, want %s", name, want)
+		} else if got := string(prop.Default.Raw); got != want {
+			t.Errorf("the default of %s is %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestPropertiesMarkers(t *testing.T) {
+	src := `package api
+
+type U struct {
+	A string ` + "`json:\"a,omitempty\"`" + `
+	B string ` + "`json:\"b,omitempty\"`" + `
+}
+
+type T struct {
+	// +kubebuilder:validation:MinProperties=1
+	// +kubebuilder:validation:MaxProperties=5
+	Labels map[string]string ` + "`json:\"labels\"`" + `
+	// +kubebuilder:validation:MinProperties=1
+	U U ` + "`json:\"u\"`" + `
+	// +kubebuilder:validation:MaxProperties=5
+	Name string ` + "`json:\"name\"`" + `
+}
+`
+	op := testGenerator(t, map[string]string{"types.go": src}, "T")
+	def := generateDefinition(t, op, "T")
+	tests := map[string]string{
+		"labels": `{"type":"object","maxProperties":5,"minProperties":1,"additionalProperties":{"type":"string"}}`,
+		"u":      `{"type":"object","minProperties":1,"properties":{"a":{"type":"string"},"b":{"type":"string"}}}`,
+		"name":   `{"type":"string"}`,
+	}
+	for name, want := range tests {
+		if got := compactJSON(t, def.Properties[name]); got != want {
+			t.Errorf("%s is %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestConflictingMarkers(t *testing.T) {
+	tests := []struct {
+		name    string
+		decls   string
+		wantErr string
+	}{
+		{
+			name:    "minimum",
+			decls:   "type T struct {\n\t// +kubebuilder:validation:Minimum=10\n\t// +kubebuilder:validation:Maximum=5\n\tN int `json:\"n\"`\n}\n",
+			wantErr: "field n: conflicting markers, minimum 10 is greater than maximum 5",
+		},
+		{
+			name:    "length",
+			decls:   "type T struct {\n\t// +kubebuilder:validation:MinLength=8\n\t// +kubebuilder:validation:MaxLength=4\n\tS string `json:\"s\"`\n}\n",
+			wantErr: "field s: conflicting markers, minLength 8 is greater than maxLength 4",
+		},
+		{
+			name:    "items",
+			decls:   "type T struct {\n\t// +kubebuilder:validation:MinItems=3\n\t// +kubebuilder:validation:MaxItems=1\n\tL []string `json:\"l\"`\n}\n",
+			wantErr: "field l: conflicting markers, minItems 3 is greater than maxItems 1",
+		},
+		{
+			name:    "nested items",
+			decls:   "type T struct {\n\t// +kubebuilder:validation:MinLength=8\n\t// +kubebuilder:validation:MaxLength=4\n\tL []string `json:\"l\"`\n}\n",
+			wantErr: "field l: conflicting markers, minLength 8 is greater than maxLength 4",
+		},
+		{
+			name:    "properties",
+			decls:   "type T struct {\n\t// +kubebuilder:validation:MinProperties=2\n\t// +kubebuilder:validation:MaxProperties=1\n\tM map[string]string `json:\"m\"`\n}\n",
+			wantErr: "field m: conflicting markers, minProperties 2 is greater than maxProperties 1",
+		},
+		{
+			name:    "named type",
+			decls:   "// +kubebuilder:validation:Minimum=100\n// +kubebuilder:validation:Maximum=0\ntype P int\n\ntype T struct {\n\tP P `json:\"p\"`\n}\n",
+			wantErr: "type P: conflicting markers, minimum 100 is greater than maximum 0",
+		},
+		{
+			name:  "equal",
+			decls: "type T struct {\n\t// +kubebuilder:validation:Minimum=5\n\t// +kubebuilder:validation:Maximum=5\n\tN int `json:\"n\"`\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": "package api\n\n" + tt.decls}, "T")
+			_, err := op.GenerateSchema()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("GenerateSchema() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("GenerateSchema() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
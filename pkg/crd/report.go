@@ -0,0 +1,127 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// Reasons a struct field is left out of the schema, see coverageReport.
+const (
+	skippedUnexported = "unexported"
+	skippedIgnored    = `tagged "-"`
+	skippedMarker     = "ignored by marker"
+)
+
+// skipReasons are the reasons a struct field is left out of the schema, in
+// the order of the report.
+var skipReasons = []string{skippedUnexported, skippedIgnored, skippedMarker}
+
+// coverageReport counts what the generation saw, see Report. It is shared by
+// the parsers of all the packages. Fields of an unsupported type aren't
+// skipped, they fail the generation.
+type coverageReport struct {
+	// requested is the number of requested types, found the number of them
+	// declared in the parsed packages.
+	requested, found int
+	// parsed is the number of definitions the packages were parsed into,
+	// pruned the number of them unreachable from the requested types.
+	parsed, pruned int
+	// generated is the number of definitions in the schema.
+	generated int
+	// skippedFields counts the struct fields left out of the schema, by
+	// reason.
+	skippedFields map[string]int
+	// unresolved are the refs of the schema to no definition of it, e.g. to
+	// the types of ExternalReferences, sorted.
+	unresolved []string
+	// warnings are the warnings logged, see warnf.
+	warnings []string
+}
+
+// warnf logs a warning and counts it.
+func (r *coverageReport) warnf(format string, args ...interface{}) {
+	warning := fmt.Sprintf(format, args...)
+	log.Printf("Warning: %s", warning)
+	if r != nil {
+		r.warnings = append(r.warnings, warning)
+	}
+}
+
+// resolve sets the refs of schema that aren't to one of its definitions.
+func (r *coverageReport) resolve(schema *v1beta1.JSONSchemaProps) {
+	seen := map[string]bool{}
+	r.unresolved = nil
+	walkDefinition(schema, func(def *v1beta1.JSONSchemaProps) {
+		if def.Ref == nil || seen[*def.Ref] {
+			return
+		}
+		seen[*def.Ref] = true
+		if name := getNameFromURL(*def.Ref); name == "" || !hasDefinition(schema, name) {
+			r.unresolved = append(r.unresolved, *def.Ref)
+		}
+	})
+	sort.Strings(r.unresolved)
+}
+
+// hasDefinition tells if schema has a definition called name.
+func hasDefinition(schema *v1beta1.JSONSchemaProps, name string) bool {
+	_, ok := schema.Definitions[name]
+	return ok
+}
+
+// skipField counts a struct field left out of the schema for reason.
+func (r *coverageReport) skipField(reason string) {
+	if r == nil {
+		return
+	}
+	if r.skippedFields == nil {
+		r.skippedFields = map[string]int{}
+	}
+	r.skippedFields[reason]++
+}
+
+// add adds the counts of what other parsed to r.
+func (r *coverageReport) add(other *coverageReport) {
+	r.parsed += other.parsed
+	r.pruned += other.pruned
+	r.warnings = append(r.warnings, other.warnings...)
+	for reason, n := range other.skippedFields {
+		if r.skippedFields == nil {
+			r.skippedFields = map[string]int{}
+		}
+		r.skippedFields[reason] += n
+	}
+}
+
+func (r *coverageReport) String() string {
+	skipped := 0
+	var reasons []string
+	for _, reason := range skipReasons {
+		skipped += r.skippedFields[reason]
+		reasons = append(reasons, fmt.Sprintf("%d %s", r.skippedFields[reason], reason))
+	}
+	unresolved := ""
+	if len(r.unresolved) > 0 {
+		unresolved = fmt.Sprintf(" (%s)", strings.Join(r.unresolved, ", "))
+	}
+	return fmt.Sprintf("%d types requested, %d found, %d definitions parsed, %d pruned, %d generated, %d fields skipped (%s), %d refs unresolved%s, %d warnings",
+		r.requested, r.found, r.parsed, r.pruned, r.generated, skipped, strings.Join(reasons, ", "), len(r.unresolved), unresolved, len(r.warnings))
+}
@@ -0,0 +1,144 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+func TestReport(t *testing.T) {
+	src := `package api
+
+type T struct {
+	hidden int
+	Skipped int ` + "`json:\"-\"`" + `
+	// +schemagen:ignore
+	Ignored int ` + "`json:\"ignored\"`" + `
+	Other   int ` + "`json:\"other\"`" + `
+	U       U   ` + "`json:\"u\"`" + `
+}
+
+type U struct {
+	name string
+}
+
+type Unused struct{}
+`
+	op := testGenerator(t, map[string]string{"types.go": src}, "T")
+	op.Flatten = true
+	op.Report = true
+	generateJSON(t, op)
+	r := op.report
+	tests := []struct {
+		name      string
+		got, want int
+	}{
+		{name: "requested", got: r.requested, want: 1},
+		{name: "found", got: r.found, want: 1},
+		{name: "parsed", got: r.parsed, want: 3},
+		{name: "pruned", got: r.pruned, want: 1},
+		{name: "generated", got: r.generated, want: 2},
+		{name: skippedUnexported, got: r.skippedFields[skippedUnexported], want: 2},
+		{name: skippedIgnored, got: r.skippedFields[skippedIgnored], want: 1},
+		{name: skippedMarker, got: r.skippedFields[skippedMarker], want: 1},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestReportUnresolved(t *testing.T) {
+	src := `// +groupName=example.com
+package api
+
+// +kubebuilder:resource:path=nodes
+type Node struct {
+	Next *Node ` + "`json:\"next\"`" + `
+}
+`
+	// refer adds refs to the schema, to a definition or not.
+	refer := func(schema *v1beta1.JSONSchemaProps) error {
+		node := schema.Definitions["Node"]
+		for _, ref := range []string{"#/definitions/Missing", "other.json#/x", "#/definitions/Node", "#/definitions/Missing"} {
+			ref := ref
+			node.AnyOf = append(node.AnyOf, v1beta1.JSONSchemaProps{Ref: &ref})
+		}
+		schema.Definitions["Node"] = node
+		return nil
+	}
+	op := testGenerator(t, map[string]string{"types.go": src}, "Node")
+	op.Report = true
+	op.Transforms = []func(*v1beta1.JSONSchemaProps) error{refer}
+	generateJSON(t, op)
+	want := []string{"#/definitions/Missing", "other.json#/x"}
+	if !reflect.DeepEqual(op.report.unresolved, want) {
+		t.Errorf("unresolved %q, want %q", op.report.unresolved, want)
+	}
+	if len(op.report.warnings) != 1 {
+		t.Errorf("warnings %q, want the one of the recursive CRD", op.report.warnings)
+	}
+	summary := op.report.String()
+	for _, part := range []string{"2 refs unresolved (#/definitions/Missing, other.json#/x)", "1 warnings"} {
+		if !strings.Contains(summary, part) {
+			t.Errorf("the report %q doesn't hold %q", summary, part)
+		}
+	}
+}
+
+func TestWarningsAsErrors(t *testing.T) {
+	hidden := "package api\n\ntype T struct {\n\tname string\n}\n"
+	tests := []struct {
+		name    string
+		src     string
+		lint    bool
+		fail    bool
+		wantErr string
+	}{
+		{name: "no warning", src: "package api\n\ntype T struct {\n\tName string `json:\"name\"`\n}\n", fail: true},
+		{name: "warning", src: hidden, fail: true, wantErr: "1 warnings, failing with WarningsAsErrors:\nT has no serialized fields"},
+		{name: "not failing", src: hidden},
+		{
+			name:    "lint",
+			src:     "package api\n\ntype T struct {\n\t// +kubebuilder:validation:Enum=x\n\tMode string `json:\"mode\"`\n}\n",
+			lint:    true,
+			fail:    true,
+			wantErr: "#/definitions/T/properties/mode: ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": tt.src}, "T")
+			op.Flatten = true
+			op.Lint = tt.lint
+			op.WarningsAsErrors = tt.fail
+			_, err := op.GenerateSchema()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("GenerateSchema() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("GenerateSchema() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
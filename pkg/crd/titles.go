@@ -0,0 +1,72 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"go/ast"
+	"strings"
+)
+
+// titleMarker sets the title of a type or a field, e.g.
+// +schemagen:title=Pod specification.
+const titleMarker = "schemagen:title"
+
+// title returns the title of the type or field with the given Go name and
+// comments: the one of its title marker, else its Go name with EmitTitles.
+func (f *file) title(goName string, comments []*ast.CommentGroup) string {
+	var lines []string
+	for _, c := range comments {
+		lines = append(lines, strings.Split(c.Text(), "\n")...)
+	}
+	if title := Comments(lines).getTag(titleMarker, "="); title != "" {
+		return title
+	}
+	if f.options.EmitTitles {
+		return goName
+	}
+	return ""
+}
+
+// fieldGoName returns the name of a struct field in Go, the name of its type
+// for an embedded field.
+func fieldGoName(field *ast.Field) string {
+	if len(field.Names) > 0 {
+		return field.Names[0].Name
+	}
+	t := field.Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	switch t := t.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	case *ast.IndexExpr:
+		return fieldGoName(&ast.Field{Type: t.X})
+	case *ast.IndexListExpr:
+		return fieldGoName(&ast.Field{Type: t.X})
+	}
+	return ""
+}
+
+// idKeyword renames the id of the root of the generic JSON form of a schema
+// to $id, its keyword from draft-06.
+func idKeyword(generic map[string]interface{}) {
+	if id, ok := generic["id"]; ok {
+		generic["$id"] = id
+		delete(generic, "id")
+	}
+}
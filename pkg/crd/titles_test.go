@@ -0,0 +1,214 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import "testing"
+
+func TestTitles(t *testing.T) {
+	src := `package api
+
+// +schemagen:title=The T type
+type T struct {
+	Name string ` + "`json:\"name\"`" + `
+	// +schemagen:title=The size
+	Size int ` + "`json:\"size\"`" + `
+	U    U   ` + "`json:\"u\"`" + `
+}
+
+type U struct {
+	A string ` + "`json:\"a\"`" + `
+}
+`
+	tests := []struct {
+		emitTitles bool
+		want       map[string]string
+	}{
+		{emitTitles: false, want: map[string]string{
+			"#/definitions/T":                 "The T type",
+			"#/definitions/T/properties/name": "",
+			"#/definitions/T/properties/size": "The size",
+			"#/definitions/U":                 "",
+			"#/definitions/U/properties/a":    "",
+		}},
+		{emitTitles: true, want: map[string]string{
+			"#/definitions/T":                 "The T type",
+			"#/definitions/T/properties/name": "Name",
+			"#/definitions/T/properties/size": "The size",
+			"#/definitions/U":                 "U",
+			"#/definitions/U/properties/a":    "A",
+		}},
+	}
+	for _, tt := range tests {
+		op := testGenerator(t, map[string]string{"types.go": src}, "T")
+		op.Flatten = true
+		op.EmitTitles = tt.emitTitles
+		generic := generateGeneric(t, op)
+		for ref, want := range tt.want {
+			got, _ := resolveRef(generic, ref+"/title").(string)
+			if got != want {
+				t.Errorf("EmitTitles %v: the title of %s is %q, want %q", tt.emitTitles, ref, got, want)
+			}
+		}
+	}
+}
+
+func TestSchemaID(t *testing.T) {
+	src := "// +groupName=example.com\npackage api\n\n// +kubebuilder:resource:path=ts\ntype T struct {\n\tName string `json:\"name\"`\n}\n"
+	const id = "https://example.com/schemas/t.json"
+	tests := []struct {
+		version   string
+		outputCRD bool
+		keyword   string
+	}{
+		{version: SchemaVersionDraft04, keyword: "id"},
+		{version: SchemaVersionDraft06, keyword: "$id"},
+		{version: SchemaVersion202012, keyword: "$id"},
+		{version: SchemaVersionDraft04, outputCRD: true},
+	}
+	for _, tt := range tests {
+		op := testGenerator(t, map[string]string{"types.go": src}, "T")
+		op.SchemaID = id
+		op.SchemaVersion = tt.version
+		op.outputCRD = tt.outputCRD
+		generic := generateGeneric(t, op)
+		for _, keyword := range []string{"id", "$id"} {
+			got, ok := generic[keyword]
+			switch {
+			case keyword == tt.keyword && got != id:
+				t.Errorf("%s (CRD %v): %s is %v, want %s", tt.version, tt.outputCRD, keyword, got, id)
+			case keyword != tt.keyword && ok:
+				t.Errorf("%s (CRD %v): %s is %v, want none", tt.version, tt.outputCRD, keyword, got)
+			}
+		}
+	}
+}
+
+func TestRootTitle(t *testing.T) {
+	src := "package api\n\n// T is a thing.\ntype T struct {\n\tName string `json:\"name\"`\n}\n\n// U is another thing.\ntype U struct {\n\tSize int `json:\"size\"`\n}\n"
+	tests := []struct {
+		name        string
+		types       []string
+		inline      bool
+		title       string
+		description string
+		want        map[string]string
+	}{
+		{
+			name:  "unset",
+			types: []string{"T"},
+			want:  map[string]string{"#/title": "", "#/description": ""},
+		},
+		{
+			name:        "set",
+			types:       []string{"T", "U"},
+			title:       "Things",
+			description: "The things of the API.",
+			want: map[string]string{
+				"#/title":                     "Things",
+				"#/description":               "The things of the API.",
+				"#/definitions/T/title":       "T",
+				"#/definitions/U/description": "U is another thing.",
+				"#/definitions/T/description": "T is a thing.",
+			},
+		},
+		{
+			// The options replace the title and description of the
+			// inlined type.
+			name:        "inline",
+			types:       []string{"T"},
+			inline:      true,
+			title:       "Thing",
+			description: "The thing of the API.",
+			want:        map[string]string{"#/title": "Thing", "#/description": "The thing of the API."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := testGenerator(t, map[string]string{"types.go": src}, tt.types...)
+			op.Flatten = !tt.inline
+			op.EmitTitles = true
+			op.Inline = tt.inline
+			op.Title = tt.title
+			op.Description = tt.description
+			generic := generateGeneric(t, op)
+			for ref, want := range tt.want {
+				got, _ := resolveRef(generic, ref).(string)
+				if got != want {
+					t.Errorf("%s is %q, want %q", ref, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPackageDescription(t *testing.T) {
+	const types = "package api\n\ntype T struct {\n\tName string `json:\"name\"`\n}\n"
+	tests := []struct {
+		name        string
+		files       map[string]string
+		omit        bool
+		description string
+		want        string
+	}{
+		{
+			name:  "doc",
+			files: map[string]string{"types.go": "// Package api holds the things. They are many.\n" + types},
+			want:  "Package api holds the things.",
+		},
+		{
+			name:  "doc with markers",
+			files: map[string]string{"types.go": "// Package api holds the things.\n// +groupName=example.com\n" + types},
+			want:  "Package api holds the things.",
+		},
+		{
+			name:  "markers only",
+			files: map[string]string{"types.go": "// +groupName=example.com\n// +kubebuilder:object:generate=true\n" + types},
+		},
+		{
+			name: "doc in another file",
+			files: map[string]string{
+				"a.go":     "// +groupName=example.com\npackage api\n",
+				"doc.go":   "// Package api holds the things.\npackage api\n",
+				"types.go": types,
+			},
+			want: "Package api holds the things.",
+		},
+		{
+			name:  "omitted",
+			files: map[string]string{"types.go": "// Package api holds the things.\n" + types},
+			omit:  true,
+		},
+		{
+			name:        "option",
+			files:       map[string]string{"types.go": "// Package api holds the things.\n" + types},
+			description: "The things.",
+			want:        "The things.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := testGenerator(t, tt.files, "T")
+			op.OmitPackageDescription = tt.omit
+			op.Description = tt.description
+			schema, err := op.GenerateSchema()
+			if err != nil {
+				t.Fatalf("GenerateSchema() = %v", err)
+			}
+			if schema.Description != tt.want {
+				t.Errorf("the description is %q, want %q", schema.Description, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"go/ast"
+	"log"
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// tupleMarker writes a fixed-size array field as a tuple of its length, e.g.
+// for [2]float64 the items are a list of two schemas and additionalItems is
+// false, for the consumers of the schema expecting a tuple. The array is
+// closed by its length either way. Kubernetes doesn't accept tuples in CRDs.
+const tupleMarker = "+schemagen:tuple"
+
+// hasTupleMarker tells if comments hold the tuple marker.
+func hasTupleMarker(comments []*ast.CommentGroup) bool {
+	for _, c := range comments {
+		for _, line := range strings.Split(c.Text(), "\n") {
+			if strings.TrimSpace(line) == tupleMarker {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tupleSchema turns def, the schema of an array of n items, into the one of
+// a tuple of n items rejecting additional ones. JSON schema ignores
+// additionalItems unless the items are a list, so the slices, without a
+// length, are left as they are.
+func tupleSchema(def *v1beta1.JSONSchemaProps, n int64, known bool) {
+	if !known {
+		log.Printf("Ignoring %s, it only applies to arrays of a known length", tupleMarker)
+		return
+	}
+	items := make([]v1beta1.JSONSchemaProps, n)
+	for i := range items {
+		items[i] = *def.Items.Schema.DeepCopy()
+	}
+	def.Items = &v1beta1.JSONSchemaPropsOrArray{JSONSchemas: items}
+	def.AdditionalItems = &v1beta1.JSONSchemaPropsOrBool{Allows: false}
+}
+
+// prefixItems turns the tuples of the generic JSON form of a schema into the
+// ones of schema version 2020-12, where the list of items is prefixItems and
+// additionalItems is items. groups are the entries of the definitions holding
+// the definitions of a package, see NamespaceDefinitions.
+func prefixItems(generic map[string]interface{}, groups map[string]bool) {
+	walkGeneric(generic, groups, func(schema map[string]interface{}) {
+		items, ok := schema["items"].([]interface{})
+		if !ok {
+			return
+		}
+		schema["prefixItems"] = items
+		delete(schema, "items")
+		if additional, ok := schema["additionalItems"]; ok {
+			schema["items"] = additional
+			delete(schema, "additionalItems")
+		}
+	})
+}
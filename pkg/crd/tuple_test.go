@@ -0,0 +1,63 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import "testing"
+
+func TestTupleMarker(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   string
+		version string
+		want    string
+	}{
+		{
+			name:  "open by default",
+			field: "F [2]float64 `json:\"f\"`",
+			want:  `{"items":{"format":"double","type":"number"},"maxItems":2,"minItems":2,"type":"array"}`,
+		},
+		{
+			name:  "tuple",
+			field: "// +schemagen:tuple\n\tF [2]float64 `json:\"f\"`",
+			want:  `{"additionalItems":false,"items":[{"format":"double","type":"number"},{"format":"double","type":"number"}],"maxItems":2,"minItems":2,"type":"array"}`,
+		},
+		{
+			name:    "prefixItems",
+			field:   "// +schemagen:tuple\n\tF [2]bool `json:\"f\"`",
+			version: SchemaVersion202012,
+			want:    `{"items":false,"maxItems":2,"minItems":2,"prefixItems":[{"type":"boolean"},{"type":"boolean"}],"type":"array"}`,
+		},
+		{
+			name:  "slice",
+			field: "// +schemagen:tuple\n\tF []string `json:\"f\"`",
+			want:  `{"items":{"type":"string"},"type":"array"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package api\n\ntype T struct {\n\t" + tt.field + "\n}\n"
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			op.Flatten = true
+			op.SchemaVersion = tt.version
+			ref := "#/definitions/T/properties/f"
+			if tt.version == SchemaVersion202012 {
+				ref = "#/$defs/T/properties/f"
+			}
+			if got := compactJSON(t, resolveRef(generateGeneric(t, op), ref)); got != tt.want {
+				t.Errorf("f is %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
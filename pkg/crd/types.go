@@ -15,12 +15,30 @@
 package crd
 
 import (
+	"sort"
+
 	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 type crdSpecByKind map[schema.GroupKind]*v1beta1.CustomResourceDefinitionSpec
 
+// sortedKinds returns the group kinds of the CRD specs ordered by group and
+// kind, so the CRDs are always written in the same order.
+func (specs crdSpecByKind) sortedKinds() []schema.GroupKind {
+	gks := make([]schema.GroupKind, 0, len(specs))
+	for gk := range specs {
+		gks = append(gks, gk)
+	}
+	sort.Slice(gks, func(i, j int) bool {
+		if gks[i].Group != gks[j].Group {
+			return gks[i].Group < gks[j].Group
+		}
+		return gks[i].Kind < gks[j].Kind
+	})
+	return gks
+}
+
 // TypeReference denotes the (typeName, packageName) tuple
 type TypeReference struct {
 	TypeName    string
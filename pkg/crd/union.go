@@ -0,0 +1,67 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"fmt"
+	"go/ast"
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// unionMarker marks a struct of which exactly one pointer field is set, e.g.
+// the mutually exclusive sources of a volume.
+const unionMarker = "+union"
+
+// isUnion tells if the comments of a type have the union marker.
+func isUnion(comments []string) bool {
+	for _, c := range comments {
+		if strings.TrimSpace(c) == unionMarker {
+			return true
+		}
+	}
+	return false
+}
+
+// unionSchema sets the oneOf of def, the schema of the union structType, with
+// a member requiring each of its pointer fields, so exactly one of them is set.
+// The other fields are left as they are.
+func (f *file) unionSchema(def *v1beta1.JSONSchemaProps, structType *ast.StructType) error {
+	members := map[string]bool{}
+	for _, field := range structType.Fields.List {
+		if _, isPointer := field.Type.(*ast.StarExpr); !isPointer || f.ignoredField(field) {
+			continue
+		}
+		key, inline, ok := fieldKey(field, parseFieldTag(field.Tag))
+		if !ok || inline {
+			continue
+		}
+		members[key] = true
+		def.OneOf = append(def.OneOf, v1beta1.JSONSchemaProps{Required: []string{key}})
+	}
+	if len(members) == 0 {
+		return fmt.Errorf("%s can only be used on a struct with pointer fields", unionMarker)
+	}
+	// A member is never required by itself, even with a +required marker.
+	var required []string
+	for _, name := range def.Required {
+		if !members[name] {
+			required = append(required, name)
+		}
+	}
+	def.Required = required
+	return nil
+}
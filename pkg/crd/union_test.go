@@ -0,0 +1,65 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnionSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "pointer members",
+			fields: "\tHostPath *string `json:\"hostPath\"`\n\tSecret *int `json:\"secret\"`\n",
+			want:   `{"type":"object","oneOf":[{"required":["hostPath"]},{"required":["secret"]}],"properties":{"hostPath":{"type":"string"},"secret":{"type":"integer","format":"int64"}}}`,
+		},
+		{
+			name:   "other fields",
+			fields: "\tName string `json:\"name\"`\n\t// +required\n\tA *string `json:\"a\"`\n\t// +schemagen:ignore\n\tB *string `json:\"b\"`\n\tC *string `json:\"-\"`\n\td *string\n\tE *string `json:\"e\"`\n",
+			want:   `{"type":"object","required":["name"],"oneOf":[{"required":["a"]},{"required":["e"]}],"properties":{"a":{"type":"string"},"e":{"type":"string"},"name":{"type":"string"}}}`,
+		},
+		{
+			name:    "no pointer",
+			fields:  "\tName string `json:\"name\"`\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package api\n\n// +union\ntype Volume struct {\n" + tt.fields + "}\n"
+			op := testGenerator(t, map[string]string{"types.go": src}, "Volume")
+			op.Flatten = true
+			schema, err := op.GenerateSchema()
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), unionMarker) {
+					t.Fatalf("GenerateSchema() = %v, want a %s error", err, unionMarker)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateSchema() = %v", err)
+			}
+			if got := compactJSON(t, definition(t, schema, "Volume")); got != tt.want {
+				t.Errorf("Volume is %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
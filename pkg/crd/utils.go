@@ -17,7 +17,9 @@ package crd
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"reflect"
+	"sort"
 	"strings"
 
 	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
@@ -26,26 +28,46 @@ import (
 const (
 	stringType  = "string"
 	intType     = "int"
+	int8Type    = "int8"
+	int16Type   = "int16"
 	int32Type   = "int32"
 	int64Type   = "int64"
+	uintType    = "uint"
+	uint8Type   = "uint8"
+	uint16Type  = "uint16"
+	uint32Type  = "uint32"
+	uint64Type  = "uint64"
 	boolType    = "bool"
 	byteType    = "byte"
+	runeType    = "rune"
 	float32Type = "float32"
 	float64Type = "float64"
 
 	stringJSONType  = "string"
 	integerJSONType = "integer"
 	booleanJSONType = "boolean"
-	floatJSONType   = "float"
+	numberJSONType  = "number"
 )
 
 func isSimpleType(typeName string) bool {
 	return typeName == stringType || typeName == intType ||
+		typeName == int8Type || typeName == int16Type ||
 		typeName == int32Type || typeName == int64Type ||
-		typeName == boolType || typeName == byteType ||
+		isUnsignedType(typeName) ||
+		typeName == boolType || typeName == byteType || typeName == runeType ||
 		typeName == float32Type || typeName == float64Type
 }
 
+// isUnsignedType tells if typeName is an unsigned integer type, whose values
+// can't be negative. byte is an alias of uint8.
+func isUnsignedType(typeName string) bool {
+	switch typeName {
+	case uintType, uint8Type, uint16Type, uint32Type, uint64Type, byteType:
+		return true
+	}
+	return false
+}
+
 // Converts the typeName simple type to json type
 func jsonifyType(typeName string) string {
 	switch typeName {
@@ -53,61 +75,106 @@ func jsonifyType(typeName string) string {
 		return stringJSONType
 	case boolType:
 		return booleanJSONType
-	case intType, int32Type, int64Type:
+	// byte and rune are aliases of uint8 and int32, only a []byte is written
+	// as a string, see isByteSlice.
+	case intType, int8Type, int16Type, int32Type, int64Type, byteType, runeType,
+		uintType, uint8Type, uint16Type, uint32Type, uint64Type:
 		return integerJSONType
 	case float32Type, float64Type:
-		return floatJSONType
-	case byteType:
-		return stringJSONType
+		return numberJSONType
 	}
-	fmt.Println("jsonifyType called with a complex type ", typeName)
+	fmt.Fprintln(os.Stderr, "jsonifyType called with a complex type ", typeName)
 	panic("jsonifyType called with a complex type")
 }
 
-func mergeDefs(lhs v1beta1.JSONSchemaDefinitions, rhs v1beta1.JSONSchemaDefinitions) {
+// jsonifyFormat returns the OpenAPI format of the typeName simple type, telling
+// the width of the integers and the precision of the numbers. It is empty for
+// the other types. Like in Kubernetes, int is taken as 64 bits wide. The
+// narrower integers have the format of the smallest one holding all their
+// values, uint and uint64 have none.
+func jsonifyFormat(typeName string) string {
+	switch typeName {
+	case int8Type, int16Type, int32Type, runeType, uint8Type, byteType, uint16Type:
+		return "int32"
+	case intType, int64Type, uint32Type:
+		return "int64"
+	case float32Type:
+		return "float"
+	case float64Type:
+		return "double"
+	}
+	return ""
+}
+
+// mergeDefs adds the definitions of rhs to lhs, keeping the ones lhs already
+// has. Those are only logged if verbose is set, they are usually the same type
+// seen again.
+func mergeDefs(lhs v1beta1.JSONSchemaDefinitions, rhs v1beta1.JSONSchemaDefinitions, verbose bool) {
 	if lhs == nil || rhs == nil {
 		return
 	}
 	for key := range rhs {
 		_, ok := lhs[key]
 		if ok {
-			// change this to logger
-			fmt.Println("JSONSchemaProps ", key, " already present")
+			if verbose {
+				fmt.Fprintln(os.Stderr, "JSONSchemaProps ", key, " already present")
+			}
 			continue
 		}
 		lhs[key] = rhs[key]
 	}
 }
 
+// mergeExternalRefs adds the references of rhs to lhs. The references of a
+// type are kept sorted by package and type name, without duplicates, so they
+// are the same on every run.
 func mergeExternalRefs(lhs ExternalReferences, rhs ExternalReferences) {
 	if lhs == nil || rhs == nil {
 		return
 	}
 	for key := range rhs {
-		_, ok := lhs[key]
-		if !ok {
-			lhs[key] = rhs[key]
-		} else {
-			lhs[key] = append(lhs[key], rhs[key]...)
+		lhs[key] = uniqueTypeRefs(append(append([]TypeReference{}, lhs[key]...), rhs[key]...))
+	}
+}
+
+// uniqueTypeRefs sorts refs by package and type name and removes the
+// duplicates.
+func uniqueTypeRefs(refs []TypeReference) []TypeReference {
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].PackageName != refs[j].PackageName {
+			return refs[i].PackageName < refs[j].PackageName
+		}
+		return refs[i].TypeName < refs[j].TypeName
+	})
+	unique := refs[:0]
+	for i, ref := range refs {
+		if i == 0 || ref != refs[i-1] {
+			unique = append(unique, ref)
 		}
 	}
+	return unique
 }
 
-func mergeCRDSpecs(lhs, rhs crdSpecByKind) {
+// mergeCRDSpecs is mergeDefs for CRD specs.
+func mergeCRDSpecs(lhs, rhs crdSpecByKind, verbose bool) {
 	if lhs == nil || rhs == nil {
 		return
 	}
 	for key := range rhs {
 		_, ok := lhs[key]
 		if ok {
-			// TODO: change this to use logger
-			fmt.Printf("CRD spec for kind %q already present", key)
+			if verbose {
+				fmt.Fprintf(os.Stderr, "CRD spec for kind %q already present\n", key)
+			}
 			continue
 		}
 		lhs[key] = rhs[key]
 	}
 }
 
+// mergeCRDVersions adds the versions of the CRDs of rhs to the ones of the same
+// kind in lhs. Their names, group and scope must match, see the mismatch
+// errors.
 func mergeCRDVersions(lhs, rhs crdSpecByKind) error {
 	if lhs == nil || rhs == nil {
 		return nil
@@ -122,34 +189,34 @@ func mergeCRDVersions(lhs, rhs crdSpecByKind) error {
 		if len(lhs[gk].Group) == 0 {
 			lhs[gk].Group = rhs[gk].Group
 		} else if lhs[gk].Group != rhs[gk].Group {
-			return fmt.Errorf("group names %q and %q from different packages must match", lhs[gk].Group, rhs[gk].Group)
+			return &GroupMismatchError{GroupKind: gk, Left: lhs[gk].Group, Right: rhs[gk].Group}
 		}
 
 		if len(lhs[gk].Scope) == 0 {
 			lhs[gk].Scope = rhs[gk].Scope
 		} else if lhs[gk].Scope != rhs[gk].Scope {
-			return fmt.Errorf("group names %q and %q from different packages must match", lhs[gk].Group, rhs[gk].Group)
+			return &ScopeMismatchError{GroupKind: gk, Left: string(lhs[gk].Scope), Right: string(rhs[gk].Scope)}
 		}
 
 		if len(lhs[gk].Names.Kind) == 0 {
 			lhs[gk].Names.Kind = rhs[gk].Names.Kind
 		} else if lhs[gk].Names.Kind != rhs[gk].Names.Kind {
-			return fmt.Errorf("kind names %q and %q from different packages must match", lhs[gk].Names.Kind, rhs[gk].Names.Kind)
+			return &KindMismatchError{GroupKind: gk, Left: lhs[gk].Names.Kind, Right: rhs[gk].Names.Kind}
 		}
 		if len(lhs[gk].Names.Plural) == 0 {
 			lhs[gk].Names.Plural = rhs[gk].Names.Plural
 		} else if lhs[gk].Names.Plural != rhs[gk].Names.Plural {
-			return fmt.Errorf("plural resource names %q and %q from different packages must match", lhs[gk].Names.Plural, rhs[gk].Names.Plural)
+			return &PluralMismatchError{GroupKind: gk, Left: lhs[gk].Names.Plural, Right: rhs[gk].Names.Plural}
 		}
 		if len(lhs[gk].Names.Singular) == 0 {
 			lhs[gk].Names.Singular = rhs[gk].Names.Singular
 		} else if lhs[gk].Names.Singular != rhs[gk].Names.Singular {
-			return fmt.Errorf("singular resource names %q and %q from different packages must match", lhs[gk].Names.Singular, rhs[gk].Names.Singular)
+			return &SingularMismatchError{GroupKind: gk, Left: lhs[gk].Names.Singular, Right: rhs[gk].Names.Singular}
 		}
 		if len(lhs[gk].Names.ShortNames) == 0 {
 			lhs[gk].Names.ShortNames = rhs[gk].Names.ShortNames
 		} else if !reflect.DeepEqual(lhs[gk].Names.ShortNames, rhs[gk].Names.ShortNames) {
-			return fmt.Errorf("short names %s and %s from different packages must match", lhs[gk].Names.ShortNames, rhs[gk].Names.ShortNames)
+			return &ShortNamesMismatchError{GroupKind: gk, Left: lhs[gk].Names.ShortNames, Right: rhs[gk].Names.ShortNames}
 		}
 
 		lhs[gk].Versions = append(lhs[gk].Versions, rhs[gk].Versions...)
@@ -157,12 +224,43 @@ func mergeCRDVersions(lhs, rhs crdSpecByKind) error {
 	return nil
 }
 
-func debugPrint(obj interface{}) {
-	b, err3 := json.Marshal(obj)
-	if err3 != nil {
-		panic("Error")
+// setStorageVersions checks that every CRD has exactly one storage version,
+// the one marked with +kubebuilder:storageversion. The version of a CRD with
+// a single one is the storage version.
+func setStorageVersions(specs crdSpecByKind) error {
+	for _, gk := range specs.sortedKinds() {
+		versions := specs[gk].Versions
+		var storage []string
+		for _, v := range versions {
+			if v.Storage {
+				storage = append(storage, v.Name)
+			}
+		}
+		switch {
+		case len(storage) == 0 && len(versions) == 1:
+			versions[0].Storage = true
+		case len(storage) == 0:
+			return fmt.Errorf("CRD %s has no storage version, mark one with +kubebuilder:storageversion", gk.Kind)
+		case len(storage) > 1:
+			return fmt.Errorf("CRD %s has several storage versions %s, only one can be marked with +kubebuilder:storageversion", gk.Kind, strings.Join(storage, ", "))
+		}
+	}
+	return nil
+}
+
+// debugPrint writes the label and obj in JSON to the standard error, if
+// verbose is set.
+func debugPrint(verbose bool, label string, obj interface{}) error {
+	if !verbose {
+		return nil
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("failed to print %s: %v", label, err)
 	}
-	fmt.Println(string(b))
+	fmt.Fprintln(os.Stderr, label)
+	fmt.Fprintln(os.Stderr, string(b))
+	return nil
 }
 
 // Gets the schema definition link of a resource
@@ -171,12 +269,39 @@ func getDefLink(resourceName string) *string {
 	return &ret
 }
 
+// getPkgPrefix returns the prefix used to name the definitions of the types in
+// the package with the given import path. It is the only place that decides
+// how a package shows up in a definition name, so names only depend on the
+// import path and never on the order in which packages are discovered.
+func getPkgPrefix(pkgPath string) string {
+	return strings.Replace(pkgPath, "/", ".", -1)
+}
+
 func getFullName(resourceName string, prefix string) string {
 	if prefix == "" {
 		return resourceName
 	}
-	prefix = strings.Replace(prefix, "/", ".", -1)
-	return prefix + "." + resourceName
+	return getPkgPrefix(prefix) + "." + resourceName
+}
+
+// containsString tells if values holds value.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of m in lexical order.
+func sortedKeys(m map[string]map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func getPrefixedDefLink(resourceName string, prefix string) *string {
@@ -185,8 +310,54 @@ func getPrefixedDefLink(resourceName string, prefix string) *string {
 }
 
 // Gets the resource name from definitions url.
-// Eg, returns 'TypeName' from '#/definitions/TypeName'
+// Eg, returns 'TypeName' from '#/definitions/TypeName',
+// '#/components/schemas/TypeName' or '#/$defs/TypeName'. It returns an empty
+// string, which no definition is named, for the refs to another document,
+// e.g. 'https://example.com/schema.json#/definitions/TypeName', and the
+// malformed ones, e.g. '#/definitions/'.
 func getNameFromURL(url string) string {
-	slice := strings.Split(url, "/")
-	return slice[len(slice)-1]
+	for _, prefix := range []string{defPrefix, componentsPrefix, defsPrefix} {
+		if !strings.HasPrefix(url, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(url, prefix)
+		if strings.Contains(name, "/") {
+			return ""
+		}
+		return name
+	}
+	return ""
+}
+
+// rebaseRef points a ref made by getDefLink at the definitions under prefix,
+// e.g. '#/definitions/TypeName' becomes '#/components/schemas/TypeName'.
+// Other refs are returned as is.
+func rebaseRef(ref string, prefix string) string {
+	if !strings.HasPrefix(ref, defPrefix) {
+		return ref
+	}
+	return prefix + strings.TrimPrefix(ref, defPrefix)
+}
+
+// hostedRefs points the refs of the generic JSON form of a schema at the
+// schemas of the definitions hosted under base, see RefBaseURI, and removes
+// the definitions.
+func hostedRefs(generic map[string]interface{}, groups map[string]bool, base string) {
+	walkGeneric(generic, groups, func(schema map[string]interface{}) {
+		if ref, ok := schema["$ref"].(string); ok && strings.HasPrefix(ref, defPrefix) {
+			schema["$ref"] = base + strings.TrimPrefix(ref, defPrefix) + ".json"
+		}
+	})
+	delete(generic, "definitions")
+}
+
+// rebaseRefs points the refs of the generic JSON form of a schema at the
+// definitions under prefix, see rebaseRef. groups are the entries of the
+// definitions holding the definitions of a package, see NamespaceDefinitions.
+func rebaseRefs(generic map[string]interface{}, groups map[string]bool, prefix string) {
+	walkGeneric(generic, groups, func(schema map[string]interface{}) {
+		if ref, ok := schema["$ref"].(string); ok {
+			schema["$ref"] = rebaseRef(ref, prefix)
+		}
+	})
 }
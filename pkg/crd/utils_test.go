@@ -0,0 +1,414 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestSimpleTypes(t *testing.T) {
+	tests := []struct {
+		typeName string
+		typ      string
+		format   string
+		unsigned bool
+	}{
+		{typeName: "int", typ: "integer", format: "int64"},
+		{typeName: "int8", typ: "integer", format: "int32"},
+		{typeName: "int16", typ: "integer", format: "int32"},
+		{typeName: "int32", typ: "integer", format: "int32"},
+		{typeName: "int64", typ: "integer", format: "int64"},
+		{typeName: "rune", typ: "integer", format: "int32"},
+		{typeName: "uint", typ: "integer", unsigned: true},
+		{typeName: "uint8", typ: "integer", format: "int32", unsigned: true},
+		{typeName: "byte", typ: "integer", format: "int32", unsigned: true},
+		{typeName: "uint16", typ: "integer", format: "int32", unsigned: true},
+		{typeName: "uint32", typ: "integer", format: "int64", unsigned: true},
+		{typeName: "uint64", typ: "integer", unsigned: true},
+		{typeName: "float32", typ: "number", format: "float"},
+		{typeName: "float64", typ: "number", format: "double"},
+		{typeName: "bool", typ: "boolean"},
+		{typeName: "string", typ: "string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typeName, func(t *testing.T) {
+			if !isSimpleType(tt.typeName) {
+				t.Fatalf("isSimpleType(%q) = false", tt.typeName)
+			}
+			if got := jsonifyType(tt.typeName); got != tt.typ {
+				t.Errorf("jsonifyType(%q) = %q, want %q", tt.typeName, got, tt.typ)
+			}
+			if got := jsonifyFormat(tt.typeName); got != tt.format {
+				t.Errorf("jsonifyFormat(%q) = %q, want %q", tt.typeName, got, tt.format)
+			}
+			if got := isUnsignedType(tt.typeName); got != tt.unsigned {
+				t.Errorf("isUnsignedType(%q) = %v, want %v", tt.typeName, got, tt.unsigned)
+			}
+		})
+	}
+	for _, typeName := range []string{"uintptr", "complex128", "Foo"} {
+		if isSimpleType(typeName) {
+			t.Errorf("isSimpleType(%q) = true", typeName)
+		}
+	}
+}
+
+// captureStderr returns what f writes to the standard error.
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	return capture(t, &os.Stderr, f)
+}
+
+// captureStdout returns what f writes to the standard output.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	return capture(t, &os.Stdout, f)
+}
+
+// capture returns what f writes to *file, replaced by a pipe meanwhile.
+func capture(t *testing.T, file **os.File, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := *file
+	*file = w
+	f()
+	*file = orig
+	w.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestVerboseOutput(t *testing.T) {
+	for _, verbose := range []bool{false, true} {
+		out := captureStderr(t, func() {
+			defs := v1beta1.JSONSchemaDefinitions{"Foo": {Type: "string"}}
+			mergeDefs(defs, v1beta1.JSONSchemaDefinitions{"Foo": {Type: "integer"}}, verbose)
+			if defs["Foo"].Type != "string" {
+				t.Errorf("mergeDefs() replaced Foo with %v", defs["Foo"])
+			}
+			if err := debugPrint(verbose, "pkgDefs", defs); err != nil {
+				t.Errorf("debugPrint() = %v", err)
+			}
+		})
+		if verbose != (out != "") {
+			t.Errorf("verbose %v wrote %q", verbose, out)
+		}
+	}
+	if err := debugPrint(true, "func", func() {}); err == nil {
+		t.Error("debugPrint() = nil, want the error marshaling a func")
+	}
+}
+
+func TestDefinitionRefPrefix(t *testing.T) {
+	src := `package api
+
+type T struct {
+	U  U            ` + "`json:\"u\"`" + `
+	Us []U          ` + "`json:\"us\"`" + `
+	M  map[string]V ` + "`json:\"m\"`" + `
+}
+
+type U struct {
+	V *V ` + "`json:\"v\"`" + `
+}
+
+type V struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+`
+	refPattern := regexp.MustCompile(`"\$ref":\s*"([^"]*)"`)
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{prefix: "", want: "#/definitions/"},
+		{prefix: "#/components/schemas/", want: "#/components/schemas/"},
+		{prefix: "#/$defs/", want: "#/$defs/"},
+	}
+	for _, tt := range tests {
+		op := testGenerator(t, map[string]string{"types.go": src}, "T")
+		op.Flatten = true
+		op.DefinitionRefPrefix = tt.prefix
+		refs := refPattern.FindAllStringSubmatch(generateOutput(t, op), -1)
+		// The root refers to T, T to U twice and to V, and U to V.
+		if len(refs) != 5 {
+			t.Errorf("prefix %q: %d refs, want 5", tt.prefix, len(refs))
+		}
+		for _, ref := range refs {
+			if !strings.HasPrefix(ref[1], tt.want) {
+				t.Errorf("prefix %q: ref %s, want it under %s", tt.prefix, ref[1], tt.want)
+			}
+		}
+	}
+
+	for _, format := range []string{"json", openAPI3Format} {
+		op := testGenerator(t, map[string]string{"types.go": src}, "T")
+		op.DefinitionRefPrefix = "#/components/schemas/"
+		op.OutputFormat = format
+		if format == "json" {
+			op.RefBaseURI = "https://schemas.example.com/"
+		}
+		if _, err := op.GenerateSchema(); err == nil || !strings.Contains(err.Error(), "definition ref prefix") {
+			t.Errorf("format %s: GenerateSchema() = %v, want a definition ref prefix error", format, err)
+		}
+	}
+}
+
+func TestRefBaseURI(t *testing.T) {
+	src := `package api
+
+type T struct {
+	U  U            ` + "`json:\"u\"`" + `
+	Us []U          ` + "`json:\"us\"`" + `
+	M  map[string]V ` + "`json:\"m\"`" + `
+}
+
+type U struct {
+	V *V ` + "`json:\"v\"`" + `
+}
+
+type V struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+`
+	refPattern := regexp.MustCompile(`"\$ref":\s*"([^"]*)"`)
+	for _, version := range []string{"", SchemaVersion201909} {
+		op := testGenerator(t, map[string]string{"types.go": src}, "T")
+		op.Flatten = true
+		op.SchemaVersion = version
+		op.RefBaseURI = "https://schemas.example.com/v1/"
+		out := generateOutput(t, op)
+		var refs []string
+		for _, ref := range refPattern.FindAllStringSubmatch(out, -1) {
+			refs = append(refs, ref[1])
+		}
+		sort.Strings(refs)
+		// T is the root itself, it refers to U twice and to V. The refs of
+		// the hosted U aren't written.
+		want := []string{"https://schemas.example.com/v1/U.json", "https://schemas.example.com/v1/U.json", "https://schemas.example.com/v1/V.json"}
+		if !reflect.DeepEqual(refs, want) {
+			t.Errorf("version %q: refs %q, want %q", version, refs, want)
+		}
+		if strings.Contains(out, `"definitions"`) || strings.Contains(out, `"$defs"`) {
+			t.Errorf("version %q: the definitions are written:\n%s", version, out)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		options func(*SingleVersionGenerator)
+		wantErr string
+	}{
+		{name: "CRD", options: func(op *SingleVersionGenerator) { op.outputCRD = true }, wantErr: "the refs of a CRD can't point at a base URI"},
+		{name: "OpenAPI", options: func(op *SingleVersionGenerator) { op.OutputFormat = openAPI3Format }, wantErr: "a ref base URI can't be used"},
+	}
+	for _, tt := range tests {
+		op := testGenerator(t, map[string]string{"types.go": src}, "T")
+		op.RefBaseURI = "https://schemas.example.com/v1/"
+		tt.options(op)
+		if _, err := op.GenerateSchema(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: GenerateSchema() = %v, want an error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMergeExternalRefs(t *testing.T) {
+	a := TypeReference{TypeName: "A", PackageName: "example.com/x"}
+	b := TypeReference{TypeName: "B", PackageName: "example.com/x"}
+	c := TypeReference{TypeName: "A", PackageName: "example.com/w"}
+	tests := []struct {
+		name     string
+		lhs, rhs ExternalReferences
+		want     ExternalReferences
+	}{
+		{
+			name: "new type",
+			lhs:  ExternalReferences{},
+			rhs:  ExternalReferences{"T": {b, a, b}},
+			want: ExternalReferences{"T": {a, b}},
+		},
+		{
+			name: "overlapping",
+			lhs:  ExternalReferences{"T": {b, a}},
+			rhs:  ExternalReferences{"T": {a, c}, "U": {a}},
+			want: ExternalReferences{"T": {c, a, b}, "U": {a}},
+		},
+		{
+			name: "same",
+			lhs:  ExternalReferences{"T": {a}},
+			rhs:  ExternalReferences{"T": {a}},
+			want: ExternalReferences{"T": {a}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rhs := ExternalReferences{}
+			for key, refs := range tt.rhs {
+				rhs[key] = append([]TypeReference{}, refs...)
+			}
+			mergeExternalRefs(tt.lhs, tt.rhs)
+			if !reflect.DeepEqual(tt.lhs, tt.want) {
+				t.Errorf("merged %v, want %v", tt.lhs, tt.want)
+			}
+			if !reflect.DeepEqual(tt.rhs, rhs) {
+				t.Errorf("rhs is %v after the merge, want it unchanged %v", tt.rhs, rhs)
+			}
+		})
+	}
+}
+
+func TestMergeCRDVersionsErrors(t *testing.T) {
+	gk := schema.GroupKind{Group: "example.com", Kind: "Widget"}
+	spec := func(version string, edit func(*v1beta1.CustomResourceDefinitionSpec)) crdSpecByKind {
+		s := &v1beta1.CustomResourceDefinitionSpec{
+			Group: "example.com",
+			Scope: v1beta1.NamespaceScoped,
+			Names: v1beta1.CustomResourceDefinitionNames{
+				Kind:       "Widget",
+				Plural:     "widgets",
+				Singular:   "widget",
+				ShortNames: []string{"wd"},
+			},
+			Versions: []v1beta1.CustomResourceDefinitionVersion{{Name: version}},
+		}
+		if edit != nil {
+			edit(s)
+		}
+		return crdSpecByKind{gk: s}
+	}
+	tests := []struct {
+		name    string
+		edit    func(*v1beta1.CustomResourceDefinitionSpec)
+		target  interface{}
+		want    interface{}
+		message string
+	}{
+		{
+			name:    "group",
+			edit:    func(s *v1beta1.CustomResourceDefinitionSpec) { s.Group = "other.com" },
+			target:  new(*GroupMismatchError),
+			want:    &GroupMismatchError{GroupKind: gk, Left: "example.com", Right: "other.com"},
+			message: `group names "example.com" and "other.com" from different packages must match`,
+		},
+		{
+			name:    "scope",
+			edit:    func(s *v1beta1.CustomResourceDefinitionSpec) { s.Scope = v1beta1.ClusterScoped },
+			target:  new(*ScopeMismatchError),
+			want:    &ScopeMismatchError{GroupKind: gk, Left: "Namespaced", Right: "Cluster"},
+			message: `scopes "Namespaced" and "Cluster" from different packages must match`,
+		},
+		{
+			name:    "kind",
+			edit:    func(s *v1beta1.CustomResourceDefinitionSpec) { s.Names.Kind = "Gadget" },
+			target:  new(*KindMismatchError),
+			want:    &KindMismatchError{GroupKind: gk, Left: "Widget", Right: "Gadget"},
+			message: `kind names "Widget" and "Gadget" from different packages must match`,
+		},
+		{
+			name:    "plural",
+			edit:    func(s *v1beta1.CustomResourceDefinitionSpec) { s.Names.Plural = "widgetz" },
+			target:  new(*PluralMismatchError),
+			want:    &PluralMismatchError{GroupKind: gk, Left: "widgets", Right: "widgetz"},
+			message: `plural resource names "widgets" and "widgetz" from different packages must match`,
+		},
+		{
+			name:    "singular",
+			edit:    func(s *v1beta1.CustomResourceDefinitionSpec) { s.Names.Singular = "wdgt" },
+			target:  new(*SingularMismatchError),
+			want:    &SingularMismatchError{GroupKind: gk, Left: "widget", Right: "wdgt"},
+			message: `singular resource names "widget" and "wdgt" from different packages must match`,
+		},
+		{
+			name:    "short names",
+			edit:    func(s *v1beta1.CustomResourceDefinitionSpec) { s.Names.ShortNames = []string{"w"} },
+			target:  new(*ShortNamesMismatchError),
+			want:    &ShortNamesMismatchError{GroupKind: gk, Left: []string{"wd"}, Right: []string{"w"}},
+			message: `short names [wd] and [w] from different packages must match`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mergeCRDVersions(spec("v1", nil), spec("v2", tt.edit))
+			if err == nil {
+				t.Fatal("mergeCRDVersions() = nil, want an error")
+			}
+			if err.Error() != tt.message {
+				t.Errorf("the error is %q, want %q", err, tt.message)
+			}
+			// A wrapped error is recovered too.
+			if !errors.As(fmt.Errorf("parsing: %w", err), tt.target) {
+				t.Fatalf("errors.As(%T) = false", err)
+			}
+			if got := reflect.ValueOf(tt.target).Elem().Interface(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("the error is %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	lhs := spec("v1", nil)
+	if err := mergeCRDVersions(lhs, spec("v2", nil)); err != nil {
+		t.Fatalf("mergeCRDVersions() = %v", err)
+	}
+	if versions := lhs[gk].Versions; len(versions) != 2 {
+		t.Errorf("the versions are %v, want v1 and v2", versions)
+	}
+}
+
+func TestGetNameFromURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"#/definitions/Foo", "Foo"},
+		{"#/components/schemas/Foo", "Foo"},
+		{"#/$defs/Foo", "Foo"},
+		{"#/definitions/example.com.api.Foo", "example.com.api.Foo"},
+		{"#/definitions/", ""},
+		{"#/definitions/Foo/", ""},
+		{"#/definitions/Foo/properties/bar", ""},
+		{"https://example.com/schema.json#/definitions/Foo", ""},
+		{"schema.json#/definitions/Foo", ""},
+		{"#Foo", ""},
+		{"Foo", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := getNameFromURL(tt.url); got != tt.want {
+			t.Errorf("getNameFromURL(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+		// The pruner only follows the refs to a definition.
+		ref := tt.url
+		names := processDefinition(&v1beta1.JSONSchemaProps{Ref: &ref})
+		if got := strings.Join(names, ","); got != tt.want {
+			t.Errorf("processDefinition() of the ref %q = %v, want %q", tt.url, names, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// validateAgainstMetaSchema validates doc against the meta-schema stored in the
+// file at metaSchemaPath. All the violations are reported in one error.
+func validateAgainstMetaSchema(doc interface{}, metaSchemaPath string) error {
+	metaSchema, err := ioutil.ReadFile(metaSchemaPath)
+	if err != nil {
+		return fmt.Errorf("failed to read meta-schema: %v", err)
+	}
+	result, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(metaSchema), gojsonschema.NewGoLoader(doc))
+	if err != nil {
+		return fmt.Errorf("failed to validate against meta-schema %q: %v", metaSchemaPath, err)
+	}
+	if result.Valid() {
+		return nil
+	}
+	var violations []string
+	for _, desc := range result.Errors() {
+		violations = append(violations, desc.String())
+	}
+	return fmt.Errorf("generated schema violates meta-schema %q:\n%s", metaSchemaPath, strings.Join(violations, "\n"))
+}
+
+// Validate validates the JSON or YAML instance in the file at instancePath
+// against the schema in the file at schemaPath, e.g. one generated by
+// Generate. For a CRD, the OpenAPI schema of its first version is used.
+// The violations are returned one per string, an instance conforming to the
+// schema gives none.
+func Validate(schemaPath, instancePath string) ([]string, error) {
+	schema, err := readJSONOrYAML(schemaPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %v", err)
+	}
+	if schema, err = crdSchema(schema); err != nil {
+		return nil, fmt.Errorf("failed to read schema %q: %v", schemaPath, err)
+	}
+	instance, err := readJSONOrYAML(instancePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance: %v", err)
+	}
+	result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(schema), gojsonschema.NewGoLoader(instance))
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate %q against schema %q: %v", instancePath, schemaPath, err)
+	}
+	var violations []string
+	for _, desc := range result.Errors() {
+		violations = append(violations, desc.String())
+	}
+	return violations, nil
+}
+
+// readJSONOrYAML decodes the JSON or YAML document in the file at path. Only
+// the first document of a YAML stream is read.
+func readJSONOrYAML(path string) (interface{}, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %v", path, err)
+	}
+	return doc, nil
+}
+
+// crdSchema returns the OpenAPI schema of doc if it is a CRD, either of its
+// spec.validation or of its first version. Other documents are returned as is.
+func crdSchema(doc interface{}) (interface{}, error) {
+	obj, ok := doc.(map[string]interface{})
+	if !ok || obj["kind"] != "CustomResourceDefinition" {
+		return doc, nil
+	}
+	spec, _ := obj["spec"].(map[string]interface{})
+	if validation, ok := spec["validation"].(map[string]interface{}); ok {
+		if schema, ok := validation["openAPIV3Schema"]; ok {
+			return schema, nil
+		}
+	}
+	if versions, ok := spec["versions"].([]interface{}); ok && len(versions) > 0 {
+		version, _ := versions[0].(map[string]interface{})
+		if schema, ok := version["schema"].(map[string]interface{}); ok {
+			if openAPI, ok := schema["openAPIV3Schema"]; ok {
+				return openAPI, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("the CRD has no openAPIV3Schema")
+}
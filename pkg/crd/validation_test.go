@@ -0,0 +1,179 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFile writes content to the file named name in dir, returning its path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMetaSchema(t *testing.T) {
+	src := "package api\n\ntype T struct {\n\tName string `json:\"name\"`\n}\n"
+	tests := []struct {
+		name       string
+		metaSchema string
+		wantErr    string
+	}{
+		{name: "allowed", metaSchema: `{"type": "object", "required": ["definitions"]}`},
+		{name: "forbidden keyword", metaSchema: `{"not": {"required": ["anyOf"]}}`, wantErr: "violates meta-schema"},
+		{name: "missing", wantErr: "failed to read meta-schema"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "meta.json")
+			if tt.metaSchema != "" {
+				writeFile(t, filepath.Dir(path), "meta.json", tt.metaSchema)
+			}
+			op := testGenerator(t, map[string]string{"types.go": src}, "T")
+			schema, err := op.GenerateSchema()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = validateAgainstMetaSchema(schema, path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateAgainstMetaSchema() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateAgainstMetaSchema() = %v, want an error containing %q", err, tt.wantErr)
+			}
+
+			// The output isn't written.
+			op.MetaSchemaPath = path
+			if outputError(t, op) == nil {
+				t.Error("the output violating the meta-schema was written")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	src := `// +groupName=example.com
+package api
+
+// +kubebuilder:resource:path=widgets
+type Widget struct {
+	// +kubebuilder:validation:Minimum=1
+	Size int    ` + "`json:\"size\"`" + `
+	Name string ` + "`json:\"name\"`" + `
+}
+`
+	tests := []struct {
+		name      string
+		outputCRD bool
+		instance  string
+		violation string
+	}{
+		{name: "valid JSON", instance: `{"size": 2, "name": "a"}`},
+		{name: "valid YAML", instance: "size: 2\nname: a\n"},
+		{name: "below the minimum", instance: `{"size": 0, "name": "a"}`, violation: "size"},
+		{name: "missing a required field", instance: "size: 2\n", violation: "name"},
+		{name: "valid in a CRD", outputCRD: true, instance: "size: 2\nname: a\n"},
+		{name: "wrong type in a CRD", outputCRD: true, instance: "size: two\nname: a\n", violation: "size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			op := testGenerator(t, map[string]string{"types.go": src}, "Widget")
+			op.outputCRD = tt.outputCRD
+			schemaPath := writeFile(t, dir, "schema.json", generateOutput(t, op))
+			instancePath := writeFile(t, dir, "instance.yaml", tt.instance)
+			violations, err := Validate(schemaPath, instancePath)
+			if err != nil {
+				t.Fatalf("Validate() = %v", err)
+			}
+			if tt.violation == "" {
+				if len(violations) > 0 {
+					t.Errorf("violations %q, want none", violations)
+				}
+				return
+			}
+			// The root schema of a JSON schema is the anyOf of the types, which
+			// is violated too.
+			found := false
+			for _, v := range violations {
+				found = found || strings.HasPrefix(v, tt.violation+": ") || strings.Contains(v, tt.violation+" is required")
+			}
+			if !found {
+				t.Errorf("violations %q, want one of %s", violations, tt.violation)
+			}
+		})
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	dir := t.TempDir()
+	instance := writeFile(t, dir, "instance.json", `{}`)
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr string
+	}{
+		{name: "missing schema", wantErr: "failed to read schema"},
+		{name: "CRD without schema", schema: `{"kind": "CustomResourceDefinition", "spec": {}}`, wantErr: "no openAPIV3Schema"},
+		{name: "malformed schema", schema: `{"type": `, wantErr: "failed to parse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "missing.json")
+			if tt.schema != "" {
+				path = writeFile(t, t.TempDir(), "schema.json", tt.schema)
+			}
+			if _, err := Validate(path, instance); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateFloats(t *testing.T) {
+	src := "package api\n\ntype T struct {\n\tF32 float32 `json:\"f32\"`\n\tF64 float64 `json:\"f64\"`\n}\n"
+	tests := []struct {
+		instance string
+		valid    bool
+	}{
+		{instance: `{"f32": 1.5, "f64": 2.5}`, valid: true},
+		{instance: `{"f32": 1, "f64": -3e10}`, valid: true},
+		{instance: `{"f32": "1.5", "f64": 2.5}`},
+		{instance: `{"f32": 1.5, "f64": true}`},
+	}
+	dir := t.TempDir()
+	op := testGenerator(t, map[string]string{"types.go": src}, "T")
+	schemaPath := writeFile(t, dir, "schema.json", generateOutput(t, op))
+	for _, tt := range tests {
+		// A strict validator rejects the schema if the floats aren't numbers.
+		violations, err := Validate(schemaPath, writeFile(t, dir, "instance.json", tt.instance))
+		if err != nil {
+			t.Fatalf("Validate() = %v", err)
+		}
+		if valid := len(violations) == 0; valid != tt.valid {
+			t.Errorf("%s: violations %q, want valid %v", tt.instance, violations, tt.valid)
+		}
+	}
+}
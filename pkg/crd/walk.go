@@ -0,0 +1,77 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"strconv"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// walkDefinition calls fn on def and then on every schema nested in it.
+// fn may modify the schema it is given, the changes are written back in place.
+// Note that maps are shared between copies of a definition, so the changes
+// are visible through every copy.
+func walkDefinition(def *v1beta1.JSONSchemaProps, fn func(*v1beta1.JSONSchemaProps)) {
+	walkDefinitionPath(def, "#", func(_ string, def *v1beta1.JSONSchemaProps) {
+		fn(def)
+	})
+}
+
+// walkDefinitionPath is walkDefinition also giving fn the JSON pointer of
+// every schema, relative to path, e.g. "#/properties/spec/items".
+func walkDefinitionPath(def *v1beta1.JSONSchemaProps, path string, fn func(string, *v1beta1.JSONSchemaProps)) {
+	if def == nil {
+		return
+	}
+	fn(path, def)
+
+	walkDefinitionMap(def.Definitions, path+"/definitions", fn)
+	walkDefinitionMap(def.Properties, path+"/properties", fn)
+	walkDefinitionMap(def.PatternProperties, path+"/patternProperties", fn)
+	walkDefinitionArray(def.AllOf, path+"/allOf", fn)
+	walkDefinitionArray(def.AnyOf, path+"/anyOf", fn)
+	walkDefinitionArray(def.OneOf, path+"/oneOf", fn)
+	walkDefinitionPath(def.Not, path+"/not", fn)
+	if def.Items != nil {
+		walkDefinitionPath(def.Items.Schema, path+"/items", fn)
+		walkDefinitionArray(def.Items.JSONSchemas, path+"/items", fn)
+	}
+	if def.AdditionalProperties != nil {
+		walkDefinitionPath(def.AdditionalProperties.Schema, path+"/additionalProperties", fn)
+	}
+	if def.AdditionalItems != nil {
+		walkDefinitionPath(def.AdditionalItems.Schema, path+"/additionalItems", fn)
+	}
+	for key := range def.Dependencies {
+		dep := def.Dependencies[key]
+		walkDefinitionPath(dep.Schema, path+"/dependencies/"+key, fn)
+		def.Dependencies[key] = dep
+	}
+}
+
+func walkDefinitionMap(defs map[string]v1beta1.JSONSchemaProps, path string, fn func(string, *v1beta1.JSONSchemaProps)) {
+	for key := range defs {
+		def := defs[key]
+		walkDefinitionPath(&def, path+"/"+key, fn)
+		defs[key] = def
+	}
+}
+
+func walkDefinitionArray(defs []v1beta1.JSONSchemaProps, path string, fn func(string, *v1beta1.JSONSchemaProps)) {
+	for i := range defs {
+		walkDefinitionPath(&defs[i], path+"/"+strconv.Itoa(i), fn)
+	}
+}